@@ -0,0 +1,58 @@
+// Multi-interval accumulation (-accumulate)
+//
+// Per-interval dirty sets are noisy for workloads that touch the same
+// pages in bursts. With -accumulate N the processes are read and cleared
+// only on every Nth interval, so the kernel accumulates the dirty set over
+// a window of N intervals, and a page written several times within a
+// window counts once. Unlike -bin-ms, which sums per-interval counts after
+// the fact, this changes what is measured, and issues N times fewer reads
+// and clears.
+//
+// Each sample is one window: it lists the window's distinct dirty pages
+// and is stamped at the window's end, so total_dirty_events counts each
+// page once per window. Rates divide delta_dirty_count by the time between
+// samples as usual, which is the window length of N intervals, so a page
+// rewritten within a window adds to the rate once. accumulated_ms gives
+// the time since the window's clear and interval_ms the window's nominal
+// length, the sum of its intervals (under -schedule, whose steps count
+// intervals, they may differ), which -deterministic and the
+// intervals_met_pct check go by. The
+// run's last sample may end a window early, and any other clear (after a
+// pause, a re-open, or -start-on-signal) starts a new window.
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// accumulation tracks the current -accumulate window
+type accumulation struct {
+	every   int           // intervals per window
+	reads   int           // intervals passed in the current window
+	window  time.Duration // their nominal length
+	startMs float64       // when the window's clear was issued
+}
+
+// advance counts an interval of the given length and reports whether it
+// ends the window, i.e. is read and cleared
+func (a *accumulation) advance(interval time.Duration) bool {
+	a.reads++
+	a.window += interval
+	return a.reads >= a.every
+}
+
+// stamp records the sample's window age and nominal length and starts the
+// next window at elapsedMs, when the sample's clear is issued
+func (a *accumulation) stamp(sample *DirtySample, elapsedMs float64) {
+	sample.AccumulatedMs = math.Round((elapsedMs-a.startMs)*1000) / 1000
+	sample.IntervalMs = float64(a.window.Microseconds()) / 1000.0
+	a.restart(elapsedMs)
+}
+
+// restart starts a new window after an out-of-turn clear at elapsedMs
+func (a *accumulation) restart(elapsedMs float64) {
+	a.reads = 0
+	a.window = 0
+	a.startMs = elapsedMs
+}
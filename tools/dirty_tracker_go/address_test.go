@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// TestAddressHandling runs maps lines with 5-level paging addresses (up to
+// 2^57), which no live process here has, through parsing, pagemap offset
+// math, and the hex formatting used in DirtyPage.Addr
+func TestAddressHandling(t *testing.T) {
+	tests := []struct {
+		line  string
+		start uint64
+		end   uint64
+	}{
+		{"7ffc1a2b3000-7ffc1a2d4000 rw-p 00000000 00:00 0 [stack]", 0x7ffc1a2b3000, 0x7ffc1a2d4000},
+		{"ffffffffff000-100000000000000 rw-p 00000000 00:00 0", 0xffffffffff000, 1 << 56},
+		{"1fffffffffe000-1ffffffffff000 rw-p 00000000 00:00 0", 0x1fffffffffe000, 0x1ffffffffff000},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%#x", tt.start), func(t *testing.T) {
+			vma, ok := parseMapsLine(tt.line)
+			if !ok || vma.Start != tt.start || vma.End != tt.end {
+				t.Fatalf("parse %q: got %#x-%#x", tt.line, vma.Start, vma.End)
+			}
+
+			lastPage := vma.End - PageSize
+			wantOffset := lastPage / PageSize * PagemapEntrySize
+			if off := pagemapOffset(lastPage); off < 0 || uint64(off) != wantOffset {
+				t.Errorf("pagemap offset of %#x: got %d, want %d", lastPage, off, wantOffset)
+			}
+
+			page := DirtyPage{Addr: fmt.Sprintf("0x%x", lastPage)}
+			if got := page.Address(); got != lastPage {
+				t.Errorf("address round trip of %#x: got %#x", lastPage, got)
+			}
+
+			data, err := json.Marshal(vma)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var back VMAInfo
+			if err := json.Unmarshal(data, &back); err != nil {
+				t.Fatal(err)
+			}
+			if back != vma {
+				t.Errorf("VMAInfo JSON round trip: got %+v, want %+v", back, vma)
+			}
+		})
+	}
+}
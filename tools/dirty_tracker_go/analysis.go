@@ -0,0 +1,701 @@
+// Derived metrics computed from recorded samples in GetDirtyPattern
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// samplePageNumbers returns the sorted, distinct page numbers dirtied in
+// a sample. The same address dirtied by several processes counts once.
+func samplePageNumbers(sample *DirtySample) []uint64 {
+	pages := make([]uint64, len(sample.DirtyPages))
+	for i := range sample.DirtyPages {
+		pages[i] = sample.DirtyPages[i].Address() / PageSize
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i] < pages[j] })
+
+	distinct := pages[:0]
+	for _, p := range pages {
+		if len(distinct) == 0 || p != distinct[len(distinct)-1] {
+			distinct = append(distinct, p)
+		}
+	}
+	return distinct
+}
+
+// countRuns returns the number of maximal runs of consecutive page numbers
+// in a sorted, distinct slice
+func countRuns(pages []uint64) int {
+	runs := 0
+	for i, p := range pages {
+		if i == 0 || p != pages[i-1]+1 {
+			runs++
+		}
+	}
+	return runs
+}
+
+// localityScore compares how contiguous each sample's dirty pages are with
+// what uniformly random single-page writes would give.
+//
+// For k distinct pages drawn uniformly from a contiguous working set of W
+// pages, the expected number of adjacent pairs is k(k-1)/W, so the expected
+// number of runs is
+//
+//	E[runs] = k - k(k-1)/W
+//
+// W is the number of unique pages dirtied over the whole run. Summed over
+// all samples,
+//
+//	LocalityScore = sum(E[runs]) / sum(observed runs)
+//
+// which is the ratio of the observed mean run length to the random one:
+// about 1 for scattered writes, growing with contiguity (a sample that
+// dirties one solid block of k pages scores up to k).
+func localityScore(samples []DirtySample, uniquePages int) float64 {
+	if uniquePages == 0 {
+		return 0
+	}
+	w := float64(uniquePages)
+
+	var expectedRuns, observedRuns float64
+	for i := range samples {
+		pages := samplePageNumbers(&samples[i])
+		if len(pages) == 0 {
+			continue
+		}
+		k := float64(len(pages))
+		expectedRuns += k - k*(k-1)/w
+		observedRuns += float64(countRuns(pages))
+	}
+	if observedRuns == 0 {
+		return 0
+	}
+	return expectedRuns / observedRuns
+}
+
+// jaccard returns |a∩b| / |a∪b| of two sorted, distinct page slices, or
+// false when both are empty
+func jaccard(a, b []uint64) (float64, bool) {
+	if len(a) == 0 && len(b) == 0 {
+		return 0, false
+	}
+	common := 0
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			common++
+			i++
+			j++
+		case a[i] < b[j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return float64(common) / float64(len(a)+len(b)-common), true
+}
+
+// spatialEntropy returns the Shannon entropy in bits of the distribution of
+// a sample's distinct dirty pages (sorted, as from samplePageNumbers) over
+// address buckets of bucketBytes, or false when there are none. It is 0
+// when all pages share a bucket and log2(n) when n buckets are equally hit.
+func spatialEntropy(pages []uint64, bucketBytes uint64) (float64, bool) {
+	if len(pages) == 0 {
+		return 0, false
+	}
+	pagesPerBucket := max(bucketBytes/PageSize, 1)
+	var h float64
+	total := float64(len(pages))
+	// Sorted pages put each bucket's pages next to each other
+	for i := 0; i < len(pages); {
+		j := i + 1
+		for j < len(pages) && pages[j]/pagesPerBucket == pages[i]/pagesPerBucket {
+			j++
+		}
+		p := float64(j-i) / total
+		h -= p * math.Log2(p)
+		i = j
+	}
+	// -0 for a single bucket
+	return math.Abs(h), true
+}
+
+// firstDirtyMs returns, per region, the timestamp of the first sample that
+// listed a dirty page in it. Regions are VMA ids when -relative-addr set
+// them and VMA types otherwise. Only listed pages count, so with
+// -max-pages-per-sample a region's onset can show up a sample late.
+func firstDirtyMs(samples []DirtySample) map[string]float64 {
+	first := make(map[string]float64)
+	for i := range samples {
+		for j := range samples[i].DirtyPages {
+			page := &samples[i].DirtyPages[j]
+			key := page.VMAId
+			if key == "" {
+				key = page.VMAType
+			}
+			if _, ok := first[key]; !ok {
+				first[key] = samples[i].TimestampMs
+			}
+		}
+	}
+	return first
+}
+
+// stackGrowth sums the samples' dirty pages of main stacks, split into
+// those mapped in by stack growth since the previous read and the rest. A
+// deep recursion dirties each new stack page once as it goes down, which
+// is not churn. The per-sample counts include pages past
+// -max-pages-per-sample or left out by -frontier.
+func stackGrowth(samples []DirtySample) (growth, redirty int) {
+	for i := range samples {
+		growth += samples[i].StackGrowthCount
+		redirty += samples[i].StackRedirtyCount
+	}
+	return growth, redirty
+}
+
+// vmaSizeBucketEdges are the upper bounds of the VMA size histogram buckets
+var vmaSizeBucketEdges = []struct {
+	limit uint64
+	label string
+}{
+	{64 << 10, "<64K"},
+	{1 << 20, "64K-1M"},
+	{16 << 20, "1M-16M"},
+	{256 << 20, "16M-256M"},
+	{4 << 30, "256M-4G"},
+}
+
+// vmaBytes returns the size of the page's VMA, from a capture read from a
+// file by its vma_start and vma_end; 0 if unknown
+func (p *DirtyPage) vmaBytes() uint64 {
+	if p.vmaSize > 0 {
+		return p.vmaSize
+	}
+	start, err := parseHexAddr(p.VMAStart)
+	if err != nil {
+		return 0
+	}
+	end, err := parseHexAddr(p.VMAEnd)
+	if err != nil || end < start {
+		return 0
+	}
+	return end - start
+}
+
+// vmaSizeBucket returns the histogram label for a VMA of the given size
+func vmaSizeBucket(size uint64) string {
+	for _, edge := range vmaSizeBucketEdges {
+		if size < edge.limit {
+			return edge.label
+		}
+	}
+	return ">=4G"
+}
+
+// interDirtyBucketEdges are the upper bounds (ms) of the inter-dirty time
+// histogram buckets
+var interDirtyBucketEdges = []struct {
+	limit float64
+	label string
+}{
+	{100, "<100ms"},
+	{250, "100ms-250ms"},
+	{500, "250ms-500ms"},
+	{1000, "500ms-1s"},
+	{2000, "1s-2s"},
+	{5000, "2s-5s"},
+	{10000, "5s-10s"},
+	{30000, "10s-30s"},
+	{60000, "30s-60s"},
+}
+
+// interDirtyBucket returns the histogram label for an inter-dirty time
+func interDirtyBucket(ms float64) string {
+	for _, edge := range interDirtyBucketEdges {
+		if ms < edge.limit {
+			return edge.label
+		}
+	}
+	return ">=60s"
+}
+
+// interDirtyHistogram buckets, for every page dirtied more than once, the
+// time between each pair of consecutive samples that saw it dirty. The
+// resolution is the sampling interval: a page rewritten several times
+// within one interval is dirty once per sample.
+func interDirtyHistogram(samples []DirtySample) map[string]int {
+	hist := make(map[string]int)
+	lastSeen := make(map[uint64]float64)
+	for i := range samples {
+		ts := samples[i].TimestampMs
+		for _, page := range samplePageNumbers(&samples[i]) {
+			if last, ok := lastSeen[page]; ok {
+				hist[interDirtyBucket(ts-last)]++
+			}
+			lastSeen[page] = ts
+		}
+	}
+	return hist
+}
+
+// effectiveDirtySet estimates how many pages are dirty in a typical future
+// interval by weighting each page dirtied during the run with its re-dirty
+// probability. A page first dirtied in sample f has n-1-f later samples in
+// which it could be dirtied again; with r re-dirties among them it gets the
+// Laplace-smoothed estimate
+//
+//	p = (r + 1) / (n - 1 - f + 2)
+//
+// so a page written once early in a long run counts for almost nothing,
+// a page dirtied in every sample for almost one, and a page with no
+// history after its first write for one half.
+func effectiveDirtySet(samples []DirtySample) float64 {
+	first := make(map[uint64]int)
+	hits := make(map[uint64]int)
+	for i := range samples {
+		for _, page := range samplePageNumbers(&samples[i]) {
+			if _, ok := first[page]; !ok {
+				first[page] = i
+			}
+			hits[page]++
+		}
+	}
+
+	var effective float64
+	for page, f := range first {
+		later := len(samples) - 1 - f
+		effective += float64(hits[page]) / float64(later+2)
+	}
+	return effective
+}
+
+// DowntimeCandidate is one sample considered as a checkpoint point
+type DowntimeCandidate struct {
+	SampleIndex   int     `json:"sample_index"`
+	TimestampMs   float64 `json:"timestamp_ms"`
+	ResidualPages int     `json:"residual_pages"`
+	Fits          bool    `json:"fits"`
+}
+
+// DowntimeBudgetReport answers when a checkpoint would have met the
+// downtime budget
+type DowntimeBudgetReport struct {
+	BudgetPages       int                 `json:"budget_pages"`
+	Candidates        []DowntimeCandidate `json:"candidates"`
+	FittingCandidates int                 `json:"fitting_candidates"`
+	FirstFitMs        *float64            `json:"first_fit_timestamp_ms,omitempty"`
+	MinResidualPages  int                 `json:"min_residual_pages"`
+}
+
+// downtimeBudget treats each sampling interval as the last pre-copy round
+// before a checkpoint at its end: everything dirtied earlier has been
+// transferred, and the pages dirtied during the round (the hot residual)
+// must be copied while the process is stopped. A checkpoint at sample i
+// fits if that residual is at most budget pages. The residual is the
+// sample's dirty count, which includes pages beyond -max-pages-per-sample.
+// Sample 0 and partial, resumed, or restored samples don't cover a full
+// interval and are not candidates.
+func downtimeBudget(samples []DirtySample, budget int) *DowntimeBudgetReport {
+	report := &DowntimeBudgetReport{BudgetPages: budget, Candidates: []DowntimeCandidate{}}
+	for i := 1; i < len(samples); i++ {
+		if samples[i].Partial || samples[i].discontinuous() {
+			continue
+		}
+		c := DowntimeCandidate{
+			SampleIndex:   i,
+			TimestampMs:   samples[i].TimestampMs,
+			ResidualPages: samples[i].DeltaDirtyCount,
+		}
+		c.Fits = c.ResidualPages <= budget
+		if c.Fits {
+			report.FittingCandidates++
+			if report.FirstFitMs == nil {
+				ts := c.TimestampMs
+				report.FirstFitMs = &ts
+			}
+		}
+		if len(report.Candidates) == 0 || c.ResidualPages < report.MinResidualPages {
+			report.MinResidualPages = c.ResidualPages
+		}
+		report.Candidates = append(report.Candidates, c)
+	}
+	return report
+}
+
+// HotSetReport describes the pages dirtied in (nearly) every interval
+type HotSetReport struct {
+	Fraction         float64  `json:"fraction"`           // of intervals a page must be dirty in
+	IntervalsCounted int      `json:"intervals_counted"`  // full intervals considered
+	AlwaysDirtyPages int      `json:"always_dirty_pages"` // pages dirty in at least that many
+	ExampleAddrs     []string `json:"example_addrs"`      // lowest addresses among them
+	ListedPagesOnly  bool     `json:"listed_pages_only,omitempty"`
+}
+
+// maxHotSetExamples caps HotSetReport.ExampleAddrs
+const maxHotSetExamples = 16
+
+// hotSet finds the pages dirtied in at least fraction of the sampling
+// intervals; with fraction 1 that is the intersection of every interval's
+// dirty set. These stay dirty however many pre-copy rounds run and are
+// always left for the stopped phase. Sample 0 and partial, resumed, or
+// restored samples don't cover a full interval and are not counted. Only
+// listed pages count, so -max-pages-per-sample truncation can hide pages;
+// ListedPagesOnly flags that.
+func hotSet(samples []DirtySample, fraction float64) *HotSetReport {
+	report := &HotSetReport{Fraction: fraction, ExampleAddrs: []string{}}
+	hits := make(map[uint64]int)
+	for i := 1; i < len(samples); i++ {
+		if samples[i].Partial || samples[i].discontinuous() {
+			continue
+		}
+		report.IntervalsCounted++
+		report.ListedPagesOnly = report.ListedPagesOnly || samples[i].Truncated
+		for _, page := range samplePageNumbers(&samples[i]) {
+			hits[page]++
+		}
+	}
+	if report.IntervalsCounted == 0 {
+		return report
+	}
+
+	// A page needs ceil(fraction * intervals) hits, and at least one
+	need := max(int(math.Ceil(fraction*float64(report.IntervalsCounted)-1e-9)), 1)
+	var hot []uint64
+	for page, n := range hits {
+		if n >= need {
+			hot = append(hot, page)
+		}
+	}
+	sort.Slice(hot, func(i, j int) bool { return hot[i] < hot[j] })
+	report.AlwaysDirtyPages = len(hot)
+	for _, page := range hot[:min(len(hot), maxHotSetExamples)] {
+		report.ExampleAddrs = append(report.ExampleAddrs, fmt.Sprintf("0x%x", page*PageSize))
+	}
+	return report
+}
+
+// sortedAddrs returns the keys of an address set in ascending order
+func sortedAddrs(set map[uint64]struct{}) []uint64 {
+	addrs := make([]uint64, 0, len(set))
+	for addr := range set {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+// anyAddrIn reports whether a sorted address slice has an entry in [start, end)
+func anyAddrIn(addrs []uint64, start, end uint64) bool {
+	i := sort.Search(len(addrs), func(i int) bool { return addrs[i] >= start })
+	return i < len(addrs) && addrs[i] < end
+}
+
+// cleanWritableRegions returns the writable VMAs from the start and end
+// maps (deduplicated by range) that contain none of the dirty addresses.
+func cleanWritableRegions(startMaps, endMaps []VMAInfo, dirty []uint64) []VMAInfo {
+	type span struct{ start, end uint64 }
+	seen := make(map[span]struct{})
+	var clean []VMAInfo
+
+	for _, maps := range [][]VMAInfo{startMaps, endMaps} {
+		for _, vma := range maps {
+			if !vma.IsWritable() {
+				continue
+			}
+			key := span{vma.Start, vma.End}
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			if !anyAddrIn(dirty, vma.Start, vma.End) {
+				clean = append(clean, vma)
+			}
+		}
+	}
+	return clean
+}
+
+// GuardRegionStats counts the PROT_NONE VMAs in the captured maps
+type GuardRegionStats struct {
+	Count int    `json:"count"`
+	Bytes uint64 `json:"bytes"`
+}
+
+// guardRegions counts the PROT_NONE VMAs from the start and end maps,
+// deduplicated by range. Returns nil if there are none.
+func guardRegions(startMaps, endMaps []VMAInfo) *GuardRegionStats {
+	type span struct{ start, end uint64 }
+	seen := make(map[span]struct{})
+	var stats GuardRegionStats
+
+	for _, maps := range [][]VMAInfo{startMaps, endMaps} {
+		for _, vma := range maps {
+			if !vma.IsGuard() {
+				continue
+			}
+			key := span{vma.Start, vma.End}
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			stats.Count++
+			stats.Bytes += vma.End - vma.Start
+		}
+	}
+	if stats.Count == 0 {
+		return nil
+	}
+	return &stats
+}
+
+// VMADirtyFraction is how much of one writable VMA was dirtied over the run
+type VMADirtyFraction struct {
+	VMAId      string  `json:"vma_id"`
+	Pages      int     `json:"pages"`
+	DirtyPages int     `json:"dirty_pages"`
+	Fraction   float64 `json:"fraction"`
+}
+
+// countAddrsIn returns how many entries of a sorted address slice are in
+// [start, end)
+func countAddrsIn(addrs []uint64, start, end uint64) int {
+	lo := sort.Search(len(addrs), func(i int) bool { return addrs[i] >= start })
+	hi := sort.Search(len(addrs), func(i int) bool { return addrs[i] >= end })
+	return hi - lo
+}
+
+// vmaDirtyFractions returns the dirtied fraction of each writable VMA in
+// the start and end maps, deduplicated by range. VMAs are identified as in
+// -relative-addr (see vmaIdentities), relative to the maps they came from.
+func vmaDirtyFractions(startMaps, endMaps []VMAInfo, dirty []uint64) []VMADirtyFraction {
+	type span struct{ start, end uint64 }
+	seen := make(map[span]struct{})
+	var fractions []VMADirtyFraction
+
+	for _, maps := range [][]VMAInfo{startMaps, endMaps} {
+		ids := vmaIdentities(maps)
+		for i, vma := range maps {
+			if !vma.IsWritable() {
+				continue
+			}
+			key := span{vma.Start, vma.End}
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+
+			pages := int((vma.End - vma.Start) / PageSize)
+			dirtyPages := countAddrsIn(dirty, vma.Start, vma.End)
+			entry := VMADirtyFraction{VMAId: ids[i], Pages: pages, DirtyPages: dirtyPages}
+			if pages > 0 {
+				entry.Fraction = float64(dirtyPages) / float64(pages)
+			}
+			fractions = append(fractions, entry)
+		}
+	}
+	return fractions
+}
+
+// sustainedPeakRate returns the highest dirty rate averaged over any span
+// of at least windowMs ending at a sample. For each sample j the window
+// starts at the latest sample i with ts[j]-ts[i] >= windowMs and the rate
+// is the pages dirtied in samples i+1..j over that span. Returns 0 when
+// the run is shorter than the window.
+func sustainedPeakRate(samples []DirtySample, windowMs float64) float64 {
+	var peak float64
+	start := 0
+	pages := 0 // dirty pages in samples start+1..j
+	for j := 1; j < len(samples); j++ {
+		pages += samples[j].DeltaDirtyCount
+		// Advance while the next start still leaves a full window
+		for start+1 < j && samples[j].TimestampMs-samples[start+1].TimestampMs >= windowMs {
+			start++
+			pages -= samples[start].DeltaDirtyCount
+		}
+		span := samples[j].TimestampMs - samples[start].TimestampMs
+		if span < windowMs || span <= 0 {
+			continue
+		}
+		peak = max(peak, float64(pages)/(span/1000.0))
+	}
+	return peak
+}
+
+// maxBurst returns the largest single-sample delta_dirty_count and the
+// timestamp of the first sample that reached it: the most pages a
+// stop-the-world phase after one interval would have had to copy.
+func maxBurst(samples []DirtySample) (pages int, timestampMs float64) {
+	for i := range samples {
+		if samples[i].DeltaDirtyCount > pages {
+			pages = samples[i].DeltaDirtyCount
+			timestampMs = samples[i].TimestampMs
+		}
+	}
+	return pages, timestampMs
+}
+
+// TimeBin aggregates the samples whose timestamps fall in one -bin-ms bin
+type TimeBin struct {
+	StartMs         float64 `json:"start_ms"`
+	EndMs           float64 `json:"end_ms"`
+	Samples         int     `json:"samples"`
+	TotalDirtyPages int     `json:"total_dirty_pages"`
+	AvgDirtyPages   float64 `json:"avg_dirty_pages"` // per sample; 0 for an empty bin
+}
+
+// binTimeline splits the run into fixed bins of widthMs from 0 through the
+// last sample and sums each sample's delta into the bin its timestamp falls
+// in. Empty bins are kept so runs of equal length yield the same number of
+// bins whatever their sampling interval.
+func binTimeline(samples []DirtySample, widthMs float64) []TimeBin {
+	if len(samples) == 0 || widthMs <= 0 {
+		return nil
+	}
+	last := samples[len(samples)-1].TimestampMs
+	bins := make([]TimeBin, int(last/widthMs)+1)
+	for i := range bins {
+		bins[i].StartMs = float64(i) * widthMs
+		bins[i].EndMs = float64(i+1) * widthMs
+	}
+	for i := range samples {
+		k := min(int(samples[i].TimestampMs/widthMs), len(bins)-1)
+		bins[k].Samples++
+		bins[k].TotalDirtyPages += samples[i].DeltaDirtyCount
+	}
+	for i := range bins {
+		if bins[i].Samples > 0 {
+			bins[i].AvgDirtyPages = float64(bins[i].TotalDirtyPages) / float64(bins[i].Samples)
+		}
+	}
+	return bins
+}
+
+// autocorrelation returns the sample autocorrelation of series at lags
+// 0..maxLag (fewer for a short series), or nil when the series is flat
+func autocorrelation(series []float64, maxLag int) []float64 {
+	if len(series) < 2 {
+		return nil
+	}
+	var mean float64
+	for _, x := range series {
+		mean += x
+	}
+	mean /= float64(len(series))
+	var variance float64
+	for _, x := range series {
+		variance += (x - mean) * (x - mean)
+	}
+	if variance == 0 {
+		return nil
+	}
+
+	acf := make([]float64, min(maxLag, len(series)-1)+1)
+	for lag := range acf {
+		var sum float64
+		for t := 0; t+lag < len(series); t++ {
+			sum += (series[t] - mean) * (series[t+lag] - mean)
+		}
+		acf[lag] = sum / variance
+	}
+	return acf
+}
+
+// autocorrPeak returns the lag of the highest positive local maximum of an
+// autocorrelation, or 0 when there is none
+func autocorrPeak(acf []float64) int {
+	best := 0
+	for lag := 1; lag+1 < len(acf); lag++ {
+		if acf[lag] > 0 && acf[lag] > acf[lag-1] && acf[lag] >= acf[lag+1] &&
+			(best == 0 || acf[lag] > acf[best]) {
+			best = lag
+		}
+	}
+	return best
+}
+
+// meanSampleStepMs returns the mean time between consecutive samples from
+// sample 1 on (those with a rate), in CPU time when cpu is set. Gaps ending
+// in a resumed or restored sample span the pause and are left out.
+func meanSampleStepMs(samples []DirtySample, cpu bool) float64 {
+	var total float64
+	steps := 0
+	for i := 2; i < len(samples); i++ {
+		if samples[i].discontinuous() {
+			continue
+		}
+		if cpu {
+			total += samples[i].CPUTimeMs - samples[i-1].CPUTimeMs
+		} else {
+			total += samples[i].TimestampMs - samples[i-1].TimestampMs
+		}
+		steps++
+	}
+	if steps == 0 {
+		return 0
+	}
+	return total / float64(steps)
+}
+
+// intervalsMetPct returns the percentage of sampling intervals that took
+// at most intervalTolerance longer than intervalMs. Intervals ending in a
+// partial, resumed, or restored sample are not regular intervals and are
+// skipped.
+func intervalsMetPct(samples []DirtySample, intervalMs float64) (float64, bool) {
+	met, total := 0, 0
+	for i := 1; i < len(samples); i++ {
+		if samples[i].Partial || samples[i].discontinuous() {
+			continue
+		}
+		total++
+		// Under -schedule each sample has its own interval
+		want := intervalMs
+		if samples[i].IntervalMs > 0 {
+			want = samples[i].IntervalMs
+		}
+		if samples[i].TimestampMs-samples[i-1].TimestampMs <= want*(1+intervalTolerance) {
+			met++
+		}
+	}
+	if total == 0 {
+		return 0, false
+	}
+	return float64(met) * 100 / float64(total), true
+}
+
+// Copy-on-write storm heuristic. After fork, parent and child share every
+// page write-protected, so the child's first writes to even read-mostly
+// data each fault and dirty a whole page. A child is flagged when it
+// dirtied at least cowStormMinPages in its first cowWindowSamples samples
+// and at least cowStormFactor times as many as in the cowWindowSamples
+// after. Children whose second window has not completed are not judged.
+const (
+	cowWindowSamples = 5
+	cowStormFactor   = 3
+	cowStormMinPages = 16
+)
+
+// cowStorms fills in the per-window dirty counts of each fork event and
+// flags likely COW storms
+func cowStorms(samples []DirtySample, forks []ForkEvent) []ForkEvent {
+	judged := make([]ForkEvent, len(forks))
+	for i, fork := range forks {
+		earlyEnd := fork.firstSampleIndex + cowWindowSamples
+		laterEnd := earlyEnd + cowWindowSamples
+		for j := fork.firstSampleIndex; j < laterEnd && j < len(samples); j++ {
+			if j < earlyEnd {
+				fork.EarlyDirtyPages += samples[j].perPid[fork.Pid]
+			} else {
+				fork.LaterDirtyPages += samples[j].perPid[fork.Pid]
+			}
+		}
+		fork.LikelyCOWStorm = laterEnd <= len(samples) &&
+			fork.EarlyDirtyPages >= cowStormMinPages &&
+			fork.EarlyDirtyPages >= cowStormFactor*fork.LaterDirtyPages
+		judged[i] = fork
+	}
+	return judged
+}
@@ -0,0 +1,86 @@
+// Pathname anonymization (-anonymize-paths)
+//
+// Filesystem paths in the output (page and VMA pathnames, VMA identities,
+// -anon-like-path matches) are replaced by "/anon-" plus 16 hex digits of
+// an HMAC-SHA256 of the path. The same path always maps to the same label
+// within a capture, so distinct files stay distinct, but the key is random
+// per run and never written out: the mapping is not recoverable, and
+// labels differ between captures. Pseudo-paths such as [heap] or [stack]
+// are kept. VMA types are derived from the original paths before they are
+// replaced. With -stream the streamed samples are anonymized too.
+// -deterministic fixes the key to all zeros (see deterministic.go).
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// pathAnonymizer maps paths to stable, unrecoverable labels
+type pathAnonymizer struct {
+	key    []byte
+	labels map[string]string
+}
+
+func newPathAnonymizer() (*pathAnonymizer, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return &pathAnonymizer{key: key, labels: make(map[string]string)}, nil
+}
+
+// path returns the label for a filesystem path, or non-paths unchanged
+func (a *pathAnonymizer) path(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		return p
+	}
+	if label, ok := a.labels[p]; ok {
+		return label
+	}
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(p))
+	label := "/anon-" + hex.EncodeToString(mac.Sum(nil)[:8])
+	a.labels[p] = label
+	return label
+}
+
+// vmaID anonymizes the path part of a vmaIdentities identity
+// ("path@0xoffset" with an optional "#n" suffix)
+func (a *pathAnonymizer) vmaID(id string) string {
+	i := strings.LastIndex(id, "@0x")
+	if !strings.HasPrefix(id, "/") || i < 0 {
+		return id
+	}
+	return a.path(id[:i]) + id[i:]
+}
+
+// maps returns an anonymized copy of a VMA list
+func (a *pathAnonymizer) maps(vmas []VMAInfo) []VMAInfo {
+	if vmas == nil {
+		return nil
+	}
+	out := make([]VMAInfo, len(vmas))
+	for i, vma := range vmas {
+		vma.Pathname = a.path(vma.Pathname)
+		out[i] = vma
+	}
+	return out
+}
+
+// pattern anonymizes the parts of a finished pattern that are built from
+// the raw memory maps; sampled pages are anonymized as they are read
+func (a *pathAnonymizer) pattern(p *DirtyPattern) {
+	p.StartMaps = a.maps(p.StartMaps)
+	p.EndMaps = a.maps(p.EndMaps)
+	p.Summary.CleanWritableRegions = a.maps(p.Summary.CleanWritableRegions)
+	for i := range p.Summary.VMADirtyFraction {
+		p.Summary.VMADirtyFraction[i].VMAId = a.vmaID(p.Summary.VMADirtyFraction[i].VMAId)
+	}
+	for i, path := range p.Summary.AnonLikePaths {
+		p.Summary.AnonLikePaths[i] = a.path(path)
+	}
+}
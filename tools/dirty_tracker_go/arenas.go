@@ -0,0 +1,133 @@
+// malloc arena attribution (-detect-arenas)
+//
+// glibc serves threads from several arenas: the main arena grows the
+// [heap], and every other arena lives in heaps that are mmap'ed as plain
+// anonymous memory, so their dirtying is lumped in with everything else
+// "anonymous". With -detect-arenas each dirty page of a heap gets an
+// arena label and the summary breaks the heap dirtying down by it, to
+// show which arena churns under a threaded allocator.
+//
+// Identification is heuristic and best-effort. A non-main heap is a
+// reservation of glibcHeapMax bytes aligned to that size, of which the
+// committed head is read-write and the rest PROT_NONE, so a private
+// anonymous read-write VMA counts as one when it starts on such a
+// boundary and either spans the whole reservation or is followed directly
+// by the PROT_NONE remainder. glibc 2.35+ with glibc.mem.decorate_maps
+// names the mappings instead ("[anon:glibc: malloc arena]"), which is
+// trusted as is. Each heap is labelled by its base address; an arena that
+// outgrew its first heap chains further heaps, which show up under their
+// own labels since the chain lives in the target's memory. Other
+// allocators (jemalloc, tcmalloc) and arenas whose alignment was disturbed
+// by a merge with a neighbouring mapping are not recognized.
+//
+// The Go runtime reserves its heap in arenas of the same 64 MiB, aligned
+// and committed from the head the same way, so the mappings alone can't
+// tell them apart. A process whose executable carries a .go.buildinfo
+// section is a Go program and its unnamed mappings are not taken for
+// glibc heaps; cgo threads' arenas there are only recognized by the
+// decorate_maps name.
+package main
+
+import (
+	"debug/elf"
+	"fmt"
+	"sort"
+)
+
+const (
+	// glibcHeapMax is HEAP_MAX_SIZE on 64-bit: 2 * DEFAULT_MMAP_THRESHOLD_MAX
+	glibcHeapMax = 64 << 20
+
+	mainArena       = "main"
+	glibcArenaLabel = "[anon:glibc: malloc arena]"
+)
+
+// ArenaDirty is the dirtying of one heap
+type ArenaDirty struct {
+	Pid         int    `json:"pid,omitempty"`
+	Arena       string `json:"arena"` // "main" or the heap's base address
+	DirtyPages  int    `json:"dirty_pages"`
+	UniquePages int    `json:"unique_pages"`
+}
+
+// mallocArenas returns the arena label of each heap VMA, keyed by start.
+// goRuntime leaves out the unnamed mappings, which would be Go heap arenas.
+func mallocArenas(vmas []VMAInfo, goRuntime bool) map[uint64]string {
+	arenas := make(map[uint64]string)
+	for i := range vmas {
+		vma := &vmas[i]
+		switch {
+		case vma.Pathname == "[heap]":
+			arenas[vma.Start] = mainArena
+		case vma.Pathname == glibcArenaLabel:
+			if vma.IsWritable() {
+				arenas[vma.Start] = fmt.Sprintf("0x%x", vma.Start&^(glibcHeapMax-1))
+			}
+		case !goRuntime && vma.Pathname == "" && vma.Perms == "rw-p" && vma.Start%glibcHeapMax == 0:
+			base := vma.Start
+			whole := vma.End-base == glibcHeapMax
+			reserved := i+1 < len(vmas) && vmas[i+1].Start == vma.End &&
+				vmas[i+1].IsGuard() && vmas[i+1].Pathname == "" && vmas[i+1].End == base+glibcHeapMax
+			if whole || reserved {
+				arenas[vma.Start] = fmt.Sprintf("0x%x", base)
+			}
+		}
+	}
+	return arenas
+}
+
+// isGoProgram reports whether the process runs a Go executable, looking it
+// up once; an executable that can't be read is taken not to be one
+func (pt *ProcessTracker) isGoProgram() bool {
+	if !pt.runtimeChecked {
+		pt.runtimeChecked = true
+		if f, err := elf.Open(fmt.Sprintf("/proc/%d/exe", pt.pid)); err == nil {
+			pt.goRuntime = f.Section(".go.buildinfo") != nil
+			f.Close()
+		}
+	}
+	return pt.goRuntime
+}
+
+// arenaDirty totals the listed dirty pages of each heap, busiest first
+func arenaDirty(samples []DirtySample) []ArenaDirty {
+	type arenaKey struct {
+		pid   int
+		arena string
+	}
+	totals := make(map[arenaKey]*ArenaDirty)
+	unique := make(map[arenaKey]map[uint64]struct{})
+	for i := range samples {
+		for j := range samples[i].DirtyPages {
+			page := &samples[i].DirtyPages[j]
+			if page.Arena == "" {
+				continue
+			}
+			key := arenaKey{page.pid, page.Arena}
+			entry, ok := totals[key]
+			if !ok {
+				entry = &ArenaDirty{Pid: page.pid, Arena: page.Arena}
+				totals[key] = entry
+				unique[key] = make(map[uint64]struct{})
+			}
+			entry.DirtyPages++
+			unique[key][page.Address()] = struct{}{}
+		}
+	}
+
+	out := make([]ArenaDirty, 0, len(totals))
+	for key, entry := range totals {
+		entry.UniquePages = len(unique[key])
+		out = append(out, *entry)
+	}
+	sort.Slice(out, func(a, b int) bool {
+		if out[a].DirtyPages != out[b].DirtyPages {
+			return out[a].DirtyPages > out[b].DirtyPages
+		}
+		if out[a].Pid != out[b].Pid {
+			return out[a].Pid < out[b].Pid
+		}
+		return out[a].Arena < out[b].Arena
+	})
+	return out
+}
@@ -0,0 +1,117 @@
+// Atomic output files
+//
+// The final outputs are written to a hidden temporary file next to the
+// target and renamed over it once complete, so a reader polling for the
+// file, such as an orchestrator waiting for the result, never sees a
+// partial one, and a failed write leaves the previous file, if any, as it
+// was. The temporary file is created with mode 0644 less the umask, like
+// the target used to be, or with the mode and owner of the file it
+// replaces, and is synced before the rename so the rename can't expose an
+// empty file after a crash. A file whose owner can't be kept (replacing
+// another user's file without the privilege to chown) is written in place
+// as before, with a warning. A path that exists but isn't a regular file
+// (/dev/stdout, a named pipe) can't be replaced and is written in place
+// too; a symlink is followed and its target replaced.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// atomicFile is an output file that only appears at its path on commit
+type atomicFile struct {
+	*os.File
+	path string // final path, empty when writing in place
+}
+
+// createAtomic creates the temporary file for path
+func createAtomic(path string) (*atomicFile, error) {
+	info, err := os.Stat(path)
+	if err == nil && !info.Mode().IsRegular() {
+		return createInPlace(path)
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	dir, base := filepath.Split(path)
+	for attempt := 0; ; attempt++ {
+		tmp := filepath.Join(dir, fmt.Sprintf(".%s.%d-%d.tmp", base, os.Getpid(), attempt))
+		f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, fs.ErrExist) && attempt < 100 {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		a := &atomicFile{File: f, path: path}
+		if info == nil {
+			return a, nil
+		}
+		if err := f.Chmod(info.Mode().Perm()); err != nil {
+			a.abort()
+			return nil, err
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok && (int(st.Uid) != os.Geteuid() || int(st.Gid) != os.Getegid()) {
+			if err := f.Chown(int(st.Uid), int(st.Gid)); err != nil {
+				a.abort()
+				fmt.Fprintf(os.Stderr, "Warning: can't keep the owner of %s (%v); overwriting it in place\n", path, err)
+				return createInPlace(path)
+			}
+		}
+		return a, nil
+	}
+}
+
+// createInPlace truncates path and writes it directly, for files that
+// can't be replaced
+func createInPlace(path string) (*atomicFile, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &atomicFile{File: f}, nil
+}
+
+// commit syncs and closes the file and renames it into place; with fsync,
+// the rename is synced to disk too. A file written in place is only synced
+// with fsync.
+func (a *atomicFile) commit(fsync bool) error {
+	if a.path != "" || fsync {
+		if err := a.Sync(); err != nil {
+			a.abort()
+			return err
+		}
+	}
+	if a.path == "" {
+		return a.Close()
+	}
+	if err := a.Close(); err != nil {
+		os.Remove(a.Name())
+		return err
+	}
+	if err := os.Rename(a.Name(), a.path); err != nil {
+		os.Remove(a.Name())
+		return err
+	}
+	if fsync {
+		if d, err := os.Open(filepath.Dir(a.path)); err == nil {
+			d.Sync()
+			d.Close()
+		}
+	}
+	return nil
+}
+
+// abort closes and removes the temporary file, leaving the path untouched
+func (a *atomicFile) abort() {
+	a.Close()
+	if a.path != "" {
+		os.Remove(a.Name())
+	}
+}
@@ -0,0 +1,80 @@
+// Read strategy benchmark (BenchmarkReadPagemap)
+//
+// Maps a synthetic address space into the test process, dirties it, and
+// measures full ReadDirtyPages passes with each read strategy, for a few
+// VMAs with many pages and for many small VMAs. The numbers depend on the
+// kernel and CPU, so run it on the target host before picking
+// -read-strategy:
+//
+//	go test -run '^$' -bench ReadPagemap
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+// benchLayouts are the synthetic address spaces measured
+var benchLayouts = []struct {
+	name        string
+	vmas        int
+	pagesPerVMA int
+}{
+	{"few-large", 8, 16384},
+	{"many-small", 4096, 16},
+}
+
+// mapBenchLayout maps vmas writable regions, separated by read-only guard
+// pages so the kernel cannot merge them, and touches every page
+func mapBenchLayout(b *testing.B, vmas, pagesPerVMA int) {
+	b.Helper()
+	pageSize := int(PageSize)
+	for i := 0; i < vmas; i++ {
+		mem, err := syscall.Mmap(-1, 0, (pagesPerVMA+1)*pageSize,
+			syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANONYMOUS)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Cleanup(func() { syscall.Munmap(mem) })
+		if err := syscall.Mprotect(mem[pagesPerVMA*pageSize:], syscall.PROT_READ); err != nil {
+			b.Fatal(err)
+		}
+		for p := 0; p < pagesPerVMA; p++ {
+			mem[p*pageSize] = 1
+		}
+	}
+}
+
+func BenchmarkReadPagemap(b *testing.B) {
+	for _, layout := range benchLayouts {
+		b.Run(layout.name, func(b *testing.B) {
+			mapBenchLayout(b, layout.vmas, layout.pagesPerVMA)
+			for _, strategy := range []string{ReadSeek, ReadPread, ReadScan} {
+				b.Run(strategy, func(b *testing.B) {
+					if strategy == ReadScan && !pagemapScanSupported() {
+						b.Skip("PAGEMAP_SCAN with soft-dirty not supported by this kernel")
+					}
+					pt := NewProcessTracker(os.Getpid())
+					pt.opts = &ReadOptions{Strategy: strategy}
+					if err := pt.Open(); err != nil {
+						b.Fatal(err)
+					}
+					defer pt.Close()
+
+					uniqueAddrs := make(map[uint64]struct{})
+					dirty := 0
+					b.ResetTimer()
+					for i := 0; i < b.N; i++ {
+						pages, _, err := pt.ReadDirtyPages(uniqueAddrs, -1)
+						if err != nil {
+							b.Fatal(err)
+						}
+						dirty = len(pages)
+					}
+					b.ReportMetric(float64(dirty), "dirty-pages/op")
+				})
+			}
+		})
+	}
+}
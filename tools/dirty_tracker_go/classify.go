@@ -0,0 +1,87 @@
+// Custom VMA classification (-classify-rules)
+//
+// VMAType only knows generic kinds of mapping, so a runtime's own regions,
+// such as the Go heap arenas or a JVM heap, end up lumped in with all other
+// "anonymous" memory. -classify-rules FILE loads an ordered list of rules
+// in JSON, each giving a label to the VMAs it matches:
+//
+//	[
+//	  {"label": "go-arena", "path": "", "perms": "rw-p", "min_size": 67108864},
+//	  {"label": "jvm-heap", "path": "[anon:java-heap*"},
+//	  {"label": "codecache", "path": "/tmp/*codecache*"}
+//	]
+//
+// A VMA takes the label of the first rule whose conditions all hold, and
+// its built-in type if none does. The conditions are:
+//
+//   - path: a glob on the pathname, '*' matching any run of characters; ""
+//     matches only unnamed mappings, and leaving it out matches any
+//   - perms: a glob on the four permission characters, e.g. "rw-p" or "r*"
+//   - min_size, max_size: bounds on the VMA size in bytes, inclusive; 0 or
+//     left out is unbounded
+//
+// A label takes the place of the type everywhere: vma_type of the dirty
+// pages, the distributions, -split-by-vma-type, and -list-vmas. A label
+// equal to a built-in type merges with it. Analyses of particular types
+// follow the label, so a rule relabelling [stack] takes its pages out of
+// stack_growth_pages and stack_redirty_pages.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// ClassifyRule labels the VMAs matching all of its conditions
+type ClassifyRule struct {
+	Label   string  `json:"label"`
+	Path    *string `json:"path,omitempty"` // nil matches any pathname
+	Perms   string  `json:"perms,omitempty"`
+	MinSize uint64  `json:"min_size,omitempty"`
+	MaxSize uint64  `json:"max_size,omitempty"`
+}
+
+// matches reports whether vma meets all of the rule's conditions
+func (r *ClassifyRule) matches(vma *VMAInfo) bool {
+	if r.Path != nil && !globMatch(*r.Path, vma.Pathname) {
+		return false
+	}
+	if r.Perms != "" && !globMatch(r.Perms, vma.Perms) {
+		return false
+	}
+	size := vma.End - vma.Start
+	return size >= r.MinSize && (r.MaxSize == 0 || size <= r.MaxSize)
+}
+
+// classifyVMA returns the label of the first rule matching vma, or "" if
+// none does
+func classifyVMA(rules []ClassifyRule, vma *VMAInfo) string {
+	for i := range rules {
+		if rules[i].matches(vma) {
+			return rules[i].Label
+		}
+	}
+	return ""
+}
+
+// loadClassifyRules reads and checks a -classify-rules file
+func loadClassifyRules(path string) ([]ClassifyRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []ClassifyRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, rule := range rules {
+		if rule.Label == "" {
+			return nil, fmt.Errorf("%s: rule %d has no label", path, i+1)
+		}
+		if rule.MaxSize != 0 && rule.MaxSize < rule.MinSize {
+			return nil, fmt.Errorf("%s: rule %d (%s) has max_size below min_size", path, i+1, rule.Label)
+		}
+	}
+	return rules, nil
+}
@@ -0,0 +1,31 @@
+// Clean page output (-emit-clean)
+//
+// Some migration strategies want the complement of the dirty set: the
+// pages that stayed clean through an interval and so need not be sent
+// again this round. With -emit-clean each sample also has clean_count, the
+// present pages of the VMAs read whose soft-dirty bit is clear, taken from
+// the pagemap entries the read already has. Swapped and never-touched
+// pages aren't present and are counted neither clean nor dirty, nor are
+// pages left out by -address-mask. Pages a process shares with others are
+// counted in each of them.
+//
+// -emit-clean-addrs also lists the clean addresses per process in
+// clean_pages, which is as large as the resident memory itself; the first
+// -max-pages-per-sample of them are listed if that is set. Needs the
+// present bit, so not with -read-strategy scan.
+package main
+
+import "fmt"
+
+// noteClean counts the present, not soft-dirty page at addr
+func (pt *ProcessTracker) noteClean(addr uint64) {
+	if pt.opts.AddressMask != nil {
+		if _, ok := pt.opts.AddressMask[addr]; !ok {
+			return
+		}
+	}
+	pt.cleanCount++
+	if pt.opts.CleanAddrs && (pt.cleanLimit < 0 || len(pt.cleanAddrs) < pt.cleanLimit) {
+		pt.cleanAddrs = append(pt.cleanAddrs, fmt.Sprintf("0x%x", addr))
+	}
+}
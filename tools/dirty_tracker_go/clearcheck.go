@@ -0,0 +1,84 @@
+// Clear verification (-verify-clear)
+//
+// Some setups accept writes to clear_refs without resetting the soft-dirty
+// bits, so every sample reports every page ever dirtied and the counts
+// only climb. checkClearRefs catches a clear_refs that can't be opened, but
+// not one that silently does nothing. With -verify-clear N every Nth
+// sample's clear is checked: right after it, up to clearCheckPages pages
+// the process just reported dirty are re-read from pagemap. A clear that
+// works leaves them clean except for the few the process rewrites in the
+// meantime; if every one is still soft-dirty the clear is counted as
+// ineffective, the sample is flagged clear_ineffective (the next sample's
+// counts include stale pages), and the first time per process a warning is
+// printed. Stack pages are skipped since they are rewritten constantly,
+// and a process that reported fewer than clearCheckMin other pages isn't
+// checked. A process whose hot set is tiny and rewritten within
+// microseconds can still be misreported, so a single ineffective clear is
+// a hint; a run of them is the signal.
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+const (
+	clearCheckPages = 64
+	clearCheckMin   = 4
+)
+
+// clearCheck counts the verified clears of a run
+type clearCheck struct {
+	every       int
+	checked     int
+	ineffective int
+	warned      map[int]struct{}
+}
+
+// stillDirty re-reads the entries of up to clearCheckPages of the given
+// pages, spread over them, and returns how many were read and how many of
+// those are soft-dirty
+func (pt *ProcessTracker) stillDirty(pages []DirtyPage) (read, dirty int) {
+	var addrs []uint64
+	for i := range pages {
+		if pages[i].VMAType != "stack" {
+			addrs = append(addrs, pages[i].Address())
+		}
+	}
+	if len(addrs) < clearCheckMin {
+		return 0, 0
+	}
+	step := max(1, len(addrs)/clearCheckPages)
+	var buf [PagemapEntrySize]byte
+	for i := 0; i < len(addrs) && read < clearCheckPages; i += step {
+		if n, err := pt.readPagemap(buf[:], pagemapOffset(addrs[i])); err != nil || n != PagemapEntrySize {
+			continue
+		}
+		read++
+		if entrySoftDirty(binary.LittleEndian.Uint64(buf[:]), pt.opts.SkipHoles) {
+			dirty++
+		}
+	}
+	return read, dirty
+}
+
+// verify checks a clear of pid that followed a read of pages and reports
+// whether it was ineffective. Must be called with dt.mu held.
+func (c *clearCheck) verify(pid int, tracker *ProcessTracker, pages []DirtyPage) bool {
+	read, dirty := tracker.stillDirty(pages)
+	if read < clearCheckMin {
+		return false
+	}
+	c.checked++
+	if dirty < read {
+		return false
+	}
+	c.ineffective++
+	if _, ok := c.warned[pid]; !ok {
+		c.warned[pid] = struct{}{}
+		fmt.Fprintf(os.Stderr, "Warning: clearing process %d left all %d re-read pages soft-dirty; clear_refs may be ineffective and its counts may keep climbing\n",
+			pid, read)
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+// clear_refs value selection (-clear-mode)
+//
+// After each sample the tracker writes "4" to /proc/[pid]/clear_refs,
+// which clears the soft-dirty bits. The kernel accepts other values too:
+//
+//	1  referenced  clear the referenced/accessed bits of all pages
+//	2  anon        clear the referenced bits of anonymous pages only
+//	3  file        clear the referenced bits of file-backed pages only
+//	4  soft-dirty  clear the soft-dirty bits (the default)
+//	5  peak-rss    reset the peak RSS (VmHWM) to the current RSS
+//
+// -clear-mode takes a comma-separated list of these, by number or name,
+// and writes each in order at every clear, e.g. "referenced,soft-dirty"
+// to reset the accessed bits alongside the dirty ones for a working-set
+// tool sampling them in between. Only "4" touches what this tracker
+// reads: without soft-dirty in the list the bits are never cleared, so
+// each sample reports every page written since the start, as with
+// -no-clear. The mode is recorded as clear_mode when it isn't the default.
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// clearRefsSoftDirty is the clear_refs value that clears soft-dirty bits
+const clearRefsSoftDirty = "4"
+
+var clearModeNames = map[string]string{
+	"referenced": "1",
+	"anon":       "2",
+	"file":       "3",
+	"soft-dirty": "4",
+	"peak-rss":   "5",
+}
+
+// parseClearMode parses a -clear-mode list into clear_refs values
+func parseClearMode(s string) ([]string, error) {
+	var values []string
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		value, ok := clearModeNames[part]
+		if !ok {
+			if !validClearRefsValue(part) {
+				return nil, fmt.Errorf("%q is not 1-5 or one of referenced, anon, file, soft-dirty, peak-rss", part)
+			}
+			value = part
+		}
+		if seen[value] {
+			return nil, fmt.Errorf("%q is given twice", part)
+		}
+		seen[value] = true
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// validClearRefsValue reports whether value may be written to clear_refs
+func validClearRefsValue(value string) bool {
+	return len(value) == 1 && value[0] >= '1' && value[0] <= '5'
+}
+
+// clearsSoftDirty reports whether writing values clears soft-dirty bits;
+// nil is the default mode
+func clearsSoftDirty(values []string) bool {
+	if values == nil {
+		return true
+	}
+	for _, value := range values {
+		if value == clearRefsSoftDirty {
+			return true
+		}
+	}
+	return false
+}
+
+// clearValues returns the values ClearSoftDirty writes
+func (pt *ProcessTracker) clearValues() []string {
+	if pt.clearMode == nil {
+		return []string{clearRefsSoftDirty}
+	}
+	return pt.clearMode
+}
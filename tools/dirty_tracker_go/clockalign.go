@@ -0,0 +1,100 @@
+// Wall-clock aligned sampling (-align-clock)
+//
+// Trackers started independently, on different processes or hosts, each
+// sample on their own schedule, so their samples can't be lined up. With
+// -align-clock the capture opens on the next multiple of the interval
+// past the Unix epoch (as -start-on-signal opens it on the signal: the
+// targets are cleared again and the clock restarted, so metadata start_time
+// is the boundary) and every later sample is taken on a boundary too. Two
+// aligned trackers with the same interval and NTP-synced clocks then have
+// samples at the same wall-clock times: start_time + timestamp_ms.
+// Boundaries are found on the wall clock, but timestamp_ms, intervals,
+// and deadlines are measured on the monotonic clock from the first one,
+// so a clock step during the run moves later boundaries, not timestamps.
+//
+// A sample that overruns its interval moves the next one to the following
+// free boundary; such skipped boundaries and the latest a sample started
+// after its boundary are recorded in metadata clock_alignment.
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// ClockAlignment describes how well -align-clock kept to the boundaries
+type ClockAlignment struct {
+	IntervalMs  int     `json:"interval_ms"`
+	MaxLagMs    float64 `json:"max_lag_ms"`
+	MissedTicks int     `json:"missed_ticks"`
+}
+
+// clockAligner tracks the boundary the sampling loop aims at
+type clockAligner struct {
+	interval time.Duration
+	tick     time.Time
+	stats    ClockAlignment
+}
+
+// nextClockTick returns the first multiple of interval past the epoch
+// after t
+func nextClockTick(t time.Time, interval time.Duration) time.Time {
+	n := t.UnixNano()/int64(interval) + 1
+	return time.Unix(0, n*int64(interval))
+}
+
+// alignToClock waits for the next boundary and restarts the capture
+// there. It returns false if a stop came first, which the sampling loop
+// then sees before taking any sample.
+func (dt *DirtyPageTracker) alignToClock(interval time.Duration) bool {
+	tick := nextClockTick(time.Now(), interval)
+	select {
+	case <-dt.stopCh:
+		return false
+	case <-time.After(time.Until(tick)):
+	}
+	// tick has no monotonic reading; the capture's clock needs one, or
+	// timestamps and deadlines would follow steps of the wall clock
+	now := time.Now()
+	start := now.Add(tick.Sub(now))
+	dt.restartCapture(start)
+	dt.aligner = &clockAligner{
+		interval: interval,
+		tick:     start,
+		stats:    ClockAlignment{IntervalMs: int(interval / time.Millisecond)},
+	}
+	if dt.metadata != nil {
+		dt.metadata.StartTime = tick.Format(time.RFC3339Nano)
+		dt.metadata.ClockAlignment = &dt.aligner.stats
+	}
+	fmt.Fprintf(os.Stderr, "Sampling aligned to %v boundaries from %s\n", interval, tick.Format("15:04:05.000"))
+	return true
+}
+
+// started records how late a sample started after its boundary
+func (a *clockAligner) started(at time.Time) {
+	lagMs := float64(at.Sub(a.tick)) / float64(time.Millisecond)
+	if lagMs > a.stats.MaxLagMs {
+		a.stats.MaxLagMs = lagMs
+	}
+}
+
+// untilNextSample returns how long the sampling loop waits after the
+// sample started at iterStart
+func (dt *DirtyPageTracker) untilNextSample(iterStart time.Time, interval time.Duration) time.Duration {
+	if dt.aligner != nil {
+		return dt.aligner.untilNext()
+	}
+	return interval - time.Since(iterStart)
+}
+
+// untilNext returns how long to sleep until the next free boundary
+func (a *clockAligner) untilNext() time.Duration {
+	now := time.Now()
+	next := nextClockTick(now, a.interval)
+	next = now.Add(next.Sub(now)) // with a monotonic reading, like tick
+	a.stats.MissedTicks += int(next.Sub(a.tick)/a.interval) - 1
+	a.tick = next
+	return next.Sub(now)
+}
@@ -0,0 +1,106 @@
+// Command-line matching (-cmdline-regex)
+//
+// Tracks every process whose command line matches a regular expression,
+// for workloads made of separately started processes that differ only in
+// their arguments (e.g. "worker --shard=[0-9]+"). The command line is
+// /proc/[pid]/cmdline with the NUL-separated arguments joined by spaces,
+// and the regex matches anywhere in it unless anchored.
+//
+// Without -pid the oldest matching process becomes the root. /proc is
+// rescanned before every sample so later matches are picked up; a scan
+// reads at most cmdlineScanLimit cmdline files, skipping processes that
+// are already tracked or known dead. Kernel threads have an empty command
+// line and never match, and the tracker itself is always skipped.
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const cmdlineScanLimit = 4096
+
+// readCmdline returns a process's arguments joined by spaces
+func readCmdline(pid int) (string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(strings.ReplaceAll(string(data), "\x00", " "), " "), nil
+}
+
+// matchCmdlines returns the processes other than the skipped ones whose
+// command line matches re, and whether the scan limit cut the scan short
+func matchCmdlines(re *regexp.Regexp, skip map[int]struct{}) ([]int, bool) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, false
+	}
+
+	var matches []int
+	scanned := 0
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || isSelf(pid) {
+			continue
+		}
+		if _, ok := skip[pid]; ok {
+			continue
+		}
+		if scanned >= cmdlineScanLimit {
+			return matches, true
+		}
+		scanned++
+
+		cmdline, err := readCmdline(pid)
+		if err == nil && cmdline != "" && re.MatchString(cmdline) {
+			matches = append(matches, pid)
+		}
+	}
+	return matches, false
+}
+
+// oldestCmdlineMatch returns the longest-running process matching re
+func oldestCmdlineMatch(re *regexp.Regexp) (int, error) {
+	matches, _ := matchCmdlines(re, nil)
+	best, bestStart := 0, uint64(0)
+	for _, pid := range matches {
+		stat, err := readProcStat(pid)
+		if err != nil {
+			continue
+		}
+		if best == 0 || stat.StartTime < bestStart {
+			best, bestStart = pid, stat.StartTime
+		}
+	}
+	if best == 0 {
+		return 0, fmt.Errorf("no process command line matches %q", re)
+	}
+	return best, nil
+}
+
+// trackCmdlineMatches adds newly matching processes. Called with dt.mu held.
+func (dt *DirtyPageTracker) trackCmdlineMatches() {
+	skip := make(map[int]struct{}, len(dt.knownPids)+len(dt.deadPids))
+	for pid := range dt.knownPids {
+		skip[pid] = struct{}{}
+	}
+	for pid := range dt.deadPids {
+		skip[pid] = struct{}{}
+	}
+
+	matches, limited := matchCmdlines(dt.cmdlineRegex, skip)
+	if limited && !dt.cmdlineLimitWarned {
+		fmt.Fprintf(os.Stderr, "Warning: -cmdline-regex scan stopped after %d processes; some matches may be missed\n", cmdlineScanLimit)
+		dt.cmdlineLimitWarned = true
+	}
+	for _, pid := range matches {
+		if dt.addProcessTracker(pid) {
+			fmt.Fprintf(os.Stderr, "Tracking command-line match: %d\n", pid)
+			dt.recordTarget(pid)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Coalesced pagemap reads (-coalesce-gap)
+//
+// mprotect and partial munmap split mappings, so a fragmented process can
+// have thousands of small writable VMAs, each costing its own pagemap
+// read. With -coalesce-gap N, runs of writable VMAs in which each starts
+// at most N pages after the previous one ends are read as one cluster,
+// with a single read spanning the gaps. Entries of the gaps, whether
+// unmapped holes (which read as zero) or read-only mappings, are never
+// looked at: each VMA takes the slice of the cluster buffer at its own
+// offset, so pages are attributed to their VMA by address exactly as with
+// separate reads. N = 0 only joins VMAs that are directly adjacent.
+//
+// A cluster is limited to -max-read-buffer-pages entries including its
+// gaps, VMAs larger than that are read on their own in passes, and a
+// cluster of a single VMA is just a normal read. The gap pages are the
+// cost: a larger N saves more reads but copies more unused entries. Not
+// used with -read-strategy scan, which reads no entries.
+package main
+
+// readCluster is a range read with one pagemap read
+type readCluster struct {
+	start, end uint64
+}
+
+// clusterReader serves the pagemap entries of clustered VMAs from one
+// buffer per cluster
+type clusterReader struct {
+	clusters []readCluster
+	byVMA    map[uint64]int // VMA start to its cluster
+	loaded   int            // cluster in pt.clusterBuf, -1 for none
+	n        int            // bytes of it read
+}
+
+// planClusters groups the writable VMAs into read clusters of at least
+// two VMAs. vmas are in address order, as in maps.
+func planClusters(vmas []VMAInfo, gapPages, maxPages int) *clusterReader {
+	cr := &clusterReader{byVMA: make(map[uint64]int), loaded: -1}
+	var members []uint64
+	var current readCluster
+	flush := func() {
+		if len(members) >= 2 {
+			for _, start := range members {
+				cr.byVMA[start] = len(cr.clusters)
+			}
+			cr.clusters = append(cr.clusters, current)
+		}
+		members = members[:0]
+	}
+	fits := func(start, end uint64) bool {
+		return maxPages <= 0 || (end-start)/PageSize <= uint64(maxPages)
+	}
+	for i := range vmas {
+		vma := &vmas[i]
+		if !vma.IsWritable() {
+			continue
+		}
+		if !fits(vma.Start, vma.End) {
+			flush()
+			continue
+		}
+		if len(members) > 0 && vma.Start >= current.end &&
+			(vma.Start-current.end)/PageSize <= uint64(gapPages) && fits(current.start, vma.End) {
+			current.end = vma.End
+		} else {
+			flush()
+			current = readCluster{vma.Start, vma.End}
+		}
+		members = append(members, vma.Start)
+	}
+	flush()
+	return cr
+}
+
+// entries returns the pagemap entries of vma, reading its cluster into
+// pt.clusterBuf first if needed. ok is false for VMAs outside clusters.
+// Entries past a short read are left out.
+func (cr *clusterReader) entries(pt *ProcessTracker, vma *VMAInfo) (data []byte, ok bool, err error) {
+	idx, ok := cr.byVMA[vma.Start]
+	if !ok {
+		return nil, false, nil
+	}
+	c := cr.clusters[idx]
+	if cr.loaded != idx {
+		size := int((c.end - c.start) / PageSize * PagemapEntrySize)
+		if cap(pt.clusterBuf) < size {
+			pt.clusterBuf = make([]byte, size)
+		}
+		cr.loaded = idx
+		cr.n, err = pt.readPagemap(pt.clusterBuf[:size], pagemapOffset(c.start))
+		if err != nil || cr.n < 0 {
+			cr.n = 0
+		}
+		if err != nil {
+			return nil, true, err
+		}
+	}
+	from := int((vma.Start - c.start) / PageSize * PagemapEntrySize)
+	to := min(int((vma.End-c.start)/PageSize*PagemapEntrySize), cr.n)
+	if from >= to {
+		return nil, true, nil
+	}
+	return pt.clusterBuf[from:to], true, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// fakePagemap returns a tracker reading a pagemap file in which the entry
+// of every page from 0 to pages holds its own page number, so an entry
+// shows which address it was read for
+func fakePagemap(t *testing.T, pages uint64) *ProcessTracker {
+	t.Helper()
+	data := make([]byte, pages*PagemapEntrySize)
+	for p := uint64(0); p < pages; p++ {
+		binary.LittleEndian.PutUint64(data[p*PagemapEntrySize:], PagePresent|SoftDirty|p)
+	}
+	path := filepath.Join(t.TempDir(), "pagemap")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fd, err := syscall.Open(path, syscall.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { syscall.Close(fd) })
+	pt := NewProcessTracker(0)
+	pt.pagemapFd = fd
+	pt.opts.Strategy = ReadPread
+	return pt
+}
+
+// vmaPages builds a VMA from page numbers
+func vmaPages(start, end uint64, perms string) VMAInfo {
+	return VMAInfo{Start: start * PageSize, End: end * PageSize, Perms: perms}
+}
+
+func TestCoalescedEntriesAttributeByAddress(t *testing.T) {
+	tests := []struct {
+		name     string
+		vmas     []VMAInfo
+		gap      int
+		maxPages int
+		clusters int
+	}{
+		{
+			name: "adjacent",
+			vmas: []VMAInfo{
+				vmaPages(16, 18, "rw-p"),
+				vmaPages(18, 20, "rw-p"),
+				vmaPages(20, 22, "rw-p"),
+			},
+			clusters: 1,
+		},
+		{
+			name: "gap with a read-only mapping",
+			vmas: []VMAInfo{
+				vmaPages(16, 18, "rw-p"),
+				vmaPages(18, 19, "r--p"),
+				vmaPages(19, 21, "rw-p"),
+				vmaPages(40, 41, "rw-p"),
+			},
+			gap:      1,
+			clusters: 1,
+		},
+		{
+			name: "overlapping",
+			vmas: []VMAInfo{
+				vmaPages(16, 18, "rw-p"),
+				vmaPages(18, 22, "rw-p"),
+				vmaPages(20, 24, "rw-p"),
+				vmaPages(24, 25, "rw-p"),
+			},
+			gap:      1,
+			clusters: 2,
+		},
+		{
+			name: "split by max buffer pages",
+			vmas: []VMAInfo{
+				vmaPages(16, 18, "rw-p"),
+				vmaPages(18, 20, "rw-p"),
+				vmaPages(20, 22, "rw-p"),
+				vmaPages(22, 23, "rw-p"),
+				vmaPages(23, 31, "rw-p"),
+			},
+			maxPages: 4,
+			clusters: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pt := fakePagemap(t, 64)
+			cr := planClusters(tt.vmas, tt.gap, tt.maxPages)
+			if len(cr.clusters) != tt.clusters {
+				t.Fatalf("%d clusters, want %d: %+v", len(cr.clusters), tt.clusters, cr.clusters)
+			}
+			for _, c := range cr.clusters {
+				if tt.maxPages > 0 && (c.end-c.start)/PageSize > uint64(tt.maxPages) {
+					t.Errorf("cluster 0x%x-0x%x over %d pages", c.start, c.end, tt.maxPages)
+				}
+			}
+			for i := range tt.vmas {
+				vma := &tt.vmas[i]
+				data, ok, err := cr.entries(pt, vma)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !ok {
+					continue
+				}
+				if !vma.IsWritable() {
+					t.Errorf("read-only VMA 0x%x clustered", vma.Start)
+				}
+				if want := int((vma.End - vma.Start) / PageSize * PagemapEntrySize); len(data) != want {
+					t.Fatalf("VMA 0x%x: %d bytes of entries, want %d", vma.Start, len(data), want)
+				}
+				for j := 0; j < len(data); j += PagemapEntrySize {
+					entry := binary.LittleEndian.Uint64(data[j:])
+					page := vma.Start/PageSize + uint64(j/PagemapEntrySize)
+					if got := entry &^ (PagePresent | SoftDirty); got != page {
+						t.Errorf("VMA 0x%x: entry %d is of page %d, want %d", vma.Start, j/PagemapEntrySize, got, page)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+// Output compression, chosen by the output file suffix or -zstd
+//
+// .gz is always available. .zst needs a binary built with -tags zstd (see
+// zstd_enabled.go); without it the output falls back to gzip and the
+// suffix is changed to .gz so the file name matches its contents.
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"strings"
+)
+
+const (
+	codecNone = ""
+	codecGzip = "gzip"
+	codecZstd = "zstd"
+)
+
+// outputCodec picks the compression codec and final path for an output
+// file. forceZstd appends .zst to paths without a compression suffix.
+func outputCodec(path string, forceZstd bool) (codec, finalPath string) {
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		return codecGzip, path
+	case strings.HasSuffix(path, ".zst"):
+		codec = codecZstd
+	case forceZstd:
+		codec = codecZstd
+		if path != "" {
+			path += ".zst"
+		}
+	default:
+		return codecNone, path
+	}
+
+	if !zstdAvailable {
+		if path != "" {
+			path = strings.TrimSuffix(path, ".zst") + ".gz"
+		}
+		return codecGzip, path
+	}
+	return codec, path
+}
+
+// nopWriteCloser adapts a plain writer for the uncompressed case
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// newCompressedWriter wraps w with the codec's encoder. Closing the result
+// flushes the encoder but does not close w.
+func newCompressedWriter(w io.Writer, codec string) (io.WriteCloser, error) {
+	switch codec {
+	case codecGzip:
+		return gzip.NewWriter(w), nil
+	case codecZstd:
+		return newZstdWriter(w)
+	default:
+		return nopWriteCloser{w}, nil
+	}
+}
@@ -0,0 +1,107 @@
+// Resolving a container's init process (-container)
+//
+// Docker, containerd, CRI-O, and Podman all put a container's processes in
+// a cgroup whose path carries the 64-hex-digit container ID, e.g.
+//
+//	0::/system.slice/docker-<id>.scope                 (systemd driver)
+//	0::/docker/<id>                                    (cgroupfs driver)
+//	0::/kubepods.slice/.../cri-containerd-<id>.scope   (Kubernetes)
+//
+// so the container's processes are found by scanning /proc/[pid]/cgroup
+// for a path component containing the ID, without talking to the runtime.
+// The ID may be abbreviated like `docker ps` does, as long as it matches
+// a single container. The init process is the matching process whose
+// parent is outside the container; the tracker then follows its tree as
+// with -pid. From inside a nested PID or cgroup namespace the host's view
+// may not be visible, in which case pass -pid instead.
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// containerIDPattern finds full container IDs in cgroup paths
+var containerIDPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// cgroupContainerIDs returns the container IDs in a /proc/[pid]/cgroup file
+func cgroupContainerIDs(data string) []string {
+	var ids []string
+	for _, line := range strings.Split(data, "\n") {
+		// hierarchy-ID:controllers:path
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		ids = append(ids, containerIDPattern.FindAllString(parts[2], -1)...)
+	}
+	return ids
+}
+
+// resolveContainer returns the host PID of the container's init process
+// and the container's full ID
+func resolveContainer(id string) (int, string, error) {
+	id = strings.ToLower(id)
+	if id == "" || strings.Trim(id, "0123456789abcdef") != "" {
+		return 0, "", fmt.Errorf("container ID %q is not hexadecimal", id)
+	}
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0, "", err
+	}
+
+	members := make(map[string][]int) // full ID -> PIDs in it
+	var full string                   // the last ID matched
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+		if err != nil {
+			continue
+		}
+		for _, cid := range cgroupContainerIDs(string(data)) {
+			if strings.HasPrefix(cid, id) {
+				members[cid] = append(members[cid], pid)
+				full = cid
+				break
+			}
+		}
+	}
+
+	if len(members) == 0 {
+		return 0, "", fmt.Errorf("no process found in a cgroup of container %s", id)
+	}
+	if len(members) > 1 {
+		return 0, "", fmt.Errorf("container ID %s is ambiguous (%d containers match)", id, len(members))
+	}
+	pids := members[full]
+
+	inside := make(map[int]struct{}, len(pids))
+	for _, pid := range pids {
+		inside[pid] = struct{}{}
+	}
+	// Several roots happen with `docker exec`; the init is the oldest
+	initPid := 0
+	var initStart uint64
+	for _, pid := range pids {
+		stat, err := readProcStat(pid)
+		if err != nil {
+			continue
+		}
+		if _, ok := inside[stat.Ppid]; ok {
+			continue
+		}
+		if initPid == 0 || stat.StartTime < initStart {
+			initPid, initStart = pid, stat.StartTime
+		}
+	}
+	if initPid == 0 {
+		return 0, "", fmt.Errorf("container %s has no readable init process", full)
+	}
+	return initPid, full, nil
+}
@@ -0,0 +1,108 @@
+// Convergence detection and the -on-converge hook
+//
+// The run is considered converged once the EMA dirty rate (see -ema-alpha)
+// has stayed below -converge-rate for -converge-samples consecutive
+// samples. That is the point where a pre-copy dump would have the least
+// left to retransmit, so -on-converge can fire the dump itself.
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// ConvergeEvent records when the run converged and what the hook did
+type ConvergeEvent struct {
+	TimestampMs float64 `json:"timestamp_ms"`
+	EMARate     float64 `json:"ema_rate_pages_per_sec"`
+	Command     string  `json:"command,omitempty"`
+	ExitCode    *int    `json:"exit_code,omitempty"`
+	Error       string  `json:"error,omitempty"`
+	DurationMs  float64 `json:"command_duration_ms,omitempty"`
+}
+
+// convergeState is the live rate tracking behind convergence detection
+type convergeState struct {
+	prevMs     float64
+	emaRate    float64
+	rateCount  int
+	belowCount int
+}
+
+// checkConvergence updates the live EMA rate with a new sample and fires
+// the convergence hook the first time the rate settles below threshold.
+func (dt *DirtyPageTracker) checkConvergence(sample *DirtySample, sampleIndex int) {
+	if dt.convergeRate <= 0 || dt.convergeEvent != nil {
+		return
+	}
+	st := &dt.converge
+	if sampleIndex == 0 || sample.discontinuous() {
+		st.prevMs = sample.TimestampMs
+		return
+	}
+
+	deltaSec := (sample.TimestampMs - st.prevMs) / 1000.0
+	st.prevMs = sample.TimestampMs
+	if deltaSec <= 0 {
+		return
+	}
+	rate := float64(sample.DeltaDirtyCount) / deltaSec
+	if st.rateCount == 0 {
+		st.emaRate = rate
+	} else {
+		st.emaRate = dt.emaAlpha*rate + (1-dt.emaAlpha)*st.emaRate
+	}
+	st.rateCount++
+
+	if st.emaRate >= dt.convergeRate {
+		st.belowCount = 0
+		return
+	}
+	st.belowCount++
+	if st.belowCount < dt.convergeSamples {
+		return
+	}
+
+	event := &ConvergeEvent{TimestampMs: sample.TimestampMs, EMARate: st.emaRate}
+	dt.convergeEvent = event
+	fmt.Fprintf(os.Stderr, "Converged at %.0fms (EMA rate %.1f pages/sec < %.1f)\n",
+		sample.TimestampMs, st.emaRate, dt.convergeRate)
+
+	if dt.onConverge != "" {
+		event.Command = dt.onConverge
+		dt.hookWG.Add(1)
+		go dt.runConvergeHook(event)
+	}
+	if dt.convergeStop {
+		dt.stopWithReason("converged")
+	}
+}
+
+// runConvergeHook runs the -on-converge command through sh with the root
+// PID as $1 and in DIRTY_TRACKER_PID, recording its exit status.
+func (dt *DirtyPageTracker) runConvergeHook(event *ConvergeEvent) {
+	defer dt.hookWG.Done()
+
+	pid := strconv.Itoa(dt.rootPid)
+	cmd := exec.Command("sh", "-c", dt.onConverge, "sh", pid)
+	cmd.Env = append(os.Environ(), "DIRTY_TRACKER_PID="+pid)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	event.DurationMs = float64(time.Since(start).Microseconds()) / 1000.0
+	if cmd.ProcessState != nil {
+		code := cmd.ProcessState.ExitCode()
+		event.ExitCode = &code
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	fmt.Fprintf(os.Stderr, "On-converge command finished: %v\n", cmd.ProcessState)
+}
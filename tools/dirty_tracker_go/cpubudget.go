@@ -0,0 +1,90 @@
+// Tracker CPU budget (-max-cpu-pct)
+//
+// After each sample the loop measures the CPU time the tracker itself used
+// for it (getrusage, all threads) and, if that exceeds the budget share of
+// the interval, stretches the wait before the next sample so that
+//
+//	tracker CPU per sample / effective interval <= max-cpu-pct / 100
+//
+// The stretch applies at once; when the cost drops the interval shrinks
+// back by a quarter per sample so it doesn't oscillate. It is capped at
+// cpuBudgetMaxStretch times -interval, below the default -watchdog limit.
+// Starting and ending a throttled stretch are logged to stderr. Only
+// wall-clock sampling is throttled; -cpu-interval already scales with the
+// workload.
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+const cpuBudgetMaxStretch = 8
+
+// TrackerCPUStats reports the tracker's own CPU use under -max-cpu-pct
+type TrackerCPUStats struct {
+	BudgetPct        float64 `json:"max_cpu_pct"`
+	AvgPct           float64 `json:"avg_cpu_pct"` // tracker CPU time over the run's wall time
+	ThrottledSamples int     `json:"throttled_samples"`
+	MaxIntervalMs    float64 `json:"max_interval_ms"`
+}
+
+// selfCPUTime returns the user plus system CPU time used by the tracker
+func selfCPUTime() time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+// cpuBudget adapts the sampling interval to the tracker's CPU cost
+type cpuBudget struct {
+	maxPct   float64
+	base     time.Duration // configured interval
+	cur      time.Duration // effective interval
+	startCPU time.Duration
+	start    time.Time
+	stats    TrackerCPUStats
+}
+
+func newCPUBudget(maxPct float64, interval time.Duration) *cpuBudget {
+	return &cpuBudget{
+		maxPct:   maxPct,
+		base:     interval,
+		cur:      interval,
+		startCPU: selfCPUTime(),
+		start:    time.Now(),
+		stats:    TrackerCPUStats{BudgetPct: maxPct},
+	}
+}
+
+// next returns the interval to use after a sample that cost sampleCPU
+func (b *cpuBudget) next(sampleCPU time.Duration) time.Duration {
+	need := time.Duration(float64(sampleCPU) * 100 / b.maxPct)
+	cur := min(max(b.base, need, b.cur*3/4), b.base*cpuBudgetMaxStretch)
+
+	if cur > b.base && b.cur == b.base {
+		fmt.Fprintf(os.Stderr, "Throttling: sampling took %v of CPU, stretching interval to %v to stay under %.1f%% CPU\n",
+			sampleCPU.Round(time.Microsecond), cur.Round(time.Millisecond), b.maxPct)
+	} else if cur == b.base && b.cur > b.base {
+		fmt.Fprintf(os.Stderr, "Throttling ended: interval back to %v\n", b.base)
+	}
+	b.cur = cur
+	if cur > b.base {
+		b.stats.ThrottledSamples++
+	}
+	b.stats.MaxIntervalMs = max(b.stats.MaxIntervalMs, float64(cur.Microseconds())/1000.0)
+	return cur
+}
+
+// finish returns the stats with the average overhead over the whole run
+func (b *cpuBudget) finish() *TrackerCPUStats {
+	stats := b.stats
+	if wall := time.Since(b.start); wall > 0 {
+		stats.AvgPct = float64(selfCPUTime()-b.startCPU) * 100 / float64(wall)
+	}
+	return &stats
+}
@@ -0,0 +1,193 @@
+// Sorted delta stream output (-format delta)
+//
+// The most compact form for consumers that only need the set of dirty
+// pages per sample. Each sample's distinct dirty pages are grouped by
+// containing VMA and stored as varint-encoded gaps between sorted page
+// indices. All integers are unsigned LEB128 varints (encoding/binary
+// Uvarint) unless noted:
+//
+//	magic         8 bytes  "DTDELTA2"
+//	page_size
+//	sample_count
+//	per sample:
+//	  timestamp_us          sample timestamp in microseconds
+//	  group_count
+//	  per VMA group, ascending by start:
+//	    start_gap           VMA start page number minus the previous
+//	                        group's (the first group stores it as is)
+//	    page_count          >= 1
+//	    first_index         first dirty page index within the VMA
+//	    gap_minus_one ...   page_count-1 entries: index - previous - 1
+//
+// Page numbers are addresses divided by page_size, the capturing host's
+// base page size. Pages dirtied by several processes at the same address
+// appear once. decodeDeltaStream (or the -decode-delta CLI mode) reads it
+// back, and still accepts "DTDELTA1" streams, which have no page_size
+// field and always used 4096.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+const (
+	deltaMagic   = "DTDELTA2"
+	deltaMagicV1 = "DTDELTA1" // implied 4 KiB pages
+)
+
+// DeltaSample is one decoded sample of a delta stream
+type DeltaSample struct {
+	TimestampMs float64  `json:"timestamp_ms"`
+	Pages       []uint64 `json:"pages"` // sorted page addresses
+}
+
+// encodeDeltaStream encodes the pattern's samples in the delta layout
+func encodeDeltaStream(pattern *DirtyPattern) []byte {
+	var buf bytes.Buffer
+	var tmp [binary.MaxVarintLen64]byte
+	put := func(v uint64) {
+		buf.Write(tmp[:binary.PutUvarint(tmp[:], v)])
+	}
+
+	buf.WriteString(deltaMagic)
+	put(PageSize)
+	put(uint64(len(pattern.Samples)))
+	for i := range pattern.Samples {
+		sample := &pattern.Samples[i]
+		put(uint64(sample.TimestampMs * 1000))
+
+		// Group distinct page numbers by containing VMA start page
+		groups := make(map[uint64][]uint64)
+		seen := make(map[uint64]struct{}, len(sample.DirtyPages))
+		for j := range sample.DirtyPages {
+			page := &sample.DirtyPages[j]
+			pageNum := page.Address() / PageSize
+			if _, dup := seen[pageNum]; dup {
+				continue
+			}
+			seen[pageNum] = struct{}{}
+			vmaStart := page.vmaStart
+			if vmaStart == 0 {
+				// Not from a live capture (e.g. decoded JSON); use the
+				// serialized VMA range, or the page itself as a last resort
+				if start, err := parseHexAddr(page.VMAStart); err == nil {
+					vmaStart = start
+				} else {
+					vmaStart = page.Address()
+				}
+			}
+			groups[vmaStart/PageSize] = append(groups[vmaStart/PageSize], pageNum)
+		}
+
+		starts := make([]uint64, 0, len(groups))
+		for start := range groups {
+			starts = append(starts, start)
+		}
+		sort.Slice(starts, func(a, b int) bool { return starts[a] < starts[b] })
+
+		put(uint64(len(starts)))
+		prevStart := uint64(0)
+		for _, start := range starts {
+			pages := groups[start]
+			sort.Slice(pages, func(a, b int) bool { return pages[a] < pages[b] })
+			put(start - prevStart)
+			prevStart = start
+			put(uint64(len(pages)))
+			put(pages[0] - start)
+			for k := 1; k < len(pages); k++ {
+				put(pages[k] - pages[k-1] - 1)
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+// decodeDeltaStream reads a delta stream back into per-sample page sets
+func decodeDeltaStream(r io.Reader) ([]DeltaSample, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(deltaMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || (string(magic) != deltaMagic && string(magic) != deltaMagicV1) {
+		return nil, errors.New("not a delta stream (bad magic)")
+	}
+	get := func(what string) (uint64, error) {
+		v, err := binary.ReadUvarint(br)
+		if err != nil {
+			return 0, fmt.Errorf("reading %s: %w", what, err)
+		}
+		return v, nil
+	}
+
+	pageSize := uint64(4096)
+	if string(magic) == deltaMagic {
+		var err error
+		if pageSize, err = get("page size"); err != nil {
+			return nil, err
+		}
+		if pageSize == 0 {
+			return nil, errors.New("delta stream has page size 0")
+		}
+	}
+	count, err := get("sample count")
+	if err != nil {
+		return nil, err
+	}
+	var samples []DeltaSample
+	for i := uint64(0); i < count; i++ {
+		ts, err := get("timestamp")
+		if err != nil {
+			return nil, err
+		}
+		sample := DeltaSample{TimestampMs: float64(ts) / 1000, Pages: []uint64{}}
+		groups, err := get("group count")
+		if err != nil {
+			return nil, err
+		}
+		start := uint64(0)
+		for g := uint64(0); g < groups; g++ {
+			gap, err := get("vma start")
+			if err != nil {
+				return nil, err
+			}
+			start += gap
+			n, err := get("page count")
+			if err != nil {
+				return nil, err
+			}
+			page := start
+			for k := uint64(0); k < n; k++ {
+				d, err := get("page index")
+				if err != nil {
+					return nil, err
+				}
+				if k == 0 {
+					page += d
+				} else {
+					page += d + 1
+				}
+				sample.Pages = append(sample.Pages, page*pageSize)
+			}
+		}
+		// Groups are ascending by VMA start and VMAs don't overlap, so
+		// this only matters for streams from other encoders
+		sort.Slice(sample.Pages, func(a, b int) bool { return sample.Pages[a] < sample.Pages[b] })
+		samples = append(samples, sample)
+	}
+	return samples, nil
+}
+
+// decodeDeltaFile decodes a delta stream file
+func decodeDeltaFile(path string) ([]DeltaSample, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return decodeDeltaStream(f)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// deltaPage is a dirty page at page number n of the VMA starting at page
+// number vma, as a live capture records it
+func deltaPage(vma, n uint64) DirtyPage {
+	return DirtyPage{
+		Addr:     fmt.Sprintf("0x%x", n*PageSize),
+		VMAStart: fmt.Sprintf("0x%x", vma*PageSize),
+		vmaStart: vma * PageSize,
+	}
+}
+
+func TestDeltaRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []DirtySample
+	}{
+		{"no samples", nil},
+		{"no pages", []DirtySample{{TimestampMs: 100, DirtyPages: []DirtyPage{}}}},
+		{"one VMA", []DirtySample{{TimestampMs: 100, DirtyPages: []DirtyPage{
+			deltaPage(16, 16), deltaPage(16, 17), deltaPage(16, 20),
+		}}}},
+		{"several VMAs, unsorted", []DirtySample{{TimestampMs: 100.25, DirtyPages: []DirtyPage{
+			deltaPage(64, 70), deltaPage(16, 18), deltaPage(64, 64), deltaPage(16, 16),
+		}}}},
+		{"same address in two processes", []DirtySample{{TimestampMs: 100, DirtyPages: []DirtyPage{
+			deltaPage(16, 17), deltaPage(16, 17), deltaPage(16, 19),
+		}}}},
+		{"VMA only serialized", []DirtySample{{TimestampMs: 100, DirtyPages: []DirtyPage{
+			{Addr: fmt.Sprintf("0x%x", 33*PageSize), VMAStart: fmt.Sprintf("0x%x", 32*PageSize)},
+			{Addr: fmt.Sprintf("0x%x", 40*PageSize), VMAStart: fmt.Sprintf("0x%x", 32*PageSize)},
+		}}}},
+		{"no VMA", []DirtySample{{TimestampMs: 100, DirtyPages: []DirtyPage{
+			{Addr: fmt.Sprintf("0x%x", 5*PageSize)},
+			{Addr: fmt.Sprintf("0x%x", 3*PageSize)},
+		}}}},
+		{"5-level paging addresses", []DirtySample{{TimestampMs: 100, DirtyPages: []DirtyPage{
+			deltaPage(0x1fffffffffe000/PageSize, 0x1fffffffffe000/PageSize),
+			deltaPage(0x7ffc1a2b3000/PageSize, 0x7ffc1a2b4000/PageSize),
+		}}}},
+		{"several samples", []DirtySample{
+			{TimestampMs: 0, DirtyPages: []DirtyPage{deltaPage(16, 16)}},
+			{TimestampMs: 100.5, DirtyPages: []DirtyPage{}},
+			{TimestampMs: 201, DirtyPages: []DirtyPage{deltaPage(16, 17), deltaPage(64, 65)}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pattern := DirtyPattern{Samples: tt.samples}
+			decoded, err := decodeDeltaStream(bytes.NewReader(encodeDeltaStream(&pattern)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(decoded) != len(pattern.Samples) {
+				t.Fatalf("%d samples, want %d", len(decoded), len(pattern.Samples))
+			}
+			for i := range pattern.Samples {
+				if got, want := decoded[i].TimestampMs, pattern.Samples[i].TimestampMs; got != want {
+					t.Errorf("sample %d: timestamp %v, want %v", i, got, want)
+				}
+				want := samplePageNumbers(&pattern.Samples[i])
+				got := decoded[i].Pages
+				if len(got) != len(want) {
+					t.Errorf("sample %d: %d pages, want %d", i, len(got), len(want))
+					continue
+				}
+				for j := range want {
+					if got[j] != want[j]*PageSize {
+						t.Errorf("sample %d: page %d is %#x, want %#x", i, j, got[j], want[j]*PageSize)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDeltaDecodeV1(t *testing.T) {
+	// DTDELTA1: no page size field, 4 KiB pages; one sample at 1 ms with
+	// pages 16 and 18 of the VMA at page 16
+	stream := append([]byte(deltaMagicV1), 1, 0xe8, 0x07, 1, 16, 2, 0, 1)
+	decoded, err := decodeDeltaStream(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 1 || decoded[0].TimestampMs != 1 || len(decoded[0].Pages) != 2 ||
+		decoded[0].Pages[0] != 16*4096 || decoded[0].Pages[1] != 18*4096 {
+		t.Errorf("decoded %+v", decoded)
+	}
+}
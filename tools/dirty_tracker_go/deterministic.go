@@ -0,0 +1,136 @@
+// Deterministic output (-deterministic)
+//
+// For golden-file tests the output must not depend on wall-clock timing,
+// the host, or the order processes were read in. With -deterministic:
+//
+//   - sample timestamps are replaced by the nominal schedule, sample i at
+//     i * interval_ms, before anything is derived from them, so the
+//     duration, rates, timeline, bins, and interval statistics follow the
+//     schedule; fork events take the time of their first sample
+//   - cpu_time_ms, io, psi, and wall_time_ms of every sample are dropped,
+//     as are tracker_cpu, metadata.runtime, and metadata.start_delay_ms
+//   - pause, restore, and convergence event times and the convergence
+//     command's duration are zeroed
+//   - metadata keeps only tool_version, page_size, container_id, and
+//     cmdline_regex; start_time and the host and build fields are cleared
+//   - dirty pages within a sample are sorted by process, then address;
+//     pids_tracked, idle_pids, pre_tracking_pids, total_pids_seen, and
+//     access_lost_pids are sorted; fork events are sorted by time, then pid
+//   - -anonymize-paths uses a fixed all-zero key, so labels are the same
+//     in every run (and, unlike normal runs, can be reversed by guessing)
+//
+// JSON maps are already written with sorted keys. Page counts, PIDs, and
+// the number of samples still come from the tracked processes, so the
+// output is byte-stable only for a reproducible input; only the final
+// output is normalized, not -stream or -live-csv.
+package main
+
+import (
+	"sort"
+)
+
+// normalizeTiming puts the samples on the nominal schedule. Called before
+// GetDirtyPattern so that everything derived from timestamps follows it.
+func (dt *DirtyPageTracker) normalizeTiming() {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+
+	elapsedMs := 0.0
+	for i := range dt.samples {
+		if i > 0 {
+			// Under -schedule each sample has its own interval
+			if ms := dt.samples[i].IntervalMs; ms > 0 {
+				elapsedMs += ms
+			} else {
+				elapsedMs += float64(dt.intervalMs)
+			}
+		}
+		dt.samples[i].TimestampMs = elapsedMs
+		dt.samples[i].CPUTimeMs = 0
+		dt.samples[i].AccumulatedMs = 0
+		dt.samples[i].IO = nil
+		dt.samples[i].PSI = nil
+		dt.samples[i].WallTimeMs = 0
+	}
+	for i := range dt.forkEvents {
+		if idx := dt.forkEvents[i].firstSampleIndex; idx < len(dt.samples) {
+			dt.forkEvents[i].TimestampMs = dt.samples[idx].TimestampMs
+		}
+	}
+	for i := range dt.regionMoves {
+		dt.regionMoves[i].TimestampMs = dt.samples[dt.regionMoves[i].sampleIndex].TimestampMs
+	}
+	if dt.alerter != nil {
+		for i := range dt.alerter.events {
+			dt.alerter.events[i].TimestampMs = dt.samples[dt.alerter.events[i].sampleIndex].TimestampMs
+		}
+	}
+}
+
+// makeDeterministic clears and sorts what normalizeTiming can't reach
+func makeDeterministic(p *DirtyPattern) {
+	p.TrackerCPU = nil
+	for i := range p.PauseEvents {
+		p.PauseEvents[i].TimestampMs = 0
+	}
+	for i := range p.RestoreEvents {
+		p.RestoreEvents[i].TimestampMs = 0
+	}
+	for i := range p.VforkEvents {
+		p.VforkEvents[i].TimestampMs = 0
+		p.VforkEvents[i].EndMs = 0
+	}
+	if p.Converged != nil {
+		p.Converged.TimestampMs = 0
+		p.Converged.DurationMs = 0
+	}
+	if p.UniqueTarget != nil {
+		p.UniqueTarget.TimestampMs = 0
+	}
+	if p.Metadata != nil {
+		p.Metadata = &Metadata{
+			ToolVersion:  p.Metadata.ToolVersion,
+			PageSize:     p.Metadata.PageSize,
+			ContainerID:  p.Metadata.ContainerID,
+			CmdlineRegex: p.Metadata.CmdlineRegex,
+			Pgid:         p.Metadata.Pgid,
+			ExperimentID: p.Metadata.ExperimentID,
+		}
+	}
+
+	for i := range p.Samples {
+		sample := &p.Samples[i]
+		sort.SliceStable(sample.DirtyPages, func(a, b int) bool {
+			pa, pb := &sample.DirtyPages[a], &sample.DirtyPages[b]
+			if pa.pid != pb.pid {
+				return pa.pid < pb.pid
+			}
+			return pa.Address() < pb.Address()
+		})
+		sort.Ints(sample.PidsTracked)
+		sort.Ints(sample.IdlePids)
+		sort.Ints(sample.PreTrackingPids)
+	}
+	sort.Ints(p.Summary.TotalPidsSeen)
+	sort.Ints(p.AccessLostPids)
+	sort.SliceStable(p.ForkEvents, func(a, b int) bool {
+		fa, fb := &p.ForkEvents[a], &p.ForkEvents[b]
+		if fa.TimestampMs != fb.TimestampMs {
+			return fa.TimestampMs < fb.TimestampMs
+		}
+		return fa.Pid < fb.Pid
+	})
+	sort.SliceStable(p.RegionMoves, func(a, b int) bool {
+		ma, mb := &p.RegionMoves[a], &p.RegionMoves[b]
+		if ma.TimestampMs != mb.TimestampMs {
+			return ma.TimestampMs < mb.TimestampMs
+		}
+		if ma.Pid != mb.Pid {
+			return ma.Pid < mb.Pid
+		}
+		return ma.From < mb.From
+	})
+	sort.SliceStable(p.VforkEvents, func(a, b int) bool {
+		return p.VforkEvents[a].ChildPid < p.VforkEvents[b].ChildPid
+	})
+}
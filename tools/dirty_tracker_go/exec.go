@@ -0,0 +1,91 @@
+// Launched workload (-exec)
+//
+// With -exec the tracker starts the workload itself instead of attaching
+// to a running -pid. The command is the arguments after the flags:
+//
+//	dirty_tracker -exec -duration 30 -children -- ./workload --size 1G
+//
+// It is started directly, not through a shell, so the tracked PID is the
+// workload's own from the first sample rather than a shell that has yet
+// to exec it. The workload runs in its own process group with the
+// tracker's stdin and stderr; its stdout goes to stderr so it doesn't mix
+// with output written to stdout. The capture ends at -duration or when the
+// workload exits, whichever comes first (stop_reason "workload_exit"). A
+// workload still running then gets SIGTERM, and SIGKILL after
+// workloadGrace; should the tracker die first, the kernel kills it.
+//
+// Under -repeat every repetition launches the workload anew, so the
+// spread is over separate runs of it.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// workloadGrace is how long a workload still running at the end of the
+// capture has to exit after SIGTERM
+const workloadGrace = 5 * time.Second
+
+// startWorkload starts the -exec command
+func startWorkload(args []string) (*exec.Cmd, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pdeathsig: syscall.SIGKILL}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// watchWorkload stops the run when the workload exits. The returned
+// channel is closed once it has been waited for.
+func (dt *DirtyPageTracker) watchWorkload(cmd *exec.Cmd) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(done)
+		fmt.Fprintf(os.Stderr, "Workload %s\n", cmd.ProcessState)
+		dt.stopWithReason("workload_exit")
+	}()
+	return done
+}
+
+// endWorkload terminates the workload's process group unless it has
+// already exited
+func endWorkload(cmd *exec.Cmd, done <-chan struct{}) {
+	select {
+	case <-done:
+		return
+	default:
+	}
+	fmt.Fprintf(os.Stderr, "Stopping workload (PID %d)\n", cmd.Process.Pid)
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	select {
+	case <-done:
+	case <-time.After(workloadGrace):
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+	}
+}
+
+// reexecArgs returns the command line's flags followed by extra flags and
+// then any arguments after the flags (the -exec command), for running the
+// tracker again with some flags overridden
+func reexecArgs(extra ...string) []string {
+	flagArgs := os.Args[1 : len(os.Args)-flag.NArg()]
+	if n := len(flagArgs); n > 0 && flagArgs[n-1] == "--" {
+		flagArgs = flagArgs[:n-1]
+	}
+	args := append(append([]string{}, flagArgs...), extra...)
+	if flag.NArg() > 0 {
+		args = append(append(args, "--"), flag.Args()...)
+	}
+	return args
+}
@@ -0,0 +1,227 @@
+// Summary expectations (-expect)
+//
+// For CI that guards a workload's dirtying behaviour, -expect FILE checks
+// the run's summary against expected values once the output is written,
+// prints every field out of bounds, and exits non-zero if there was one.
+// The file is JSON:
+//
+//	{
+//	  "default_tolerance_pct": 10,
+//	  "fields": {
+//	    "avg_dirty_rate_per_sec": {"value": 1500},
+//	    "peak_dirty_rate": {"value": 4000, "tolerance_pct": 25},
+//	    "sample_count": {"value": 100, "tolerance": 2},
+//	    "total_unique_pages": {"min": 1000, "max": 20000},
+//	    "vma_distribution.heap": {"min": 0.5},
+//	    "maps_explosion_detected": {"value": false}
+//	  },
+//	  "summary": {...}
+//	}
+//
+// Only the fields listed are checked, by their JSON name in the summary
+// (top-level capture fields such as clear_mode or stop_reason aren't
+// reachable); a dotted name reaches into an object such as
+// vma_distribution or page_tables. A numeric field passes within
+// tolerance (absolute) or tolerance_pct (of the value), whichever is
+// larger, of the expected value, and within min and max, inclusive, when
+// given; with neither tolerance, default_tolerance_pct applies, and
+// without that the value must match exactly. Other values (strings,
+// booleans, lists) must be equal. A field without value, min, or max
+// takes its value from the optional "summary" object, so the summary of a
+// known-good capture can be pasted in and the fields to check picked out.
+// A field the summary leaves out because it is empty counts as 0, false,
+// or "", while an unknown top-level name is an error when the file is
+// loaded, to catch typos.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// FieldExpectation bounds one summary field
+type FieldExpectation struct {
+	Value        any      `json:"value,omitempty"`
+	Tolerance    *float64 `json:"tolerance,omitempty"`
+	TolerancePct *float64 `json:"tolerance_pct,omitempty"`
+	Min          *float64 `json:"min,omitempty"`
+	Max          *float64 `json:"max,omitempty"`
+}
+
+// SummaryExpectations is an -expect file
+type SummaryExpectations struct {
+	DefaultTolerancePct float64                     `json:"default_tolerance_pct,omitempty"`
+	Fields              map[string]FieldExpectation `json:"fields"`
+	Summary             map[string]any              `json:"summary,omitempty"`
+}
+
+// summaryFieldNames returns the JSON names of the Summary fields
+func summaryFieldNames() map[string]struct{} {
+	names := make(map[string]struct{})
+	t := reflect.TypeOf(Summary{})
+	for i := 0; i < t.NumField(); i++ {
+		if name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ","); name != "" && name != "-" {
+			names[name] = struct{}{}
+		}
+	}
+	return names
+}
+
+// lookupPath follows a dotted field name through decoded JSON
+func lookupPath(doc map[string]any, path string) (any, bool) {
+	var v any = doc
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if v, ok = obj[key]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// loadExpectations reads and checks an -expect file
+func loadExpectations(path string) (*SummaryExpectations, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var e SummaryExpectations
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(e.Fields) == 0 {
+		return nil, fmt.Errorf("%s: no fields to check", path)
+	}
+	known := summaryFieldNames()
+	for name, f := range e.Fields {
+		top, _, _ := strings.Cut(name, ".")
+		if _, ok := known[top]; !ok {
+			return nil, fmt.Errorf("%s: %q is not a summary field", path, name)
+		}
+		if f.Value == nil && f.Min == nil && f.Max == nil {
+			v, ok := lookupPath(e.Summary, name)
+			if !ok {
+				return nil, fmt.Errorf("%s: %q has no value, min, or max, and isn't in the summary", path, name)
+			}
+			f.Value = v
+			e.Fields[name] = f
+		}
+	}
+	return &e, nil
+}
+
+// check compares summary with the expectations and returns a line per
+// field out of bounds, in name order
+func (e *SummaryExpectations) check(summary *Summary) ([]string, error) {
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(e.Fields))
+	for name := range e.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var failures []string
+	for _, name := range names {
+		f := e.Fields[name]
+		actual, found := lookupPath(doc, name)
+		want, numeric := f.Value.(float64)
+		if f.Value == nil || numeric {
+			got := 0.0
+			if found {
+				var ok bool
+				if got, ok = actual.(float64); !ok {
+					failures = append(failures, fmt.Sprintf("%s = %v, expected a number", name, jsonText(actual)))
+					continue
+				}
+			}
+			lo, hi := math.Inf(-1), math.Inf(1)
+			if numeric {
+				slack := 0.0
+				if f.Tolerance != nil {
+					slack = *f.Tolerance
+				}
+				if f.TolerancePct != nil || f.Tolerance == nil {
+					pct := e.DefaultTolerancePct
+					if f.TolerancePct != nil {
+						pct = *f.TolerancePct
+					}
+					slack = max(slack, math.Abs(want)*pct/100)
+				}
+				lo, hi = want-slack, want+slack
+			}
+			if f.Min != nil {
+				lo = max(lo, *f.Min)
+			}
+			if f.Max != nil {
+				hi = min(hi, *f.Max)
+			}
+			if got >= lo && got <= hi {
+				continue
+			}
+			switch {
+			case math.IsInf(lo, -1):
+				failures = append(failures, fmt.Sprintf("%s = %g, expected at most %g", name, got, hi))
+			case math.IsInf(hi, 1):
+				failures = append(failures, fmt.Sprintf("%s = %g, expected at least %g", name, got, lo))
+			default:
+				failures = append(failures, fmt.Sprintf("%s = %g, expected %g to %g", name, got, lo, hi))
+			}
+			continue
+		}
+		if !found {
+			switch f.Value.(type) {
+			case bool:
+				actual, found = false, true
+			case string:
+				actual, found = "", true
+			}
+		}
+		if !found || !reflect.DeepEqual(actual, f.Value) {
+			failures = append(failures, fmt.Sprintf("%s = %s, expected %s", name, jsonText(actual), jsonText(f.Value)))
+		}
+	}
+	return failures, nil
+}
+
+// jsonText renders a decoded JSON value for a message
+func jsonText(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+// checkExpectations prints the fields of summary out of bounds and reports
+// whether there were none
+func checkExpectations(e *SummaryExpectations, summary *Summary) bool {
+	failures, err := e.check(summary)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error checking -expect: %v\n", err)
+		return false
+	}
+	for _, msg := range failures {
+		fmt.Fprintf(os.Stderr, "Expectation failed: %s\n", msg)
+	}
+	if len(failures) > 0 {
+		fmt.Fprintf(os.Stderr, "Error: %d of %d expectations failed\n", len(failures), len(e.Fields))
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "All %d expectations met\n", len(e.Fields))
+	return true
+}
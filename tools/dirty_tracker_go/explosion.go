@@ -0,0 +1,57 @@
+// Maps explosions (-maps-explosion-vmas, -max-read-buffer-pages)
+//
+// A workload that suddenly maps tens of thousands of regions, or reserves
+// a huge writable range, used to make every read allocate a pagemap
+// buffer sized for its largest VMA (8 bytes per page, so 2 GiB for a 1 TiB
+// reservation) and could take the tracker down mid-capture. Instead:
+//
+//   - the pagemap buffer is capped at -max-read-buffer-pages entries,
+//     reused across reads, and larger VMAs are read in passes of that size
+//   - a process with more than -maps-explosion-vmas VMAs is reported once
+//     with a warning and sets summary.maps_explosion_detected; tracking
+//     goes on, but each read of it takes correspondingly longer, so
+//     intervals may be missed
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// mapsExplosion records processes over ReadOptions.ExplosionVMAs. It is
+// shared by all ProcessTrackers of a DirtyPageTracker.
+type mapsExplosion struct {
+	warned  map[int]struct{}
+	maxVMAs int // largest VMA count seen over the threshold
+}
+
+func newMapsExplosion() *mapsExplosion {
+	return &mapsExplosion{warned: make(map[int]struct{})}
+}
+
+// note records a read of pid with n VMAs
+func (e *mapsExplosion) note(pid, n, threshold int) {
+	if e == nil || threshold <= 0 || n <= threshold {
+		return
+	}
+	e.maxVMAs = max(e.maxVMAs, n)
+	if _, ok := e.warned[pid]; ok {
+		return
+	}
+	e.warned[pid] = struct{}{}
+	fmt.Fprintf(os.Stderr, "Warning: process %d has %d VMAs (over -maps-explosion-vmas %d); continuing, but samples may be slow\n",
+		pid, n, threshold)
+}
+
+// readBuffer returns a pagemap buffer for VMAs of up to maxPages pages,
+// capped at opts.MaxBufferPages entries and reused between reads
+func (pt *ProcessTracker) readBuffer(maxPages int) []byte {
+	if pt.opts.MaxBufferPages > 0 {
+		maxPages = min(maxPages, pt.opts.MaxBufferPages)
+	}
+	size := maxPages * PagemapEntrySize
+	if cap(pt.readBuf) < size {
+		pt.readBuf = make([]byte, size)
+	}
+	return pt.readBuf[:size]
+}
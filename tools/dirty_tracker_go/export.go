@@ -0,0 +1,361 @@
+// Columnar CSV export (-format csv)
+//
+// The tool has no third-party dependencies, so instead of writing Arrow or
+// Feather directly it writes flat CSV tables with a fixed schema. They load
+// straight into pandas/polars, and convert to Arrow with e.g.
+//
+//	pyarrow.csv.read_csv("run.timeline.csv").to_pandas()
+//	pyarrow.feather.write_feather(pyarrow.csv.read_csv("run.pages.csv"), "run.pages.feather")
+//
+// Given -output run.json, three files are written next to it:
+//
+// run.samples.csv, one row per sample:
+//
+//	sample_index       int64
+//	timestamp_ms       float64
+//	delta_dirty_count  int64
+//	processes_tracked  int64
+//	pids_tracked       string   space-separated PIDs
+//
+// run.pages.csv, one row per dirty page per sample:
+//
+//	sample_index       int64    joins run.samples.csv
+//	timestamp_ms       float64
+//	addr               string   hex, e.g. 0x7f12a000
+//	vma_type           string
+//	vma_perms          string
+//	pathname           string
+//	size               int64    bytes
+//
+// run.timeline.csv, one row per dirty_rate_timeline entry:
+//
+//	timestamp_ms            float64
+//	rate_pages_per_sec      float64
+//	ema_rate_pages_per_sec  float64
+//	cumulative_pages        int64
+//	processes_tracked       int64
+//	touched_regions         int64    0 unless -granularity 2M
+//
+// -format folded instead writes one "vma_type;pathname bytes" line per
+// backing path, the folded-stack input of flamegraph.pl:
+//
+//	./dirty_tracker -pid 1234 -format folded -output run.folded
+//	flamegraph.pl --countname bytes run.folded > run.svg
+//
+// -format influx writes the dirty rate timeline as InfluxDB line protocol,
+// one record per sample with nanosecond timestamps:
+//
+//	dirty_rate,workload=redis,pids=3 rate=120.5,ema_rate=98.2,cumulative=4210i,delta=12i 1700000000123456789
+//
+// and can be loaded with e.g. influx write -b <bucket> -f run.lp.
+//
+// -format matrix writes a sparse sample x address-bucket matrix of dirty
+// page counts in COO form. Buckets are the -granularity regions (pages by
+// default, 2M regions with -granularity 2M); only buckets dirtied at least
+// once get a column, numbered in address order. Rows are sample indices,
+// including samples with nothing dirty. Two # header lines give the shape
+// and the start address of each column:
+//
+//	# rows=120 cols=3 nnz=5 bucket_bytes=2097152
+//	# col_addrs=0x55d0a0000000,0x55d0a0200000,0x7f12a0000000
+//	row,col,value
+//	0,0,12
+//	0,2,1
+//
+// and load with e.g. pandas.read_csv("run.coo", comment="#") into
+// scipy.sparse.coo_matrix((value, (row, col)), shape=(rows, cols)).
+//
+// -format vma-csv writes one row per sample with that sample's dirty page
+// count per VMA type, ready for a stacked-area chart. The type columns
+// are sorted and cover every type dirtied during the run, and only listed
+// pages count (see -max-pages-per-sample):
+//
+//	timestamp_ms,anonymous,heap,stack
+//	0.213,0,0,0
+//	100.481,96,24,1
+//
+// Whatever the format, -summary-line also prints one line of key=value
+// pairs to stdout after the run, for shell loops:
+//
+//	workload=redis root_pid=1234 samples=100 duration_ms=9901.2 unique_pages=4210 dirty_events=9811 avg_rate=990.9 peak_rate=2410.0 procs=3 stop_reason=duration
+//
+// The keys and their order are fixed. A value with spaces, quotes, or "="
+// is Go-quoted.
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// csvTablePaths returns the per-table CSV paths derived from -output
+func csvTablePaths(output string) (samples, pages, timeline string) {
+	base := strings.TrimSuffix(output, filepath.Ext(output))
+	return base + ".samples.csv", base + ".pages.csv", base + ".timeline.csv"
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// writeCSVTables writes the pattern as CSV tables and returns their paths
+func writeCSVTables(pattern *DirtyPattern, output string) ([]string, error) {
+	samplesPath, pagesPath, timelinePath := csvTablePaths(output)
+
+	err := writeCSVFile(samplesPath, []string{
+		"sample_index", "timestamp_ms", "delta_dirty_count", "processes_tracked", "pids_tracked",
+	}, func(w *csv.Writer) error {
+		for i, sample := range pattern.Samples {
+			pids := make([]string, len(sample.PidsTracked))
+			for j, pid := range sample.PidsTracked {
+				pids[j] = strconv.Itoa(pid)
+			}
+			if err := w.Write([]string{
+				strconv.Itoa(i),
+				formatFloat(sample.TimestampMs),
+				strconv.Itoa(sample.DeltaDirtyCount),
+				strconv.Itoa(len(sample.PidsTracked)),
+				strings.Join(pids, " "),
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeCSVFile(pagesPath, []string{
+		"sample_index", "timestamp_ms", "addr", "vma_type", "vma_perms", "pathname", "size",
+	}, func(w *csv.Writer) error {
+		for i, sample := range pattern.Samples {
+			ts := formatFloat(sample.TimestampMs)
+			for _, page := range sample.DirtyPages {
+				if err := w.Write([]string{
+					strconv.Itoa(i), ts, page.Addr, page.VMAType, page.VMAPerms, page.Pathname, strconv.Itoa(page.Size),
+				}); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeTimelineCSV(pattern, timelinePath); err != nil {
+		return nil, err
+	}
+
+	return []string{samplesPath, pagesPath, timelinePath}, nil
+}
+
+// writeTimelineCSV writes the run.timeline.csv table
+func writeTimelineCSV(pattern *DirtyPattern, path string) error {
+	return writeCSVFile(path, []string{
+		"timestamp_ms", "rate_pages_per_sec", "ema_rate_pages_per_sec", "cumulative_pages", "processes_tracked", "touched_regions",
+	}, func(w *csv.Writer) error {
+		for _, entry := range pattern.DirtyRateTimeline {
+			if err := w.Write([]string{
+				formatFloat(entry.TimestampMs),
+				formatFloat(entry.RatePagesPerSec),
+				formatFloat(entry.EMARate),
+				strconv.Itoa(entry.CumulativePages),
+				strconv.Itoa(entry.ProcessesTracked),
+				strconv.Itoa(entry.TouchedRegions),
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// foldedStacks totals dirty bytes over all samples by VMA type and backing
+// path and renders them as folded stacks, sorted for stable output.
+// Anonymous mappings have no path and appear as "[anon]".
+func foldedStacks(pattern *DirtyPattern) []byte {
+	totals := make(map[string]int)
+	for _, sample := range pattern.Samples {
+		for _, page := range sample.DirtyPages {
+			path := page.Pathname
+			if path == "" {
+				path = "[anon]"
+			}
+			// ';' separates frames in the folded format
+			stack := strings.ReplaceAll(page.VMAType, ";", "_") + ";" + strings.ReplaceAll(path, ";", "_")
+			totals[stack] += page.Size
+		}
+	}
+
+	stacks := make([]string, 0, len(totals))
+	for stack := range totals {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+
+	var b strings.Builder
+	for _, stack := range stacks {
+		fmt.Fprintf(&b, "%s %d\n", stack, totals[stack])
+	}
+	return []byte(b.String())
+}
+
+// sparseMatrix renders the -format matrix COO table
+func sparseMatrix(pattern *DirtyPattern) []byte {
+	bucket := PageSize
+	if pattern.Summary.RegionSizeBytes > 0 {
+		bucket = uint64(pattern.Summary.RegionSizeBytes)
+	}
+
+	rows := make([]map[uint64]int, len(pattern.Samples))
+	buckets := make(map[uint64]struct{})
+	for i := range pattern.Samples {
+		rows[i] = make(map[uint64]int)
+		for _, page := range samplePageNumbers(&pattern.Samples[i]) {
+			b := page * PageSize / bucket
+			rows[i][b]++
+			buckets[b] = struct{}{}
+		}
+	}
+
+	// Dense column numbers in address order
+	cols := sortedAddrs(buckets)
+	colOf := make(map[uint64]int, len(cols))
+	colAddrs := make([]string, len(cols))
+	for i, b := range cols {
+		colOf[b] = i
+		colAddrs[i] = fmt.Sprintf("0x%x", b*bucket)
+	}
+	nnz := 0
+	for _, row := range rows {
+		nnz += len(row)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# rows=%d cols=%d nnz=%d bucket_bytes=%d\n", len(rows), len(cols), nnz, bucket)
+	fmt.Fprintf(&b, "# col_addrs=%s\n", strings.Join(colAddrs, ","))
+	b.WriteString("row,col,value\n")
+	for i, row := range rows {
+		// Column order is bucket order
+		rowBuckets := make([]uint64, 0, len(row))
+		for bkt := range row {
+			rowBuckets = append(rowBuckets, bkt)
+		}
+		sort.Slice(rowBuckets, func(x, y int) bool { return rowBuckets[x] < rowBuckets[y] })
+		for _, bkt := range rowBuckets {
+			fmt.Fprintf(&b, "%d,%d,%d\n", i, colOf[bkt], row[bkt])
+		}
+	}
+	return []byte(b.String())
+}
+
+// sampleTypeCounts partitions a sample's listed dirty pages by VMA type
+func sampleTypeCounts(sample *DirtySample) map[string]int {
+	counts := make(map[string]int)
+	for i := range sample.DirtyPages {
+		counts[sample.DirtyPages[i].VMAType]++
+	}
+	return counts
+}
+
+// vmaTypeCSV renders the -format vma-csv table
+func vmaTypeCSV(pattern *DirtyPattern) []byte {
+	rows := make([]map[string]int, len(pattern.Samples))
+	seen := make(map[string]struct{})
+	for i := range pattern.Samples {
+		rows[i] = sampleTypeCounts(&pattern.Samples[i])
+		for vmaType := range rows[i] {
+			seen[vmaType] = struct{}{}
+		}
+	}
+	types := make([]string, 0, len(seen))
+	for vmaType := range seen {
+		types = append(types, vmaType)
+	}
+	sort.Strings(types)
+
+	var b strings.Builder
+	b.WriteString("timestamp_ms")
+	for _, vmaType := range types {
+		b.WriteString("," + vmaType)
+	}
+	b.WriteString("\n")
+	for i, row := range rows {
+		b.WriteString(formatFloat(pattern.Samples[i].TimestampMs))
+		for _, vmaType := range types {
+			b.WriteString("," + strconv.Itoa(row[vmaType]))
+		}
+		b.WriteString("\n")
+	}
+	return []byte(b.String())
+}
+
+// influxTagEscaper escapes tag values for line protocol
+var influxTagEscaper = strings.NewReplacer(",", "\\,", "=", "\\=", " ", "\\ ")
+
+// influxLines renders the dirty rate timeline as line protocol. Sample
+// timestamps are relative, so start anchors them in wall-clock time.
+func influxLines(pattern *DirtyPattern, start time.Time) []byte {
+	workload := influxTagEscaper.Replace(pattern.Workload)
+	if workload == "" {
+		workload = "unknown"
+	}
+
+	var b strings.Builder
+	for i, entry := range pattern.DirtyRateTimeline {
+		delta := 0
+		if i < len(pattern.Samples) {
+			delta = pattern.Samples[i].DeltaDirtyCount
+		}
+		ts := start.Add(time.Duration(entry.TimestampMs * float64(time.Millisecond)))
+		fmt.Fprintf(&b, "dirty_rate,workload=%s,pids=%d rate=%s,ema_rate=%s,cumulative=%di,delta=%di %d\n",
+			workload, entry.ProcessesTracked, formatFloat(entry.RatePagesPerSec), formatFloat(entry.EMARate),
+			entry.CumulativePages, delta, ts.UnixNano())
+	}
+	return []byte(b.String())
+}
+
+// summaryLine renders the -summary-line key=value line, without newline
+func summaryLine(pattern *DirtyPattern) string {
+	value := func(s string) string {
+		if s == "" || strings.ContainsAny(s, " \t=\"") {
+			return strconv.Quote(s)
+		}
+		return s
+	}
+	s := &pattern.Summary
+	return fmt.Sprintf("workload=%s root_pid=%d samples=%d duration_ms=%.1f unique_pages=%d dirty_events=%d avg_rate=%.1f peak_rate=%.1f procs=%d stop_reason=%s",
+		value(pattern.Workload), pattern.RootPid, s.SampleCount, pattern.TrackingDurationMs, s.TotalUniquePages,
+		s.TotalDirtyEvents, s.AvgDirtyRatePerSec, s.PeakDirtyRate, s.MaxProcessesTracked, value(pattern.StopReason))
+}
+
+// writeCSVFile creates path, writes the header, then lets rows fill it in
+func writeCSVFile(path string, header []string, rows func(*csv.Writer) error) error {
+	f, err := createAtomic(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	if err := w.Write(header); err != nil {
+		f.abort()
+		return err
+	}
+	if err := rows(w); err != nil {
+		f.abort()
+		return err
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.abort()
+		return err
+	}
+	return f.commit(false)
+}
@@ -0,0 +1,66 @@
+// Tracked-process cap (-max-tracked)
+//
+// Every tracked process holds file descriptors open (pagemap, clear_refs,
+// and its pidfd, plus /proc/[pid]/mem with -detect-zero), so a wide or
+// fork-bombing tree can run the tracker out of descriptors, after which
+// every Open fails and new processes would look dead. The tracker
+// therefore tracks at most -max-tracked processes; the default, 0, takes
+// as many as its RLIMIT_NOFILE soft limit allows. The Go runtime (1.19 and
+// later) has already raised that to the hard limit at startup, and
+// restores the original for the commands the tracker runs (hooks, -helper,
+// -post-process), which it stops doing once the program calls Setrlimit
+// itself, so the limit is only read here.
+//
+// At the cap a newly discovered process is not tracked, so the root and
+// the processes tracked longest are kept. It is not marked dead either:
+// once a tracked process exits, the next discovery pass can take its
+// place. Each process turned away (by the cap or by EMFILE/ENFILE from
+// Open) is counted once in the summary's cap_skipped_processes, and the
+// first is warned about.
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+const (
+	fdsPerTracker = 3  // pagemap, clear_refs, pidfd
+	fdReserve     = 64 // stdio, outputs, sinks, /proc reads
+)
+
+// fdLimit returns the soft RLIMIT_NOFILE
+func fdLimit() (uint64, error) {
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil {
+		return 0, err
+	}
+	return lim.Cur, nil
+}
+
+// fdTrackerBudget returns how many processes fit in a descriptor limit
+// when each takes perTracker descriptors
+func fdTrackerBudget(limit uint64, perTracker int) int {
+	if limit <= fdReserve {
+		return 1
+	}
+	return max(int((limit-fdReserve)/uint64(perTracker)), 1)
+}
+
+// atCapacity reports whether pid must be turned away by -max-tracked.
+// The root is always admitted.
+func (dt *DirtyPageTracker) atCapacity(pid int) bool {
+	return dt.maxTracked > 0 && pid != dt.rootPid && len(dt.trackers) >= dt.maxTracked
+}
+
+// noteCapSkipped records a process turned away for lack of room
+func (dt *DirtyPageTracker) noteCapSkipped(pid int, why string) {
+	if _, ok := dt.capSkipped[pid]; ok {
+		return
+	}
+	if len(dt.capSkipped) == 0 {
+		fmt.Fprintf(os.Stderr, "Warning: not tracking PID %d: %s; further processes are skipped while full (see cap_skipped_processes)\n", pid, why)
+	}
+	dt.capSkipped[pid] = struct{}{}
+}
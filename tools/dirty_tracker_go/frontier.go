@@ -0,0 +1,80 @@
+// Dirty frontier output (-frontier)
+//
+// A consumer that follows the dirty set as it evolves, e.g. to model the
+// pages each pre-copy round would resend, only needs what changed. With
+// -frontier each sample lists, instead of all its dirty pages, the
+// addresses that are dirty now but weren't in the previous sample
+// (newly_dirty) and those that were but aren't now (newly_clean). The
+// first sample's newly_dirty is its whole set. Samples are written with an
+// empty dirty_pages list; the summary and timeline are still computed from
+// the full pages, which the tracker keeps in memory as usual.
+//
+// Only the previous sample's address set is retained for the comparison.
+// Like the unique page count, it is built from addresses, not (process,
+// address) pairs, and only from listed pages: in a truncated sample the
+// pages past -max-pages-per-sample look clean.
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// frontier holds the previous sample's dirty addresses
+type frontier struct {
+	prev map[uint64]struct{}
+}
+
+// advance fills in sample's NewlyDirty and NewlyClean and makes its
+// pages the previous set
+func (f *frontier) advance(sample *DirtySample) {
+	current := make(map[uint64]struct{}, len(sample.DirtyPages))
+	for i := range sample.DirtyPages {
+		current[sample.DirtyPages[i].Address()] = struct{}{}
+	}
+
+	var dirtied, cleaned []uint64
+	for addr := range current {
+		if _, ok := f.prev[addr]; !ok {
+			dirtied = append(dirtied, addr)
+		}
+	}
+	for addr := range f.prev {
+		if _, ok := current[addr]; !ok {
+			cleaned = append(cleaned, addr)
+		}
+	}
+	sample.NewlyDirty = hexAddrs(dirtied)
+	sample.NewlyClean = hexAddrs(cleaned)
+	f.prev = current
+}
+
+// hexAddrs sorts addrs and formats them like DirtyPage.Addr
+func hexAddrs(addrs []uint64) []string {
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	out := make([]string, len(addrs))
+	for i, addr := range addrs {
+		out[i] = fmt.Sprintf("0x%x", addr)
+	}
+	return out
+}
+
+// frontierOnly returns a copy of sample without its page list, as
+// -frontier writes it
+func frontierOnly(sample *DirtySample) *DirtySample {
+	out := *sample
+	out.DirtyPages = []DirtyPage{}
+	return &out
+}
+
+// outputSamples returns the samples as they are written out
+func (dt *DirtyPageTracker) outputSamples() []DirtySample {
+	if dt.frontier == nil {
+		return dt.samples
+	}
+	out := make([]DirtySample, len(dt.samples))
+	for i := range dt.samples {
+		out[i] = *frontierOnly(&dt.samples[i])
+	}
+	return out
+}
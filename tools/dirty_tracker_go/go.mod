@@ -1,3 +1,5 @@
 module dirty_tracker
 
 go 1.21
+
+require github.com/klauspost/compress v1.17.11
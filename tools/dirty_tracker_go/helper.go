@@ -0,0 +1,307 @@
+// Privileged helper (-helper)
+//
+// Reading pagemap and writing clear_refs of another user's process needs
+// root (or CAP_SYS_PTRACE). With -helper PATH the tracker itself can run
+// unprivileged: it spawns PATH, typically a setuid-root copy of this
+// binary, and every /proc access a ProcessTracker makes goes through it.
+// Only the helper holds the privileged fds.
+//
+// The helper is started with DIRTY_TRACKER_HELPER=1 in its environment,
+// which makes this binary serve the protocol instead of tracking. Any
+// other program speaking the same protocol can be used instead.
+//
+// Wire protocol, over the helper's stdin (requests) and stdout (replies).
+// Each request is one text line:
+//
+//	OPEN pid                   open /proc/pid/pagemap and clear_refs
+//	CLOSE pid                  close them
+//	CLEAR pid [value]          write value (default "4", clear soft-dirty)
+//	                           to clear_refs; see clearmode.go
+//	MAPS pid                   return the contents of /proc/pid/maps
+//	PAGEMAP pid offset count   return up to count bytes of pagemap at offset
+//
+// and is answered by either
+//
+//	OK n\n followed by n bytes of payload (n is 0 except for MAPS/PAGEMAP)
+//	ERR errno\n                the syscall.Errno the operation failed with
+//
+// The helper exits when its stdin is closed. It only serves a PID its
+// caller could trace itself: the caller is the peer of a unix socket on
+// stdin (SO_PEERCRED), else the real user of the helper, which for a
+// setuid binary is whoever ran it. Unless that is root, the target's real,
+// effective, saved, and filesystem uids and gids must all be the caller's,
+// and the target must be dumpable (its /proc directory owned by the
+// caller), as for ptrace; every request but CLOSE is refused with EACCES
+// otherwise. PAGEMAP replies have the PFN bits (0-54) of present pages
+// cleared, since physical addresses aren't needed and leak kernel layout;
+// -raw-pagemap captures taken through a helper therefore have no PFNs.
+// Still restrict who may execute it (e.g. chgrp tracker && chmod 4750).
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	helperEnv = "DIRTY_TRACKER_HELPER"
+
+	// helperMaxRead bounds a single PAGEMAP reply
+	helperMaxRead = 64 << 20
+
+	// helperPFNBits are the PFN bits of a present pagemap entry, which
+	// the helper clears
+	helperPFNBits = uint64(1)<<55 - 1
+)
+
+// helperCaller returns the uid and gid the helper serves: the peer of a
+// unix socket on stdin, else the real ids of the process
+func helperCaller() (uid, gid int) {
+	if cred, err := syscall.GetsockoptUcred(0, syscall.SOL_SOCKET, syscall.SO_PEERCRED); err == nil {
+		return int(cred.Uid), int(cred.Gid)
+	}
+	return os.Getuid(), os.Getgid()
+}
+
+// helperMayAccess checks that uid/gid could ptrace pid: root may trace
+// anything, anyone else only a dumpable process whose ids are all theirs
+func helperMayAccess(pid, uid, gid int) error {
+	if uid == 0 {
+		return nil
+	}
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return err
+	}
+	ids := map[string]int{"Uid:": uid, "Gid:": gid}
+	checked := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		want, ok := ids[fields[0]]
+		if !ok {
+			continue
+		}
+		checked++
+		for _, field := range fields[1:] {
+			if id, err := strconv.Atoi(field); err != nil || id != want {
+				return syscall.EACCES
+			}
+		}
+	}
+	if checked != len(ids) {
+		return syscall.EACCES
+	}
+	// A process that isn't dumpable has its /proc directory owned by root
+	var st syscall.Stat_t
+	if err := syscall.Stat(fmt.Sprintf("/proc/%d", pid), &st); err != nil {
+		return err
+	}
+	if int(st.Uid) != uid {
+		return syscall.EACCES
+	}
+	return nil
+}
+
+// errnoOf extracts the errno the helper should report for err
+func errnoOf(err error) syscall.Errno {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return errno
+	}
+	return syscall.EIO
+}
+
+// runHelper serves the helper protocol on stdin/stdout until stdin closes
+func runHelper() {
+	type fds struct{ pagemap, clearRefs int }
+	open := make(map[int]fds)
+	uid, gid := helperCaller()
+
+	in := bufio.NewReader(os.Stdin)
+	out := bufio.NewWriter(os.Stdout)
+	reply := func(payload []byte, err error) {
+		if err != nil {
+			fmt.Fprintf(out, "ERR %d\n", errnoOf(err))
+		} else {
+			fmt.Fprintf(out, "OK %d\n", len(payload))
+			out.Write(payload)
+		}
+		out.Flush()
+	}
+
+	for {
+		line, err := in.ReadString('\n')
+		if err != nil {
+			break
+		}
+		args := strings.Fields(line)
+		if len(args) < 2 {
+			reply(nil, syscall.EINVAL)
+			continue
+		}
+		pid, err := strconv.Atoi(args[1])
+		if err != nil || pid <= 0 {
+			reply(nil, syscall.EINVAL)
+			continue
+		}
+		// Checked on every request, as the target may have changed
+		// credentials (or exited and its PID been reused) since OPEN
+		if args[0] != "CLOSE" {
+			if err := helperMayAccess(pid, uid, gid); err != nil {
+				reply(nil, err)
+				continue
+			}
+		}
+
+		switch args[0] {
+		case "OPEN":
+			if _, ok := open[pid]; ok {
+				reply(nil, nil)
+				continue
+			}
+			pt := NewProcessTracker(pid)
+			err := pt.Open()
+			if err == nil {
+				open[pid] = fds{pt.pagemapFd, pt.clearRefsFd}
+			}
+			reply(nil, err)
+		case "CLOSE":
+			if f, ok := open[pid]; ok {
+				syscall.Close(f.pagemap)
+				syscall.Close(f.clearRefs)
+				delete(open, pid)
+			}
+			reply(nil, nil)
+		case "CLEAR":
+			f, ok := open[pid]
+			if !ok {
+				reply(nil, syscall.EBADF)
+				continue
+			}
+			value := clearRefsSoftDirty
+			if len(args) == 3 {
+				value = args[2]
+			}
+			if len(args) > 3 || !validClearRefsValue(value) {
+				reply(nil, syscall.EINVAL)
+				continue
+			}
+			_, err := syscall.Pwrite(f.clearRefs, []byte(value), 0)
+			reply(nil, err)
+		case "MAPS":
+			data, err := os.ReadFile(fmt.Sprintf("/proc/%d/maps", pid))
+			reply(data, err)
+		case "PAGEMAP":
+			f, ok := open[pid]
+			if !ok {
+				reply(nil, syscall.EBADF)
+				continue
+			}
+			if len(args) != 4 {
+				reply(nil, syscall.EINVAL)
+				continue
+			}
+			offset, err1 := strconv.ParseInt(args[2], 10, 64)
+			count, err2 := strconv.Atoi(args[3])
+			if err1 != nil || err2 != nil || offset < 0 || count < 0 || count > helperMaxRead {
+				reply(nil, syscall.EINVAL)
+				continue
+			}
+			buf := make([]byte, count)
+			n, err := syscall.Pread(f.pagemap, buf, offset)
+			if n < 0 {
+				n = 0
+			}
+			for i := 0; i+PagemapEntrySize <= n; i += PagemapEntrySize {
+				entry := binary.LittleEndian.Uint64(buf[i:])
+				if entry&PagePresent != 0 {
+					binary.LittleEndian.PutUint64(buf[i:], entry&^helperPFNBits)
+				}
+			}
+			reply(buf[:n], err)
+		default:
+			reply(nil, syscall.EINVAL)
+		}
+	}
+}
+
+// helperClient talks to a running helper process
+type helperClient struct {
+	mu  sync.Mutex
+	cmd *exec.Cmd
+	w   *bufio.Writer
+	r   *bufio.Reader
+	in  io.Closer
+}
+
+// startHelper spawns the helper binary at path
+func startHelper(path string) (*helperClient, error) {
+	cmd := exec.Command(path)
+	cmd.Env = append(os.Environ(), helperEnv+"=1")
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &helperClient{
+		cmd: cmd,
+		w:   bufio.NewWriter(stdin),
+		r:   bufio.NewReader(stdout),
+		in:  stdin,
+	}, nil
+}
+
+// call sends one request and returns the reply payload. Errors reported
+// by the helper come back as syscall.Errno, like the direct /proc path.
+func (hc *helperClient) call(format string, args ...any) ([]byte, error) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	fmt.Fprintf(hc.w, format+"\n", args...)
+	if err := hc.w.Flush(); err != nil {
+		return nil, fmt.Errorf("helper: %w", err)
+	}
+	status, err := hc.r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("helper: %w", err)
+	}
+
+	var n int
+	if _, err := fmt.Sscanf(status, "OK %d", &n); err == nil {
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(hc.r, payload); err != nil {
+			return nil, fmt.Errorf("helper: %w", err)
+		}
+		return payload, nil
+	}
+	var errno int
+	if _, err := fmt.Sscanf(status, "ERR %d", &errno); err == nil {
+		return nil, syscall.Errno(errno)
+	}
+	return nil, fmt.Errorf("helper: malformed reply %q", strings.TrimSpace(status))
+}
+
+// Close shuts the helper down by closing its stdin
+func (hc *helperClient) Close() error {
+	hc.in.Close()
+	return hc.cmd.Wait()
+}
@@ -0,0 +1,111 @@
+// Per-sample storage I/O of the tracked processes (-io-stats)
+//
+// Each sample records how many bytes the tracked processes read from and
+// wrote to storage since the previous sample, summed over processes, from
+// /proc/[pid]/io. A process's first reading is its baseline, so its I/O
+// before it was first seen is not counted, and I/O between its last sample
+// and its exit is lost. Processes whose io file can't be read (permissions,
+// or the process just exited) are listed and left out of the sums.
+//
+// The timeline then carries I/O rates next to the dirty rate, and the
+// summary gives their Pearson correlation over the run, e.g. close to 1
+// for a database whose dirtying follows its write throughput.
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// IOSample is the storage I/O during one sampling interval
+type IOSample struct {
+	ReadBytes      uint64 `json:"read_bytes"`
+	WriteBytes     uint64 `json:"write_bytes"`
+	UnreadablePids []int  `json:"unreadable_pids,omitempty"`
+}
+
+// IOCorrelation is the Pearson correlation of the dirty rate with the I/O
+// rates over the timeline. A field is omitted when either series is flat,
+// and the whole object when both are.
+type IOCorrelation struct {
+	ReadBytes  *float64 `json:"read_bytes,omitempty"`
+	WriteBytes *float64 `json:"write_bytes,omitempty"`
+}
+
+// sampleIO reads the io counters of every tracked process and returns the
+// growth since each process's previous reading. Must hold dt.mu.
+func (dt *DirtyPageTracker) sampleIO() *IOSample {
+	sample := &IOSample{}
+	for pid := range dt.trackers {
+		cur, err := readProcIO(pid)
+		if err != nil {
+			sample.UnreadablePids = append(sample.UnreadablePids, pid)
+			continue
+		}
+		if prev, ok := dt.ioSeen[pid]; ok {
+			// Counters only grow; guard against a recycled PID
+			if cur.ReadBytes >= prev.ReadBytes {
+				sample.ReadBytes += cur.ReadBytes - prev.ReadBytes
+			}
+			if cur.WriteBytes >= prev.WriteBytes {
+				sample.WriteBytes += cur.WriteBytes - prev.WriteBytes
+			}
+		}
+		dt.ioSeen[pid] = cur
+	}
+	for pid := range dt.ioSeen {
+		if _, ok := dt.trackers[pid]; !ok {
+			delete(dt.ioSeen, pid)
+		}
+	}
+	sort.Ints(sample.UnreadablePids)
+	return sample
+}
+
+// ioCorrelation correlates the dirty rate with the I/O rates over the
+// timeline entries that have an interval
+func ioCorrelation(samples []DirtySample, timeline []DirtyRateEntry) *IOCorrelation {
+	var dirty, reads, writes []float64
+	for i := 1; i < len(timeline) && i < len(samples); i++ {
+		if samples[i].IO == nil || samples[i].discontinuous() {
+			continue
+		}
+		dirty = append(dirty, timeline[i].RatePagesPerSec)
+		reads = append(reads, timeline[i].IOReadRate)
+		writes = append(writes, timeline[i].IOWriteRate)
+	}
+	corr := &IOCorrelation{ReadBytes: pearson(dirty, reads), WriteBytes: pearson(dirty, writes)}
+	if corr.ReadBytes == nil && corr.WriteBytes == nil {
+		return nil
+	}
+	return corr
+}
+
+// pearson returns the correlation coefficient of xs and ys, or nil when it
+// is undefined (fewer than two points or a constant series)
+func pearson(xs, ys []float64) *float64 {
+	n := float64(len(xs))
+	if len(xs) < 2 {
+		return nil
+	}
+	var meanX, meanY float64
+	for i := range xs {
+		meanX += xs[i]
+		meanY += ys[i]
+	}
+	meanX /= n
+	meanY /= n
+
+	var cov, varX, varY float64
+	for i := range xs {
+		dx, dy := xs[i]-meanX, ys[i]-meanY
+		cov += dx * dy
+		varX += dx * dx
+		varY += dy * dy
+	}
+	if varX == 0 || varY == 0 {
+		return nil
+	}
+	r := cov / math.Sqrt(varX*varY)
+	return &r
+}
@@ -0,0 +1,84 @@
+// Live CSV feed (-live-csv)
+//
+// One CSV line per sample, written unbuffered as each sample is produced,
+// for tailing into a live plot:
+//
+//	timestamp_ms,rate_pages_per_sec,cumulative_pages,processes_tracked
+//	0.213,0,0,1
+//	100.481,1204.2,121,1
+//
+// The columns are computed as in dirty_rate_timeline: the first sample and
+// resumed or restored samples have rate 0, and with -cpu-interval the rate
+// is per CPU-second. The file is always appended to, so a tail -f reader
+// isn't confused by truncation; the header is only written when the file is
+// empty, and timestamps restart at 0 with each run. "-" writes to stdout.
+//
+//	./dirty_tracker -pid 1234 -duration 600 -live-csv rate.csv &
+//	tail -f rate.csv | feedgnuplot --lines --stream --domain
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const liveCSVHeader = "timestamp_ms,rate_pages_per_sec,cumulative_pages,processes_tracked\n"
+
+// liveCSV writes the -live-csv feed
+type liveCSV struct {
+	f          *os.File
+	cpuRate    bool // rate over CPU time (-cpu-interval)
+	cumulative int
+
+	havePrev    bool
+	prevMs      float64
+	prevCPUTime float64
+}
+
+func openLiveCSV(path string, cpuRate bool) (*liveCSV, error) {
+	l := &liveCSV{f: os.Stdout, cpuRate: cpuRate}
+	if path != "-" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		l.f = f
+	}
+
+	// Only a regular file that already has content gets no header
+	if info, err := l.f.Stat(); err == nil && info.Mode().IsRegular() && info.Size() > 0 {
+		return l, nil
+	}
+	if _, err := l.f.WriteString(liveCSVHeader); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// write appends the line for one sample
+func (l *liveCSV) write(sample *DirtySample) error {
+	l.cumulative += sample.DeltaDirtyCount
+	var rate float64
+	if l.havePrev && !sample.discontinuous() {
+		deltaTime := (sample.TimestampMs - l.prevMs) / 1000.0
+		if l.cpuRate {
+			deltaTime = (sample.CPUTimeMs - l.prevCPUTime) / 1000.0
+		}
+		if deltaTime > 0 {
+			rate = float64(sample.DeltaDirtyCount) / deltaTime
+		}
+	}
+	l.havePrev, l.prevMs, l.prevCPUTime = true, sample.TimestampMs, sample.CPUTimeMs
+
+	_, err := fmt.Fprintf(l.f, "%s,%s,%d,%d\n", formatFloat(sample.TimestampMs), formatFloat(rate),
+		l.cumulative, len(sample.PidsTracked))
+	return err
+}
+
+func (l *liveCSV) Close() error {
+	if l.f == os.Stdout {
+		return nil
+	}
+	return l.f.Close()
+}
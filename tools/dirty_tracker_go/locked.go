@@ -0,0 +1,122 @@
+// Locked memory (-detect-locked)
+//
+// mlock'ed pages can't be swapped out, so pages dirtied in them are always
+// resident when the dump reads them, and a locked working set can't be
+// shrunk ahead of a checkpoint. With -detect-locked every read also parses
+// /proc/[pid]/smaps for the Locked: size of each VMA. Dirty pages in a VMA
+// with any locked memory are tagged in_locked_vma, and the summary lists
+// the writable VMAs that had locked memory and how many distinct pages
+// were dirtied in them.
+//
+// Locked: is the resident locked memory of the VMA, so a VMA locked with
+// MLOCK_ONFAULT, or still being faulted in, is only partially locked by
+// that measure; fully_locked is set once it covers the whole VMA. smaps
+// walks every page table of the process, which is why this is opt-in. Not
+// available with -helper, which can't read smaps.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LockedVMA is a writable VMA that had locked memory when it was read.
+// LockedBytes is the most seen locked at once.
+type LockedVMA struct {
+	Pid         int    `json:"pid"`
+	Start       string `json:"start"`
+	End         string `json:"end"`
+	Pathname    string `json:"pathname"`
+	LockedBytes uint64 `json:"locked_bytes"`
+	FullyLocked bool   `json:"fully_locked"`
+}
+
+// readLockedBytes returns the locked bytes of each VMA with any, keyed by
+// VMA start
+func readLockedBytes(pid int) (map[uint64]uint64, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/smaps", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	locked := make(map[uint64]uint64)
+	var start uint64
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if vma, ok := parseMapsLine(line); ok {
+			start = vma.Start
+			continue
+		}
+		// Locked:                0 kB
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] != "Locked:" {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err == nil && kb > 0 {
+			locked[start] = kb << 10
+		}
+	}
+	return locked, scanner.Err()
+}
+
+// noteLocked records a VMA read with locked memory in pt.lockedVMAs
+func (pt *ProcessTracker) noteLocked(vma *VMAInfo, pathname string, lockedBytes uint64) {
+	if pt.lockedVMAs == nil {
+		return
+	}
+	ref := vmaRef{pt.pid, vma.Start, vma.End}
+	if seen, ok := pt.lockedVMAs[ref]; ok {
+		seen.LockedBytes = max(seen.LockedBytes, lockedBytes)
+		seen.FullyLocked = seen.LockedBytes >= vma.End-vma.Start
+		return
+	}
+	pt.lockedVMAs[ref] = &LockedVMA{
+		Pid:         pt.pid,
+		Start:       fmt.Sprintf("0x%x", vma.Start),
+		End:         fmt.Sprintf("0x%x", vma.End),
+		Pathname:    pathname,
+		LockedBytes: lockedBytes,
+		FullyLocked: lockedBytes >= vma.End-vma.Start,
+	}
+}
+
+// lockedSummary returns the distinct pages dirtied in locked VMAs and the
+// locked VMAs sorted by process and address
+func lockedSummary(samples []DirtySample, vmas map[vmaRef]*LockedVMA) (int, []LockedVMA) {
+	type pageKey struct {
+		pid  int
+		addr string
+	}
+	pages := make(map[pageKey]struct{})
+	for i := range samples {
+		for j := range samples[i].DirtyPages {
+			page := &samples[i].DirtyPages[j]
+			if page.InLockedVMA {
+				pages[pageKey{page.pid, page.Addr}] = struct{}{}
+			}
+		}
+	}
+
+	refs := make([]vmaRef, 0, len(vmas))
+	for ref := range vmas {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].pid != refs[j].pid {
+			return refs[i].pid < refs[j].pid
+		}
+		return refs[i].start < refs[j].start
+	})
+	list := make([]LockedVMA, len(refs))
+	for i, ref := range refs {
+		list[i] = *vmas[ref]
+	}
+	return len(pages), list
+}
@@ -6,31 +6,69 @@
 // Usage:
 //
 //	./dirty_tracker -pid 1234 -interval 100 -duration 10 -output dirty_pattern.json
+//
+// SIGINT/SIGTERM stop tracking and write the output; SIGUSR2 toggles a
+// pause in sampling. With -start-on-signal sampling begins at SIGUSR1.
 package main
 
 import (
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
+// PageSize is the base page size, by which pagemap is indexed: 4 KiB on
+// x86-64, but 16 KiB or 64 KiB on some arm64 and ppc64 kernels
+var PageSize = uint64(os.Getpagesize())
+
 const (
-	PageSize         = 4096
 	PagemapEntrySize = 8
 
 	// Pagemap entry flags
-	PagePresent = uint64(1) << 63
-	PageSwapped = uint64(1) << 62
-	SoftDirty   = uint64(1) << 55
+	PagePresent   = uint64(1) << 63
+	PageSwapped   = uint64(1) << 62
+	PageFile      = uint64(1) << 61 // file-page or shared-anon
+	PageExclusive = uint64(1) << 56 // PM_MMAP_EXCLUSIVE
+	SoftDirty     = uint64(1) << 55
+
+	// HugeRegionSize is the 2 MiB bucket used by -granularity 2M
+	HugeRegionSize = 2 << 20
+
+	// DefaultEMAAlpha is the smoothing factor for the timeline's EMA rate
+	DefaultEMAAlpha = 0.3
+
+	// Retries when a new process's pagemap is not yet readable
+	DefaultOpenRetries = 3
+	DefaultOpenBackoff = 10 * time.Millisecond
+
+	// cpuPollInterval is how often -cpu-interval checks tracked CPU time
+	cpuPollInterval = 10 * time.Millisecond
+
+	// logEverySamples is how often progress is logged to stderr
+	logEverySamples = 10
+
+	// DefaultWatchdogMult is how many intervals without a sample count as a stall
+	DefaultWatchdogMult = 10.0
+
+	// An interval is met if it took at most this much longer than requested;
+	// below intervalsMetWarnPct met, rate conclusions get a warning
+	intervalTolerance   = 0.10
+	intervalsMetWarnPct = 90.0
 )
 
 // VMAInfo represents a Virtual Memory Area from /proc/[pid]/maps
@@ -42,13 +80,123 @@ type VMAInfo struct {
 	Device   string
 	Inode    uint64
 	Pathname string
+
+	anonLike bool   // Pathname matched -anon-like-path
+	label    string // from -classify-rules, replacing the built-in type
+	deleted  bool   // maps marked the file "(deleted)"
+}
+
+// vmaInfoJSON is the serialized form of VMAInfo, with addresses in hex
+// like the Python tracker's vma_start/vma_end fields
+type vmaInfoJSON struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Perms    string `json:"perms"`
+	Offset   uint64 `json:"offset"`
+	Device   string `json:"device"`
+	Inode    uint64 `json:"inode"`
+	Pathname string `json:"pathname"`
+}
+
+func (v VMAInfo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(vmaInfoJSON{
+		Start:    fmt.Sprintf("0x%x", v.Start),
+		End:      fmt.Sprintf("0x%x", v.End),
+		Perms:    v.Perms,
+		Offset:   v.Offset,
+		Device:   v.Device,
+		Inode:    v.Inode,
+		Pathname: v.Pathname,
+	})
+}
+
+func (v *VMAInfo) UnmarshalJSON(data []byte) error {
+	var j vmaInfoJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	start, err := strconv.ParseUint(strings.TrimPrefix(j.Start, "0x"), 16, 64)
+	if err != nil {
+		return fmt.Errorf("vma start: %w", err)
+	}
+	end, err := strconv.ParseUint(strings.TrimPrefix(j.End, "0x"), 16, 64)
+	if err != nil {
+		return fmt.Errorf("vma end: %w", err)
+	}
+	*v = VMAInfo{
+		Start:    start,
+		End:      end,
+		Perms:    j.Perms,
+		Offset:   j.Offset,
+		Device:   j.Device,
+		Inode:    j.Inode,
+		Pathname: j.Pathname,
+	}
+	return nil
+}
+
+// vmaIdentities returns an ASLR-independent identity for each VMA: the
+// pathname (with file offset) or the VMA type for unnamed regions, with a
+// "#n" suffix on the n-th repeat of the same key in address order.
+func vmaIdentities(vmas []VMAInfo) []string {
+	ids := make([]string, len(vmas))
+	seen := make(map[string]int)
+	for i := range vmas {
+		key := vmas[i].Pathname
+		if strings.HasPrefix(key, "/") {
+			key = fmt.Sprintf("%s@0x%x", key, vmas[i].Offset)
+		} else if key == "" {
+			key = vmas[i].VMAType()
+		}
+		if n := seen[key]; n > 0 {
+			ids[i] = fmt.Sprintf("%s#%d", key, n)
+		} else {
+			ids[i] = key
+		}
+		seen[key]++
+	}
+	return ids
+}
+
+// IsPrivateAnon reports whether the mapping is private anonymous memory
+// (MAP_PRIVATE|MAP_ANONYMOUS), which CRIU dumps as page contents: unnamed
+// mappings plus the heap and stacks. -anon-like-path reclassification
+// doesn't apply, since those mappings are file-backed.
+func (v *VMAInfo) IsPrivateAnon() bool {
+	if len(v.Perms) < 4 || v.Perms[3] != 'p' {
+		return false
+	}
+	return v.Pathname == "" || v.Pathname == "[heap]" || strings.HasPrefix(v.Pathname, "[stack")
+}
+
+// IsGuard reports whether the mapping is PROT_NONE
+func (v *VMAInfo) IsGuard() bool {
+	return strings.HasPrefix(v.Perms, "---")
 }
 
 func (v *VMAInfo) IsWritable() bool {
 	return len(v.Perms) > 1 && v.Perms[1] == 'w'
 }
 
+// IsDeviceBacked reports whether the mapping's backing object lives on a
+// device (anything other than 00:00, which anonymous memory uses). Soft-dirty
+// semantics can differ on some of these, e.g. DAX or hugetlbfs.
+func (v *VMAInfo) IsDeviceBacked() bool {
+	return v.Device != "" && v.Device != "00:00"
+}
+
 func (v *VMAInfo) VMAType() string {
+	if v.label != "" {
+		return v.label
+	}
+	// PROT_NONE: thread stack guards, gaps between library segments, and
+	// reserved-but-uncommitted ranges. They can never be dirtied.
+	if v.IsGuard() {
+		return "guard"
+	}
+	if v.anonLike {
+		return "anonymous"
+	}
 	switch v.Pathname {
 	case "[heap]":
 		return "heap"
@@ -75,7 +223,55 @@ type DirtyPage struct {
 	VMAType  string `json:"vma_type"`
 	VMAPerms string `json:"vma_perms"`
 	Pathname string `json:"pathname"`
+	VMAStart string `json:"vma_start"` // containing VMA, as in the Python output
+	VMAEnd   string `json:"vma_end"`
 	Size     int    `json:"size"`
+
+	// Decoded pagemap bits, only set with -decode-flags
+	Exclusive *bool `json:"exclusive,omitempty"`
+	FileOrShm *bool `json:"file_or_shared_anon,omitempty"`
+	Swapped   *bool `json:"swapped,omitempty"`
+
+	// ASLR-independent location, only set with -relative-addr
+	VMAId     string `json:"vma_id,omitempty"`
+	VMAOffset string `json:"vma_offset,omitempty"`
+
+	// Process whose PTE had the soft-dirty bit, only set with -share-group.
+	// Addr is an address in this process's address space.
+	ObservedByPid int `json:"observed_by_pid,omitempty"`
+
+	// Containing VMA had mlock'ed memory, only set with -detect-locked
+	InLockedVMA bool `json:"in_locked_vma,omitempty"`
+
+	// Page of the main stack mapped in since the process's previous read:
+	// dirtied by the stack deepening rather than by reuse
+	StackGrowth bool `json:"stack_growth,omitempty"`
+
+	// Address before the containing region's first move, only set with
+	// -remap-moves for pages of moved regions (see regionmove.go)
+	StableAddr string `json:"stable_addr,omitempty"`
+
+	// malloc heap of the page, only set with -detect-arenas (see arenas.go)
+	Arena string `json:"arena,omitempty"`
+
+	// In a shared file mapping written back to the file rather than
+	// dumped, only set with -shared-file-writeback (see writeback.go)
+	FileWriteback bool `json:"file_writeback,omitempty"`
+
+	vmaStart uint64 // containing VMA, for in-process analyses only
+	vmaSize  uint64
+	pid      int // process whose pagemap listed the page
+}
+
+// Address returns the page address as a number
+func (p *DirtyPage) Address() uint64 {
+	addr, _ := parseHexAddr(p.Addr)
+	return addr
+}
+
+// parseHexAddr parses an address formatted as 0x-prefixed hex
+func parseHexAddr(s string) (uint64, error) {
+	return strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 64)
 }
 
 // DirtySample represents a single sampling point
@@ -84,29 +280,251 @@ type DirtySample struct {
 	DirtyPages      []DirtyPage `json:"dirty_pages"`
 	DeltaDirtyCount int         `json:"delta_dirty_count"`
 	PidsTracked     []int       `json:"pids_tracked"`
+	CPUTimeMs       float64     `json:"cpu_time_ms,omitempty"`
+	IO              *IOSample   `json:"io,omitempty"`      // with -io-stats
+	PSI             *PSISample  `json:"psi,omitempty"`     // with -psi
+	Partial         bool        `json:"partial,omitempty"` // cut short by a stop between intervals
+	Resumed         bool        `json:"resumed,omitempty"` // first sample after a pause; covers one interval
+
+	// Only the first -max-pages-per-sample pages are listed in DirtyPages;
+	// DeltaDirtyCount still counts all of them
+	Truncated bool `json:"truncated,omitempty"`
+	// First sample after re-attaching with -follow-restore; covers one
+	// interval
+	Restored bool `json:"restored,omitempty"`
+
+	// Children whose first read after discovery was held out of this sample
+	// (-child-first-sample), and with "pre-tracking" how many pages it had
+	PreTrackingPids       []int `json:"pre_tracking_pids,omitempty"`
+	PreTrackingDirtyCount int   `json:"pre_tracking_dirty_count,omitempty"`
+
+	// Processes asleep at sample time and not read (-skip-sleeping), and
+	// whether that was all of them
+	IdlePids []int `json:"idle_pids,omitempty"`
+	Idle     bool  `json:"idle,omitempty"`
+
+	// Dirty shared pages also seen by a later mapper this sample, and the
+	// ones each mapper saw, listed under it or not (-share-group)
+	SharedDuplicates   int         `json:"shared_duplicate_observations,omitempty"`
+	SharedObservations map[int]int `json:"shared_observations_by_pid,omitempty"`
+
+	// VMAs and /proc/[pid]/maps bytes of the processes read this sample,
+	// summed (-maps-stats)
+	VMACount  int `json:"vma_count,omitempty"`
+	MapsBytes int `json:"maps_bytes,omitempty"`
+
+	// Where the dirtying processes' threads were executing (-sample-rip)
+	RIPs []RIPSample `json:"rip_samples,omitempty"`
+	// Summed resident set of the tracked processes (-rss-growth)
+	RSSBytes uint64 `json:"rss_bytes,omitempty"`
+	// Their Private_Dirty plus Shared_Dirty from smaps_rollup (-smaps-rollup)
+	RollupDirtyKB uint64 `json:"rollup_dirty_kb,omitempty"`
+
+	// Nominal interval before this sample, set with -schedule, and with
+	// -accumulate the length of the sample's window
+	IntervalMs float64 `json:"interval_ms,omitempty"`
+
+	// Addresses dirty now and not in the previous sample, and the reverse
+	// (-frontier)
+	NewlyDirty []string `json:"newly_dirty,omitempty"`
+	NewlyClean []string `json:"newly_clean,omitempty"`
+
+	// Time since the last clear, set with -accumulate (see accumulate.go)
+	AccumulatedMs float64 `json:"accumulated_ms,omitempty"`
+
+	// This sample's clear left re-read pages soft-dirty (-verify-clear)
+	ClearIneffective bool `json:"clear_ineffective,omitempty"`
+
+	// Dirty pages left out because they are swapped (-resident-only)
+	SwappedDirtyCount int `json:"swapped_dirty_count,omitempty"`
+
+	// Dirty pages of the main stack mapped in by its growth since the
+	// previous read, and the rest, listed or not (see stackGrowth)
+	StackGrowthCount  int `json:"stack_growth_count,omitempty"`
+	StackRedirtyCount int `json:"stack_redirty_count,omitempty"`
+
+	// Present pages that stayed clean, only set with -emit-clean, and
+	// their addresses by process with -emit-clean-addrs (see clean.go)
+	CleanCount int              `json:"clean_count,omitempty"`
+	CleanPages map[int][]string `json:"clean_pages,omitempty"`
+
+	// System clock time of the sample in Unix ms, only set with
+	// -wall-clock (see wallclock.go); timestamp_ms is monotonic
+	WallTimeMs float64 `json:"wall_time_ms,omitempty"`
+
+	perPid map[int]int // dirty pages per process, for fork analysis
+}
+
+// discontinuous reports whether the sample follows a gap in tracking (a
+// pause or a restore) and so only covers the interval since the last clear
+func (s *DirtySample) discontinuous() bool {
+	return s.Resumed || s.Restored
+}
+
+// ForkEvent is a child that appeared during tracking and whether its first
+// samples look like a copy-on-write fault storm (see cowStorms)
+type ForkEvent struct {
+	Pid              int     `json:"pid"`
+	TimestampMs      float64 `json:"timestamp_ms"`
+	EarlyDirtyPages  int     `json:"early_dirty_pages"` // first cowWindowSamples samples
+	LaterDirtyPages  int     `json:"later_dirty_pages"` // the cowWindowSamples after those
+	LikelyCOWStorm   bool    `json:"likely_cow_storm"`
+	firstSampleIndex int
+}
+
+// PauseEvent marks where sampling was paused or resumed with SIGUSR2
+type PauseEvent struct {
+	TimestampMs float64 `json:"timestamp_ms"`
+	Event       string  `json:"event"` // "pause" or "resume"
+}
+
+// ProcessInfo describes one tracked process
+type ProcessInfo struct {
+	HostPid int `json:"host_pid"`
+	NsPid   int `json:"ns_pid,omitempty"` // PID inside its own PID namespace
 }
 
 // DirtyRateEntry represents a point in the dirty rate timeline
 type DirtyRateEntry struct {
 	TimestampMs      float64 `json:"timestamp_ms"`
 	RatePagesPerSec  float64 `json:"rate_pages_per_sec"`
+	EMARate          float64 `json:"ema_rate_pages_per_sec"`
 	CumulativePages  int     `json:"cumulative_pages"`
 	ProcessesTracked int     `json:"processes_tracked"`
+	IOReadRate       float64 `json:"io_read_bytes_per_sec,omitempty"`  // with -io-stats
+	IOWriteRate      float64 `json:"io_write_bytes_per_sec,omitempty"` // with -io-stats
+	TouchedRegions   int     `json:"touched_regions,omitempty"`
+	LikelyCOWStorm   bool    `json:"likely_cow_storm,omitempty"` // inside a flagged post-fork window
+
+	// Jaccard similarity of this and the previous sample's dirty sets
+	// (-jaccard); unset for the first sample and when both are empty
+	DirtySetJaccard *float64 `json:"dirty_set_jaccard,omitempty"`
+	// Shannon entropy in bits of this sample's dirty pages over address
+	// buckets (-spatial-entropy); unset when nothing was dirtied
+	SpatialEntropy *float64 `json:"spatial_entropy,omitempty"`
 }
 
 // Summary contains aggregated statistics
 type Summary struct {
-	TotalUniquePages    int                `json:"total_unique_pages"`
-	TotalDirtyEvents    int                `json:"total_dirty_events"`
-	TotalDirtySizeBytes int                `json:"total_dirty_size_bytes"`
-	AvgDirtyRatePerSec  float64            `json:"avg_dirty_rate_per_sec"`
-	PeakDirtyRate       float64            `json:"peak_dirty_rate"`
-	VMADistribution     map[string]float64 `json:"vma_distribution"`
-	VMASizeDistribution map[string]int     `json:"vma_size_distribution"`
-	SampleCount         int                `json:"sample_count"`
-	IntervalMs          float64            `json:"interval_ms"`
-	MaxProcessesTracked int                `json:"max_processes_tracked"`
-	TotalPidsSeen       []int              `json:"total_pids_seen"`
+	TotalUniquePages    int                       `json:"total_unique_pages"`
+	TotalDirtyEvents    int                       `json:"total_dirty_events"`
+	TotalDirtySizeBytes int                       `json:"total_dirty_size_bytes"`
+	AvgDirtyRatePerSec  float64                   `json:"avg_dirty_rate_per_sec"`
+	PeakDirtyRate       float64                   `json:"peak_dirty_rate"`
+	SustainedPeakRate   float64                   `json:"sustained_peak_rate,omitempty"` // -peak-window
+	PeakWindowMs        float64                   `json:"peak_window_ms,omitempty"`
+	MaxBurstPages       int                       `json:"max_burst_pages"` // largest delta_dirty_count
+	MaxBurstTimestampMs float64                   `json:"max_burst_timestamp_ms"`
+	VMADistribution     map[string]float64        `json:"vma_distribution"`
+	VMASizeDistribution map[string]int            `json:"vma_size_distribution"`
+	FirstDirtyMs        map[string]float64        `json:"first_dirty_ms,omitempty"` // by VMA id with -relative-addr, else VMA type
+	SampleCount         int                       `json:"sample_count"`
+	IntervalMs          float64                   `json:"interval_ms"`
+	MaxProcessesTracked int                       `json:"max_processes_tracked"`
+	TotalPidsSeen       []int                     `json:"total_pids_seen"`
+	RegionSizeBytes     int                       `json:"region_size_bytes,omitempty"`
+	TouchedRegions      int                       `json:"touched_regions,omitempty"`
+	ExclusiveDirtyPages int                       `json:"exclusive_dirty_pages,omitempty"`
+	LocalityScore       float64                   `json:"locality_score"`
+	IntervalsMetPct     *float64                  `json:"intervals_met_pct,omitempty"` // wall-clock sampling only
+	DeviceVMAsSkipped   int                       `json:"device_backed_vmas_skipped,omitempty"`
+	PrivateAnonVMAs     int                       `json:"private_anon_vmas,omitempty"` // distinct VMAs read with -private-anon-only
+	TruncatedSamples    int                       `json:"truncated_samples,omitempty"`
+	AnonLikePaths       []string                  `json:"anon_like_paths,omitempty"` // reclassified by -anon-like-path
+	IOCorrelation       *IOCorrelation            `json:"dirty_io_correlation,omitempty"`
+	VMASizeBuckets      map[string]map[string]int `json:"vma_size_buckets,omitempty"`
+	AvgRatePerProcess   float64                   `json:"avg_dirty_rate_per_process,omitempty"`
+	AvgRatePerCPU       float64                   `json:"avg_dirty_rate_per_cpu,omitempty"`
+
+	// Writable VMAs of the root process that were never dirtied (-capture-maps)
+	CleanWritableRegions []VMAInfo `json:"clean_writable_regions,omitempty"`
+	// Dirtied share of each writable VMA of the root process (-capture-maps)
+	VMADirtyFraction []VMADirtyFraction `json:"vma_dirty_fraction,omitempty"`
+	// PROT_NONE (guard) VMAs of the root process (-capture-maps)
+	GuardRegions *GuardRegionStats `json:"guard_regions,omitempty"`
+	// Times between consecutive dirtyings of the same page (-inter-dirty)
+	InterDirtyTimeHistogram map[string]int `json:"inter_dirty_time_histogram,omitempty"`
+	// Unique pages weighted by their re-dirty probability (-weighted-estimate)
+	EffectiveDirtySetPages *float64 `json:"effective_dirty_set_pages,omitempty"`
+	// Residual dirty set at each candidate checkpoint (-downtime-budget-pages)
+	DowntimeBudget *DowntimeBudgetReport `json:"downtime_budget,omitempty"`
+	// Pages dirtied in every (or -hot-set-fraction of) interval
+	HotSet *HotSetReport `json:"hot_set,omitempty"`
+	// Per-sample vma_count over the run (-maps-stats)
+	VMACountStats *MetricStats `json:"vma_count_stats,omitempty"`
+	// Autocorrelation of the timeline's dirty rate at lags of 0..-autocorr
+	// samples, and its highest peak: the likely period of a cyclic workload
+	// (in CPU time with -cpu-interval)
+	RateAutocorrelation []float64 `json:"rate_autocorrelation,omitempty"`
+	AutocorrPeakLag     int       `json:"autocorr_peak_lag,omitempty"`
+	AutocorrPeriodMs    float64   `json:"autocorr_period_ms,omitempty"`
+	// Samples recorded without page lists near -max-output-mb, and whether
+	// the limit cut the capture short
+	OutputDetailDropped int  `json:"output_detail_dropped_samples,omitempty"`
+	OutputLimited       bool `json:"output_limited,omitempty"`
+	// All-zero dirty pages among those checked (-detect-zero)
+	ZeroPages *ZeroPageStats `json:"zero_pages,omitempty"`
+	// Writable regions moved by mremap and their total size (-track-moves)
+	RegionMoves      int    `json:"region_moves,omitempty"`
+	MovedRegionBytes uint64 `json:"moved_region_bytes,omitempty"`
+	// Samples at or over -sample-rip, instruction pointers recorded in
+	// them, and threads whose pointer couldn't be read
+	RIPSpikeSamples int `json:"rip_spike_samples,omitempty"`
+	RIPsRecorded    int `json:"rips_recorded,omitempty"`
+	RIPFailures     int `json:"rip_failures,omitempty"`
+	// Processes not tracked for lack of room (-max-tracked)
+	CapSkippedProcesses int `json:"cap_skipped_processes,omitempty"`
+	// RSS change from the first sample to the last, the largest RSS, and
+	// the share of dirty events that were growth (-rss-growth, see rss.go)
+	RSSGrowthBytes  int64    `json:"rss_growth_bytes,omitempty"`
+	PeakRSSBytes    uint64   `json:"peak_rss_bytes,omitempty"`
+	AllocationShare *float64 `json:"allocation_share,omitempty"`
+	// Largest smaps_rollup dirty size seen, and the unique soft-dirty size
+	// over it (-smaps-rollup, see rollup.go)
+	MaxRollupDirtyKB uint64   `json:"max_rollup_dirty_kb,omitempty"`
+	RollupRatio      *float64 `json:"rollup_ratio,omitempty"`
+	// Dirty main-stack pages that were newly mapped as the stack grew, and
+	// those re-dirtied in the existing stack (listed pages, per sample)
+	StackGrowthPages  int `json:"stack_growth_pages,omitempty"`
+	StackRedirtyPages int `json:"stack_redirty_pages,omitempty"`
+	// Pages dirtied under VMAs of different types over the run, e.g. a file
+	// mapping mprotect'ed to executable, with the first few as examples
+	TypeChangedPages   int          `json:"type_changed_pages,omitempty"`
+	TypeChangeExamples []TypeChange `json:"type_change_examples,omitempty"`
+	// Writable VMAs read with mlock'ed memory and the distinct pages
+	// dirtied in them (-detect-locked)
+	LockedDirtyPages int         `json:"locked_dirty_pages,omitempty"`
+	LockedVMAs       []LockedVMA `json:"locked_vmas,omitempty"`
+	// A process's maps grew past -maps-explosion-vmas, and the most VMAs
+	// seen (see explosion.go)
+	MapsExplosionDetected bool `json:"maps_explosion_detected,omitempty"`
+	MapsExplosionMaxVMAs  int  `json:"maps_explosion_max_vmas,omitempty"`
+	// Mean spatial_entropy of the samples that dirtied pages, and the
+	// bucket size it was computed over (-spatial-entropy)
+	AvgSpatialEntropy  *float64 `json:"avg_spatial_entropy,omitempty"`
+	EntropyBucketBytes int      `json:"entropy_bucket_bytes,omitempty"`
+	// Listed dirty pages per malloc heap (-detect-arenas, see arenas.go)
+	ArenaDirty []ArenaDirty `json:"arena_dirty,omitempty"`
+	// Clears checked and found ineffective (-verify-clear, see
+	// clearcheck.go)
+	ClearsVerified    int `json:"clears_verified,omitempty"`
+	IneffectiveClears int `json:"ineffective_clears,omitempty"`
+	// Swapped dirty pages left out, and the dirty bytes with them
+	// (-resident-only, see resident.go)
+	SwappedDirtyEvents int `json:"swapped_dirty_events,omitempty"`
+	AllDirtySizeBytes  int `json:"all_dirty_size_bytes,omitempty"`
+	// Dirtying of shared file mappings apart from checkpoint content
+	// (-shared-file-writeback, see writeback.go)
+	SharedFileWriteback *FileWritebackReport `json:"shared_file_writeback,omitempty"`
+	// Share of the distinct dirty pages (by address) of each VMA type.
+	// vma_distribution counts every dirtying, so a type whose few pages are
+	// rewritten every sample ranks high there but low here.
+	VMAUniqueDistribution map[string]float64 `json:"vma_unique_distribution"`
+	// Page-table pages estimated to map the dirty pages (-track-pagetables,
+	// see pagetables.go)
+	PageTables *PageTableEstimate `json:"page_tables,omitempty"`
+	// Wall clock adjustments during the run (-wall-clock, see wallclock.go)
+	WallClock *WallClockReport `json:"wall_clock,omitempty"`
 }
 
 // DirtyPattern is the main output structure (compatible with Python version)
@@ -114,13 +532,91 @@ type DirtyPattern struct {
 	Workload           string           `json:"workload"`
 	RootPid            int              `json:"root_pid"`
 	TrackChildren      bool             `json:"track_children"`
+	MaxDepth           *int             `json:"max_depth,omitempty"`
 	TrackingDurationMs float64          `json:"tracking_duration_ms"`
 	PageSize           int              `json:"page_size"`
+	SamplingClock      string           `json:"sampling_clock,omitempty"`
 	PagemapScanUsed    bool             `json:"pagemap_scan_used"`
 	ClearOnScan        bool             `json:"clear_on_scan"`
+	ClearRefsAvailable bool             `json:"clear_refs_available"` // false: some processes were tracked cumulatively
+	ClearScope         string           `json:"clear_scope,omitempty"`
+	ClearMode          string           `json:"clear_mode,omitempty"` // clear_refs values written, see clearmode.go
+	StopReason         string           `json:"stop_reason,omitempty"`
+	Converged          *ConvergeEvent   `json:"converged,omitempty"`
+	UniqueTarget       *UniqueTarget    `json:"unique_target,omitempty"`    // with -stop-at-unique
+	AccessLostPids     []int            `json:"access_lost_pids,omitempty"` // alive but no longer readable
+	PauseEvents        []PauseEvent     `json:"pause_events,omitempty"`
+	RestoreEvents      []RestoreEvent   `json:"restore_events,omitempty"`
+	TrackerCPU         *TrackerCPUStats `json:"tracker_cpu,omitempty"` // with -max-cpu-pct
+	ForkEvents         []ForkEvent      `json:"fork_events,omitempty"`
+	VforkEvents        []VforkEvent     `json:"vfork_events,omitempty"` // with -detect-vfork
+	RateEvents         []RateEvent      `json:"rate_events,omitempty"`  // with -threshold
+	RegionMoves        []RegionMove     `json:"region_moves,omitempty"` // with -track-moves
+	Processes          []ProcessInfo    `json:"processes,omitempty"`
+	Metadata           *Metadata        `json:"metadata,omitempty"`
 	Samples            []DirtySample    `json:"samples"`
 	Summary            Summary          `json:"summary"`
 	DirtyRateTimeline  []DirtyRateEntry `json:"dirty_rate_timeline"`
+	BinnedTimeline     []TimeBin        `json:"binned_timeline,omitempty"` // with -bin-ms
+	RSSGrowthTimeline  []RSSGrowthEntry `json:"rss_growth_timeline,omitempty"`
+	StartMaps          []VMAInfo        `json:"start_maps,omitempty"`
+	EndMaps            []VMAInfo        `json:"end_maps,omitempty"`
+}
+
+// summaryOnlyPattern is the -no-samples output: the zero-valued Samples
+// field shadows the embedded "samples" in JSON and is omitted, while the
+// summary and timeline computed from the samples are kept.
+type summaryOnlyPattern struct {
+	DirtyPattern
+	Samples []DirtySample `json:"samples,omitempty"`
+}
+
+// ReadOptions controls how ReadDirtyPages interprets pagemap entries.
+// It is shared by all ProcessTrackers of a DirtyPageTracker.
+type ReadOptions struct {
+	DecodeFlags  bool                // report exclusive/file bits per dirty page
+	AddressMask  map[uint64]struct{} // if set, only these page addresses are recorded
+	RelativeAddr bool                // report VMA identity and offset per dirty page
+	OnlyVMAAt    uint64              // if nonzero, only the VMA containing this address is read
+
+	SkipDeviceBacked bool   // don't read VMAs with IsDeviceBacked
+	DetectLocked     bool   // tag pages in VMAs with locked memory (see locked.go)
+	MaxBufferPages   int    // pagemap entries read per pass; 0 for no cap
+	ExplosionVMAs    int    // VMA count reported as a maps explosion; 0 disables
+	PrivateAnonOnly  bool   // only read VMAs with IsPrivateAnon
+	TrackMoves       bool   // record mremap'ed regions (see regionmove.go)
+	RemapMoves       bool   // count moved regions' pages at their first address
+	DetectArenas     bool   // label heap pages by malloc arena (see arenas.go)
+	ResidentOnly     bool   // count swapped pages apart (see resident.go)
+	SkipHoles        bool   // don't count soft-dirty holes (see entrySoftDirty)
+	FileWriteback    bool   // tag pages of shared file mappings (see writeback.go)
+	EmitClean        bool   // count present clean pages (see clean.go)
+	CleanAddrs       bool   // and list them
+	Strategy         string // ReadSeek (default), ReadPread, or ReadScan
+
+	// If set, writable VMAs up to CoalesceGap pages apart are read
+	// together (see coalesce.go)
+	Coalesce    bool
+	CoalesceGap int
+
+	// Pathname globs whose VMAs ParseMaps marks as anonymous for VMAType
+	AnonLikePaths []string
+	// Rules ParseMaps labels VMAs by, ahead of VMAType's own (see classify.go)
+	ClassifyRules []ClassifyRule
+	// If set, pathnames and VMA identities of dirty pages are anonymized
+	Anonymizer *pathAnonymizer
+}
+
+// narrowed reports whether reads are limited to part of the address space.
+// Clearing can't be limited the same way (see ClearSoftDirty).
+func (o *ReadOptions) narrowed() bool {
+	return o.AddressMask != nil || o.OnlyVMAAt != 0
+}
+
+// vmaRef identifies one VMA of one process
+type vmaRef struct {
+	pid        int
+	start, end uint64
 }
 
 // ProcessTracker tracks dirty pages for a single process
@@ -129,62 +625,194 @@ type ProcessTracker struct {
 	pagemapFd   int
 	clearRefsFd int
 	isOpen      bool
+	pidfd       int    // -1 without pidfd support (see pidfd.go)
+	startTicks  uint64 // process start time at the first Open
+	opts        *ReadOptions
+	shared      *sharedPages  // set with -share-group
+	helper      *helperClient // set with -helper; all /proc access goes through it
+
+	deviceSkipped map[vmaRef]struct{} // collects VMAs skipped by SkipDeviceBacked
+	anonIncluded  map[vmaRef]struct{} // collects VMAs read under PrivateAnonOnly
+	cumulative    map[uint64]struct{} // without clear_refs: pages already reported
+	anonLikeSeen  map[string]struct{} // collects pathnames matched by AnonLikePaths
+	scanVec       []pageRegion        // reused PAGEMAP_SCAN output buffer
+
+	// Collects VMAs read with locked memory under DetectLocked
+	lockedVMAs map[vmaRef]*LockedVMA
+	// Records processes over ExplosionVMAs (see explosion.go)
+	explosion *mapsExplosion
+	readBuf   []byte // reused pagemap buffer, see readBuffer
+	// Reused buffer of -coalesce-gap read clusters
+	clusterBuf []byte
+	// Swapped dirty pages left out of the last read under ResidentOnly
+	swappedDirty int
+	// Main stack pages of the last read mapped in by growth, and the rest
+	stackGrown     int
+	stackRedirtied int
+	// Present clean pages of the last read under EmitClean, and the first
+	// cleanLimit of their addresses (all if negative) under CleanAddrs
+	cleanCount int
+	cleanAddrs []string
+	cleanLimit int
+	// Size of the last maps read, for -maps-stats
+	mapsBytes int
+	vmaCount  int
+	// Shared -detect-zero counters (see zeropages.go), this process's
+	// /proc/[pid]/mem, and the page buffer
+	zero    *ZeroPageStats
+	memFile *os.File
+	zeroBuf []byte
+	// Writable VMAs of the last read, current start of each moved region
+	// to its first, and moves not yet taken (see regionmove.go)
+	prevVMAs  []VMAInfo
+	movedFrom map[uint64]uint64
+	moves     []RegionMove
+	// Start of the [stack] VMA at the last read; 0 before the first
+	stackStart uint64
+	// clear_refs values to write, nil for soft-dirty (see clearmode.go)
+	clearMode []string
+	// Class of each page dirtied so far, the maps layout of the last read,
+	// and changes not yet taken (see typechange.go)
+	pageClasses map[uint64]pageClass
+	classLayout []classRange
+	typeChanges []TypeChange
+	// Whether the executable is a Go program, once looked up (see arenas.go)
+	runtimeChecked bool
+	goRuntime      bool
 }
 
 func NewProcessTracker(pid int) *ProcessTracker {
-	return &ProcessTracker{pid: pid}
+	return &ProcessTracker{pid: pid, pidfd: -1, opts: &ReadOptions{}}
 }
 
 func (pt *ProcessTracker) Open() error {
+	if pt.helper != nil {
+		if _, err := pt.helper.call("OPEN %d", pt.pid); err != nil {
+			return fmt.Errorf("open pagemap via helper: %w", err)
+		}
+		pt.isOpen = true
+		return nil
+	}
+
 	pagemapPath := fmt.Sprintf("/proc/%d/pagemap", pt.pid)
 	clearRefsPath := fmt.Sprintf("/proc/%d/clear_refs", pt.pid)
 
+	pt.openIdentity()
 	var err error
 	pt.pagemapFd, err = syscall.Open(pagemapPath, syscall.O_RDONLY, 0)
 	if err != nil {
 		return fmt.Errorf("open pagemap: %w", err)
 	}
 
+	// Without clear_refs (e.g. a read-only /proc) pagemap can still be
+	// read; CanClear tells the caller to fall back to cumulative tracking
 	pt.clearRefsFd, err = syscall.Open(clearRefsPath, syscall.O_WRONLY, 0)
 	if err != nil {
-		syscall.Close(pt.pagemapFd)
-		return fmt.Errorf("open clear_refs: %w", err)
+		pt.clearRefsFd = -1
 	}
 
 	pt.isOpen = true
+	if err := pt.checkIdentity(); err != nil {
+		pt.Close()
+		return err
+	}
 	return nil
 }
 
+// CanClear reports whether the open process's soft-dirty bits can be reset
+func (pt *ProcessTracker) CanClear() bool {
+	return pt.helper != nil || pt.clearRefsFd >= 0
+}
+
 func (pt *ProcessTracker) Close() {
+	if pt.helper != nil {
+		if pt.isOpen {
+			pt.helper.call("CLOSE %d", pt.pid)
+		}
+		pt.isOpen = false
+		return
+	}
 	if pt.pagemapFd > 0 {
 		syscall.Close(pt.pagemapFd)
 	}
 	if pt.clearRefsFd > 0 {
 		syscall.Close(pt.clearRefsFd)
 	}
+	if pt.pidfd >= 0 {
+		syscall.Close(pt.pidfd)
+		pt.pidfd = -1
+	}
+	if pt.memFile != nil {
+		pt.memFile.Close()
+		pt.memFile = nil
+	}
 	pt.isOpen = false
 }
 
+// isAccessError reports whether err means we may no longer inspect the
+// process, e.g. after it dropped privileges with setuid
+func isAccessError(err error) bool {
+	return errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES)
+}
+
 func (pt *ProcessTracker) IsAlive() bool {
+	if pt.pidfd >= 0 {
+		return pidfdAlive(pt.pidfd)
+	}
 	_, err := os.Stat(fmt.Sprintf("/proc/%d", pt.pid))
 	return err == nil
 }
 
+// ClearSoftDirty clears the soft-dirty bits of the whole process. The
+// kernel offers no per-range clear: clear_refs applies to every VMA, and
+// PAGEMAP_SCAN can only write-protect ranges registered with userfaultfd,
+// which would change the target's fault handling. With -address-mask or
+// -thread the bits outside the tracked range are cleared too. That doesn't
+// affect this tracker, which never reports those pages, but anything else
+// relying on the process's soft-dirty bits (another tracker, CRIU's
+// pre-dump) loses them; use -no-clear to leave them alone.
 func (pt *ProcessTracker) ClearSoftDirty() error {
 	if !pt.isOpen {
 		return nil
 	}
+	for _, value := range pt.clearValues() {
+		if err := pt.writeClearRefs(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeClearRefs writes one value to clear_refs
+func (pt *ProcessTracker) writeClearRefs(value string) error {
+	if pt.helper != nil {
+		// The bare form is the original protocol, which other helpers speak
+		if value == clearRefsSoftDirty {
+			_, err := pt.helper.call("CLEAR %d", pt.pid)
+			return err
+		}
+		_, err := pt.helper.call("CLEAR %d %s", pt.pid, value)
+		return err
+	}
+	if pt.clearRefsFd < 0 {
+		return nil
+	}
 	_, err := syscall.Seek(pt.clearRefsFd, 0, 0)
 	if err != nil {
 		return err
 	}
-	_, err = syscall.Write(pt.clearRefsFd, []byte("4"))
+	_, err = syscall.Write(pt.clearRefsFd, []byte(value))
 	return err
 }
 
 func (pt *ProcessTracker) ParseMaps() ([]VMAInfo, error) {
-	mapsPath := fmt.Sprintf("/proc/%d/maps", pt.pid)
-	data, err := os.ReadFile(mapsPath)
+	var data []byte
+	var err error
+	if pt.helper != nil {
+		data, err = pt.helper.call("MAPS %d", pt.pid)
+	} else {
+		data, err = os.ReadFile(fmt.Sprintf("/proc/%d/maps", pt.pid))
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -193,116 +821,399 @@ func (pt *ProcessTracker) ParseMaps() ([]VMAInfo, error) {
 	lines := strings.Split(string(data), "\n")
 
 	for _, line := range lines {
-		if line == "" {
-			continue
+		if vma, ok := parseMapsLine(line); ok {
+			if pt.opts != nil && vma.Pathname != "" && matchAnyGlob(pt.opts.AnonLikePaths, vma.Pathname) {
+				vma.anonLike = true
+				if pt.anonLikeSeen != nil {
+					pt.anonLikeSeen[vma.Pathname] = struct{}{}
+				}
+			}
+			if pt.opts != nil && len(pt.opts.ClassifyRules) > 0 {
+				vma.label = classifyVMA(pt.opts.ClassifyRules, &vma)
+			}
+			vmas = append(vmas, vma)
 		}
+	}
 
-		fields := strings.Fields(line)
-		if len(fields) < 5 {
-			continue
-		}
+	pt.mapsBytes, pt.vmaCount = len(data), len(vmas)
+	return vmas, nil
+}
 
-		addrRange := strings.Split(fields[0], "-")
-		if len(addrRange) != 2 {
-			continue
+// matchAnyGlob reports whether name matches one of the patterns, where '*'
+// matches any run of characters (including '/') and everything else is
+// literal
+func matchAnyGlob(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if globMatch(pattern, name) {
+			return true
 		}
+	}
+	return false
+}
 
-		start, err := strconv.ParseUint(addrRange[0], 16, 64)
-		if err != nil {
-			continue
-		}
-		end, err := strconv.ParseUint(addrRange[1], 16, 64)
-		if err != nil {
-			continue
+func globMatch(pattern, name string) bool {
+	// Greedy match with backtracking to the most recent '*'
+	p, n := 0, 0
+	star, mark := -1, 0
+	for n < len(name) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, n
+			p++
+		case p < len(pattern) && pattern[p] == name[n]:
+			p++
+			n++
+		case star >= 0:
+			mark++
+			p, n = star+1, mark
+		default:
+			return false
 		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
 
-		offset, _ := strconv.ParseUint(fields[2], 16, 64)
-		inode, _ := strconv.ParseUint(fields[4], 10, 64)
+// parseMapsLine parses one /proc/[pid]/maps line. Addresses are parsed as
+// full 64-bit values, so 5-level paging (57-bit) layouts are handled.
+func parseMapsLine(line string) (VMAInfo, bool) {
+	if line == "" {
+		return VMAInfo{}, false
+	}
 
-		pathname := ""
-		if len(fields) > 5 {
-			pathname = fields[5]
-		}
+	fields := strings.Fields(line)
+	if len(fields) < 5 {
+		return VMAInfo{}, false
+	}
 
-		vmas = append(vmas, VMAInfo{
-			Start:    start,
-			End:      end,
-			Perms:    fields[1],
-			Offset:   offset,
-			Device:   fields[3],
-			Inode:    inode,
-			Pathname: pathname,
-		})
+	addrRange := strings.Split(fields[0], "-")
+	if len(addrRange) != 2 {
+		return VMAInfo{}, false
 	}
 
-	return vmas, nil
+	start, err := strconv.ParseUint(addrRange[0], 16, 64)
+	if err != nil {
+		return VMAInfo{}, false
+	}
+	end, err := strconv.ParseUint(addrRange[1], 16, 64)
+	if err != nil {
+		return VMAInfo{}, false
+	}
+
+	offset, _ := strconv.ParseUint(fields[2], 16, 64)
+	inode, _ := strconv.ParseUint(fields[4], 10, 64)
+
+	pathname := ""
+	if len(fields) > 5 {
+		pathname = fields[5]
+	}
+
+	return VMAInfo{
+		Start:    start,
+		End:      end,
+		Perms:    fields[1],
+		Offset:   offset,
+		Device:   fields[3],
+		Inode:    inode,
+		Pathname: pathname,
+		deleted:  len(fields) > 6 && fields[len(fields)-1] == "(deleted)",
+	}, true
+}
+
+// pagemapOffset returns the byte offset of addr's entry in pagemap. The
+// largest user address (2^57 with 5-level paging) gives 2^48, well within
+// int64.
+func pagemapOffset(addr uint64) int64 {
+	return int64(addr / PageSize * PagemapEntrySize)
 }
 
-func (pt *ProcessTracker) ReadDirtyPages(uniqueAddrs map[uint64]struct{}) ([]DirtyPage, error) {
+// entrySoftDirty reports whether a pagemap entry is soft-dirty. Bit 55
+// lies above both the PFN (bits 0-54) of a present entry and the swap
+// type (bits 0-4) and offset (bits 5-54) of a swapped one, and the kernel
+// carries it into the swap entry when a dirty page is swapped out, so it
+// reads the same for both. An entry that is neither is a hole: the kernel
+// reports it soft-dirty in VMAs created or grown since the last clear
+// (VM_SOFTDIRTY), though there is no page to transfer. Holes count as the
+// tracker always has unless skipHoles (-skip-holes) is set.
+func entrySoftDirty(entry uint64, skipHoles bool) bool {
+	if entry&SoftDirty == 0 {
+		return false
+	}
+	return !skipHoles || entry&(PagePresent|PageSwapped) != 0
+}
+
+// readPagemap reads pagemap entries starting at byte offset off
+func (pt *ProcessTracker) readPagemap(buf []byte, off int64) (int, error) {
+	if pt.helper != nil {
+		data, err := pt.helper.call("PAGEMAP %d %d %d", pt.pid, off, len(buf))
+		return copy(buf, data), err
+	}
+	if pt.opts.Strategy == ReadPread {
+		return syscall.Pread(pt.pagemapFd, buf, off)
+	}
+	if _, err := syscall.Seek(pt.pagemapFd, off, 0); err != nil {
+		return 0, err
+	}
+	return syscall.Read(pt.pagemapFd, buf)
+}
+
+// ReadDirtyPages returns the process's soft-dirty pages and adds their
+// addresses to uniqueAddrs. At most limit pages are returned (all when
+// limit is negative); count includes the ones past the limit.
+func (pt *ProcessTracker) ReadDirtyPages(uniqueAddrs map[uint64]struct{}, limit int) (dirtyPages []DirtyPage, count int, err error) {
 	if !pt.isOpen {
-		return nil, nil
+		return nil, 0, nil
 	}
 
 	vmas, err := pt.ParseMaps()
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	var dirtyPages []DirtyPage
+	pt.explosion.note(pt.pid, len(vmas), pt.opts.ExplosionVMAs)
+	pt.swappedDirty = 0
+	pt.stackGrown, pt.stackRedirtied = 0, 0
+	pt.cleanCount, pt.cleanAddrs = 0, nil
+	if pt.opts.TrackMoves {
+		pt.noteMoves(vmas)
+	}
+	pt.noteTypeChanges(vmas)
 
 	// Pre-allocate buffer for reading pagemap entries
+	scan := pt.opts.Strategy == ReadScan
 	maxPages := 0
 	for _, vma := range vmas {
-		if vma.IsWritable() {
+		if vma.IsWritable() && !scan {
 			numPages := int((vma.End - vma.Start) / PageSize)
 			if numPages > maxPages {
 				maxPages = numPages
 			}
 		}
 	}
-	buf := make([]byte, maxPages*PagemapEntrySize)
+	buf := pt.readBuffer(maxPages)
 
-	for _, vma := range vmas {
+	var clusters *clusterReader
+	if pt.opts.Coalesce && !scan {
+		clusters = planClusters(vmas, pt.opts.CoalesceGap, pt.opts.MaxBufferPages)
+	}
+
+	var locked map[uint64]uint64
+	if pt.opts.DetectLocked {
+		// A process whose smaps can't be read is reported as unlocked
+		locked, _ = readLockedBytes(pt.pid)
+	}
+
+	var arenas map[uint64]string
+	if pt.opts.DetectArenas {
+		arenas = mallocArenas(vmas, pt.isGoProgram())
+	}
+
+	var vmaIds []string
+	if pt.opts.RelativeAddr {
+		vmaIds = vmaIdentities(vmas)
+		if pt.opts.Anonymizer != nil {
+			for i := range vmaIds {
+				vmaIds[i] = pt.opts.Anonymizer.vmaID(vmaIds[i])
+			}
+		}
+	}
+
+	for vmaIdx, vma := range vmas {
 		if !vma.IsWritable() {
 			continue
 		}
+		if pt.opts.OnlyVMAAt != 0 && (pt.opts.OnlyVMAAt < vma.Start || pt.opts.OnlyVMAAt >= vma.End) {
+			continue
+		}
+		if pt.opts.SkipDeviceBacked && vma.IsDeviceBacked() {
+			if pt.deviceSkipped != nil {
+				pt.deviceSkipped[vmaRef{pt.pid, vma.Start, vma.End}] = struct{}{}
+			}
+			continue
+		}
+		if pt.opts.PrivateAnonOnly {
+			if !vma.IsPrivateAnon() {
+				continue
+			}
+			if pt.anonIncluded != nil {
+				pt.anonIncluded[vmaRef{pt.pid, vma.Start, vma.End}] = struct{}{}
+			}
+		}
 
-		startPage := vma.Start / PageSize
-		numPages := (vma.End - vma.Start) / PageSize
-		pagemapOffset := int64(startPage * PagemapEntrySize)
+		vmaType := vma.VMAType()
+		pathname := vma.Pathname
+		if pt.opts.Anonymizer != nil {
+			pathname = pt.opts.Anonymizer.path(pathname)
+		}
+		lockedBytes := locked[vma.Start]
+		if lockedBytes > 0 {
+			pt.noteLocked(&vma, pathname, lockedBytes)
+		}
+		sharedObj, isShared := sharedKey(&vma)
+		isShared = isShared && pt.shared != nil
+		// The main stack grows down; pages below where it started at the
+		// last read are new. A [stack] given another type by
+		// -classify-rules isn't counted as the main stack.
+		mainStack := vma.Pathname == "[stack]" && vmaType == "stack"
+		var growthEnd uint64
+		if vma.Pathname == "[stack]" {
+			if mainStack && pt.stackStart != 0 && vma.Start < pt.stackStart {
+				growthEnd = pt.stackStart
+			}
+			pt.stackStart = vma.Start
+		}
+		origin, moved := pt.movedFrom[vma.Start]
+		moved = moved && pt.opts.RemapMoves
+		arena := arenas[vma.Start]
+		writeback := pt.opts.FileWriteback && vma.isFileWriteback()
 
-		_, err := syscall.Seek(pt.pagemapFd, pagemapOffset, 0)
-		if err != nil {
-			continue
+		// record adds the i-th page of the VMA, whose pagemap entry is
+		// soft-dirty (with ReadScan only the SoftDirty bit is known)
+		record := func(i uint64, entry uint64) {
+			addr := vma.Start + i*PageSize
+			if pt.opts.AddressMask != nil {
+				if _, ok := pt.opts.AddressMask[addr]; !ok {
+					return
+				}
+			}
+			pt.notePageClass(addr, &vma)
+			// Soft-dirty bits can't be reset, so only growth of the set
+			// is new
+			if pt.cumulative != nil {
+				if _, ok := pt.cumulative[addr]; ok {
+					return
+				}
+				pt.cumulative[addr] = struct{}{}
+			}
+			uniqueAddr := addr
+			if moved {
+				uniqueAddr = origin + (addr - vma.Start)
+			}
+			if isShared {
+				var ok bool
+				key := sharedFilePage{sharedObj, vma.Offset/PageSize + i}
+				if uniqueAddr, ok = pt.shared.attribute(key, addr, pt.pid); !ok {
+					return
+				}
+			}
+			if pt.opts.ResidentOnly && entry&PagePresent == 0 {
+				pt.swappedDirty++
+				return
+			}
+			count++
+			uniqueAddrs[uniqueAddr] = struct{}{}
+			if addr < growthEnd {
+				pt.stackGrown++
+			} else if mainStack {
+				pt.stackRedirtied++
+			}
+			if limit >= 0 && len(dirtyPages) >= limit {
+				return
+			}
+
+			page := DirtyPage{
+				Addr:     fmt.Sprintf("0x%x", addr),
+				VMAType:  vmaType,
+				VMAPerms: vma.Perms,
+				Pathname: pathname,
+				VMAStart: fmt.Sprintf("0x%x", vma.Start),
+				VMAEnd:   fmt.Sprintf("0x%x", vma.End),
+				Size:     int(PageSize),
+				vmaStart: vma.Start,
+				vmaSize:  vma.End - vma.Start,
+				pid:      pt.pid,
+			}
+			if pt.shared != nil {
+				page.ObservedByPid = pt.pid
+			}
+			page.InLockedVMA = lockedBytes > 0
+			page.StackGrowth = addr < growthEnd
+			page.Arena = arena
+			page.FileWriteback = writeback
+			if moved {
+				page.StableAddr = fmt.Sprintf("0x%x", origin+(addr-vma.Start))
+			}
+			if pt.opts.DecodeFlags {
+				exclusive := entry&PageExclusive != 0
+				fileOrShm := entry&PageFile != 0
+				swapped := entry&PageSwapped != 0
+				page.Exclusive = &exclusive
+				page.FileOrShm = &fileOrShm
+				page.Swapped = &swapped
+			}
+			if vmaIds != nil {
+				page.VMAId = vmaIds[vmaIdx]
+				page.VMAOffset = fmt.Sprintf("0x%x", addr-vma.Start)
+			}
+			if pt.zero != nil && entry&PagePresent != 0 {
+				pt.checkZero(addr)
+			}
+			dirtyPages = append(dirtyPages, page)
 		}
 
-		readSize := int(numPages * PagemapEntrySize)
-		n, err := syscall.Read(pt.pagemapFd, buf[:readSize])
-		if err != nil || n == 0 {
+		if scan {
+			var regions []pageRegion
+			regions, pt.scanVec, err = scanSoftDirty(pt.pagemapFd, vma.Start, vma.End, pt.scanVec, pt.opts.SkipHoles)
+			if isAccessError(err) {
+				return dirtyPages, count, err
+			}
+			for _, region := range regions {
+				for addr := region.start; addr < region.end; addr += PageSize {
+					record((addr-vma.Start)/PageSize, SoftDirty)
+				}
+			}
 			continue
 		}
 
-		actualPages := n / PagemapEntrySize
-		vmaType := vma.VMAType()
+		if clusters != nil {
+			data, ok, err := clusters.entries(pt, &vma)
+			if isAccessError(err) {
+				return dirtyPages, count, err
+			}
+			if ok {
+				for i := 0; i+PagemapEntrySize <= len(data); i += PagemapEntrySize {
+					entry := binary.LittleEndian.Uint64(data[i : i+PagemapEntrySize])
+					if entrySoftDirty(entry, pt.opts.SkipHoles) {
+						record(uint64(i/PagemapEntrySize), entry)
+					} else if pt.opts.EmitClean && entry&PagePresent != 0 {
+						pt.noteClean(vma.Start + uint64(i/PagemapEntrySize)*PageSize)
+					}
+				}
+				continue
+			}
+		}
 
-		for i := 0; i < actualPages; i++ {
-			entry := binary.LittleEndian.Uint64(buf[i*PagemapEntrySize : (i+1)*PagemapEntrySize])
+		// VMAs larger than the buffer are read in several passes
+		numPages := (vma.End - vma.Start) / PageSize
+		passPages := uint64(len(buf) / PagemapEntrySize)
+		for first := uint64(0); first < numPages; first += passPages {
+			readSize := int(min(passPages, numPages-first) * PagemapEntrySize)
+			n, err := pt.readPagemap(buf[:readSize], pagemapOffset(vma.Start+first*PageSize))
+			if isAccessError(err) {
+				return dirtyPages, count, err
+			}
+			if err != nil || n == 0 {
+				break
+			}
 
-			if entry&SoftDirty != 0 {
-				addr := vma.Start + uint64(i)*PageSize
-				dirtyPages = append(dirtyPages, DirtyPage{
-					Addr:     fmt.Sprintf("0x%x", addr),
-					VMAType:  vmaType,
-					VMAPerms: vma.Perms,
-					Pathname: vma.Pathname,
-					Size:     PageSize,
-				})
-				uniqueAddrs[addr] = struct{}{}
+			actualPages := n / PagemapEntrySize
+			for i := 0; i < actualPages; i++ {
+				entry := binary.LittleEndian.Uint64(buf[i*PagemapEntrySize : (i+1)*PagemapEntrySize])
+				if entrySoftDirty(entry, pt.opts.SkipHoles) {
+					record(first+uint64(i), entry)
+				} else if pt.opts.EmitClean && entry&PagePresent != 0 {
+					pt.noteClean(vma.Start + (first+uint64(i))*PageSize)
+				}
+			}
+			if n < readSize {
+				break
 			}
 		}
 	}
 
-	return dirtyPages, nil
+	return dirtyPages, count, nil
 }
 
 // DirtyPageTracker is the main tracker with child process support
@@ -312,17 +1223,165 @@ type DirtyPageTracker struct {
 	trackChildren bool
 	workloadName  string
 	noClear       bool
+	emaAlpha      float64
+	until         time.Time // absolute deadline; overrides Run's duration when set
+	captureMaps   bool
+	regionSize    uint64 // aggregation granularity; 0 means per-page only
+	interDirty    bool   // histogram the time between re-dirtyings of a page
+	jaccard       bool   // compare consecutive samples' dirty sets
+	entropy       bool   // spatial entropy of each sample's dirty pages
+	perProcess    bool   // also report the average rate per tracked process
+	perCPU        bool   // also report the average rate per online CPU
+	pageTables    bool   // estimate the page tables behind the dirty pages
+	wallClock     bool   // record each sample's wall-clock time
+	readOpts      ReadOptions
+	stream        *SampleStream // optional per-sample NDJSON output
+	liveCSV       *liveCSV      // optional per-sample CSV rate feed
+	progress      *progressFifo // optional JSON progress feed (see progressfifo.go)
+	metadata      *Metadata
+	cpuInterval   time.Duration // sample every this much tracked CPU time instead of wall time
+	recordNsPids  bool          // look up each process's namespaced PID
+	debugRuntime  bool          // record the tracker's own heap/GC stats per sample
+	once          bool          // take a single sample one interval after clearing
+	peakWindow    time.Duration // sliding window for the sustained peak rate
+	binWidth      time.Duration // width of the binned_timeline bins
+	shareGroup    *sharedPages  // also track processes sharing writable memory with the root
+	helper        *helperClient // privileged helper for /proc access (-helper)
+	ioStats       bool          // record storage I/O per sample (see iostats.go)
+	psi           bool          // record memory pressure per sample (see psi.go)
+	mapsStats     bool          // record VMA count and maps size per sample
+	rssGrowth     bool          // record RSS per sample (see rss.go)
+	smapsRollup   bool          // record smaps_rollup dirty size per sample (see rollup.go)
+	frontier      *frontier     // write newly dirty/clean pages instead of page lists (see frontier.go)
+	recordTargets bool          // record the command line and start of each root in metadata
+	maxCPUPct     float64       // keep the tracker's own CPU under this (see cpubudget.go)
+	skipSleeping  bool          // don't read processes whose threads are all asleep
+	deterministic bool          // normalize the output for golden tests (see deterministic.go)
+
+	// Record thread instruction pointers in samples with at least
+	// ripThreshold dirty pages, through ptrace with ripPtrace, and count
+	// the threads that couldn't be read (see rip.go)
+	ripThreshold int
+	ripPtrace    bool
+	ripFailures  int
+
+	maxTracked int // most processes tracked at once; 0 is unlimited
+
+	// Varying intervals (-schedule, see schedule.go)
+	schedule *sampleSchedule
+
+	// clear_refs values written at each clear, nil for soft-dirty only
+	// (-clear-mode, see clearmode.go)
+	clearMode []string
+
+	// Children held out while sharing a parent's mm, to their index in
+	// vforkEvents; nil without -detect-vfork (see vfork.go)
+	openVforks  map[int]int
+	vforkEvents []VforkEvent
+
+	rawPagemap *rawPagemapDump // pending -raw-pagemap snapshot
+
+	// What to do with a discovered process's first read: "" keeps it,
+	// "discard" or "pre-tracking" (discard but report the count) hold it out
+	childFirstSample string
+	maxDepth         int           // descendant generations to track; -1 is unlimited
+	openRetries      int           // extra Open attempts on EACCES/ENOENT
+	openBackoff      time.Duration // delay before the first retry, doubled each time
+	weightedEstimate bool          // weight unique pages by re-dirty probability
+	downtimeBudget   int           // pages transferable while stopped; 0 = off
+	hotSetFraction   float64       // share of intervals a hot-set page is dirty in; 0 = off
+	autocorrLags     int           // max lag of the rate autocorrelation; 0 = off
+
+	// Stream size limit in bytes (0 = none) and "truncate" or "stop"; see
+	// outputlimit.go
+	outputLimit         int64
+	outputLimitAction   string
+	outputDetailDropped int
+
+	// Pages listed per sample beyond which only counting continues
+	// (-max-pages-per-sample); 0 lists all
+	maxPagesPerSample int
+
+	// Receives when sampling may start (-start-on-signal)
+	startSignal <-chan os.Signal
+
+	// Open the capture and sample on interval boundaries (-align-clock),
+	// and the state once aligned (see clockalign.go)
+	alignClock bool
+	aligner    *clockAligner
+
+	// Also track processes whose command line matches (see cmdline.go)
+	cmdlineRegex       *regexp.Regexp
+	cmdlineLimitWarned bool
+
+	// Track a process group's members instead of a tree (see pgid.go)
+	pgid int
+
+	// Convergence detection (see converge.go)
+	convergeRate    float64
+	convergeSamples int
+	onConverge      string
+	convergeStop    bool
+
+	// Rate level changes (-threshold, see thresholds.go)
+	alerter *rateAlerter
+
+	// Sample and clear only every N intervals (-accumulate, see
+	// accumulate.go)
+	accum *accumulation
+
+	// Check every Nth clear (-verify-clear, see clearcheck.go)
+	clearCheck *clearCheck
+
+	// Stop at a number of unique pages (-stop-at-unique, see uniquetarget.go)
+	uniqueTarget *UniqueTarget
 
 	mu              sync.Mutex
 	trackers        map[int]*ProcessTracker
 	knownPids       map[int]struct{}
 	deadPids        map[int]struct{}
+	capSkipped      map[int]struct{} // turned away at -max-tracked (see fdlimit.go)
+	accessLost      map[int]struct{} // alive but no longer readable (see handleAccessLoss)
 	samples         []DirtySample
 	uniqueAddrs     map[uint64]struct{}
 	totalDirtyPages int
+	startMaps       []VMAInfo
+	endMaps         []VMAInfo
+	cpuSeen         map[int]*cpuAccount
+	ioSeen          map[int]ProcIO
+	processInfo     map[int]*ProcessInfo
+	converge        convergeState
+	convergeEvent   *ConvergeEvent
+	pauseEvents     []PauseEvent
+	restoreEvents   []RestoreEvent
+	follow          *restoreFollower // set with -follow-restore
+	trackerCPU      *TrackerCPUStats
+	forkEvents      []ForkEvent
+	regionMoves     []RegionMove
+	typeChanged     map[typeChangeKey]struct{} // seen by reads, see typechange.go
+	typeChanges     []TypeChange
+	deviceSkipped   map[vmaRef]struct{}
+	anonIncluded    map[vmaRef]struct{}
+	lockedVMAs      map[vmaRef]*LockedVMA
+	explosion       *mapsExplosion
+	zeroStats       *ZeroPageStats // set with -detect-zero
+	anonLikeSeen    map[string]struct{}
+	noClearRefs     map[int]struct{} // opened without clear_refs (see checkClearRefs)
+	freshPids       map[int]struct{} // discovered processes not yet read once
+	lastShareScan   time.Time
+	hookWG          sync.WaitGroup
+
+	stopCh     chan struct{}
+	stopOnce   sync.Once
+	stopReason string
+	startTime  time.Time
+	paused     atomic.Bool
 
-	stopCh    chan struct{}
-	startTime time.Time
+	// Watchdog: warn (and optionally stop) when no sample has been recorded
+	// for watchdogMult intervals
+	watchdogMult   float64
+	watchdogAbort  bool
+	lastSampleNano atomic.Int64
 }
 
 func NewDirtyPageTracker(rootPid, intervalMs int, trackChildren bool, workloadName string, noClear bool) *DirtyPageTracker {
@@ -332,23 +1391,52 @@ func NewDirtyPageTracker(rootPid, intervalMs int, trackChildren bool, workloadNa
 		trackChildren: trackChildren,
 		workloadName:  workloadName,
 		noClear:       noClear,
+		emaAlpha:      DefaultEMAAlpha,
+		maxDepth:      -1,
+		openRetries:   DefaultOpenRetries,
+		openBackoff:   DefaultOpenBackoff,
+		watchdogMult:  DefaultWatchdogMult,
 		trackers:      make(map[int]*ProcessTracker),
 		knownPids:     make(map[int]struct{}),
 		deadPids:      make(map[int]struct{}),
+		capSkipped:    make(map[int]struct{}),
+		accessLost:    make(map[int]struct{}),
+		deviceSkipped: make(map[vmaRef]struct{}),
+		anonIncluded:  make(map[vmaRef]struct{}),
+		lockedVMAs:    make(map[vmaRef]*LockedVMA),
+		explosion:     newMapsExplosion(),
+		anonLikeSeen:  make(map[string]struct{}),
+		noClearRefs:   make(map[int]struct{}),
+		freshPids:     make(map[int]struct{}),
+		cpuSeen:       make(map[int]*cpuAccount),
+		ioSeen:        make(map[int]ProcIO),
+		processInfo:   make(map[int]*ProcessInfo),
 		uniqueAddrs:   make(map[uint64]struct{}),
 		stopCh:        make(chan struct{}),
 	}
 }
 
+// discoverDescendants walks the children files below pid. Their PIDs are
+// those of the namespace /proc belongs to (see setns.go for -setns).
 func (dt *DirtyPageTracker) discoverDescendants(pid int) map[int]struct{} {
+	type pending struct {
+		pid   int
+		depth int
+	}
+
 	descendants := make(map[int]struct{})
-	toCheck := []int{pid}
+	toCheck := []pending{{pid, 0}}
 	checked := make(map[int]struct{})
 
 	for len(toCheck) > 0 {
-		currentPid := toCheck[0]
+		currentPid := toCheck[0].pid
+		depth := toCheck[0].depth
 		toCheck = toCheck[1:]
 
+		if dt.maxDepth >= 0 && depth >= dt.maxDepth {
+			continue
+		}
+
 		if _, ok := checked[currentPid]; ok {
 			continue
 		}
@@ -372,7 +1460,7 @@ func (dt *DirtyPageTracker) discoverDescendants(pid int) map[int]struct{} {
 			}
 			if _, ok := descendants[childPid]; !ok {
 				descendants[childPid] = struct{}{}
-				toCheck = append(toCheck, childPid)
+				toCheck = append(toCheck, pending{childPid, depth + 1})
 			}
 		}
 	}
@@ -387,19 +1475,126 @@ func (dt *DirtyPageTracker) addProcessTracker(pid int) bool {
 	if _, ok := dt.deadPids[pid]; ok {
 		return false
 	}
-
+	if _, ok := dt.accessLost[pid]; ok {
+		return false
+	}
+	// Tracking ourselves would measure the tracker's own buffers and clear
+	// its soft-dirty bits; dead pids are never retried
+	if isSelf(pid) {
+		fmt.Fprintf(os.Stderr, "Warning: not tracking PID %d, which is the tracker itself\n", pid)
+		dt.deadPids[pid] = struct{}{}
+		return false
+	}
+	if dt.atCapacity(pid) {
+		dt.noteCapSkipped(pid, fmt.Sprintf("-max-tracked %d reached", dt.maxTracked))
+		return false
+	}
+
 	tracker := NewProcessTracker(pid)
-	if err := tracker.Open(); err != nil {
+	tracker.opts = &dt.readOpts
+	tracker.shared = dt.shareGroup
+	tracker.helper = dt.helper
+	tracker.deviceSkipped = dt.deviceSkipped
+	tracker.anonIncluded = dt.anonIncluded
+	tracker.lockedVMAs = dt.lockedVMAs
+	tracker.explosion = dt.explosion
+	tracker.zero = dt.zeroStats
+	tracker.anonLikeSeen = dt.anonLikeSeen
+	tracker.clearMode = dt.clearMode
+	if pid != dt.rootPid && dt.childFirstSample != "" {
+		dt.freshPids[pid] = struct{}{}
+	}
+	if err := dt.openWithRetry(tracker); err != nil {
+		tracker.Close()
+		// Out of descriptors says nothing about the process
+		if errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) {
+			dt.noteCapSkipped(pid, "out of file descriptors")
+			return false
+		}
 		dt.deadPids[pid] = struct{}{}
 		return false
 	}
 
 	dt.trackers[pid] = tracker
 	dt.knownPids[pid] = struct{}{}
+	dt.checkClearRefs(pid, tracker)
+	if dt.recordNsPids {
+		info := &ProcessInfo{HostPid: pid}
+		if nsPid, err := readNSpid(pid); err == nil {
+			info.NsPid = nsPid
+		}
+		dt.processInfo[pid] = info
+	}
 	tracker.ClearSoftDirty()
 	return true
 }
 
+// openWithRetry opens the tracker, retrying with backoff while the process
+// exists but its pagemap is not yet accessible (e.g. right after exec).
+func (dt *DirtyPageTracker) openWithRetry(tracker *ProcessTracker) error {
+	backoff := dt.openBackoff
+	err := tracker.Open()
+	for attempt := 0; err != nil && attempt < dt.openRetries; attempt++ {
+		if !errors.Is(err, syscall.EACCES) && !errors.Is(err, syscall.ENOENT) {
+			break
+		}
+		if !tracker.IsAlive() {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		err = tracker.Open()
+	}
+	return err
+}
+
+// handleAccessLoss deals with EPERM/EACCES from a tracked process. A dead
+// process is left to removeDeadProcesses. A live one (typically after a
+// setuid) is re-opened if we are still permitted, losing only the current
+// interval; otherwise it is dropped and reported in access_lost_pids
+// rather than silently showing zero dirty pages. Must be called with dt.mu
+// held.
+func (dt *DirtyPageTracker) handleAccessLoss(pid int, tracker *ProcessTracker, cause error) {
+	if !tracker.IsAlive() {
+		return
+	}
+	tracker.Close()
+	if err := tracker.Open(); err == nil {
+		dt.checkClearRefs(pid, tracker)
+		tracker.ClearSoftDirty()
+		fmt.Fprintf(os.Stderr, "Re-opened process %d after %v\n", pid, cause)
+		return
+	}
+	tracker.Close()
+	delete(dt.trackers, pid)
+	dt.accessLost[pid] = struct{}{}
+	fmt.Fprintf(os.Stderr, "Warning: lost access to process %d (%v), no longer tracking it\n", pid, cause)
+}
+
+// checkClearRefs switches a freshly opened process whose clear_refs could
+// not be opened to cumulative tracking: what is soft-dirty now becomes the
+// baseline, and each sample reports only pages that joined the set since.
+// Pages dirtied again after they were first reported are invisible, so
+// the rate is a lower bound. -no-clear never clears and is unaffected.
+func (dt *DirtyPageTracker) checkClearRefs(pid int, tracker *ProcessTracker) {
+	if tracker.CanClear() || dt.noClear || tracker.cumulative != nil {
+		return
+	}
+	tracker.cumulative = make(map[uint64]struct{})
+	tracker.ReadDirtyPages(make(map[uint64]struct{}), 0)
+	dt.noClearRefs[pid] = struct{}{}
+	fmt.Fprintf(os.Stderr, "Warning: cannot open clear_refs of process %d; reporting growth of its cumulative soft-dirty set instead\n", pid)
+}
+
+// clearScope returns "process" when the run clears soft-dirty bits outside
+// the part of the address space it reads
+func (dt *DirtyPageTracker) clearScope() string {
+	if dt.noClear || !dt.readOpts.narrowed() {
+		return ""
+	}
+	return "process"
+}
+
 func (dt *DirtyPageTracker) removeDeadProcesses() {
 	for pid, tracker := range dt.trackers {
 		if !tracker.IsAlive() {
@@ -410,119 +1605,748 @@ func (dt *DirtyPageTracker) removeDeadProcesses() {
 	}
 }
 
+// cpuAccount is a process's CPU ticks when first seen and most recently
+type cpuAccount struct {
+	base uint64
+	last uint64
+}
+
+// trackedCPUTicks returns the CPU ticks used by tracked processes since each
+// was first seen. Exited processes keep contributing their last reading, so
+// the total never goes backwards. Caller must hold dt.mu.
+func (dt *DirtyPageTracker) trackedCPUTicks() uint64 {
+	for pid := range dt.trackers {
+		stat, err := readProcStat(pid)
+		if err != nil {
+			continue
+		}
+		if acct, ok := dt.cpuSeen[pid]; ok {
+			acct.last = max(acct.last, stat.CPUTicks())
+		} else {
+			dt.cpuSeen[pid] = &cpuAccount{base: stat.CPUTicks(), last: stat.CPUTicks()}
+		}
+	}
+	var total uint64
+	for _, acct := range dt.cpuSeen {
+		total += acct.last - acct.base
+	}
+	return total
+}
+
+// waitForCPU polls until the tracked processes have used cpuInterval more
+// CPU time than fromTicks, or the run reaches its deadline. It returns true
+// if the run was stopped while waiting.
+func (dt *DirtyPageTracker) waitForCPU(fromTicks uint64, deadline time.Time) bool {
+	target := fromTicks + uint64(dt.cpuInterval*ClockTicksPerSec/time.Second)
+	for time.Now().Before(deadline) {
+		select {
+		case <-dt.stopCh:
+			return true
+		case <-time.After(cpuPollInterval):
+		}
+		dt.mu.Lock()
+		ticks := dt.trackedCPUTicks()
+		dt.mu.Unlock()
+		// Idle waiting is progress as far as the watchdog is concerned
+		dt.lastSampleNano.Store(time.Now().UnixNano())
+		if ticks >= target {
+			return false
+		}
+	}
+	return false
+}
+
+// trackNewDescendants starts tracking descendants not seen before. Must
+// be called with dt.mu held.
+func (dt *DirtyPageTracker) trackNewDescendants() {
+	descendants := dt.discoverDescendants(dt.rootPid)
+	if dt.openVforks != nil {
+		dt.endVforks(descendants)
+	}
+	for childPid := range descendants {
+		if _, known := dt.knownPids[childPid]; !known {
+			if _, dead := dt.deadPids[childPid]; !dead {
+				if dt.openVforks != nil && dt.holdVforkChild(childPid) {
+					continue
+				}
+				if dt.addProcessTracker(childPid) {
+					fmt.Fprintf(os.Stderr, "Tracking child process: %d\n", childPid)
+					// Children found before the first sample predate tracking
+					if len(dt.samples) > 0 {
+						dt.forkEvents = append(dt.forkEvents, ForkEvent{
+							Pid:              childPid,
+							TimestampMs:      float64(time.Since(dt.startTime).Microseconds()) / 1000.0,
+							firstSampleIndex: len(dt.samples),
+						})
+					}
+				}
+			}
+		}
+	}
+}
+
 func (dt *DirtyPageTracker) Run(duration time.Duration) {
 	dt.startTime = time.Now()
+	if !dt.until.IsZero() {
+		duration = dt.until.Sub(dt.startTime)
+	}
 	interval := time.Duration(dt.intervalMs) * time.Millisecond
 
 	// Initialize root process tracker
 	if !dt.addProcessTracker(dt.rootPid) {
 		fmt.Fprintf(os.Stderr, "Failed to open root process %d\n", dt.rootPid)
+		dt.stopReason = "open_failed"
 		return
 	}
+	dt.recordTarget(dt.rootPid)
+	if dt.captureMaps {
+		dt.startMaps, _ = dt.trackers[dt.rootPid].ParseMaps()
+	}
+	if dt.startSignal != nil && dt.waitForStart() && !dt.until.IsZero() {
+		duration = dt.until.Sub(dt.startTime)
+	}
+	if dt.alignClock && dt.alignToClock(interval) && !dt.until.IsZero() {
+		duration = dt.until.Sub(dt.startTime)
+	}
 
 	deadline := time.Now().Add(duration)
 	sampleCount := 0
+	if dt.cpuInterval > 0 {
+		// Establish per-process baselines
+		dt.mu.Lock()
+		dt.trackedCPUTicks()
+		dt.mu.Unlock()
+	}
+	var sampleTicks uint64
+	var rtStats *RuntimeStats
+	if dt.debugRuntime && dt.metadata != nil {
+		rtStats = newRuntimeStats()
+		dt.metadata.Runtime = rtStats
+	}
+	var budget *cpuBudget
+	if dt.maxCPUPct > 0 && dt.cpuInterval == 0 {
+		budget = newCPUBudget(dt.maxCPUPct, interval)
+	}
+	var iterCPU time.Duration
+
+	// Set when a stop arrives between samples: one more (shortened) sample
+	// is taken so the activity since the last one isn't lost
+	partial := false
+	// Set while paused so the next recorded sample is marked as resumed
+	resumed := false
+	// Set after re-attaching to a restored process (see restore.go)
+	restored := false
+	if dt.follow != nil {
+		dt.follow.rootStart, _ = liveStart(dt.rootPid)
+	}
+
+	// -once: everything is cleared up front, so wait one interval before
+	// the single read instead of sampling immediately
+	if dt.once {
+		if dt.trackChildren {
+			dt.mu.Lock()
+			dt.trackNewDescendants()
+			dt.mu.Unlock()
+		}
+		wait := interval
+		if dt.aligner != nil {
+			wait = dt.aligner.untilNext()
+		}
+		select {
+		case <-dt.stopCh:
+			partial = true
+		case <-time.After(wait):
+		}
+	}
+
+	dt.lastSampleNano.Store(time.Now().UnixNano())
+	watchdogDone := make(chan struct{})
+	if dt.watchdogMult > 0 {
+		stall := interval
+		if dt.schedule != nil {
+			stall = dt.schedule.longest()
+		}
+		go dt.watchdog(stall, watchdogDone)
+	}
 
 	for {
 		iterStart := time.Now()
+		if dt.aligner != nil && !partial {
+			dt.aligner.started(iterStart)
+		}
+		if budget != nil {
+			iterCPU = selfCPUTime()
+		}
 
 		// Check stop conditions
-		select {
-		case <-dt.stopCh:
-			goto cleanup
-		default:
+		if !partial {
+			select {
+			case <-dt.stopCh:
+				goto cleanup
+			default:
+			}
+
+			if !dt.once && time.Now().After(deadline) {
+				dt.stopWithReason("duration")
+				goto cleanup
+			}
 		}
 
-		if time.Now().After(deadline) {
-			goto cleanup
+		if dt.paused.Load() && !partial {
+			dt.mu.Lock()
+			if dt.trackChildren {
+				dt.trackNewDescendants()
+			}
+			dt.removeDeadProcesses()
+			if !dt.noClear {
+				for _, tracker := range dt.trackers {
+					tracker.ClearSoftDirty()
+				}
+				if dt.accum != nil {
+					dt.accum.restart(float64(time.Since(dt.startTime).Microseconds()) / 1000.0)
+				}
+			}
+			if dt.ioStats {
+				// Rebase so the resumed sample's I/O covers one interval
+				dt.sampleIO()
+			}
+			dt.mu.Unlock()
+			dt.lastSampleNano.Store(time.Now().UnixNano())
+			resumed = true
+
+			select {
+			case <-dt.stopCh:
+				goto cleanup
+			case <-time.After(dt.untilNextSample(iterStart, interval)):
+			}
+			continue
+		}
+
+		var restoredPid int
+		var restoredStart uint64
+		restoreFound := false
+		if dt.follow != nil && !partial {
+			restoredPid, restoredStart, restoreFound = dt.follow.restored(dt.rootPid)
 		}
 
 		dt.mu.Lock()
 
+		if restoreFound && dt.attachRestored(restoredPid, restoredStart, float64(time.Since(dt.startTime).Microseconds())/1000.0) {
+			// Give the restored process one interval from its clear
+			dt.mu.Unlock()
+			dt.lastSampleNano.Store(time.Now().UnixNano())
+			restored = true
+			select {
+			case <-dt.stopCh:
+				goto cleanup
+			case <-time.After(dt.untilNextSample(iterStart, interval)):
+			}
+			continue
+		}
+
 		// Discover new child processes
 		if dt.trackChildren {
-			descendants := dt.discoverDescendants(dt.rootPid)
-			for childPid := range descendants {
-				if _, known := dt.knownPids[childPid]; !known {
-					if _, dead := dt.deadPids[childPid]; !dead {
-						if dt.addProcessTracker(childPid) {
-							fmt.Fprintf(os.Stderr, "Tracking child process: %d\n", childPid)
-						}
-					}
-				}
+			dt.trackNewDescendants()
+		}
+		if dt.shareGroup != nil {
+			if time.Since(dt.lastShareScan) >= shareGroupRescan {
+				dt.trackShareGroup()
 			}
+			dt.shareGroup.startSample()
+		}
+		if dt.cmdlineRegex != nil {
+			dt.trackCmdlineMatches()
+		}
+		if dt.pgid > 0 {
+			dt.trackProcessGroup()
 		}
 
 		// Remove dead processes
 		dt.removeDeadProcesses()
 
+		// The run's last sample reads everything so no deferred pages are lost
+		lastSample := partial || dt.once || time.Until(deadline) <= interval ||
+			(dt.schedule != nil && dt.schedule.last())
+
+		// -accumulate: only a window's last interval is read (see
+		// accumulate.go)
+		if dt.accum != nil && !dt.accum.advance(interval) && !lastSample {
+			dt.mu.Unlock()
+			dt.lastSampleNano.Store(time.Now().UnixNano())
+			if dt.schedule != nil {
+				// Not the schedule's last interval, or lastSample would be set
+				interval, _ = dt.schedule.next()
+			}
+			if dt.cpuInterval > 0 {
+				sampleTicks += uint64(dt.cpuInterval * ClockTicksPerSec / time.Second)
+				partial = dt.waitForCPU(sampleTicks, deadline)
+				continue
+			}
+			select {
+			case <-dt.stopCh:
+				partial = true
+			case <-time.After(dt.untilNextSample(iterStart, interval)):
+			}
+			continue
+		}
+
 		// Read dirty pages from all tracked processes
-		var allDirtyPages []DirtyPage
-		var trackedPids []int
+		// Empty rather than nil: the Python format has lists, never null
+		allDirtyPages := []DirtyPage{}
+		trackedPids := []int{}
+
+		perPid := make(map[int]int, len(dt.trackers))
+		var preTrackingPids []int
+		preTrackingCount := 0
+		dirtyCount, swappedCount := 0, 0
+		stackGrown, stackRedirtied := 0, 0
+		cleanCount := 0
+		var cleanPages map[int][]string
+		cleanListed := 0
+		vmaCount, mapsBytes := 0, 0
+		var moves []RegionMove
+		var typeChanges []TypeChange
+		var idlePids []int
 
+		dt.dumpRawPagemap()
+		verifyClear := dt.clearCheck != nil && (sampleCount+1)%dt.clearCheck.every == 0
+		clearIneffective := false
 		for pid, tracker := range dt.trackers {
 			trackedPids = append(trackedPids, pid)
-			dirtyPages, err := tracker.ReadDirtyPages(dt.uniqueAddrs)
+			// A sleeping process is neither read nor cleared, so whatever it
+			// dirtied since the last read shows up in a later sample
+			if dt.skipSleeping && !lastSample && processSleeping(pid) {
+				idlePids = append(idlePids, pid)
+				continue
+			}
+			// A newly discovered child's first read is kept out of the
+			// sample and the unique set (see -child-first-sample)
+			addrSet := dt.uniqueAddrs
+			_, fresh := dt.freshPids[pid]
+			if fresh {
+				addrSet = make(map[uint64]struct{})
+				delete(dt.freshPids, pid)
+			}
+			limit := -1
+			if dt.maxPagesPerSample > 0 {
+				limit = max(dt.maxPagesPerSample-len(allDirtyPages), 0)
+			}
+			tracker.cleanLimit = -1
+			if dt.maxPagesPerSample > 0 {
+				tracker.cleanLimit = max(dt.maxPagesPerSample-cleanListed, 0)
+			}
+			dirtyPages, count, err := tracker.ReadDirtyPages(addrSet, limit)
 			if err == nil {
+				vmaCount += tracker.vmaCount
+				mapsBytes += tracker.mapsBytes
+			}
+			moves = append(moves, tracker.takeMoves()...)
+			typeChanges = append(typeChanges, tracker.takeTypeChanges()...)
+			if err == nil && fresh {
+				preTrackingPids = append(preTrackingPids, pid)
+				preTrackingCount += count
+			} else if err == nil {
 				allDirtyPages = append(allDirtyPages, dirtyPages...)
+				dirtyCount += count
+				perPid[pid] = count
+				swappedCount += tracker.swappedDirty
+				stackGrown += tracker.stackGrown
+				stackRedirtied += tracker.stackRedirtied
+				cleanCount += tracker.cleanCount
+				if len(tracker.cleanAddrs) > 0 {
+					if cleanPages == nil {
+						cleanPages = make(map[int][]string)
+					}
+					cleanPages[pid] = tracker.cleanAddrs
+					cleanListed += len(tracker.cleanAddrs)
+				}
+			} else if isAccessError(err) {
+				dt.handleAccessLoss(pid, tracker, err)
+				continue
 			}
 			if !dt.noClear {
-				tracker.ClearSoftDirty()
+				if err := tracker.ClearSoftDirty(); isAccessError(err) {
+					dt.handleAccessLoss(pid, tracker, err)
+				} else if err == nil && verifyClear && tracker.cumulative == nil {
+					clearIneffective = dt.clearCheck.verify(pid, tracker, dirtyPages) || clearIneffective
+				}
 			}
 		}
 
-		elapsedMs := float64(time.Since(dt.startTime).Microseconds()) / 1000.0
+		now := time.Now()
+		elapsedMs := float64(now.Sub(dt.startTime).Microseconds()) / 1000.0
 
 		sample := DirtySample{
 			TimestampMs:     elapsedMs,
 			DirtyPages:      allDirtyPages,
-			DeltaDirtyCount: len(allDirtyPages),
+			DeltaDirtyCount: dirtyCount,
 			PidsTracked:     trackedPids,
+			Partial:         partial,
+			Resumed:         resumed,
+			Restored:        restored,
+			Truncated:       dirtyCount > len(allDirtyPages),
+			IdlePids:        idlePids,
+			Idle:            len(idlePids) > 0 && len(idlePids) == len(trackedPids),
+			PreTrackingPids: preTrackingPids,
+			perPid:          perPid,
+		}
+		sample.ClearIneffective = clearIneffective
+		sample.SwappedDirtyCount = swappedCount
+		sample.StackGrowthCount, sample.StackRedirtyCount = stackGrown, stackRedirtied
+		sample.CleanCount, sample.CleanPages = cleanCount, cleanPages
+		if dt.wallClock {
+			sample.WallTimeMs = float64(now.UnixMicro()) / 1000.0
+		}
+		if dt.childFirstSample == "pre-tracking" {
+			sample.PreTrackingDirtyCount = preTrackingCount
+		}
+		if dt.ioStats {
+			sample.IO = dt.sampleIO()
+		}
+		if dt.mapsStats {
+			sample.VMACount, sample.MapsBytes = vmaCount, mapsBytes
+		}
+		if dt.psi {
+			sample.PSI, _ = readMemoryPressure()
+		}
+		if dt.rssGrowth {
+			sample.RSSBytes = sampleRSS(trackedPids)
+		}
+		if dt.smapsRollup {
+			sample.RollupDirtyKB = sampleRollupDirty(trackedPids)
+		}
+		if dt.ripThreshold > 0 && dirtyCount >= dt.ripThreshold {
+			sample.RIPs = dt.sampleRIPs(perPid)
+		}
+		if dt.shareGroup != nil {
+			sample.SharedDuplicates = dt.shareGroup.duplicates
+			sample.SharedObservations = dt.shareGroup.observed
+		}
+		resumed = false
+		restored = false
+		if dt.cpuInterval > 0 {
+			sampleTicks = dt.trackedCPUTicks()
+			sample.CPUTimeMs = float64(sampleTicks) * 1000 / ClockTicksPerSec
+		}
+		for _, move := range moves {
+			move.TimestampMs = elapsedMs
+			move.sampleIndex = len(dt.samples)
+			dt.regionMoves = append(dt.regionMoves, move)
+		}
+		for _, change := range typeChanges {
+			change.TimestampMs = elapsedMs
+			dt.noteTypeChange(change)
 		}
+		if dt.frontier != nil {
+			dt.frontier.advance(&sample)
+		}
+		if dt.schedule != nil {
+			sample.IntervalMs = float64(interval.Microseconds()) / 1000.0
+		}
+		if dt.accum != nil {
+			dt.accum.stamp(&sample, elapsedMs)
+		}
+		dt.limitDetail(&sample)
 		dt.samples = append(dt.samples, sample)
 		sampleCount++
-		dt.totalDirtyPages += len(allDirtyPages)
+		dt.totalDirtyPages += dirtyCount
+		reachedUnique := dt.uniqueTarget != nil && dt.uniqueTarget.reach(len(dt.uniqueAddrs), elapsedMs)
 
 		dt.mu.Unlock()
+		dt.lastSampleNano.Store(time.Now().UnixNano())
+
+		dt.checkConvergence(&sample, sampleCount-1)
+		if dt.alerter != nil {
+			dt.alerter.observe(&sample, sampleCount-1)
+		}
+		if rtStats != nil {
+			rtStats.record(sample.TimestampMs)
+		}
+
+		if dt.stream != nil {
+			streamed := &sample
+			if dt.frontier != nil {
+				streamed = frontierOnly(&sample)
+			}
+			if err := dt.stream.WriteSample(streamed); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: sample stream write failed, disabling stream: %v\n", err)
+				dt.stream.Close()
+				dt.stream = nil
+			}
+			dt.checkOutputLimit()
+		}
+		if dt.liveCSV != nil {
+			if err := dt.liveCSV.write(&sample); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: live CSV write failed, disabling it: %v\n", err)
+				dt.liveCSV.Close()
+				dt.liveCSV = nil
+			}
+		}
 
-		if sampleCount%10 == 0 {
+		if sampleCount%logEverySamples == 0 {
 			fmt.Fprintf(os.Stderr, "Sample %d: %d dirty pages, %d processes\n",
-				sampleCount, len(allDirtyPages), len(trackedPids))
+				sampleCount, dirtyCount, len(trackedPids))
+		}
+		if dt.progress != nil {
+			dt.progress.observe(&sample, sampleCount)
+		}
+
+		if partial {
+			goto cleanup
+		}
+		if reachedUnique {
+			fmt.Fprintf(os.Stderr, "Reached %d unique pages after %.0f ms, stopping\n",
+				dt.uniqueTarget.UniquePages, elapsedMs)
+			dt.stopWithReason("unique_target")
+			goto cleanup
+		}
+		if dt.once {
+			dt.stopWithReason("once")
+			goto cleanup
+		}
+		// A stop that arrived while sampling ends the run with this sample
+		select {
+		case <-dt.stopCh:
+			goto cleanup
+		default:
+		}
+		if dt.schedule != nil {
+			next, ok := dt.schedule.next()
+			if !ok {
+				dt.stopWithReason("schedule")
+				goto cleanup
+			}
+			interval = next
+		}
+
+		if dt.cpuInterval > 0 {
+			partial = dt.waitForCPU(sampleTicks, deadline)
+			continue
+		}
+
+		if budget != nil {
+			interval = budget.next(selfCPUTime() - iterCPU)
 		}
 
 		// Sleep for remaining time to maintain accurate interval
-		elapsed := time.Since(iterStart)
-		if remaining := interval - elapsed; remaining > 0 {
-			time.Sleep(remaining)
+		if remaining := dt.untilNextSample(iterStart, interval); remaining > 0 {
+			select {
+			case <-dt.stopCh:
+				partial = true
+			case <-time.After(remaining):
+			}
 		}
 	}
 
 cleanup:
+	close(watchdogDone)
+	if budget != nil {
+		dt.trackerCPU = budget.finish()
+	}
 	dt.mu.Lock()
+	if dt.captureMaps {
+		if tracker, ok := dt.trackers[dt.rootPid]; ok {
+			if vmas, err := tracker.ParseMaps(); err == nil {
+				dt.endMaps = vmas
+			}
+		}
+	}
 	for _, tracker := range dt.trackers {
 		tracker.Close()
 	}
 	dt.mu.Unlock()
+	if dt.liveCSV != nil {
+		dt.liveCSV.Close()
+	}
+	if dt.progress != nil {
+		dt.progress.finish()
+		dt.progress.Close()
+	}
+	// An array stream is closed by the caller once the summary is known
+	if dt.stream != nil && !dt.stream.array {
+		if err := dt.stream.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: closing sample stream: %v\n", err)
+		}
+	}
+	dt.hookWG.Wait()
+	if dt.rawPagemap != nil && !dt.rawPagemap.done {
+		fmt.Fprintf(os.Stderr, "Warning: -raw-pagemap not written; the run ended before sample %d\n", dt.rawPagemap.sample)
+	}
 	fmt.Fprintf(os.Stderr, "Stopped tracking (total %d samples)\n", sampleCount)
 }
 
+// watchdog warns when the sampling loop has not produced a sample for
+// watchdogMult intervals, e.g. because a /proc read hangs on a frozen
+// process. With watchdogAbort it also stops the run with reason "stalled";
+// the loop exits once the blocked call returns.
+func (dt *DirtyPageTracker) watchdog(interval time.Duration, done <-chan struct{}) {
+	limit := time.Duration(dt.watchdogMult * float64(interval))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	warned := false
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		since := time.Since(time.Unix(0, dt.lastSampleNano.Load()))
+		if since < limit {
+			warned = false
+			continue
+		}
+		if warned {
+			continue
+		}
+		warned = true
+		fmt.Fprintf(os.Stderr, "Warning: no sample for %v (%gx interval), sampling loop may be stalled\n",
+			since.Round(time.Millisecond), dt.watchdogMult)
+		if dt.watchdogAbort {
+			dt.stopWithReason("stalled")
+		}
+	}
+}
+
 func (dt *DirtyPageTracker) Stop() {
-	close(dt.stopCh)
+	dt.stopWithReason("stopped")
+}
+
+// TogglePause pauses or resumes sampling and records the event. While
+// paused the trackers stay open and soft-dirty keeps being cleared every
+// interval, so nothing accumulates, but no samples are recorded.
+func (dt *DirtyPageTracker) TogglePause() bool {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	paused := !dt.paused.Load()
+	dt.paused.Store(paused)
+
+	event := PauseEvent{
+		TimestampMs: float64(time.Since(dt.startTime).Microseconds()) / 1000.0,
+		Event:       "resume",
+	}
+	if paused {
+		event.Event = "pause"
+	}
+	dt.pauseEvents = append(dt.pauseEvents, event)
+	return paused
+}
+
+// waitForStart blocks until the -start-on-signal signal arrives, then
+// clears the soft-dirty bits again and restarts the clock so the capture
+// window opens at the signal. It returns false if a stop came first, which
+// the sampling loop then sees before taking any sample.
+func (dt *DirtyPageTracker) waitForStart() bool {
+	fmt.Fprintf(os.Stderr, "Waiting for SIGUSR1 (kill -USR1 %d) to start sampling\n", os.Getpid())
+	select {
+	case <-dt.startSignal:
+	case <-dt.stopCh:
+		return false
+	}
+
+	now := time.Now()
+	delay := now.Sub(dt.startTime)
+	dt.restartCapture(now)
+	if dt.metadata != nil {
+		dt.metadata.StartTime = now.Format(time.RFC3339Nano)
+		delayMs := float64(delay) / float64(time.Millisecond)
+		dt.metadata.StartDelayMs = &delayMs
+	}
+	fmt.Fprintf(os.Stderr, "Start signal received after %.1fs\n", delay.Seconds())
+	return true
+}
+
+// restartCapture opens the capture window anew at now: processes are
+// rediscovered and cleared again, and the clock is restarted
+func (dt *DirtyPageTracker) restartCapture(now time.Time) {
+	dt.mu.Lock()
+	if dt.trackChildren {
+		dt.trackNewDescendants()
+	}
+	dt.removeDeadProcesses()
+	if !dt.noClear {
+		for _, tracker := range dt.trackers {
+			tracker.ClearSoftDirty()
+		}
+	}
+	if dt.ioStats {
+		dt.sampleIO()
+	}
+	if dt.accum != nil {
+		dt.accum.restart(0)
+	}
+	dt.mu.Unlock()
+	dt.startTime = now
+}
+
+// stopWithReason stops the run, recording why unless it is already stopping
+func (dt *DirtyPageTracker) stopWithReason(reason string) {
+	dt.stopOnce.Do(func() {
+		dt.stopReason = reason
+		close(dt.stopCh)
+	})
 }
 
 func (dt *DirtyPageTracker) GetDirtyPattern() DirtyPattern {
 	dt.mu.Lock()
 	defer dt.mu.Unlock()
 
+	// After a restore the run is still reported under its original root
+	rootPid := dt.rootPid
+	if len(dt.restoreEvents) > 0 {
+		rootPid = dt.restoreEvents[0].OldPid
+	}
+	var maxDepth *int
+	if dt.maxDepth >= 0 {
+		maxDepth = &dt.maxDepth
+	}
+	samplingClock := ""
+	if dt.cpuInterval > 0 {
+		samplingClock = "cpu"
+	}
+
+	var processes []ProcessInfo
+	for _, info := range dt.processInfo {
+		processes = append(processes, *info)
+	}
+	sort.Slice(processes, func(i, j int) bool { return processes[i].HostPid < processes[j].HostPid })
+
+	var accessLost []int
+	for pid := range dt.accessLost {
+		accessLost = append(accessLost, pid)
+	}
+	sort.Ints(accessLost)
+
 	if len(dt.samples) == 0 {
-		return DirtyPattern{
-			Workload:        dt.workloadName,
-			RootPid:         dt.rootPid,
-			TrackChildren:   dt.trackChildren,
-			PageSize:        PageSize,
-			PagemapScanUsed: false,
-			ClearOnScan:     !dt.noClear,
+		empty := DirtyPattern{
+			Workload:          dt.workloadName,
+			RootPid:           rootPid,
+			TrackChildren:     dt.trackChildren,
+			MaxDepth:          maxDepth,
+			PageSize:          int(PageSize),
+			SamplingClock:     samplingClock,
+			PagemapScanUsed:   dt.readOpts.Strategy == ReadScan,
+			ClearOnScan:       !dt.noClear && clearsSoftDirty(dt.clearMode),
+			StopReason:        dt.stopReason,
+			Converged:         dt.convergeEvent,
+			UniqueTarget:      dt.uniqueTarget.result(0),
+			AccessLostPids:    accessLost,
+			PauseEvents:       dt.pauseEvents,
+			RestoreEvents:     dt.restoreEvents,
+			TrackerCPU:        dt.trackerCPU,
+			Processes:         processes,
+			Metadata:          dt.metadata,
+			Samples:           []DirtySample{},
+			DirtyRateTimeline: []DirtyRateEntry{},
+			StartMaps:         dt.startMaps,
+			EndMaps:           dt.endMaps,
 		}
+		empty.ClearRefsAvailable = len(dt.noClearRefs) == 0
+		empty.ClearScope = dt.clearScope()
+		empty.ClearMode = strings.Join(dt.clearMode, ",")
+		empty.Summary.VMAUniqueDistribution = map[string]float64{}
+		return empty
 	}
 
 	durationMs := dt.samples[len(dt.samples)-1].TimestampMs
@@ -530,11 +2354,27 @@ func (dt *DirtyPageTracker) GetDirtyPattern() DirtyPattern {
 	// Calculate VMA distribution
 	vmaCounts := make(map[string]int)
 	vmaSizes := make(map[string]int)
+	vmaUnique := make(map[string]map[uint64]struct{})
+	exclusiveDirty := 0
+	sizeBuckets := make(map[string]map[string]int)
 
 	for _, sample := range dt.samples {
 		for _, page := range sample.DirtyPages {
 			vmaCounts[page.VMAType]++
 			vmaSizes[page.VMAType] += page.Size
+			if vmaUnique[page.VMAType] == nil {
+				vmaUnique[page.VMAType] = make(map[uint64]struct{})
+			}
+			vmaUnique[page.VMAType][page.Address()] = struct{}{}
+			if page.Exclusive != nil && *page.Exclusive {
+				exclusiveDirty++
+			}
+			if size := page.vmaBytes(); size > 0 {
+				if sizeBuckets[page.VMAType] == nil {
+					sizeBuckets[page.VMAType] = make(map[string]int)
+				}
+				sizeBuckets[page.VMAType][vmaSizeBucket(size)]++
+			}
 		}
 	}
 
@@ -550,6 +2390,17 @@ func (dt *DirtyPageTracker) GetDirtyPattern() DirtyPattern {
 		}
 	}
 
+	// The same over distinct pages, each counted once however often it
+	// was dirtied
+	totalUnique := 0
+	for _, addrs := range vmaUnique {
+		totalUnique += len(addrs)
+	}
+	vmaUniqueDistribution := make(map[string]float64)
+	for vmaType, addrs := range vmaUnique {
+		vmaUniqueDistribution[vmaType] = float64(len(addrs)) / float64(totalUnique)
+	}
+
 	// Calculate dirty rate timeline
 	var timeline []DirtyRateEntry
 	cumulative := 0
@@ -557,18 +2408,42 @@ func (dt *DirtyPageTracker) GetDirtyPattern() DirtyPattern {
 	allPidsSeen := make(map[int]struct{})
 
 	var rates []float64
+	var perProcessRates []float64
+	var emaRate float64
+	touchedRegions := make(map[uint64]struct{})
+	var prevPages []uint64 // previous sample's dirty pages, with -jaccard
+	entropyBucket := uint64(HugeRegionSize)
+	if dt.regionSize > 0 {
+		entropyBucket = dt.regionSize
+	}
+	var entropySum float64
+	entropySamples := 0
 
 	for i, sample := range dt.samples {
 		cumulative += sample.DeltaDirtyCount
 		var rate float64
 
-		if i > 0 {
+		// A resumed or restored sample only covers the last cleared
+		// interval, not the gap since the previous recorded sample, so like
+		// sample 0 it has no rate and leaves the EMA alone
+		if i > 0 && !sample.discontinuous() {
 			deltaTime := (sample.TimestampMs - dt.samples[i-1].TimestampMs) / 1000.0
+			if dt.cpuInterval > 0 {
+				// Pages per CPU-second of the tracked processes
+				deltaTime = (sample.CPUTimeMs - dt.samples[i-1].CPUTimeMs) / 1000.0
+			}
 			if deltaTime > 0 {
 				rate = float64(sample.DeltaDirtyCount) / deltaTime
 			}
 		}
 
+		// Seed the EMA with the first real rate (sample 0 has no interval)
+		if i <= 1 {
+			emaRate = rate
+		} else if !sample.discontinuous() {
+			emaRate = dt.emaAlpha*rate + (1-dt.emaAlpha)*emaRate
+		}
+
 		numProcs := len(sample.PidsTracked)
 		if numProcs > maxProcesses {
 			maxProcesses = numProcs
@@ -577,19 +2452,67 @@ func (dt *DirtyPageTracker) GetDirtyPattern() DirtyPattern {
 			allPidsSeen[pid] = struct{}{}
 		}
 
-		timeline = append(timeline, DirtyRateEntry{
+		entry := DirtyRateEntry{
 			TimestampMs:      sample.TimestampMs,
 			RatePagesPerSec:  rate,
+			EMARate:          emaRate,
 			CumulativePages:  cumulative,
 			ProcessesTracked: numProcs,
-		})
+		}
+		if sample.IO != nil && i > 0 && !sample.discontinuous() {
+			// Bytes per wall-clock second, also under -cpu-interval
+			if wallSec := (sample.TimestampMs - dt.samples[i-1].TimestampMs) / 1000.0; wallSec > 0 {
+				entry.IOReadRate = float64(sample.IO.ReadBytes) / wallSec
+				entry.IOWriteRate = float64(sample.IO.WriteBytes) / wallSec
+			}
+		}
+		if dt.regionSize > 0 {
+			sampleRegions := make(map[uint64]struct{})
+			for j := range sample.DirtyPages {
+				region := sample.DirtyPages[j].Address() / dt.regionSize
+				sampleRegions[region] = struct{}{}
+				touchedRegions[region] = struct{}{}
+			}
+			entry.TouchedRegions = len(sampleRegions)
+		}
+		if dt.jaccard {
+			pages := samplePageNumbers(&dt.samples[i])
+			if i > 0 {
+				if sim, ok := jaccard(prevPages, pages); ok {
+					entry.DirtySetJaccard = &sim
+				}
+			}
+			prevPages = pages
+		}
+		if dt.entropy {
+			if h, ok := spatialEntropy(samplePageNumbers(&dt.samples[i]), entropyBucket); ok {
+				entry.SpatialEntropy = &h
+				entropySum += h
+				entropySamples++
+			}
+		}
+		timeline = append(timeline, entry)
 
 		if rate > 0 {
 			rates = append(rates, rate)
+			if numProcs > 0 {
+				perProcessRates = append(perProcessRates, rate/float64(numProcs))
+			}
 		}
 	}
 
-	// Calculate average and peak rates
+	// Mark the post-fork window of likely COW storms in the timeline
+	forkEvents := cowStorms(dt.samples, dt.forkEvents)
+	for _, fork := range forkEvents {
+		if !fork.LikelyCOWStorm {
+			continue
+		}
+		for j := fork.firstSampleIndex; j < fork.firstSampleIndex+cowWindowSamples; j++ {
+			timeline[j].LikelyCOWStorm = true
+		}
+	}
+
+	// Calculate average and peak rates
 	var avgRate, peakRate float64
 	if len(rates) > 0 {
 		sum := 0.0
@@ -603,7 +2526,7 @@ func (dt *DirtyPageTracker) GetDirtyPattern() DirtyPattern {
 	}
 
 	// Convert allPidsSeen to slice
-	var pidList []int
+	pidList := []int{}
 	for pid := range allPidsSeen {
 		pidList = append(pidList, pid)
 	}
@@ -611,7 +2534,7 @@ func (dt *DirtyPageTracker) GetDirtyPattern() DirtyPattern {
 	summary := Summary{
 		TotalUniquePages:    len(dt.uniqueAddrs),
 		TotalDirtyEvents:    dt.totalDirtyPages,
-		TotalDirtySizeBytes: dt.totalDirtyPages * PageSize,
+		TotalDirtySizeBytes: dt.totalDirtyPages * int(PageSize),
 		AvgDirtyRatePerSec:  avgRate,
 		PeakDirtyRate:       peakRate,
 		VMADistribution:     vmaDistribution,
@@ -620,81 +2543,1610 @@ func (dt *DirtyPageTracker) GetDirtyPattern() DirtyPattern {
 		IntervalMs:          float64(dt.intervalMs),
 		MaxProcessesTracked: maxProcesses,
 		TotalPidsSeen:       pidList,
+		ExclusiveDirtyPages: exclusiveDirty,
+		LocalityScore:       localityScore(dt.samples, len(dt.uniqueAddrs)),
+		VMASizeBuckets:      sizeBuckets,
+		FirstDirtyMs:        firstDirtyMs(dt.samples),
+	}
+	summary.MaxBurstPages, summary.MaxBurstTimestampMs = maxBurst(dt.samples)
+	summary.VMAUniqueDistribution = vmaUniqueDistribution
+	summary.TypeChangedPages, summary.TypeChangeExamples = vmaTypeChanges(dt.samples, dt.typeChanged, dt.typeChanges)
+	summary.StackGrowthPages, summary.StackRedirtyPages = stackGrowth(dt.samples)
+	if dt.readOpts.DetectArenas {
+		summary.ArenaDirty = arenaDirty(dt.samples)
+	}
+	summary.WallClock = wallClockReport(dt.samples)
+	if dt.pageTables {
+		summary.PageTables = pageTableEstimate(dt.samples, len(dt.uniqueAddrs))
+	}
+	if dt.readOpts.FileWriteback {
+		summary.SharedFileWriteback = fileWriteback(dt.samples, dt.totalDirtyPages, len(dt.uniqueAddrs))
+	}
+	if dt.readOpts.ResidentOnly {
+		summary.SwappedDirtyEvents = swappedDirtyEvents(dt.samples)
+		summary.AllDirtySizeBytes = (dt.totalDirtyPages + summary.SwappedDirtyEvents) * int(PageSize)
+	}
+	if dt.clearCheck != nil {
+		summary.ClearsVerified = dt.clearCheck.checked
+		summary.IneffectiveClears = dt.clearCheck.ineffective
+	}
+	if dt.perProcess && len(perProcessRates) > 0 {
+		sum := 0.0
+		for _, r := range perProcessRates {
+			sum += r
+		}
+		summary.AvgRatePerProcess = sum / float64(len(perProcessRates))
+	}
+	if dt.perCPU {
+		summary.AvgRatePerCPU = avgRate / float64(onlineCPUs())
+	}
+	if dt.cpuInterval == 0 {
+		if pct, ok := intervalsMetPct(dt.samples, float64(dt.intervalMs)); ok {
+			summary.IntervalsMetPct = &pct
+		}
+	}
+	if dt.captureMaps {
+		dirty := sortedAddrs(dt.uniqueAddrs)
+		summary.CleanWritableRegions = cleanWritableRegions(dt.startMaps, dt.endMaps, dirty)
+		summary.VMADirtyFraction = vmaDirtyFractions(dt.startMaps, dt.endMaps, dirty)
+		summary.GuardRegions = guardRegions(dt.startMaps, dt.endMaps)
+	}
+	if dt.readOpts.SkipDeviceBacked {
+		summary.DeviceVMAsSkipped = len(dt.deviceSkipped)
+	}
+	if dt.readOpts.PrivateAnonOnly {
+		summary.PrivateAnonVMAs = len(dt.anonIncluded)
+	}
+	if dt.entropy {
+		summary.EntropyBucketBytes = int(entropyBucket)
+		if entropySamples > 0 {
+			avg := entropySum / float64(entropySamples)
+			summary.AvgSpatialEntropy = &avg
+		}
+	}
+	if dt.explosion.maxVMAs > 0 {
+		summary.MapsExplosionDetected = true
+		summary.MapsExplosionMaxVMAs = dt.explosion.maxVMAs
+	}
+	if dt.readOpts.DetectLocked {
+		summary.LockedDirtyPages, summary.LockedVMAs = lockedSummary(dt.samples, dt.lockedVMAs)
+	}
+	for path := range dt.anonLikeSeen {
+		summary.AnonLikePaths = append(summary.AnonLikePaths, path)
+	}
+	sort.Strings(summary.AnonLikePaths)
+	for i := range dt.samples {
+		if dt.samples[i].Truncated {
+			summary.TruncatedSamples++
+		}
+	}
+	if dt.ioStats {
+		summary.IOCorrelation = ioCorrelation(dt.samples, timeline)
+	}
+	if dt.interDirty {
+		summary.InterDirtyTimeHistogram = interDirtyHistogram(dt.samples)
+	}
+	if dt.weightedEstimate {
+		effective := effectiveDirtySet(dt.samples)
+		summary.EffectiveDirtySetPages = &effective
+	}
+	if dt.downtimeBudget > 0 {
+		summary.DowntimeBudget = downtimeBudget(dt.samples, dt.downtimeBudget)
+	}
+	if dt.mapsStats {
+		counts := make([]float64, len(dt.samples))
+		for i := range dt.samples {
+			counts[i] = float64(dt.samples[i].VMACount)
+		}
+		stats := metricStats(counts)
+		summary.VMACountStats = &stats
+	}
+	summary.OutputDetailDropped = dt.outputDetailDropped
+	if dt.zeroStats != nil {
+		zero := dt.zeroStats.finish(summary.TotalDirtyEvents)
+		summary.ZeroPages = &zero
+	}
+	summary.RegionMoves = len(dt.regionMoves)
+	summary.CapSkippedProcesses = len(dt.capSkipped)
+	if dt.smapsRollup {
+		for i := range dt.samples {
+			summary.MaxRollupDirtyKB = max(summary.MaxRollupDirtyKB, dt.samples[i].RollupDirtyKB)
+		}
+		if summary.MaxRollupDirtyKB > 0 {
+			ratio := float64(summary.TotalUniquePages) * float64(PageSize) / 1024 / float64(summary.MaxRollupDirtyKB)
+			summary.RollupRatio = &ratio
+		}
+	}
+	var rssTimeline []RSSGrowthEntry
+	if dt.rssGrowth {
+		rssTimeline = rssGrowthTimeline(dt.samples)
+		for _, entry := range rssTimeline {
+			summary.PeakRSSBytes = max(summary.PeakRSSBytes, entry.RSSBytes)
+		}
+		summary.RSSGrowthBytes = int64(rssTimeline[len(rssTimeline)-1].RSSBytes) - int64(rssTimeline[0].RSSBytes)
+		summary.AllocationShare = allocationShare(rssTimeline)
+	}
+	if dt.ripThreshold > 0 {
+		for i := range dt.samples {
+			if dt.samples[i].DeltaDirtyCount >= dt.ripThreshold {
+				summary.RIPSpikeSamples++
+			}
+			summary.RIPsRecorded += len(dt.samples[i].RIPs)
+		}
+		summary.RIPFailures = dt.ripFailures
+	}
+	for _, move := range dt.regionMoves {
+		summary.MovedRegionBytes += move.SizeBytes
+	}
+	summary.OutputLimited = dt.stopReason == "output_limit"
+	if dt.autocorrLags > 0 && len(timeline) > 1 {
+		// Sample 0 has no interval and so no rate
+		series := make([]float64, len(timeline)-1)
+		for i := range series {
+			series[i] = timeline[i+1].RatePagesPerSec
+		}
+		summary.RateAutocorrelation = autocorrelation(series, dt.autocorrLags)
+		summary.AutocorrPeakLag = autocorrPeak(summary.RateAutocorrelation)
+		if dt.schedule == nil {
+			step := meanSampleStepMs(dt.samples, dt.cpuInterval > 0)
+			summary.AutocorrPeriodMs = float64(summary.AutocorrPeakLag) * step
+		}
+	}
+	if dt.hotSetFraction > 0 {
+		summary.HotSet = hotSet(dt.samples, dt.hotSetFraction)
+	}
+	if dt.peakWindow > 0 {
+		summary.PeakWindowMs = float64(dt.peakWindow.Microseconds()) / 1000.0
+		summary.SustainedPeakRate = sustainedPeakRate(dt.samples, summary.PeakWindowMs)
+	}
+	if dt.regionSize > 0 {
+		summary.RegionSizeBytes = int(dt.regionSize)
+		summary.TouchedRegions = len(touchedRegions)
 	}
 
 	return DirtyPattern{
 		Workload:           dt.workloadName,
-		RootPid:            dt.rootPid,
+		RootPid:            rootPid,
 		TrackChildren:      dt.trackChildren,
+		MaxDepth:           maxDepth,
 		TrackingDurationMs: durationMs,
-		PageSize:           PageSize,
-		PagemapScanUsed:    false,
-		ClearOnScan:        !dt.noClear,
-		Samples:            dt.samples,
+		PageSize:           int(PageSize),
+		SamplingClock:      samplingClock,
+		PagemapScanUsed:    dt.readOpts.Strategy == ReadScan,
+		ClearOnScan:        !dt.noClear && clearsSoftDirty(dt.clearMode),
+		ClearRefsAvailable: len(dt.noClearRefs) == 0,
+		ClearScope:         dt.clearScope(),
+		ClearMode:          strings.Join(dt.clearMode, ","),
+		StopReason:         dt.stopReason,
+		Converged:          dt.convergeEvent,
+		UniqueTarget:       dt.uniqueTarget.result(len(dt.uniqueAddrs)),
+		AccessLostPids:     accessLost,
+		PauseEvents:        dt.pauseEvents,
+		RestoreEvents:      dt.restoreEvents,
+		TrackerCPU:         dt.trackerCPU,
+		ForkEvents:         forkEvents,
+		VforkEvents:        dt.vforkEvents,
+		RateEvents:         dt.rateEvents(),
+		RegionMoves:        dt.regionMoves,
+		Processes:          processes,
+		Metadata:           dt.metadata,
+		Samples:            dt.outputSamples(),
 		Summary:            summary,
 		DirtyRateTimeline:  timeline,
+		BinnedTimeline:     binTimeline(dt.samples, float64(dt.binWidth.Microseconds())/1000.0),
+		RSSGrowthTimeline:  rssTimeline,
+		StartMaps:          dt.startMaps,
+		EndMaps:            dt.endMaps,
+	}
+}
+
+// parseGranularity converts a -granularity value into a region size in
+// bytes, with 0 meaning the default per-page accounting
+func parseGranularity(s string) (uint64, error) {
+	switch strings.ToUpper(s) {
+	case "", "4K":
+		return 0, nil
+	case "2M":
+		return HugeRegionSize, nil
+	default:
+		return 0, fmt.Errorf("unsupported granularity %q (use 4K or 2M)", s)
+	}
+}
+
+// loadAddressList reads page addresses, one per line, as hex with or
+// without a 0x prefix. Blank lines and lines starting with # are ignored,
+// and addresses are rounded down to their page.
+func loadAddressList(path string) (map[uint64]struct{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make(map[uint64]struct{})
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addr, err := strconv.ParseUint(strings.TrimPrefix(line, "0x"), 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid address %q", path, i+1, line)
+		}
+		addrs[addr&^uint64(PageSize-1)] = struct{}{}
+	}
+	return addrs, nil
+}
+
+// resolveThreadStack finds an address inside thread tid's stack VMA: the
+// "[stack:TID]" mapping on kernels that name it, "[stack]" for the main
+// thread, or otherwise the thread's current stack pointer.
+func resolveThreadStack(pid, tid int) (uint64, error) {
+	if _, err := os.Stat(fmt.Sprintf("/proc/%d/task/%d", pid, tid)); err != nil {
+		return 0, fmt.Errorf("thread %d not found in process %d", tid, pid)
+	}
+
+	vmas, err := NewProcessTracker(pid).ParseMaps()
+	if err != nil {
+		return 0, err
+	}
+	want := fmt.Sprintf("[stack:%d]", tid)
+	for _, vma := range vmas {
+		if vma.Pathname == want || (tid == pid && vma.Pathname == "[stack]") {
+			return vma.Start, nil
+		}
+	}
+
+	sp, err := threadStackPointer(pid, tid)
+	if err != nil {
+		return 0, err
+	}
+	for _, vma := range vmas {
+		if sp >= vma.Start && sp < vma.End {
+			return vma.Start, nil
+		}
+	}
+	return 0, fmt.Errorf("stack pointer 0x%x of thread %d is not in any mapping", sp, tid)
+}
+
+// writeCompressed writes data to w through the codec's encoder
+func writeCompressed(w io.Writer, data []byte, codec string) error {
+	return encodeCompressed(w, codec, func(cw io.Writer) error {
+		_, err := cw.Write(data)
+		return err
+	})
+}
+
+// encodeCompressed runs encode on a writer compressing into w
+func encodeCompressed(w io.Writer, codec string, encode func(io.Writer) error) error {
+	cw, err := newCompressedWriter(w, codec)
+	if err != nil {
+		return err
+	}
+	if err := encode(cw); err != nil {
+		return err
+	}
+	return cw.Close()
+}
+
+// writeOutputFile writes the final output through the given compression
+// codec, optionally fsyncing it, and moves it into place complete (see
+// atomicwrite.go)
+func writeOutputFile(path string, data []byte, fsync bool, codec string) error {
+	return encodeOutputFile(path, fsync, codec, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// encodeOutputFile is writeOutputFile with the output produced by encode
+func encodeOutputFile(path string, fsync bool, codec string, encode func(io.Writer) error) error {
+	f, err := createAtomic(path)
+	if err != nil {
+		return err
+	}
+	if err := encodeCompressed(f, codec, encode); err != nil {
+		f.abort()
+		return err
 	}
+	return f.commit(fsync)
 }
 
 func main() {
-	pid := flag.Int("pid", 0, "Process ID to track (required)")
+	if spec := os.Getenv(selfTestChildEnv); spec != "" {
+		runSelfTestChild(spec)
+		return
+	}
+	if os.Getenv(helperEnv) != "" {
+		runHelper()
+		return
+	}
+	if os.Getenv(setnsChildEnv) != "" {
+		if err := mountNamespaceProc(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: -setns: mounting /proc: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	pid := flag.Int("pid", 0, "Process ID to track (required unless -container or -exec is given)")
+	execWorkload := flag.Bool("exec", false, "Launch the command given after the flags (-- CMD ARGS...) and track it instead of -pid, until it exits (see exec.go)")
+	containerID := flag.String("container", "", "Track the init process of this Docker/containerd container (full or abbreviated ID) instead of -pid")
+	setns := flag.Bool("setns", false, "Track from inside the target's PID namespace, with in-namespace PIDs throughout; needs root (see setns.go)")
 	intervalMs := flag.Int("interval", 100, "Sampling interval in milliseconds")
 	durationSec := flag.Float64("duration", 10, "Tracking duration in seconds")
+	untilStr := flag.String("until", "", "Track until this absolute RFC3339 time (mutually exclusive with -duration)")
 	outputFile := flag.String("output", "", "Output JSON file (default: stdout)")
 	workload := flag.String("workload", "unknown", "Workload name")
 	trackChildren := flag.Bool("children", true, "Track child processes")
 	noClear := flag.Bool("no-clear", false, "Don't clear dirty bits after scan (accumulate mode)")
+	emaAlpha := flag.Float64("ema-alpha", DefaultEMAAlpha, "Smoothing factor (0,1] for the EMA dirty rate in the timeline")
+	captureMaps := flag.Bool("capture-maps", false, "Record the root process memory map at start and end of tracking")
+	granularity := flag.String("granularity", "4K", "Aggregation granularity for touched regions: 4K or 2M")
+	decodeFlags := flag.Bool("decode-flags", false, "Decode exclusive-mapping, file/shared-anon, and swapped pagemap bits per dirty page")
+	selfTest := flag.Bool("selftest", false, "Track a synthetic child with a known dirty rate and verify the measurement")
+	selfTestRate := flag.Int("selftest-rate", 2000, "Pages per second the -selftest child dirties")
+	selfTestPages := flag.Int("selftest-pages", 8192, "Working set size in pages for the -selftest child")
+	selfTestTolerance := flag.Float64("selftest-tolerance", 0.15, "Maximum relative error for -selftest to pass")
+	streamFile := flag.String("stream", "", "Also write each sample as a JSON line to this file while tracking")
+	streamAppend := flag.Bool("stream-append", false, "Append to the -stream file instead of truncating it")
+	flushEvery := flag.Int("flush-every", 1, "Flush the -stream buffer every N samples")
+	fsyncEvery := flag.Int("fsync-every", 0, "Fsync the -stream file every N samples and the final output on write (0 = never)")
+	rotateInterval := flag.Float64("rotate-interval", 0, "Start a new timestamped -stream segment every N seconds (0 = never)")
+	rotateSizeMB := flag.Int("rotate-size", 0, "Start a new timestamped -stream segment once the current one reaches N megabytes (0 = never)")
+	maxOutputMB := flag.Float64("max-output-mb", 0, "Stop the capture once -stream has written this many MB, and keep the final output under it too (0 = no limit; see outputlimit.go)")
+	outputLimitAction := flag.String("output-limit-action", "truncate", "Near -max-output-mb: truncate (drop per-page detail from 90% of the limit, then stop) or stop (keep detail, stop at the limit)")
+	streamArray := flag.Bool("stream-array", false, "Write -stream as one JSON document ({\"samples\":[...],\"summary\":{...}}) instead of JSON lines, closed properly on SIGINT/SIGTERM")
+	addressMask := flag.String("address-mask", "", "Only record dirty pages whose address is listed in this file (one hex address per line)")
+	maxDepth := flag.Int("max-depth", -1, "Track descendants at most N generations below the root (-1 = unlimited, 0 = root only)")
+	openRetries := flag.Int("open-retries", DefaultOpenRetries, "Retries when a process's pagemap is briefly unreadable (EACCES/ENOENT)")
+	openBackoffMs := flag.Int("open-backoff", int(DefaultOpenBackoff/time.Millisecond), "Initial delay in ms between open retries (doubles each retry)")
+	relativeAddr := flag.Bool("relative-addr", false, "Also report each dirty page as a VMA identity plus offset (comparable across ASLR restarts)")
+	watchdogMult := flag.Float64("watchdog", DefaultWatchdogMult, "Warn when no sample is produced for this many intervals (0 = disabled)")
+	watchdogAbort := flag.Bool("watchdog-abort", false, "Stop tracking with stop_reason=stalled when the watchdog fires")
+	format := flag.String("format", "json", "Output format: json, normalized (VMA table + page references), csv (columnar tables next to -output), folded (flamegraph.pl input of dirty bytes by VMA type and path), influx (InfluxDB line protocol of the rate timeline), delta (compact binary dirty page sets, see -decode-delta), matrix (sparse sample x address-bucket COO table), vma-csv (per-sample dirty pages by VMA type, for stacked-area charts), or md (Markdown report)")
+	withMetadata := flag.Bool("metadata", true, "Embed host, kernel, and tool version metadata in the output")
+	interDirty := flag.Bool("inter-dirty", false, "Histogram the time between consecutive dirtyings of the same page")
+	weightedEstimate := flag.Bool("weighted-estimate", false, "Report an effective dirty set size weighting each page by its re-dirty probability")
+	cpuIntervalMs := flag.Int("cpu-interval", 0, "Sample every N ms of tracked CPU time instead of wall time (rates become pages per CPU-second)")
+	nsPids := flag.Bool("ns-pids", false, "Record each tracked process's PID inside its PID namespace alongside the host PID")
+	useZstd := flag.Bool("zstd", false, "Compress the JSON output with zstd (implied by a .zst -output suffix; .gz selects gzip)")
+	merge := flag.Bool("merge", false, "Merge the JSON captures given as arguments into one pattern aligned on their start times, and exit (see merge.go)")
+	denormalize := flag.String("denormalize", "", "Convert a -format normalized capture back to the flat JSON format and exit")
+	threadID := flag.Int("thread", 0, "Only track the stack VMA of this thread (TID) of -pid; implies -children=false")
+	normalize := flag.String("normalize", "", "Also report normalized average rates: per-process, per-cpu, or both comma-separated")
+	convergeRate := flag.Float64("converge-rate", 0, "Consider the run converged when the EMA dirty rate stays below this many pages/sec (0 = off)")
+	convergeSamples := flag.Int("converge-samples", 5, "Consecutive samples below -converge-rate required for convergence")
+	onConverge := flag.String("on-converge", "", "Shell command to run on convergence (root PID passed as $1 and DIRTY_TRACKER_PID)")
+	convergeStop := flag.Bool("converge-stop", false, "Stop tracking once converged (after starting -on-converge)")
+	debugRuntime := flag.Bool("debug-runtime", false, "Record the tracker's own Go heap and GC stats after every sample in metadata.runtime (diagnostic)")
+	once := flag.Bool("once", false, "Take a single snapshot: clear, wait one interval, read once, and exit (ignores -duration)")
+	validate := flag.Bool("validate-schema", false, "Check the JSON output against the Python tracker's schema and exit non-zero on drift")
+	peakWindowMs := flag.Int("peak-window", 0, "Also report the peak dirty rate averaged over a sliding window of this many ms (sustained_peak_rate)")
+	shareGroup := flag.Bool("share-group", false, "Also track processes that map the root's writable shared files/shmem; shared pages are counted once, with each process's observations in shared_observations_by_pid")
+	helperPath := flag.String("helper", "", "Delegate pagemap/clear_refs/maps access to this privileged (e.g. setuid) helper binary")
+	noSamples := flag.Bool("no-samples", false, "Omit the raw samples from -format json output, keeping the summary and timeline computed from them")
+	skipDevice := flag.Bool("skip-device-backed", false, "Skip writable mappings backed by a device (device != 00:00, e.g. files, DAX, hugetlbfs)")
+	readStrategy := flag.String("read-strategy", ReadSeek, "How pagemap is read: seek, pread, or scan (PAGEMAP_SCAN, Linux 6.7+; see BenchmarkReadPagemap)")
+	streamChecksum := flag.Bool("checksum", false, "Seal each -stream sample line with a CRC-32C and end the file (or each segment) with a checksum of its contents; see checksum.go")
+	verifyStreamPath := flag.String("verify-stream", "", "Check the checksums of a -checksum stream file, report corrupt lines and truncation as JSON, and exit")
+	decodeDelta := flag.String("decode-delta", "", "Decode a -format delta file to JSON (timestamp and page addresses per sample) and exit")
+	childFirst := flag.String("child-first-sample", "keep", "A discovered child's first read, which may include pre-discovery writes: keep, discard, or pre-tracking (discard but count in pre_tracking_dirty_count)")
+	var sinks sinkList
+	flag.Var(&sinks, "sink", "Extra output FORMAT:DEST (file, -, unix:PATH, tcp:HOST:PORT); repeatable, see sinks.go")
+	maxPagesPerSample := flag.Int("max-pages-per-sample", 0, "List at most N dirty pages per sample; further pages are counted but not recorded (0 = no limit)")
+	ioStats := flag.Bool("io-stats", false, "Record storage read/write bytes of the tracked processes per sample from /proc/[pid]/io")
+	mapsStats := flag.Bool("maps-stats", false, "Record the tracked processes' total VMA count and /proc/[pid]/maps size per sample, and summarize the VMA count")
+	psi := flag.Bool("psi", false, "Record the system-wide memory pressure (some/full avg10 from /proc/pressure/memory) per sample")
+	anonLikePaths := flag.String("anon-like-path", "", "Comma-separated pathname globs ('*' matches anything) to count as anonymous memory, e.g. '/tmp/*codecache*'")
+	maxCPUPct := flag.Float64("max-cpu-pct", 0, "Stretch the sampling interval as needed to keep the tracker's own CPU use under this percentage (0 = off)")
+	expectPath := flag.String("expect", "", "Check the summary against this JSON file of expected values and tolerances after writing the output, exiting non-zero if a field is out of bounds (see expect.go)")
+	classifyRules := flag.String("classify-rules", "", "JSON file of ordered rules (path glob, perms, size range -> label) that classify VMAs ahead of the built-in types (see classify.go)")
+	listVMAsFlag := flag.Bool("list-vmas", false, "Print the target's parsed and classified VMAs (table, or JSON with -format json) and exit without sampling")
+	anonymizePaths := flag.Bool("anonymize-paths", false, "Replace file paths in the output with per-run HMAC labels (not recoverable; see anonymize.go)")
+	followRestore := flag.String("follow-restore", "", "Keep tracking across a CRIU checkpoint/restore, finding the restored root via pidfile:PATH or cmd:COMMAND (see restore.go)")
+	skipSleeping := flag.Bool("skip-sleeping", false, "Don't read or clear processes whose threads are all sleeping (S/D) at sample time; their pages are picked up by a later sample")
+	liveCSVPath := flag.String("live-csv", "", "Append one CSV line per sample (timestamp, rate, cumulative, procs) to this file (- for stdout) as it's produced, for live plotting")
+	repeat := flag.Int("repeat", 1, "Capture N times in a row and report mean/stddev of the headline metrics plus each run (see repeat.go)")
+	spatialEntropyFlag := flag.Bool("spatial-entropy", false, "Report the Shannon entropy of each sample's dirty pages over 2M address buckets (-granularity regions if set) in the timeline, and its mean")
+	jaccardFlag := flag.Bool("jaccard", false, "Report the Jaccard similarity of consecutive samples' dirty page sets in the timeline")
+	startOnSignal := flag.Bool("start-on-signal", false, "Open and clear the target, then wait for SIGUSR1 before sampling; the wait is recorded as metadata start_delay_ms")
+	autocorr := flag.Int("autocorr", 0, "Report the autocorrelation of the dirty rate up to this many samples of lag, and its peak as the likely period of cyclic dirtying (0 = off)")
+	hotSetFraction := flag.Float64("hot-set-fraction", 0, "Report the persistent hot set: pages dirtied in at least this fraction of intervals, 1 for every interval (0 = off)")
+	downtimeBudgetPages := flag.Int("downtime-budget-pages", 0, "Report, for each sample as a candidate checkpoint, the residual dirty set a stop there would have to transfer and whether it fits in N pages (0 = off)")
+	cmdlineRegex := flag.String("cmdline-regex", "", "Also track every process whose command line (arguments joined by spaces) matches this regex, rescanning each sample; without -pid the oldest match is the root")
+	emitPlot := flag.String("emit-plot", "", "Also write a gnuplot script or vega-lite spec of the rate timeline next to -output (see plot.go)")
+	privateAnonOnly := flag.Bool("private-anon-only", false, "Only track private anonymous memory (unnamed mappings, heap, and stacks), what CRIU dumps as page contents")
+	binMs := flag.Int("bin-ms", 0, "Also aggregate the samples into fixed bins of this many ms (binned_timeline), alongside the raw timeline")
+	deterministic := flag.Bool("deterministic", false, "Normalize timestamps, host metadata, and ordering so the output is byte-stable for golden tests (see deterministic.go)")
+	detectLocked := flag.Bool("detect-locked", false, "Tag dirty pages in VMAs with mlock'ed memory and report them, from /proc/[pid]/smaps (costly; see locked.go)")
+	maxBufferPages := flag.Int("max-read-buffer-pages", 1<<18, "Cap the pagemap read buffer at this many entries (8 bytes each) and read larger VMAs in passes; 0 for no cap")
+	explosionVMAs := flag.Int("maps-explosion-vmas", 20000, "Warn and report maps_explosion_detected when a process has more than this many VMAs; 0 disables")
+	uploadURL := flag.String("upload-url", "", "After writing the output, POST the capture as JSON to this HTTP(S) URL, with retries (see upload.go)")
+	uploadToken := flag.String("upload-token", "", "Bearer token for -upload-url (default $DIRTY_TRACKER_UPLOAD_TOKEN)")
+	uploadGzip := flag.Bool("upload-gzip", false, "Gzip the -upload-url body")
+	detectZero := flag.Bool("detect-zero", false, "Read present dirty pages from /proc/[pid]/mem and count the all-zero ones (costly; see zeropages.go)")
+	zeroSampleEvery := flag.Int("zero-sample-every", 1, "With -detect-zero, check only every Nth present dirty page")
+	trackMoves := flag.Bool("track-moves", false, "Detect writable regions moved by mremap between samples and record them in region_moves")
+	remapMoves := flag.Bool("remap-moves", false, "Like -track-moves, and count moved regions' pages in the unique set at their address before the move (see regionmove.go)")
+	alignClock := flag.Bool("align-clock", false, "Start and take samples on multiples of -interval past the Unix epoch, so independent trackers sample at the same wall-clock times (see clockalign.go)")
+	printSummaryLine := flag.Bool("summary-line", false, "After the run, print one line of key=value summary pairs to stdout (needs -output or a sink so stdout is free)")
+	sampleRIP := flag.Int("sample-rip", 0, "Record where the dirtying processes' threads execute in samples with at least N dirty pages; 0 disables (see rip.go)")
+	ripPtrace := flag.Bool("rip-ptrace", false, "With -sample-rip, read instruction pointers with ptrace, which briefly stops each thread")
+	rssGrowth := flag.Bool("rss-growth", false, "Record the tracked processes' resident set per sample and output its growth next to the dirty counts (see rss.go)")
+	maxTracked := flag.Int("max-tracked", 0, "Track at most N processes at once, keeping the root and the longest tracked; 0 takes as many as RLIMIT_NOFILE allows (see fdlimit.go)")
+	smapsRollup := flag.Bool("smaps-rollup", false, "Record the tracked processes' kernel-reported dirty size from smaps_rollup per sample, as a cheap cross-check of the soft-dirty counts (see rollup.go)")
+	frontierMode := flag.Bool("frontier", false, "List each sample's newly dirty and newly clean pages instead of all its dirty pages (see frontier.go)")
+	recordTarget := flag.Bool("record-target", false, "Record the command line and start time of the tracked root (and of command-line matches and restored roots) in metadata targets")
+	scheduleStr := flag.String("schedule", "", "Sample at varying intervals, e.g. \"1s x60, 100ms x100\": INTERVAL xCOUNT steps taken in turn (see schedule.go)")
+	clearMode := flag.String("clear-mode", "", "clear_refs values to write at each clear, comma-separated: 1-5 or referenced, anon, file, soft-dirty, peak-rss (default soft-dirty; see clearmode.go)")
+	detectVfork := flag.Bool("detect-vfork", false, "Don't track children while they share their parent's address space (vfork until exec), and record those windows in vfork_events (see vfork.go)")
+	rawPagemap := flag.String("raw-pagemap", "", "Write the complete pagemap entries of all writable VMAs to this binary file once, before -raw-pagemap-sample (see rawpagemap.go)")
+	rawPagemapSample := flag.Int("raw-pagemap-sample", 1, "Which sample (1-based) -raw-pagemap is taken before")
+	decodeRaw := flag.String("decode-raw-pagemap", "", "Decode a -raw-pagemap file to JSON (non-zero entries with their flags, PFN, or swap slot) and exit")
+	probeIntervalFlag := flag.Bool("probe-interval", false, "Time -probe-iterations full sampling passes over the target, -interval apart, print the p50/p90/p99 and the minimum feasible and recommended intervals as JSON, and exit (see probe.go)")
+	probeIterations := flag.Int("probe-iterations", 20, "Sampling passes timed by -probe-interval")
+	detectArenas := flag.Bool("detect-arenas", false, "Label heap dirty pages by glibc malloc arena (heuristic) and report per-arena dirty counts in the summary (see arenas.go)")
+	thresholdStr := flag.String("threshold", "", "Record changes of the dirty rate between levels NAME:RATE, e.g. warn:1000,crit:5000 pages/sec, in rate_events (see thresholds.go)")
+	thresholdSamples := flag.Int("threshold-samples", 3, "Consecutive samples a new -threshold level must hold before its event is recorded")
+	thresholdLog := flag.Bool("threshold-log", false, "Also print -threshold events to stderr as they happen")
+	accumulate := flag.Int("accumulate", 1, "Sample and clear soft-dirty bits only every N intervals, so each sample is the distinct pages dirtied over its N-interval window (see accumulate.go)")
+	experimentID := flag.String("experiment-id", "", "Record this ID in metadata experiment_id to group related captures; every capture also gets a random metadata capture_id")
+	coalesceGap := flag.Int("coalesce-gap", -1, "Read runs of writable VMAs at most this many pages apart with one pagemap read each; -1 reads every VMA separately (see coalesce.go)")
+	splitByType := flag.Bool("split-by-vma-type", false, "Write a separate JSON capture per VMA type (-output prefix.heap.json, prefix.anonymous.json, ...), each with its own summary (see splitvmatype.go)")
+	verifyClear := flag.Int("verify-clear", 0, "After every Nth sample's clear, re-read some just-dirtied pages and report clears that left them soft-dirty (see clearcheck.go); 0 disables")
+	postProcessProg := flag.String("post-process", "", "Pipe the final JSON capture through this program; its stdout becomes the output (falls back to the JSON if it fails; see postprocess.go)")
+	skipHoles := flag.Bool("skip-holes", false, "Don't count soft-dirty pagemap entries with no page behind them (holes in VMAs created or grown since the last clear), only present or swapped pages")
+	residentOnly := flag.Bool("resident-only", false, "Count only present dirty pages in the pages, counts, and rates, reporting swapped ones apart, to size a RAM-to-RAM transfer (see resident.go)")
+	stopAtUnique := flag.Int("stop-at-unique", 0, "Stop once the run has seen this many distinct dirty pages, recording the time it took in unique_target (0 = off; see uniquetarget.go)")
+	emitClean := flag.Bool("emit-clean", false, "Also count each sample's present writable pages that stayed clean (clean_count), the complement of the dirty pages (see clean.go)")
+	emitCleanAddrs := flag.Bool("emit-clean-addrs", false, "With -emit-clean, also list the clean page addresses per process (clean_pages; as large as resident memory)")
+	wallClock := flag.Bool("wall-clock", false, "Also record each sample's system clock time (wall_time_ms) and report clock steps and drift against the monotonic timestamps in the summary (see wallclock.go)")
+	trackPageTables := flag.Bool("track-pagetables", false, "Estimate the page-table pages (PTE/PMD/PUD) spanned by the dirty pages and report them in the summary (see pagetables.go)")
+	progressFifoPath := flag.String("progress-fifo", "", "Write a JSON progress line (elapsed, samples, rate, procs) to this named pipe with every progress log, without ever blocking on the reader (see progressfifo.go)")
+	modelCapture := flag.String("model", "", "Replay this JSON capture against a pre-copy live migration model and print the predicted rounds, downtime, and transfer, and exit (see model.go)")
+	modelBandwidth := flag.Float64("model-bandwidth", 1000, "Migration bandwidth in MiB/s for -model")
+	modelMaxRounds := flag.Int("model-max-rounds", 30, "Pre-copy rounds before -model stops the process regardless of the downtime")
+	modelDowntimeMs := flag.Float64("model-downtime-ms", 300, "Downtime budget for -model: stop and copy once the rest fits in this many ms")
+	modelCompression := flag.Float64("model-compression", 1, "Compression ratio (>= 1) of the pages sent in -model")
+	modelMemoryMB := flag.Float64("model-memory-mb", 0, "Memory copied by -model's first round, in MiB (default: the capture's writable start_maps, else its unique dirty pages)")
+	sharedFileWriteback := flag.Bool("shared-file-writeback", false, "Tag dirty pages of MAP_SHARED file mappings, which go back to the file rather than into a checkpoint, and report them apart (see writeback.go)")
+	pgid := flag.Int("pgid", 0, "Track the processes of this process group, rescanning each sample, instead of -pid's tree; the leader (or oldest member) is the root (see pgid.go)")
 
 	flag.Parse()
 
-	if *pid == 0 {
-		fmt.Fprintln(os.Stderr, "Error: -pid is required")
-		flag.Usage()
-		os.Exit(1)
+	if *merge {
+		if flag.NArg() < 2 {
+			fmt.Fprintln(os.Stderr, "Error: -merge needs at least two capture files as arguments")
+			os.Exit(1)
+		}
+		pattern, err := mergeCaptures(flag.Args())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if *experimentID != "" && pattern.Metadata != nil {
+			pattern.Metadata.ExperimentID = *experimentID
+		}
+		jsonData, err := json.MarshalIndent(pattern, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding JSON: %v\n", err)
+			os.Exit(1)
+		}
+		if *outputFile != "" {
+			if err := writeOutputFile(*outputFile, jsonData, false, codecNone); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing file: %v\n", err)
+				os.Exit(1)
+			}
+		} else {
+			fmt.Println(string(jsonData))
+		}
+		return
+	}
+	if *modelCapture != "" {
+		if *modelBandwidth <= 0 || *modelMaxRounds < 1 || *modelDowntimeMs < 0 || *modelCompression < 1 || *modelMemoryMB < 0 {
+			fmt.Fprintln(os.Stderr, "Error: -model needs a positive -model-bandwidth, -model-max-rounds of at least 1, -model-compression of at least 1, and non-negative -model-downtime-ms and -model-memory-mb")
+			os.Exit(1)
+		}
+		model, err := modelMigration(*modelCapture, ModelParams{
+			BandwidthMiBps:   *modelBandwidth,
+			MaxRounds:        *modelMaxRounds,
+			DowntimeBudgetMs: *modelDowntimeMs,
+			CompressionRatio: *modelCompression,
+			MemoryMiB:        *modelMemoryMB,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := printModel(model, *outputFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing model: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *denormalize != "" {
+		pattern, err := denormalizeFile(*denormalize)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		jsonData, err := json.MarshalIndent(pattern, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding JSON: %v\n", err)
+			os.Exit(1)
+		}
+		if *outputFile != "" {
+			if err := writeOutputFile(*outputFile, jsonData, false, codecNone); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing file: %v\n", err)
+				os.Exit(1)
+			}
+		} else {
+			fmt.Println(string(jsonData))
+		}
+		return
 	}
 
-	tracker := NewDirtyPageTracker(*pid, *intervalMs, *trackChildren, *workload, *noClear)
+	if *decodeRaw != "" {
+		snap, err := decodeRawPagemapFile(*decodeRaw)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		jsonData, err := json.MarshalIndent(snap, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding JSON: %v\n", err)
+			os.Exit(1)
+		}
+		if *outputFile != "" {
+			if err := writeOutputFile(*outputFile, jsonData, false, codecNone); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing file: %v\n", err)
+				os.Exit(1)
+			}
+		} else {
+			fmt.Println(string(jsonData))
+		}
+		return
+	}
 
-	// Handle Ctrl+C
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<-sigCh
-		fmt.Fprintln(os.Stderr, "\nReceived interrupt, stopping...")
-		tracker.Stop()
-	}()
+	if *verifyStreamPath != "" {
+		report, err := verifyStream(*verifyStreamPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		jsonData, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding JSON: %v\n", err)
+			os.Exit(1)
+		}
+		if *outputFile != "" {
+			if err := writeOutputFile(*outputFile, jsonData, false, codecNone); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing file: %v\n", err)
+				os.Exit(1)
+			}
+		} else {
+			fmt.Println(string(jsonData))
+		}
+		if !report.OK {
+			os.Exit(1)
+		}
+		return
+	}
 
-	clearStr := "on"
-	if *noClear {
-		clearStr = "off (accumulate)"
+	if *decodeDelta != "" {
+		samples, err := decodeDeltaFile(*decodeDelta)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		jsonData, err := json.MarshalIndent(samples, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding JSON: %v\n", err)
+			os.Exit(1)
+		}
+		if *outputFile != "" {
+			if err := writeOutputFile(*outputFile, jsonData, false, codecNone); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing file: %v\n", err)
+				os.Exit(1)
+			}
+		} else {
+			fmt.Println(string(jsonData))
+		}
+		return
 	}
-	fmt.Fprintf(os.Stderr, "Tracking PID %d for %.1f seconds (interval=%dms, children=%v, clear=%s)\n",
-		*pid, *durationSec, *intervalMs, *trackChildren, clearStr)
 
-	tracker.Run(time.Duration(*durationSec * float64(time.Second)))
+	if *selfTest {
+		if !runSelfTest(*selfTestRate, *selfTestPages, *intervalMs,
+			time.Duration(*durationSec*float64(time.Second)), *selfTestTolerance) {
+			os.Exit(1)
+		}
+		return
+	}
 
-	pattern := tracker.GetDirtyPattern()
+	if *execWorkload {
+		if *pid != 0 || *containerID != "" || *pgid != 0 || *cmdlineRegex != "" || *listVMAsFlag {
+			fmt.Fprintln(os.Stderr, "Error: -exec cannot be combined with -pid, -container, -pgid, -cmdline-regex, or -list-vmas")
+			os.Exit(1)
+		}
+		if flag.NArg() == 0 {
+			fmt.Fprintln(os.Stderr, "Error: -exec needs the command after the flags (-- CMD ARGS...)")
+			os.Exit(1)
+		}
+	}
 
-	jsonData, err := json.MarshalIndent(pattern, "", "  ")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error encoding JSON: %v\n", err)
+	var fullContainerID string
+	if *containerID != "" {
+		if *pid != 0 {
+			fmt.Fprintln(os.Stderr, "Error: -pid and -container are mutually exclusive")
+			os.Exit(1)
+		}
+		var err error
+		*pid, fullContainerID, err = resolveContainer(*containerID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Container %.12s: init process is PID %d\n", fullContainerID, *pid)
+	}
+	if *setns {
+		if *pid == 0 || *pgid != 0 || *helperPath != "" {
+			fmt.Fprintln(os.Stderr, "Error: -setns needs -pid or -container and cannot be combined with -pgid or -helper")
+			os.Exit(1)
+		}
+		code, err := runInPIDNamespace(*pid)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: -setns: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(code)
+	}
+	if *pgid < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -pgid must be positive")
 		os.Exit(1)
 	}
-
-	if *outputFile != "" {
-		// Create directory if needed
-		dir := filepath.Dir(*outputFile)
-		if dir != "" && dir != "." {
-			os.MkdirAll(dir, 0755)
+	if *pgid > 0 {
+		if *pid != 0 || *cmdlineRegex != "" {
+			fmt.Fprintln(os.Stderr, "Error: -pgid cannot be combined with -pid, -container, or -cmdline-regex")
+			os.Exit(1)
+		}
+		var err error
+		if *pid, err = processGroupRoot(*pgid); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		// Membership, not descent, decides what is tracked
+		*trackChildren = false
+		fmt.Fprintf(os.Stderr, "Process group %d: root is PID %d\n", *pgid, *pid)
+	}
+	var cmdlineRe *regexp.Regexp
+	if *cmdlineRegex != "" {
+		var err error
+		if cmdlineRe, err = regexp.Compile(*cmdlineRegex); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: bad -cmdline-regex: %v\n", err)
+			os.Exit(1)
 		}
+		if *pid == 0 {
+			if *pid, err = oldestCmdlineMatch(cmdlineRe); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Fprintf(os.Stderr, "Oldest command-line match is PID %d\n", *pid)
+		}
+	}
+	if *pid == 0 && !*execWorkload {
+		fmt.Fprintln(os.Stderr, "Error: -pid is required")
+		flag.Usage()
+		os.Exit(1)
+	}
 
-		err = os.WriteFile(*outputFile, jsonData, 0644)
+	if *listVMAsFlag {
+		// -format defaults to json, so only an explicit one selects JSON
+		asJSON := false
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "format" {
+				asJSON = *format == "json"
+			}
+		})
+		opts := ReadOptions{}
+		if *anonLikePaths != "" {
+			opts.AnonLikePaths = strings.Split(*anonLikePaths, ",")
+		}
+		if *classifyRules != "" {
+			var err error
+			if opts.ClassifyRules, err = loadClassifyRules(*classifyRules); err != nil {
+				fmt.Fprintf(os.Stderr, "Error loading -classify-rules: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		data, err := listVMAs(*pid, &opts, asJSON)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error writing file: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Error reading maps of process %d: %v\n", *pid, err)
 			os.Exit(1)
 		}
-		fmt.Fprintf(os.Stderr, "Output written to %s\n", *outputFile)
-	} else {
-		fmt.Println(string(jsonData))
+		if *outputFile != "" {
+			if err := writeOutputFile(*outputFile, data, false, codecNone); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing file: %v\n", err)
+				os.Exit(1)
+			}
+		} else {
+			os.Stdout.Write(data)
+			if asJSON {
+				fmt.Println()
+			}
+		}
+		return
+	}
+	if *repeat > 1 {
+		if *format != "json" {
+			fmt.Fprintln(os.Stderr, "Error: -repeat only writes JSON")
+			os.Exit(1)
+		}
+		report, runErr := runRepeated(*repeat)
+		if runErr != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", runErr)
+		}
+		if report.Completed > 0 {
+			jsonData, err := json.MarshalIndent(report, "", "  ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error encoding JSON: %v\n", err)
+				os.Exit(1)
+			}
+			codec, outputPath := outputCodec(*outputFile, *useZstd)
+			if outputPath != "" {
+				if err := writeOutputFile(outputPath, jsonData, *fsyncEvery > 0, codec); err != nil {
+					fmt.Fprintf(os.Stderr, "Error writing file: %v\n", err)
+					os.Exit(1)
+				}
+				fmt.Fprintf(os.Stderr, "Output of %d repetitions written to %s\n", report.Completed, outputPath)
+			} else if err := writeCompressed(os.Stdout, append(jsonData, '\n'), codec); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing output: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if runErr != nil || report.Completed == 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	if *emaAlpha <= 0 || *emaAlpha > 1 {
+		fmt.Fprintln(os.Stderr, "Error: -ema-alpha must be in (0, 1]")
+		os.Exit(1)
+	}
+	if *peakWindowMs < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -peak-window must be non-negative")
+		os.Exit(1)
+	}
+	if *maxBufferPages < 0 || *explosionVMAs < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -max-read-buffer-pages and -maps-explosion-vmas must be non-negative")
+		os.Exit(1)
+	}
+	if *coalesceGap < -1 {
+		fmt.Fprintln(os.Stderr, "Error: -coalesce-gap must be -1 or a number of pages")
+		os.Exit(1)
+	}
+	if *binMs < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -bin-ms must be non-negative")
+		os.Exit(1)
+	}
+	if *autocorr < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -autocorr must be non-negative")
+		os.Exit(1)
+	}
+	if *maxCPUPct != 0 && (*maxCPUPct < 0 || *maxCPUPct >= 100) {
+		fmt.Fprintln(os.Stderr, "Error: -max-cpu-pct must be in (0, 100), or 0 for no limit")
+		os.Exit(1)
+	}
+	if *hotSetFraction < 0 || *hotSetFraction > 1 {
+		fmt.Fprintln(os.Stderr, "Error: -hot-set-fraction must be in [0, 1]")
+		os.Exit(1)
+	}
+	if *downtimeBudgetPages < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -downtime-budget-pages must be non-negative")
+		os.Exit(1)
+	}
+	if *maxPagesPerSample < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -max-pages-per-sample must be non-negative")
+		os.Exit(1)
+	}
+
+	switch *format {
+	case "json", "normalized", "folded", "influx", "delta", "matrix", "vma-csv", "md":
+	case "csv":
+		if *outputFile == "" {
+			fmt.Fprintln(os.Stderr, "Error: -format csv requires -output")
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown -format %q\n", *format)
+		os.Exit(1)
+	}
+	if *uploadURL != "" && !strings.HasPrefix(*uploadURL, "http://") && !strings.HasPrefix(*uploadURL, "https://") {
+		fmt.Fprintln(os.Stderr, "Error: -upload-url is not an http:// or https:// URL")
+		os.Exit(1)
+	}
+	if *printSummaryLine {
+		stdoutUsed := *outputFile == "" && len(sinks) == 0
+		for _, sink := range sinks {
+			stdoutUsed = stdoutUsed || sink.Dest == "-"
+		}
+		if stdoutUsed {
+			fmt.Fprintln(os.Stderr, "Error: -summary-line needs stdout to itself; write the capture with -output or a file sink")
+			os.Exit(1)
+		}
+	}
+	if *emitPlot != "" {
+		if _, err := plotPath(*emitPlot, *outputFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if *outputFile == "" {
+			fmt.Fprintln(os.Stderr, "Error: -emit-plot requires -output")
+			os.Exit(1)
+		}
+	}
+	if *validate && *format != "json" {
+		fmt.Fprintln(os.Stderr, "Error: -validate-schema only applies to -format json")
+		os.Exit(1)
+	}
+	if *noSamples && *format != "json" {
+		fmt.Fprintln(os.Stderr, "Error: -no-samples only applies to -format json")
+		os.Exit(1)
+	}
+	if *splitByType && (*format != "json" || *outputFile == "") {
+		fmt.Fprintln(os.Stderr, "Error: -split-by-vma-type needs -format json and an -output prefix")
+		os.Exit(1)
+	}
+	if *postProcessProg != "" && (*format != "json" || *splitByType) {
+		fmt.Fprintln(os.Stderr, "Error: -post-process takes -format json output and cannot be combined with -split-by-vma-type")
+		os.Exit(1)
+	}
+	if *noSamples && *validate {
+		fmt.Fprintln(os.Stderr, "Error: -no-samples output has no samples and cannot match the Python schema; drop -validate-schema")
+		os.Exit(1)
+	}
+
+	var perProcess, perCPU bool
+	for _, mode := range strings.Split(*normalize, ",") {
+		switch strings.TrimSpace(mode) {
+		case "":
+		case "per-process":
+			perProcess = true
+		case "per-cpu":
+			perCPU = true
+		default:
+			fmt.Fprintf(os.Stderr, "Error: unknown -normalize mode %q (use per-process or per-cpu)\n", mode)
+			os.Exit(1)
+		}
+	}
+
+	regionSize, err := parseGranularity(*granularity)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *once && *untilStr != "" {
+		fmt.Fprintln(os.Stderr, "Error: -once and -until are mutually exclusive")
+		os.Exit(1)
+	}
+
+	var until time.Time
+	if *untilStr != "" {
+		durationSet := false
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "duration" {
+				durationSet = true
+			}
+		})
+		if durationSet {
+			fmt.Fprintln(os.Stderr, "Error: -until and -duration are mutually exclusive")
+			os.Exit(1)
+		}
+		until, err = time.Parse(time.RFC3339, *untilStr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -until time: %v\n", err)
+			os.Exit(1)
+		}
+		if !until.After(time.Now()) {
+			fmt.Fprintf(os.Stderr, "Error: -until time %s is in the past\n", *untilStr)
+			os.Exit(1)
+		}
+	}
+
+	var schedule *sampleSchedule
+	scheduleEnds := false // the schedule, not -duration, ends the run
+	if *scheduleStr != "" {
+		schedule, err = parseSchedule(*scheduleStr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: -schedule: %v\n", err)
+			os.Exit(1)
+		}
+		if *cpuIntervalMs > 0 || *maxCPUPct > 0 || *alignClock || *once {
+			fmt.Fprintln(os.Stderr, "Error: -schedule cannot be combined with -cpu-interval, -max-cpu-pct, -align-clock, or -once")
+			os.Exit(1)
+		}
+		*intervalMs = int(schedule.interval() / time.Millisecond)
+		durationSet := false
+		flag.Visit(func(f *flag.Flag) {
+			durationSet = durationSet || f.Name == "duration"
+		})
+		if schedule.bounded() && !durationSet && until.IsZero() {
+			// A year stands in for no limit
+			scheduleEnds = true
+			*durationSec = 365 * 24 * 3600
+		}
+	}
+
+	var launched *exec.Cmd
+	if *execWorkload {
+		var err error
+		if launched, err = startWorkload(flag.Args()); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: -exec: %v\n", err)
+			os.Exit(1)
+		}
+		*pid = launched.Process.Pid
+		fmt.Fprintf(os.Stderr, "Started %s as PID %d\n", flag.Arg(0), *pid)
+	}
+
+	tracker := NewDirtyPageTracker(*pid, *intervalMs, *trackChildren, *workload, *noClear)
+	tracker.schedule = schedule
+	tracker.emaAlpha = *emaAlpha
+	tracker.peakWindow = time.Duration(*peakWindowMs) * time.Millisecond
+	tracker.binWidth = time.Duration(*binMs) * time.Millisecond
+	tracker.deterministic = *deterministic
+	tracker.readOpts.SkipDeviceBacked = *skipDevice
+	tracker.readOpts.PrivateAnonOnly = *privateAnonOnly
+	tracker.readOpts.TrackMoves = *trackMoves || *remapMoves
+	tracker.readOpts.RemapMoves = *remapMoves
+	tracker.readOpts.DetectLocked = *detectLocked
+	tracker.readOpts.MaxBufferPages = *maxBufferPages
+	tracker.readOpts.Coalesce = *coalesceGap >= 0
+	tracker.readOpts.CoalesceGap = *coalesceGap
+	tracker.readOpts.ExplosionVMAs = *explosionVMAs
+	if *anonymizePaths {
+		tracker.readOpts.Anonymizer, err = newPathAnonymizer()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: -anonymize-paths: %v\n", err)
+			os.Exit(1)
+		}
+		if *deterministic {
+			tracker.readOpts.Anonymizer.key = make([]byte, 32)
+		}
+	}
+	if *anonLikePaths != "" {
+		tracker.readOpts.AnonLikePaths = strings.Split(*anonLikePaths, ",")
+	}
+	if *classifyRules != "" {
+		if tracker.readOpts.ClassifyRules, err = loadClassifyRules(*classifyRules); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading -classify-rules: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	var expectations *SummaryExpectations
+	if *expectPath != "" {
+		if expectations, err = loadExpectations(*expectPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading -expect: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	switch *childFirst {
+	case "keep":
+	case "discard", "pre-tracking":
+		tracker.childFirstSample = *childFirst
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown -child-first-sample %q\n", *childFirst)
+		os.Exit(1)
+	}
+	switch *readStrategy {
+	case ReadSeek, ReadPread:
+	case ReadScan:
+		if *decodeFlags || *helperPath != "" {
+			fmt.Fprintln(os.Stderr, "Error: -read-strategy scan cannot be combined with -decode-flags or -helper")
+			os.Exit(1)
+		}
+		if !pagemapScanSupported() {
+			fmt.Fprintln(os.Stderr, "Error: this kernel's PAGEMAP_SCAN does not report soft-dirty (needs Linux 6.7+)")
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown -read-strategy %q\n", *readStrategy)
+		os.Exit(1)
+	}
+	tracker.readOpts.Strategy = *readStrategy
+	if *residentOnly && *readStrategy == ReadScan {
+		fmt.Fprintln(os.Stderr, "Error: -resident-only needs the present bit, which -read-strategy scan does not read")
+		os.Exit(1)
+	}
+	tracker.readOpts.ResidentOnly = *residentOnly
+	tracker.readOpts.SkipHoles = *skipHoles
+	if *emitCleanAddrs && !*emitClean {
+		fmt.Fprintln(os.Stderr, "Error: -emit-clean-addrs needs -emit-clean")
+		os.Exit(1)
+	}
+	if *emitClean && *readStrategy == ReadScan {
+		fmt.Fprintln(os.Stderr, "Error: -emit-clean needs the present bit, which -read-strategy scan does not read")
+		os.Exit(1)
+	}
+	tracker.readOpts.EmitClean = *emitClean
+	tracker.readOpts.CleanAddrs = *emitCleanAddrs
+	tracker.readOpts.FileWriteback = *sharedFileWriteback
+	if *shareGroup {
+		tracker.shareGroup = newSharedPages()
+	}
+	if *detectZero {
+		if *zeroSampleEvery < 1 {
+			fmt.Fprintln(os.Stderr, "Error: -zero-sample-every must be at least 1")
+			os.Exit(1)
+		}
+		if *helperPath != "" || *readStrategy == ReadScan {
+			fmt.Fprintln(os.Stderr, "Error: -detect-zero cannot be combined with -helper or -read-strategy scan")
+			os.Exit(1)
+		}
+		tracker.zeroStats = newZeroPageStats(*zeroSampleEvery)
+	}
+	if *detectLocked && *helperPath != "" {
+		fmt.Fprintln(os.Stderr, "Error: -detect-locked cannot be combined with -helper")
+		os.Exit(1)
+	}
+	if *helperPath != "" {
+		tracker.helper, err = startHelper(*helperPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error starting helper %s: %v\n", *helperPath, err)
+			os.Exit(1)
+		}
+	}
+	tracker.until = until
+	tracker.captureMaps = *captureMaps
+	tracker.regionSize = regionSize
+	tracker.interDirty = *interDirty
+	tracker.jaccard = *jaccardFlag
+	tracker.entropy = *spatialEntropyFlag
+	tracker.pageTables = *trackPageTables
+	tracker.wallClock = *wallClock
+	tracker.weightedEstimate = *weightedEstimate
+	tracker.downtimeBudget = *downtimeBudgetPages
+	tracker.hotSetFraction = *hotSetFraction
+	tracker.autocorrLags = *autocorr
+	tracker.maxCPUPct = *maxCPUPct
+	tracker.skipSleeping = *skipSleeping
+	if *followRestore != "" {
+		tracker.follow, err = parseFollowRestore(*followRestore)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	tracker.perProcess = perProcess
+	tracker.perCPU = perCPU
+	tracker.readOpts.DecodeFlags = *decodeFlags
+	tracker.readOpts.RelativeAddr = *relativeAddr
+	tracker.maxDepth = *maxDepth
+	tracker.maxPagesPerSample = *maxPagesPerSample
+	tracker.openRetries = *openRetries
+	tracker.openBackoff = time.Duration(*openBackoffMs) * time.Millisecond
+	tracker.watchdogMult = *watchdogMult
+	tracker.watchdogAbort = *watchdogAbort
+	tracker.cpuInterval = time.Duration(*cpuIntervalMs) * time.Millisecond
+	if *alignClock && (*cpuIntervalMs > 0 || *maxCPUPct > 0) {
+		fmt.Fprintln(os.Stderr, "Error: -align-clock needs a fixed wall-clock interval and cannot be combined with -cpu-interval or -max-cpu-pct")
+		os.Exit(1)
+	}
+	tracker.alignClock = *alignClock
+	if *sampleRIP < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -sample-rip must be non-negative")
+		os.Exit(1)
+	}
+	if *ripPtrace && (*sampleRIP == 0 || *helperPath != "") {
+		fmt.Fprintln(os.Stderr, "Error: -rip-ptrace needs -sample-rip and cannot be combined with -helper")
+		os.Exit(1)
+	}
+	tracker.ripThreshold = *sampleRIP
+	if *maxTracked < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -max-tracked must be non-negative")
+		os.Exit(1)
+	}
+	perTracker := fdsPerTracker
+	if *detectZero {
+		perTracker++
+	}
+	if limit, err := fdLimit(); err != nil {
+		tracker.maxTracked = *maxTracked
+	} else if budget := fdTrackerBudget(limit, perTracker); *maxTracked == 0 {
+		tracker.maxTracked = budget
+	} else {
+		if *maxTracked > budget {
+			fmt.Fprintf(os.Stderr, "Warning: -max-tracked %d exceeds the %d processes RLIMIT_NOFILE %d allows; Open may fail with EMFILE\n",
+				*maxTracked, budget, limit)
+		}
+		tracker.maxTracked = *maxTracked
+	}
+	tracker.ripPtrace = *ripPtrace
+	tracker.recordNsPids = *nsPids
+	tracker.debugRuntime = *debugRuntime
+	tracker.ioStats = *ioStats
+	tracker.mapsStats = *mapsStats
+	tracker.rssGrowth = *rssGrowth
+	if *smapsRollup && *helperPath != "" {
+		fmt.Fprintln(os.Stderr, "Error: -smaps-rollup cannot be combined with -helper")
+		os.Exit(1)
+	}
+	tracker.smapsRollup = *smapsRollup
+	tracker.recordTargets = *recordTarget
+	if *clearMode != "" {
+		if *noClear {
+			fmt.Fprintln(os.Stderr, "Error: -clear-mode cannot be combined with -no-clear")
+			os.Exit(1)
+		}
+		tracker.clearMode, err = parseClearMode(*clearMode)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: -clear-mode: %v\n", err)
+			os.Exit(1)
+		}
+		if !clearsSoftDirty(tracker.clearMode) {
+			fmt.Fprintln(os.Stderr, "Warning: -clear-mode leaves soft-dirty bits set, so each sample reports every page written since the start")
+		}
+	}
+	if *detectVfork {
+		if *helperPath != "" {
+			fmt.Fprintln(os.Stderr, "Error: -detect-vfork cannot be combined with -helper")
+			os.Exit(1)
+		}
+		tracker.openVforks = make(map[int]int)
+	}
+	tracker.readOpts.DetectArenas = *detectArenas
+	if *accumulate < 1 {
+		fmt.Fprintln(os.Stderr, "Error: -accumulate must be at least 1")
+		os.Exit(1)
+	}
+	if *accumulate > 1 {
+		if *noClear || *once {
+			fmt.Fprintln(os.Stderr, "Error: -accumulate cannot be combined with -no-clear or -once")
+			os.Exit(1)
+		}
+		tracker.accum = &accumulation{every: *accumulate}
+	}
+	if *verifyClear < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -verify-clear must be non-negative")
+		os.Exit(1)
+	}
+	if *verifyClear > 0 {
+		if *noClear || !clearsSoftDirty(tracker.clearMode) {
+			fmt.Fprintln(os.Stderr, "Error: -verify-clear needs clears of the soft-dirty bits; drop -no-clear or add soft-dirty to -clear-mode")
+			os.Exit(1)
+		}
+		tracker.clearCheck = &clearCheck{every: *verifyClear, warned: make(map[int]struct{})}
+	}
+	if *stopAtUnique < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -stop-at-unique must be non-negative")
+		os.Exit(1)
+	}
+	if *stopAtUnique > 0 {
+		tracker.uniqueTarget = &UniqueTarget{Target: *stopAtUnique}
+	}
+	if *rawPagemap != "" {
+		if *rawPagemapSample < 1 {
+			fmt.Fprintln(os.Stderr, "Error: -raw-pagemap-sample must be at least 1")
+			os.Exit(1)
+		}
+		tracker.rawPagemap = &rawPagemapDump{path: *rawPagemap, sample: *rawPagemapSample}
+	}
+	if *frontierMode {
+		formats := []string{*format}
+		for _, sink := range sinks {
+			formats = append(formats, sink.Format)
+		}
+		for _, f := range formats {
+			if f != "json" && f != "normalized" && f != "influx" && f != "md" {
+				fmt.Fprintf(os.Stderr, "Error: -frontier drops page lists and so cannot be combined with -format %s\n", f)
+				os.Exit(1)
+			}
+		}
+		tracker.frontier = &frontier{}
+	}
+	if *psi {
+		if _, err := readMemoryPressure(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: -psi: memory pressure unavailable, not recording it: %v\n", err)
+		} else {
+			tracker.psi = true
+		}
+	}
+	tracker.once = *once
+	tracker.convergeRate = *convergeRate
+	tracker.convergeSamples = *convergeSamples
+	if *thresholdStr != "" {
+		thresholds, err := parseThresholds(*thresholdStr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: -threshold: %v\n", err)
+			os.Exit(1)
+		}
+		if *thresholdSamples < 1 {
+			fmt.Fprintln(os.Stderr, "Error: -threshold-samples must be at least 1")
+			os.Exit(1)
+		}
+		tracker.alerter = newRateAlerter(thresholds, *thresholdSamples, *thresholdLog)
+	}
+	tracker.onConverge = *onConverge
+	tracker.convergeStop = *convergeStop
+	if (*onConverge != "" || *convergeStop) && *convergeRate <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: -on-converge and -converge-stop require -converge-rate")
+		os.Exit(1)
+	}
+	if *threadID != 0 {
+		stackAddr, err := resolveThreadStack(*pid, *threadID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: cannot resolve stack of thread %d, tracking the whole process: %v\n", *threadID, err)
+		} else {
+			fmt.Fprintf(os.Stderr, "Tracking only the stack VMA of thread %d (0x%x)\n", *threadID, stackAddr)
+			tracker.readOpts.OnlyVMAAt = stackAddr
+			tracker.trackChildren = false
+		}
+	}
+	// -debug-runtime, -container, -start-on-signal, -cmdline-regex, and
+	// -pgid report through metadata, so they imply -metadata
+	if *withMetadata || *debugRuntime || fullContainerID != "" || *startOnSignal || *alignClock || *recordTarget || schedule != nil || cmdlineRe != nil || *experimentID != "" || *pgid > 0 {
+		tracker.metadata = collectMetadata()
+		if schedule != nil {
+			tracker.metadata.Schedule = schedule.String()
+		}
+		tracker.metadata.ContainerID = fullContainerID
+		tracker.metadata.CmdlineRegex = *cmdlineRegex
+		tracker.metadata.ExperimentID = *experimentID
+		tracker.metadata.Pgid = *pgid
+	}
+	tracker.cmdlineRegex = cmdlineRe
+	tracker.pgid = *pgid
+	if *addressMask != "" {
+		tracker.readOpts.AddressMask, err = loadAddressList(*addressMask)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading address mask: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Address mask: %d pages\n", len(tracker.readOpts.AddressMask))
+	}
+	if tracker.clearScope() != "" {
+		fmt.Fprintln(os.Stderr, "Warning: clear_refs is process-wide, so soft-dirty bits outside the tracked range are cleared too; use -no-clear if something else relies on them")
+	}
+
+	if *probeIntervalFlag {
+		if *probeIterations < 1 {
+			fmt.Fprintln(os.Stderr, "Error: -probe-iterations must be at least 1")
+			os.Exit(1)
+		}
+		report, err := tracker.probeInterval(*probeIterations)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		printProbeSummary(report)
+		jsonData, _ := json.MarshalIndent(report, "", "  ")
+		if *outputFile != "" {
+			if err := writeOutputFile(*outputFile, jsonData, false, codecNone); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing file: %v\n", err)
+				os.Exit(1)
+			}
+		} else {
+			fmt.Println(string(jsonData))
+		}
+		return
+	}
+
+	rotating := *rotateInterval > 0 || *rotateSizeMB > 0
+	if rotating && (*streamFile == "" || *streamAppend) {
+		fmt.Fprintln(os.Stderr, "Error: -rotate-interval and -rotate-size need -stream and can't be used with -stream-append")
+		os.Exit(1)
+	}
+	if *maxOutputMB > 0 && *streamFile == "" {
+		fmt.Fprintln(os.Stderr, "Error: -max-output-mb needs -stream")
+		os.Exit(1)
+	}
+	if *maxOutputMB < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -max-output-mb must be non-negative")
+		os.Exit(1)
+	}
+	switch *outputLimitAction {
+	case "truncate", "stop":
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown -output-limit-action %q (use truncate or stop)\n", *outputLimitAction)
+		os.Exit(1)
+	}
+	tracker.outputLimit = int64(*maxOutputMB * (1 << 20))
+	tracker.outputLimitAction = *outputLimitAction
+	if *streamArray && (*streamFile == "" || *streamAppend || rotating) {
+		fmt.Fprintln(os.Stderr, "Error: -stream-array needs -stream and can't be used with -stream-append or rotation")
+		os.Exit(1)
+	}
+	if *streamChecksum && (*streamFile == "" || *streamArray) {
+		fmt.Fprintln(os.Stderr, "Error: -checksum needs -stream and can't be used with -stream-array")
+		os.Exit(1)
+	}
+	if rotating {
+		header := StreamHeader{Workload: *workload, RootPid: *pid, PageSize: int(PageSize), IntervalMs: *intervalMs}
+		tracker.stream, err = OpenRotatingStream(*streamFile, time.Duration(*rotateInterval*float64(time.Second)),
+			int64(*rotateSizeMB)<<20, header, *flushEvery, *fsyncEvery)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening stream file: %v\n", err)
+			os.Exit(1)
+		}
+	} else if *streamArray {
+		tracker.stream, err = OpenArrayStream(*streamFile, *flushEvery, *fsyncEvery)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening stream file: %v\n", err)
+			os.Exit(1)
+		}
+	} else if *streamFile != "" {
+		tracker.stream, err = OpenSampleStream(*streamFile, *streamAppend, *flushEvery, *fsyncEvery)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening stream file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if tracker.stream != nil {
+		tracker.stream.checksum = *streamChecksum
+	}
+
+	if *progressFifoPath != "" {
+		tracker.progress, err = openProgressFifo(*progressFifoPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: -progress-fifo: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *liveCSVPath != "" {
+		tracker.liveCSV, err = openLiveCSV(*liveCSVPath, tracker.cpuInterval > 0)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening live CSV: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Handle Ctrl+C
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		fmt.Fprintln(os.Stderr, "\nReceived interrupt, stopping...")
+		tracker.Stop()
+	}()
+
+	if *startOnSignal {
+		startCh := make(chan os.Signal, 1)
+		signal.Notify(startCh, syscall.SIGUSR1)
+		tracker.startSignal = startCh
+	}
+
+	// SIGUSR2 pauses/resumes sampling without closing the trackers
+	pauseCh := make(chan os.Signal, 1)
+	signal.Notify(pauseCh, syscall.SIGUSR2)
+	go func() {
+		for range pauseCh {
+			if tracker.TogglePause() {
+				fmt.Fprintln(os.Stderr, "Paused sampling (SIGUSR2 to resume)")
+			} else {
+				fmt.Fprintln(os.Stderr, "Resumed sampling")
+			}
+		}
+	}()
+
+	clearStr := "on"
+	if *noClear {
+		clearStr = "off (accumulate)"
+	} else if tracker.clearMode != nil {
+		clearStr = "clear_refs " + strings.Join(tracker.clearMode, ",")
+	}
+	if *once {
+		fmt.Fprintf(os.Stderr, "Taking one snapshot of PID %d after %dms (children=%v)\n",
+			*pid, *intervalMs, tracker.trackChildren)
+	} else if scheduleEnds {
+		fmt.Fprintf(os.Stderr, "Tracking PID %d on schedule %q (children=%v, clear=%s)\n",
+			*pid, schedule.String(), tracker.trackChildren, clearStr)
+	} else if !until.IsZero() {
+		fmt.Fprintf(os.Stderr, "Tracking PID %d until %s (interval=%dms, children=%v, clear=%s)\n",
+			*pid, until.Format(time.RFC3339), *intervalMs, tracker.trackChildren, clearStr)
+	} else {
+		fmt.Fprintf(os.Stderr, "Tracking PID %d for %.1f seconds (interval=%dms, children=%v, clear=%s)\n",
+			*pid, *durationSec, *intervalMs, tracker.trackChildren, clearStr)
+	}
+
+	var workloadDone <-chan struct{}
+	if launched != nil {
+		workloadDone = tracker.watchWorkload(launched)
+	}
+	tracker.Run(time.Duration(*durationSec * float64(time.Second)))
+	if tracker.helper != nil {
+		tracker.helper.Close()
+	}
+	if launched != nil {
+		endWorkload(launched, workloadDone)
+	}
+
+	if tracker.deterministic {
+		tracker.normalizeTiming()
+	}
+	pattern := tracker.GetDirtyPattern()
+	var byType []vmaTypePattern
+	if *splitByType {
+		byType = tracker.vmaTypePatterns()
+	}
+	if tracker.readOpts.Anonymizer != nil {
+		tracker.readOpts.Anonymizer.pattern(&pattern)
+		for i := range byType {
+			tracker.readOpts.Anonymizer.pattern(&byType[i].pattern)
+		}
+	}
+	if tracker.deterministic {
+		makeDeterministic(&pattern)
+		for i := range byType {
+			makeDeterministic(&byType[i].pattern)
+		}
+	}
+	if tracker.stream != nil && tracker.stream.array {
+		if err := tracker.stream.CloseArray(&pattern.Summary); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: closing sample stream: %v\n", err)
+		}
+	}
+	// Throttled runs miss the interval on purpose and have said so already
+	throttled := pattern.TrackerCPU != nil && pattern.TrackerCPU.ThrottledSamples > 0
+	if pct := pattern.Summary.IntervalsMetPct; pct != nil && *pct < intervalsMetWarnPct && !throttled {
+		fmt.Fprintf(os.Stderr, "Warning: only %.1f%% of intervals were met within %.0f%% of %dms; per-interval rates are unreliable, consider a longer -interval\n",
+			*pct, intervalTolerance*100, *intervalMs)
+	}
+	if ratio := pattern.Summary.RollupRatio; ratio != nil && *ratio > rollupDivergence {
+		fmt.Fprintf(os.Stderr, "Warning: soft-dirty tracking saw %.2fx the most dirty memory smaps_rollup reported; the dirty counts may be inflated\n", *ratio)
+	}
+	// Checked before writing but only fails the run after, so the capture
+	// that broke an expectation is kept
+	expectOK := expectations == nil || checkExpectations(expectations, &pattern.Summary)
+
+	if *emitPlot != "" {
+		paths, err := writePlot(*emitPlot, &pattern, *outputFile, *format == "csv")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing plot: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Plot written to %s\n", strings.Join(paths, ", "))
+	}
+	if *splitByType {
+		codec, outputPath := outputCodec(*outputFile, *useZstd)
+		if dir := filepath.Dir(outputPath); dir != "" && dir != "." {
+			os.MkdirAll(dir, 0755)
+		}
+		var paths []string
+		for i := range byType {
+			data, err := renderOutput(*format, &byType[i].pattern, tracker.startTime, *noSamples)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error encoding JSON: %v\n", err)
+				os.Exit(1)
+			}
+			path := vmaTypePath(outputPath, byType[i].vmaType)
+			if err := writeOutputFile(path, data, *fsyncEvery > 0, codec); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing file: %v\n", err)
+				os.Exit(1)
+			}
+			paths = append(paths, path)
+			if *validate {
+				if errs := validateSchema(data); len(errs) > 0 {
+					for _, msg := range errs {
+						fmt.Fprintf(os.Stderr, "Schema drift in %s: %s\n", path, msg)
+					}
+					os.Exit(1)
+				}
+			}
+		}
+		if len(paths) == 0 {
+			fmt.Fprintln(os.Stderr, "Warning: no dirty pages were listed; -split-by-vma-type wrote no files")
+		} else {
+			fmt.Fprintf(os.Stderr, "Output written to %s\n", strings.Join(paths, ", "))
+		}
+		ok := writeSinks(sinks, &pattern, tracker.startTime, *noSamples)
+		if *uploadURL != "" {
+			ok = uploadPattern(*uploadURL, *uploadToken, *uploadGzip, &pattern, *noSamples) && ok
+		}
+		if *printSummaryLine {
+			fmt.Println(summaryLine(&pattern))
+		}
+		if !ok || !expectOK {
+			os.Exit(1)
+		}
+		return
+	}
+	if *format == "csv" {
+		dir := filepath.Dir(*outputFile)
+		if dir != "" && dir != "." {
+			os.MkdirAll(dir, 0755)
+		}
+		paths, err := writeCSVTables(&pattern, *outputFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing CSV: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Output written to %s\n", strings.Join(paths, ", "))
+		ok := writeSinks(sinks, &pattern, tracker.startTime, *noSamples)
+		if *uploadURL != "" {
+			ok = uploadPattern(*uploadURL, *uploadToken, *uploadGzip, &pattern, *noSamples) && ok
+		}
+		if *printSummaryLine {
+			fmt.Println(summaryLine(&pattern))
+		}
+		if !ok || !expectOK {
+			os.Exit(1)
+		}
+		return
+	}
+
+	codec, outputPath := outputCodec(*outputFile, *useZstd)
+	if codec == codecGzip && (*useZstd || strings.HasSuffix(*outputFile, ".zst")) {
+		fmt.Fprintln(os.Stderr, "Warning: zstd support not built in (rebuild with -tags zstd), using gzip")
+	}
+
+	// JSON that nothing else reads is encoded straight into the file or
+	// compressor instead of being rendered in full first
+	streamed := *format == "json" && *postProcessProg == "" && !*validate && tracker.outputLimit <= 0 &&
+		(outputPath != "" || codec != codecNone)
+	var data, rendered []byte
+	if !streamed {
+		var err error
+		if data, err = renderOutput(*format, &pattern, tracker.startTime, *noSamples); err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding JSON: %v\n", err)
+			os.Exit(1)
+		}
+		data = tracker.limitOutput(&pattern, data, *format, *noSamples)
+		rendered = data
+		if *postProcessProg != "" {
+			data = postProcess(*postProcessProg, data)
+		}
+	}
+	encode := func(w io.Writer) error {
+		if streamed {
+			return encodeJSONOutput(w, &pattern, *noSamples)
+		}
+		_, err := w.Write(data)
+		return err
+	}
+
+	// With only sinks requested the primary isn't also dumped to stdout
+	if len(sinks) == 0 || *outputFile != "" {
+		if outputPath != "" {
+			// Create directory if needed
+			dir := filepath.Dir(outputPath)
+			if dir != "" && dir != "." {
+				os.MkdirAll(dir, 0755)
+			}
+
+			if err := encodeOutputFile(outputPath, *fsyncEvery > 0, codec, encode); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing file: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Fprintf(os.Stderr, "Output written to %s\n", outputPath)
+		} else if codec != codecNone {
+			if err := encodeCompressed(os.Stdout, codec, encode); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing output: %v\n", err)
+				os.Exit(1)
+			}
+		} else if *postProcessProg != "" || *format == "folded" || *format == "influx" || *format == "delta" || *format == "matrix" || *format == "vma-csv" || *format == "md" {
+			os.Stdout.Write(data)
+		} else {
+			fmt.Println(string(data))
+		}
+	}
+	sinksOK := writeSinks(sinks, &pattern, tracker.startTime, *noSamples)
+	uploadOK := *uploadURL == "" || uploadPattern(*uploadURL, *uploadToken, *uploadGzip, &pattern, *noSamples)
+	if *printSummaryLine {
+		fmt.Println(summaryLine(&pattern))
+	}
+
+	// Checked after writing so a drift report never costs the capture
+	if *validate {
+		errs := validateSchema(rendered)
+		for _, msg := range errs {
+			fmt.Fprintf(os.Stderr, "Schema drift: %s\n", msg)
+		}
+		if len(errs) > 0 {
+			fmt.Fprintf(os.Stderr, "Error: output does not match the Python tracker's schema (%d problems)\n", len(errs))
+			os.Exit(1)
+		}
+		fmt.Fprintln(os.Stderr, "Output matches the Python tracker's schema")
+	}
+	if !sinksOK || !uploadOK || !expectOK {
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,162 @@
+// Markdown report (-format md)
+//
+// A report to paste into an issue or pull request: a header with the
+// workload and capture metadata, a summary table, the dirty rate timeline
+// as a sparkline, and the VMA type distribution. It is rendered from the
+// same summary as the JSON output and has no external dependencies.
+//
+// The sparkline is drawn twice: as a line of Unicode block characters,
+// which renders anywhere, and as an SVG embedded as a base64 data URI,
+// which renderers such as VS Code and GitLab show but GitHub strips. Both
+// are left out when the timeline has fewer than two points.
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// Sparklines are resampled to at most this many points
+	sparklineWidth = 60
+
+	sparkSVGWidth  = 480
+	sparkSVGHeight = 60
+)
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// markdownReport renders the pattern as a Markdown report
+func markdownReport(pattern *DirtyPattern) []byte {
+	s := &pattern.Summary
+	var b strings.Builder
+
+	workload := pattern.Workload
+	if workload == "" {
+		workload = "unknown"
+	}
+	fmt.Fprintf(&b, "# Dirty page report: %s\n\n", mdEscape(workload))
+	fmt.Fprintf(&b, "- Root PID: %d (children %v)\n", pattern.RootPid, pattern.TrackChildren)
+	fmt.Fprintf(&b, "- Duration: %.1f s, %d samples every %.0f ms\n",
+		pattern.TrackingDurationMs/1000, s.SampleCount, s.IntervalMs)
+	if pattern.StopReason != "" {
+		fmt.Fprintf(&b, "- Stop reason: %s\n", pattern.StopReason)
+	}
+	if md := pattern.Metadata; md != nil {
+		if md.StartTime != "" {
+			fmt.Fprintf(&b, "- Started: %s\n", md.StartTime)
+		}
+		if md.Hostname != "" || md.KernelRelease != "" {
+			fmt.Fprintf(&b, "- Host: %s, Linux %s (%s), %d CPUs\n", mdEscape(md.Hostname), md.KernelRelease, md.Machine, md.NumCPU)
+		}
+		fmt.Fprintf(&b, "- Tool: dirty_tracker %s\n", md.ToolVersion)
+	}
+
+	b.WriteString("\n## Summary\n\n")
+	b.WriteString("| Metric | Value |\n|---|---:|\n")
+	row := func(name, format string, args ...any) {
+		fmt.Fprintf(&b, "| %s | %s |\n", name, fmt.Sprintf(format, args...))
+	}
+	row("Unique dirty pages", "%d", s.TotalUniquePages)
+	row("Unique dirty size", "%.1f MiB", float64(s.TotalUniquePages)*float64(pattern.PageSize)/(1<<20))
+	row("Dirty events", "%d", s.TotalDirtyEvents)
+	row("Average rate", "%.1f pages/s", s.AvgDirtyRatePerSec)
+	row("Peak rate", "%.1f pages/s", s.PeakDirtyRate)
+	if s.SustainedPeakRate > 0 {
+		row("Sustained peak rate", "%.1f pages/s over %.0f ms", s.SustainedPeakRate, s.PeakWindowMs)
+	}
+	row("Max processes tracked", "%d", s.MaxProcessesTracked)
+	row("Locality score", "%.3f", s.LocalityScore)
+	if s.IntervalsMetPct != nil {
+		row("Intervals met", "%.1f%%", *s.IntervalsMetPct)
+	}
+
+	rates := make([]float64, 0, len(pattern.DirtyRateTimeline))
+	for _, entry := range pattern.DirtyRateTimeline {
+		rates = append(rates, entry.RatePagesPerSec)
+	}
+	if len(rates) >= 2 {
+		rates = resampleMax(rates, sparklineWidth)
+		lo, hi := rates[0], rates[0]
+		for _, r := range rates {
+			lo, hi = min(lo, r), max(hi, r)
+		}
+		b.WriteString("\n## Dirty rate\n\n")
+		fmt.Fprintf(&b, "`%s` %.1f to %.1f pages/s\n\n", sparkline(rates, lo, hi), lo, hi)
+		svg := sparklineSVG(rates, lo, hi)
+		fmt.Fprintf(&b, "![dirty rate](data:image/svg+xml;base64,%s)\n", base64.StdEncoding.EncodeToString(svg))
+	}
+
+	if len(s.VMADistribution) > 0 {
+		types := make([]string, 0, len(s.VMADistribution))
+		for vmaType := range s.VMADistribution {
+			types = append(types, vmaType)
+		}
+		sort.Slice(types, func(i, j int) bool {
+			if s.VMADistribution[types[i]] != s.VMADistribution[types[j]] {
+				return s.VMADistribution[types[i]] > s.VMADistribution[types[j]]
+			}
+			return types[i] < types[j]
+		})
+		b.WriteString("\n## VMA distribution\n\n")
+		b.WriteString("| VMA type | Share of dirty pages | Share of unique pages | Dirty bytes |\n|---|---:|---:|---:|\n")
+		for _, vmaType := range types {
+			fmt.Fprintf(&b, "| %s | %.1f%% | %.1f%% | %d |\n", mdEscape(vmaType), s.VMADistribution[vmaType]*100,
+				s.VMAUniqueDistribution[vmaType]*100, s.VMASizeDistribution[vmaType])
+		}
+	}
+	return []byte(b.String())
+}
+
+// mdEscape keeps a value from breaking a table or inline formatting
+func mdEscape(s string) string {
+	return strings.NewReplacer("|", "\\|", "*", "\\*", "_", "\\_", "`", "\\`").Replace(s)
+}
+
+// resampleMax shrinks values to at most n points, each the maximum of
+// its bucket so that spikes survive
+func resampleMax(values []float64, n int) []float64 {
+	if len(values) <= n {
+		return values
+	}
+	out := make([]float64, n)
+	for i := range out {
+		from, to := i*len(values)/n, (i+1)*len(values)/n
+		out[i] = values[from]
+		for _, v := range values[from:to] {
+			out[i] = max(out[i], v)
+		}
+	}
+	return out
+}
+
+// sparkline draws values as block characters scaled between lo and hi
+func sparkline(values []float64, lo, hi float64) string {
+	var b strings.Builder
+	for _, v := range values {
+		level := 0
+		if hi > lo {
+			level = int((v - lo) / (hi - lo) * float64(len(sparkBlocks)-1))
+		}
+		b.WriteRune(sparkBlocks[level])
+	}
+	return b.String()
+}
+
+// sparklineSVG draws values as a polyline scaled between lo and hi
+func sparklineSVG(values []float64, lo, hi float64) []byte {
+	var points strings.Builder
+	for i, v := range values {
+		x := float64(i) * sparkSVGWidth / float64(len(values)-1)
+		y := float64(sparkSVGHeight - 1)
+		if hi > lo {
+			y -= (v - lo) / (hi - lo) * (sparkSVGHeight - 2)
+		}
+		fmt.Fprintf(&points, "%.1f,%.1f ", x, y)
+	}
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d">`+
+		`<polyline fill="none" stroke="#d62728" stroke-width="1.5" points="%s"/></svg>`,
+		sparkSVGWidth, sparkSVGHeight, strings.TrimSpace(points.String())))
+}
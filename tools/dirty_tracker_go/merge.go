@@ -0,0 +1,174 @@
+// Merging captures (-merge)
+//
+//	./dirty_tracker -merge -output all.json redis.json worker.json
+//
+// combines JSON captures of separately tracked processes into one pattern,
+// as if they had been tracked together. Captures are aligned on their
+// metadata.start_time, so each needs -metadata (or a feature that implies
+// it); one without a start time is assumed to have started with the
+// earliest and is reported. start_time is taken when tracking starts, so
+// alignment is good to a few milliseconds.
+//
+// Sample times are rebased on the earliest start. Samples of different
+// captures within half an interval of each other are combined into one
+// sample at the time of the earliest of them: their pages are
+// concatenated, their counts summed, and their pids joined. A capture
+// contributes at most one sample to each combined sample. The summary and
+// timeline are then recomputed from the combined samples with default
+// options. The unique page set is
+// rebuilt from the listed pages, so pages beyond -max-pages-per-sample are
+// not in it, and like a live multi-process capture it counts addresses,
+// not (process, address) pairs. Captures should share one -interval; the
+// first capture's is used and a mismatch is reported.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// loadCapture reads a flat JSON capture
+func loadCapture(path string) (*DirtyPattern, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var pattern DirtyPattern
+	if err := json.Unmarshal(data, &pattern); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &pattern, nil
+}
+
+// captureStart returns a capture's start time, if recorded
+func captureStart(pattern *DirtyPattern) (time.Time, bool) {
+	if pattern.Metadata == nil || pattern.Metadata.StartTime == "" {
+		return time.Time{}, false
+	}
+	start, err := time.Parse(time.RFC3339Nano, pattern.Metadata.StartTime)
+	return start, err == nil
+}
+
+// mergeCaptures combines the captures at paths into one pattern
+func mergeCaptures(paths []string) (DirtyPattern, error) {
+	patterns := make([]*DirtyPattern, len(paths))
+	starts := make([]time.Time, len(paths))
+	var earliest time.Time
+	for i, path := range paths {
+		pattern, err := loadCapture(path)
+		if err != nil {
+			return DirtyPattern{}, err
+		}
+		patterns[i] = pattern
+		if start, ok := captureStart(pattern); ok {
+			starts[i] = start
+			if earliest.IsZero() || start.Before(earliest) {
+				earliest = start
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: %s has no metadata.start_time; aligning it with the earliest capture\n", path)
+		}
+	}
+
+	first := patterns[0]
+	intervalMs := int(first.Summary.IntervalMs)
+	type timedSample struct {
+		ts     float64
+		src    int
+		sample *DirtySample
+	}
+	var all []timedSample
+	workloads := []string{}
+	seenWorkload := make(map[string]struct{})
+	for i, pattern := range patterns {
+		if int(pattern.Summary.IntervalMs) != intervalMs {
+			fmt.Fprintf(os.Stderr, "Warning: %s was sampled every %.0fms, not %dms; its rates are approximate\n",
+				paths[i], pattern.Summary.IntervalMs, intervalMs)
+		}
+		if _, ok := seenWorkload[pattern.Workload]; !ok {
+			seenWorkload[pattern.Workload] = struct{}{}
+			workloads = append(workloads, pattern.Workload)
+		}
+		offsetMs := 0.0
+		if !starts[i].IsZero() {
+			offsetMs = float64(starts[i].Sub(earliest).Microseconds()) / 1000.0
+		}
+		for j := range pattern.Samples {
+			sample := &pattern.Samples[j]
+			// Keep same-address pages of different captures apart in
+			// per-process analyses
+			for k := range sample.DirtyPages {
+				sample.DirtyPages[k].pid = pattern.RootPid
+			}
+			all = append(all, timedSample{sample.TimestampMs + offsetMs, i, sample})
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].ts < all[j].ts })
+
+	dt := NewDirtyPageTracker(first.RootPid, intervalMs, first.TrackChildren, strings.Join(workloads, "+"), !first.ClearOnScan)
+	var group map[int]struct{} // captures in the current combined sample
+	groupStart := 0.0
+	for _, ts := range all {
+		_, dup := group[ts.src]
+		if group == nil || dup || ts.ts-groupStart >= float64(intervalMs)/2 {
+			dt.samples = append(dt.samples, DirtySample{TimestampMs: ts.ts, DirtyPages: []DirtyPage{}, PidsTracked: []int{}})
+			group = make(map[int]struct{})
+			groupStart = ts.ts
+		}
+		group[ts.src] = struct{}{}
+
+		// A combined sample keeps the time of its earliest part, all
+		// times being rebased on the earliest capture's start
+		merged := &dt.samples[len(dt.samples)-1]
+		merged.DirtyPages = append(merged.DirtyPages, ts.sample.DirtyPages...)
+		merged.DeltaDirtyCount += ts.sample.DeltaDirtyCount
+		dt.totalDirtyPages += ts.sample.DeltaDirtyCount
+		merged.StackGrowthCount += ts.sample.StackGrowthCount
+		merged.StackRedirtyCount += ts.sample.StackRedirtyCount
+		merged.PidsTracked = append(merged.PidsTracked, ts.sample.PidsTracked...)
+		merged.Partial = merged.Partial || ts.sample.Partial
+		merged.Resumed = merged.Resumed || ts.sample.Resumed
+		merged.Restored = merged.Restored || ts.sample.Restored
+		merged.Truncated = merged.Truncated || ts.sample.Truncated
+		for k := range ts.sample.DirtyPages {
+			dt.uniqueAddrs[ts.sample.DirtyPages[k].Address()] = struct{}{}
+		}
+	}
+	for i := range dt.samples {
+		sort.Ints(dt.samples[i].PidsTracked)
+	}
+
+	for _, pattern := range patterns {
+		for _, info := range pattern.Processes {
+			info := info
+			dt.processInfo[info.HostPid] = &info
+		}
+	}
+	if first.Metadata != nil {
+		metadata := *first.Metadata
+		if !earliest.IsZero() {
+			metadata.StartTime = earliest.Format(time.RFC3339Nano)
+		}
+		metadata.Runtime = nil
+		metadata.CaptureID = newCaptureID()
+		metadata.MergedFrom = nil
+		for _, pattern := range patterns {
+			if pattern.Metadata == nil {
+				continue
+			}
+			if pattern.Metadata.CaptureID != "" {
+				metadata.MergedFrom = append(metadata.MergedFrom, pattern.Metadata.CaptureID)
+			}
+			// Only a campaign all inputs share carries over
+			if pattern.Metadata.ExperimentID != metadata.ExperimentID {
+				metadata.ExperimentID = ""
+			}
+		}
+		dt.metadata = &metadata
+	}
+	return dt.GetDirtyPattern(), nil
+}
@@ -0,0 +1,287 @@
+// Host, kernel, and tool metadata embedded in the output
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+// Metadata records where and with what a capture was taken. Soft-dirty
+// behaviour differs across kernels, so this is needed to compare captures.
+type Metadata struct {
+	ToolVersion   string `json:"tool_version"`
+	ToolCommit    string `json:"tool_commit,omitempty"`
+	GoVersion     string `json:"go_version"`
+	Hostname      string `json:"hostname"`
+	KernelRelease string `json:"kernel_release"`
+	KernelVersion string `json:"kernel_version"`
+	Machine       string `json:"machine"`
+	PageSize      int    `json:"page_size"`
+	NumCPU        int    `json:"num_cpu"` // online CPUs of the host
+	StartTime     string `json:"start_time"`
+	ContainerID   string `json:"container_id,omitempty"` // -container, resolved to the full ID
+	CmdlineRegex  string `json:"cmdline_regex,omitempty"`
+	Pgid          int    `json:"pgid,omitempty"` // -pgid
+
+	// Random UUID of this capture, and the campaign it belongs to
+	// (-experiment-id); a merged capture lists its inputs' IDs
+	CaptureID    string   `json:"capture_id,omitempty"`
+	ExperimentID string   `json:"experiment_id,omitempty"`
+	MergedFrom   []string `json:"merged_from,omitempty"`
+
+	// Time between opening the target and the start signal (-start-on-signal)
+	StartDelayMs *float64 `json:"start_delay_ms,omitempty"`
+	// Sampling on interval boundaries (-align-clock)
+	ClockAlignment *ClockAlignment `json:"clock_alignment,omitempty"`
+	// Sampling schedule in canonical form (-schedule)
+	Schedule string `json:"schedule,omitempty"`
+	// The root, command-line matches, and restored roots (-record-target)
+	Targets []TargetInfo `json:"targets,omitempty"`
+
+	Runtime *RuntimeStats `json:"runtime,omitempty"` // only with -debug-runtime
+}
+
+// RuntimeStats is the tracker's own Go heap and GC footprint, recorded
+// after every sample with -debug-runtime. ReadMemStats briefly stops the
+// world, so this is for diagnosing the tracker, not for normal captures.
+type RuntimeStats struct {
+	PeakHeapAllocBytes uint64            `json:"peak_heap_alloc_bytes"`
+	TotalAllocBytes    uint64            `json:"total_alloc_bytes"`
+	Mallocs            uint64            `json:"mallocs"`
+	NumGC              uint32            `json:"num_gc"`
+	PauseTotalNs       uint64            `json:"gc_pause_total_ns"`
+	MaxPauseNs         uint64            `json:"gc_pause_max_ns"`
+	Samples            []RuntimeSnapshot `json:"samples"`
+
+	startTotalAlloc uint64
+	startMallocs    uint64
+	startNumGC      uint32
+	startPauseTotal uint64
+}
+
+// RuntimeSnapshot is the heap and GC state right after one sample. The
+// counters are cumulative since tracking started.
+type RuntimeSnapshot struct {
+	TimestampMs     float64 `json:"timestamp_ms"`
+	HeapAllocBytes  uint64  `json:"heap_alloc_bytes"`
+	HeapSysBytes    uint64  `json:"heap_sys_bytes"`
+	TotalAllocBytes uint64  `json:"total_alloc_bytes"`
+	Mallocs         uint64  `json:"mallocs"`
+	NumGC           uint32  `json:"num_gc"`
+	PauseTotalNs    uint64  `json:"gc_pause_total_ns"`
+}
+
+// newRuntimeStats snapshots the baseline counters at the start of tracking
+func newRuntimeStats() *RuntimeStats {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return &RuntimeStats{
+		startTotalAlloc: ms.TotalAlloc,
+		startMallocs:    ms.Mallocs,
+		startNumGC:      ms.NumGC,
+		startPauseTotal: ms.PauseTotalNs,
+	}
+}
+
+// record appends a snapshot and folds in GC pauses since the last one
+func (rs *RuntimeStats) record(timestampMs float64) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	prevGC := rs.startNumGC + rs.NumGC
+	// PauseNs is a ring of the last 256 pauses; older ones are gone
+	if ms.NumGC-prevGC > uint32(len(ms.PauseNs)) {
+		prevGC = ms.NumGC - uint32(len(ms.PauseNs))
+	}
+	for gc := prevGC + 1; gc <= ms.NumGC; gc++ {
+		rs.MaxPauseNs = max(rs.MaxPauseNs, ms.PauseNs[(gc+255)%256])
+	}
+
+	rs.PeakHeapAllocBytes = max(rs.PeakHeapAllocBytes, ms.HeapAlloc)
+	rs.TotalAllocBytes = ms.TotalAlloc - rs.startTotalAlloc
+	rs.Mallocs = ms.Mallocs - rs.startMallocs
+	rs.NumGC = ms.NumGC - rs.startNumGC
+	rs.PauseTotalNs = ms.PauseTotalNs - rs.startPauseTotal
+	rs.Samples = append(rs.Samples, RuntimeSnapshot{
+		TimestampMs:     timestampMs,
+		HeapAllocBytes:  ms.HeapAlloc,
+		HeapSysBytes:    ms.HeapSys,
+		TotalAllocBytes: rs.TotalAllocBytes,
+		Mallocs:         rs.Mallocs,
+		NumGC:           rs.NumGC,
+		PauseTotalNs:    rs.PauseTotalNs,
+	})
+}
+
+// collectMetadata gathers metadata once at startup
+func collectMetadata() *Metadata {
+	md := &Metadata{
+		ToolVersion: version,
+		GoVersion:   runtime.Version(),
+		PageSize:    os.Getpagesize(),
+		NumCPU:      onlineCPUs(),
+		StartTime:   time.Now().Format(time.RFC3339Nano),
+		CaptureID:   newCaptureID(),
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				md.ToolCommit = setting.Value
+			}
+		}
+	}
+
+	md.Hostname, _ = os.Hostname()
+
+	var uts syscall.Utsname
+	if err := syscall.Uname(&uts); err == nil {
+		md.KernelRelease = utsString(uts.Release[:])
+		md.KernelVersion = utsString(uts.Version[:])
+		md.Machine = utsString(uts.Machine[:])
+	}
+	return md
+}
+
+// onlineCPUs returns the number of online CPUs. runtime.NumCPU counts
+// only those the tracker may run on, which taskset or a cpuset narrows
+// down, so it is the fallback when sysfs can't be read.
+func onlineCPUs() int {
+	data, err := os.ReadFile("/sys/devices/system/cpu/online")
+	if err != nil {
+		return runtime.NumCPU()
+	}
+	n := 0
+	for _, span := range strings.Split(strings.TrimSpace(string(data)), ",") {
+		first, last, isRange := strings.Cut(span, "-")
+		lo, err := strconv.Atoi(first)
+		if err != nil {
+			return runtime.NumCPU()
+		}
+		hi := lo
+		if isRange {
+			if hi, err = strconv.Atoi(last); err != nil || hi < lo {
+				return runtime.NumCPU()
+			}
+		}
+		n += hi - lo + 1
+	}
+	return n
+}
+
+// newCaptureID returns a random (version 4) UUID, or "" if the system's
+// random source fails
+func newCaptureID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// TargetInfo identifies a process the capture was started on. StartTime
+// is derived from the boot time and so is only good to a clock tick.
+type TargetInfo struct {
+	Pid        int      `json:"pid"`
+	Cmdline    []string `json:"cmdline"`
+	StartTicks uint64   `json:"start_ticks"` // clock ticks after boot, as in /proc/[pid]/stat
+	StartTime  string   `json:"start_time,omitempty"`
+}
+
+// readTargetInfo reads a process's arguments and start time. Arguments
+// that are paths are anonymized like pathnames when anon is set.
+func readTargetInfo(pid int, anon *pathAnonymizer) (TargetInfo, error) {
+	info := TargetInfo{Pid: pid, Cmdline: []string{}}
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return info, err
+	}
+	if args := strings.TrimRight(string(data), "\x00"); args != "" {
+		info.Cmdline = strings.Split(args, "\x00")
+	}
+	if anon != nil {
+		for i, arg := range info.Cmdline {
+			info.Cmdline[i] = anon.path(arg)
+		}
+	}
+
+	stat, err := readProcStat(pid)
+	if err != nil {
+		return info, err
+	}
+	info.StartTicks = stat.StartTime
+	if boot, err := bootTime(); err == nil {
+		start := boot.Add(time.Duration(stat.StartTime) * time.Second / ClockTicksPerSec)
+		info.StartTime = start.Format(time.RFC3339Nano)
+	}
+	return info, nil
+}
+
+// bootTime returns the btime line of /proc/stat
+func bootTime() (time.Time, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "btime "); ok {
+			secs, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(secs, 0), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("/proc/stat: no btime")
+}
+
+// recordTarget adds pid to the metadata targets (-record-target). A
+// process that can't be read is reported and left out.
+func (dt *DirtyPageTracker) recordTarget(pid int) {
+	if !dt.recordTargets || dt.metadata == nil {
+		return
+	}
+	info, err := readTargetInfo(pid, dt.readOpts.Anonymizer)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: -record-target: process %d: %v\n", pid, err)
+		return
+	}
+	dt.metadata.Targets = append(dt.metadata.Targets, info)
+}
+
+// unameRelease returns the running kernel release string
+func unameRelease() (string, error) {
+	var uts syscall.Utsname
+	if err := syscall.Uname(&uts); err != nil {
+		return "", err
+	}
+	return utsString(uts.Release[:]), nil
+}
+
+// utsString converts a NUL-terminated utsname field to a string. The
+// element type is int8 or uint8 depending on the architecture.
+func utsString[T int8 | uint8](field []T) string {
+	b := make([]byte, 0, len(field))
+	for _, c := range field {
+		if c == 0 {
+			break
+		}
+		b = append(b, byte(c))
+	}
+	return string(b)
+}
@@ -0,0 +1,242 @@
+// Pre-copy migration model (-model)
+//
+//	./dirty_tracker -model capture.json -model-bandwidth 1000 -model-downtime-ms 300
+//
+// replays a recorded capture against the standard pre-copy live migration
+// model and predicts how the migration would go, without tracking
+// anything. The first round copies the whole memory; each following round
+// copies the pages dirtied while the previous one ran; once what is left
+// can be copied within -model-downtime-ms, or after -model-max-rounds
+// rounds, the process is stopped and the rest is copied. Pages are sent at
+// -model-bandwidth MiB/s after shrinking by -model-compression.
+//
+// The memory size is -model-memory-mb if given, else the writable VMAs of
+// the capture's start_maps (-capture-maps), else its unique dirty pages,
+// which underestimates the first round; memory_source says which. Pages
+// dirtied during a round are the distinct listed pages of the samples in
+// it; a sample only partly inside the round contributes the matching
+// fraction of its pages not already counted. A sample whose pages weren't
+// all listed (-max-pages-per-sample) contributes its count without
+// de-duplication, which overestimates, and sets counts_not_deduplicated.
+// A migration that outlasts the capture replays it from the start again,
+// treating the workload as periodic, and sets capture_looped. Resumed or
+// restored samples only cover one interval; the gap before them counts as
+// clean.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// ModelParams are the -model parameters
+type ModelParams struct {
+	BandwidthMiBps   float64 `json:"bandwidth_mib_per_sec"`
+	MaxRounds        int     `json:"max_rounds"`
+	DowntimeBudgetMs float64 `json:"downtime_budget_ms"`
+	CompressionRatio float64 `json:"compression_ratio"`
+	MemoryMiB        float64 `json:"memory_mib,omitempty"` // 0: from the capture
+}
+
+// ModelRound is one pre-copy round, or the final stop-and-copy
+type ModelRound struct {
+	Round      int     `json:"round"` // from 1; the stop-and-copy is last
+	StartMs    float64 `json:"start_ms"`
+	DurationMs float64 `json:"duration_ms"`
+	Pages      int     `json:"pages"`
+	Bytes      int64   `json:"bytes"` // sent, after compression
+	StopCopy   bool    `json:"stop_and_copy,omitempty"`
+}
+
+// MigrationModel is the predicted course of a pre-copy migration
+type MigrationModel struct {
+	Capture      string       `json:"capture"`
+	Params       ModelParams  `json:"params"`
+	PageSize     int          `json:"page_size"`
+	MemoryPages  int          `json:"memory_pages"`
+	MemorySource string       `json:"memory_source"` // "param", "start_maps", or "dirty_set"
+	Rounds       []ModelRound `json:"rounds"`
+
+	PrecopyRounds    int     `json:"precopy_rounds"`
+	Converged        bool    `json:"converged"`   // stopped within the downtime budget
+	StopReason       string  `json:"stop_reason"` // "downtime_budget" or "max_rounds"
+	DowntimeMs       float64 `json:"downtime_ms"`
+	TotalTimeMs      float64 `json:"total_time_ms"`
+	TransferredPages int     `json:"transferred_pages"`
+	TransferredBytes int64   `json:"transferred_bytes"`
+
+	CaptureLooped         bool `json:"capture_looped,omitempty"`
+	CountsNotDeduplicated bool `json:"counts_not_deduplicated,omitempty"`
+}
+
+// modelReplay answers how many pages a capture dirtied in a time window
+type modelReplay struct {
+	samples  []DirtySample
+	starts   []float64 // start of each sample's interval
+	pages    [][]uint64
+	lengthMs float64
+
+	looped, undeduplicated bool
+}
+
+func newModelReplay(pattern *DirtyPattern) *modelReplay {
+	r := &modelReplay{samples: pattern.Samples}
+	prevMs := 0.0
+	for i := range pattern.Samples {
+		sample := &pattern.Samples[i]
+		start := prevMs
+		if sample.discontinuous() {
+			start = max(prevMs, sample.TimestampMs-pattern.Summary.IntervalMs)
+		}
+		r.starts = append(r.starts, start)
+		r.pages = append(r.pages, samplePageNumbers(sample))
+		prevMs = sample.TimestampMs
+	}
+	r.lengthMs = prevMs
+	return r
+}
+
+// dirtyIn returns the pages dirtied from fromMs for durationMs, replaying
+// the capture from its start as often as needed
+func (r *modelReplay) dirtyIn(fromMs, durationMs float64) int {
+	if r.lengthMs <= 0 || durationMs <= 0 {
+		return 0
+	}
+	toMs := fromMs + durationMs
+	if toMs > r.lengthMs {
+		r.looped = true
+	}
+	// A window of a whole replay or more covers every sample
+	if durationMs >= r.lengthMs {
+		fromMs, toMs = 0, r.lengthMs
+	}
+
+	seen := make(map[uint64]struct{})
+	counted := 0.0
+	type partialSample struct {
+		index    int
+		fraction float64
+	}
+	var partials []partialSample
+	for cycle := int(fromMs / r.lengthMs); float64(cycle)*r.lengthMs < toMs; cycle++ {
+		offset := float64(cycle) * r.lengthMs
+		for i := range r.samples {
+			start, end := r.starts[i]+offset, r.samples[i].TimestampMs+offset
+			overlap := min(end, toMs) - max(start, fromMs)
+			if end <= start || overlap <= 0 {
+				continue
+			}
+			fraction := min(1, overlap/(end-start))
+			switch {
+			case len(r.pages[i]) < r.samples[i].DeltaDirtyCount:
+				r.undeduplicated = true
+				counted += fraction * float64(r.samples[i].DeltaDirtyCount)
+			case fraction < 1:
+				partials = append(partials, partialSample{i, fraction})
+			default:
+				for _, page := range r.pages[i] {
+					seen[page] = struct{}{}
+				}
+			}
+		}
+	}
+	for _, p := range partials {
+		fresh := 0
+		for _, page := range r.pages[p.index] {
+			if _, ok := seen[page]; !ok {
+				fresh++
+			}
+		}
+		counted += p.fraction * float64(fresh)
+	}
+	return len(seen) + int(counted+0.5)
+}
+
+// writableStartPages sums the writable VMAs of the capture's start maps
+func writableStartPages(pattern *DirtyPattern, pageSize uint64) int {
+	var bytes uint64
+	for _, vma := range pattern.StartMaps {
+		if vma.IsWritable() {
+			bytes += vma.End - vma.Start
+		}
+	}
+	return int(bytes / pageSize)
+}
+
+// modelMigration runs the pre-copy model over the capture at path
+func modelMigration(path string, params ModelParams) (*MigrationModel, error) {
+	pattern, err := loadCapture(path)
+	if err != nil {
+		return nil, err
+	}
+	pageSize := uint64(pattern.PageSize)
+	if pageSize == 0 {
+		pageSize = PageSize
+	}
+
+	m := &MigrationModel{Capture: path, Params: params, PageSize: int(pageSize), Rounds: []ModelRound{}}
+	switch {
+	case params.MemoryMiB > 0:
+		m.MemoryPages, m.MemorySource = int(params.MemoryMiB*(1<<20)/float64(pageSize)), "param"
+	case len(pattern.StartMaps) > 0:
+		m.MemoryPages, m.MemorySource = writableStartPages(pattern, pageSize), "start_maps"
+	default:
+		m.MemoryPages, m.MemorySource = pattern.Summary.TotalUniquePages, "dirty_set"
+		fmt.Fprintln(os.Stderr, "Warning: the capture has no start_maps and -model-memory-mb is unset; using its unique dirty pages as the memory size, which underestimates the first round")
+	}
+
+	wireBytes := func(pages int) int64 {
+		return int64(float64(pages) * float64(pageSize) / params.CompressionRatio)
+	}
+	bytesPerMs := params.BandwidthMiBps * (1 << 20) / 1000
+	durationMs := func(pages int) float64 {
+		return float64(wireBytes(pages)) / bytesPerMs
+	}
+
+	replay := newModelReplay(pattern)
+	nowMs := 0.0
+	pending := m.MemoryPages
+	m.StopReason = "max_rounds"
+	for round := 1; round <= params.MaxRounds; round++ {
+		if round > 1 && durationMs(pending) <= params.DowntimeBudgetMs {
+			m.StopReason = "downtime_budget"
+			break
+		}
+		d := durationMs(pending)
+		m.Rounds = append(m.Rounds, ModelRound{Round: round, StartMs: nowMs, DurationMs: d,
+			Pages: pending, Bytes: wireBytes(pending)})
+		m.PrecopyRounds++
+		m.TransferredPages += pending
+		m.TransferredBytes += wireBytes(pending)
+		pending = replay.dirtyIn(nowMs, d)
+		nowMs += d
+	}
+	if m.StopReason == "max_rounds" && durationMs(pending) <= params.DowntimeBudgetMs {
+		m.StopReason = "downtime_budget"
+	}
+	m.Converged = m.StopReason == "downtime_budget"
+
+	m.DowntimeMs = durationMs(pending)
+	m.Rounds = append(m.Rounds, ModelRound{Round: len(m.Rounds) + 1, StartMs: nowMs, DurationMs: m.DowntimeMs,
+		Pages: pending, Bytes: wireBytes(pending), StopCopy: true})
+	m.TransferredPages += pending
+	m.TransferredBytes += wireBytes(pending)
+	m.TotalTimeMs = nowMs + m.DowntimeMs
+	m.CaptureLooped = replay.looped
+	m.CountsNotDeduplicated = replay.undeduplicated
+	return m, nil
+}
+
+// printModel writes the model to stdout or path
+func printModel(m *MigrationModel, path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if path != "" {
+		return writeOutputFile(path, data, false, codecNone)
+	}
+	fmt.Println(string(data))
+	return nil
+}
@@ -0,0 +1,170 @@
+// Normalized output (-format normalized)
+//
+// The flat format repeats vma_type, vma_perms, pathname, and the VMA range
+// for every dirty page. The normalized form lists each VMA once and records each dirty page
+// as a [vma_id, page_index] pair, where page_index counts pages from the
+// VMA start. All other top-level fields are identical to the flat format,
+// and "samples" has the same shape except for the pages.
+//
+// Per-page optional fields (-decode-flags, -relative-addr) are not carried
+// in the normalized form.
+//
+// Denormalize (or the -denormalize CLI mode) rebuilds the flat form.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// NormalizedVMA is one entry of the VMA table
+type NormalizedVMA struct {
+	ID       int    `json:"id"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Perms    string `json:"perms"`
+	Pathname string `json:"pathname"`
+	VMAType  string `json:"vma_type"`
+}
+
+// NormalizedSample is a DirtySample whose pages reference the VMA table
+type NormalizedSample struct {
+	TimestampMs     float64     `json:"timestamp_ms"`
+	Pages           [][2]uint64 `json:"pages"` // [vma_id, page_index]
+	DeltaDirtyCount int         `json:"delta_dirty_count"`
+	PidsTracked     []int       `json:"pids_tracked"`
+	CPUTimeMs       float64     `json:"cpu_time_ms,omitempty"`
+	IO              *IOSample   `json:"io,omitempty"`
+	Partial         bool        `json:"partial,omitempty"`
+	Truncated       bool        `json:"truncated,omitempty"`
+}
+
+// NormalizedPattern is the normalized output document. Its Samples field
+// shadows the embedded DirtyPattern's "samples" in JSON.
+type NormalizedPattern struct {
+	Format string `json:"format"`
+	DirtyPattern
+	VMAs    []NormalizedVMA    `json:"vmas"`
+	Samples []NormalizedSample `json:"samples"`
+}
+
+const normalizedFormat = "normalized-v1"
+
+// Normalize converts a live pattern into the normalized form
+func Normalize(pattern *DirtyPattern) *NormalizedPattern {
+	type vmaKey struct {
+		start, end      uint64
+		perms, pathname string
+		vmaType         string
+	}
+
+	np := &NormalizedPattern{Format: normalizedFormat, DirtyPattern: *pattern}
+	np.DirtyPattern.Samples = nil
+	ids := make(map[vmaKey]int)
+	pageSize := np.pageSize()
+
+	for _, sample := range pattern.Samples {
+		ns := NormalizedSample{
+			TimestampMs:     sample.TimestampMs,
+			Pages:           make([][2]uint64, 0, len(sample.DirtyPages)),
+			DeltaDirtyCount: sample.DeltaDirtyCount,
+			PidsTracked:     sample.PidsTracked,
+			CPUTimeMs:       sample.CPUTimeMs,
+			IO:              sample.IO,
+			Partial:         sample.Partial,
+			Truncated:       sample.Truncated,
+		}
+		for i := range sample.DirtyPages {
+			page := &sample.DirtyPages[i]
+			key := vmaKey{page.vmaStart, page.vmaStart + page.vmaSize, page.VMAPerms, page.Pathname, page.VMAType}
+			id, ok := ids[key]
+			if !ok {
+				id = len(np.VMAs)
+				ids[key] = id
+				np.VMAs = append(np.VMAs, NormalizedVMA{
+					ID:       id,
+					Start:    fmt.Sprintf("0x%x", key.start),
+					End:      fmt.Sprintf("0x%x", key.end),
+					Perms:    key.perms,
+					Pathname: key.pathname,
+					VMAType:  key.vmaType,
+				})
+			}
+			ns.Pages = append(ns.Pages, [2]uint64{uint64(id), (page.Address() - page.vmaStart) / pageSize})
+		}
+		np.Samples = append(np.Samples, ns)
+	}
+	return np
+}
+
+// pageSize returns the capture's page size, the host's for captures that
+// don't record it
+func (np *NormalizedPattern) pageSize() uint64 {
+	if np.PageSize == 0 {
+		return PageSize
+	}
+	return uint64(np.PageSize)
+}
+
+// Denormalize rebuilds the flat DirtyPattern
+func (np *NormalizedPattern) Denormalize() (*DirtyPattern, error) {
+	pageSize := np.pageSize()
+	starts := make([]uint64, len(np.VMAs))
+	for i, vma := range np.VMAs {
+		if vma.ID != i {
+			return nil, fmt.Errorf("vma table entry %d has id %d", i, vma.ID)
+		}
+		var err error
+		if starts[i], err = parseHexAddr(vma.Start); err != nil {
+			return nil, fmt.Errorf("vma %d start: %w", i, err)
+		}
+	}
+
+	pattern := np.DirtyPattern
+	pattern.Samples = make([]DirtySample, 0, len(np.Samples))
+	for _, ns := range np.Samples {
+		sample := DirtySample{
+			TimestampMs:     ns.TimestampMs,
+			DeltaDirtyCount: ns.DeltaDirtyCount,
+			PidsTracked:     ns.PidsTracked,
+			CPUTimeMs:       ns.CPUTimeMs,
+			IO:              ns.IO,
+			Partial:         ns.Partial,
+			Truncated:       ns.Truncated,
+		}
+		for _, ref := range ns.Pages {
+			if ref[0] >= uint64(len(np.VMAs)) {
+				return nil, fmt.Errorf("page references unknown vma %d", ref[0])
+			}
+			vma := &np.VMAs[ref[0]]
+			sample.DirtyPages = append(sample.DirtyPages, DirtyPage{
+				Addr:     fmt.Sprintf("0x%x", starts[ref[0]]+ref[1]*pageSize),
+				VMAType:  vma.VMAType,
+				VMAPerms: vma.Perms,
+				Pathname: vma.Pathname,
+				VMAStart: vma.Start,
+				VMAEnd:   vma.End,
+				Size:     int(pageSize),
+			})
+		}
+		pattern.Samples = append(pattern.Samples, sample)
+	}
+	return &pattern, nil
+}
+
+// denormalizeFile reads a normalized capture and returns the flat form
+func denormalizeFile(path string) (*DirtyPattern, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var np NormalizedPattern
+	if err := json.Unmarshal(data, &np); err != nil {
+		return nil, err
+	}
+	if np.Format != normalizedFormat {
+		return nil, fmt.Errorf("%s: not a normalized capture (format %q)", path, np.Format)
+	}
+	return np.Denormalize()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// normalizedPage is a dirty page at page number n of the VMA starting at
+// page number vma, for a capture of the given page size
+func normalizedPage(pageSize, vma, n uint64) DirtyPage {
+	return DirtyPage{
+		Addr:     fmt.Sprintf("0x%x", n*pageSize),
+		VMAType:  "heap",
+		VMAPerms: "rw-p",
+		Pathname: "[heap]",
+		VMAStart: fmt.Sprintf("0x%x", vma*pageSize),
+		VMAEnd:   fmt.Sprintf("0x%x", (vma+16)*pageSize),
+		Size:     int(pageSize),
+		vmaStart: vma * pageSize,
+		vmaSize:  16 * pageSize,
+	}
+}
+
+func TestNormalizeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		pageSize uint64
+	}{
+		{"host page size", PageSize},
+		{"16K capture", 16384},
+		{"64K capture", 65536},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pattern := DirtyPattern{PageSize: int(tt.pageSize), Samples: []DirtySample{
+				{TimestampMs: 0, DirtyPages: []DirtyPage{normalizedPage(tt.pageSize, 16, 16)}},
+				{TimestampMs: 100, DirtyPages: []DirtyPage{
+					normalizedPage(tt.pageSize, 16, 17), normalizedPage(tt.pageSize, 64, 70),
+				}},
+			}}
+			flat, err := Normalize(&pattern).Denormalize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(flat.Samples) != len(pattern.Samples) {
+				t.Fatalf("%d samples, want %d", len(flat.Samples), len(pattern.Samples))
+			}
+			for i := range pattern.Samples {
+				want := pattern.Samples[i].DirtyPages
+				got := flat.Samples[i].DirtyPages
+				if len(got) != len(want) {
+					t.Errorf("sample %d: %d pages, want %d", i, len(got), len(want))
+					continue
+				}
+				for j := range want {
+					if got[j].Addr != want[j].Addr || got[j].Size != want[j].Size {
+						t.Errorf("sample %d: page %d is %s (%d bytes), want %s (%d bytes)",
+							i, j, got[j].Addr, got[j].Size, want[j].Addr, want[j].Size)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+// Output size limit (-max-output-mb)
+//
+// A safety valve for long or unexpectedly dense -stream captures on small
+// disks. The limit counts the bytes this run writes to the stream, over
+// all segments when rotating. -output-limit-action picks what happens:
+//
+//	truncate  (default) once the stream reaches 90% of the limit, samples
+//	          are recorded and written without their page lists (counts,
+//	          unique pages, and rates stay exact; such samples are marked
+//	          truncated), and at the limit the capture stops
+//	stop      samples keep their detail and the capture stops at the limit
+//
+// Stopping ends the run as a signal would, with stop_reason "output_limit":
+// an array stream or rotated segment is closed properly and the final
+// output is written. The final output holds the same samples as the
+// stream, so it gets the same limit: should it come out larger, it is
+// rendered again with no page lists (marking the samples that lose theirs
+// truncated). A summary alone over the limit is still written whole, and
+// the per-type files of -split-by-vma-type and -format csv are not capped.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// outputDetailShare is the share of the limit after which "truncate"
+// drops page lists
+const outputDetailShare = 0.9
+
+// limitDetail drops a sample's page list once the stream nears the limit
+func (dt *DirtyPageTracker) limitDetail(sample *DirtySample) {
+	if dt.stream == nil || dt.outputLimit <= 0 || dt.outputLimitAction != "truncate" {
+		return
+	}
+	if float64(dt.stream.total) < outputDetailShare*float64(dt.outputLimit) {
+		return
+	}
+	if dt.outputDetailDropped == 0 {
+		fmt.Fprintf(os.Stderr, "Warning: stream is at %d%% of -max-output-mb; dropping per-page detail\n",
+			int(outputDetailShare*100))
+	}
+	sample.DirtyPages = []DirtyPage{}
+	sample.CleanPages = nil
+	sample.Truncated = sample.DeltaDirtyCount > 0
+	dt.outputDetailDropped++
+}
+
+// limitOutput returns the rendered final output, rendered again without
+// page lists when data is over the limit
+func (dt *DirtyPageTracker) limitOutput(pattern *DirtyPattern, data []byte, format string, noSamples bool) []byte {
+	if dt.outputLimit <= 0 || int64(len(data)) <= dt.outputLimit {
+		return data
+	}
+	capped := *pattern
+	capped.Samples = make([]DirtySample, len(pattern.Samples))
+	for i, sample := range pattern.Samples {
+		if len(sample.DirtyPages) > 0 {
+			sample.DirtyPages = []DirtyPage{}
+			sample.Truncated = true
+			capped.Summary.OutputDetailDropped++
+		}
+		sample.CleanPages = nil
+		capped.Samples[i] = sample
+	}
+	out, err := renderOutput(format, &capped, dt.startTime, noSamples)
+	if err != nil {
+		return data
+	}
+	fmt.Fprintf(os.Stderr, "Warning: output is %d bytes, over -max-output-mb; writing it without per-page detail (%d bytes)\n",
+		len(data), len(out))
+	return out
+}
+
+// checkOutputLimit stops the run once the stream has reached the limit
+func (dt *DirtyPageTracker) checkOutputLimit() {
+	if dt.stream == nil || dt.outputLimit <= 0 || dt.stream.total < dt.outputLimit {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Stream reached -max-output-mb (%d bytes), stopping\n", dt.stream.total)
+	dt.stopWithReason("output_limit")
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestEntrySoftDirty(t *testing.T) {
+	const (
+		pfnBits  = uint64(1)<<55 - 1 // bits 0-54
+		swapType = uint64(0x1f)      // bits 0-4
+		swapOff  = pfnBits &^ swapType
+	)
+	tests := []struct {
+		name      string
+		entry     uint64
+		dirty     bool // by default
+		skipHoles bool // with -skip-holes
+	}{
+		{"present, soft-dirty", PagePresent | SoftDirty | pfnBits, true, true},
+		{"present, clean", PagePresent | pfnBits, false, false},
+		{"present, clean, PFN all ones", PagePresent | PageExclusive | pfnBits, false, false},
+		{"swapped, soft-dirty", PageSwapped | SoftDirty | swapOff | swapType, true, true},
+		{"swapped, soft-dirty, exclusive", PageSwapped | SoftDirty | PageExclusive | 1<<5 | 2, true, true},
+		{"swapped, clean", PageSwapped | swapOff | swapType, false, false},
+		{"swapped shmem, soft-dirty", PageSwapped | PageFile | SoftDirty | 1<<5, true, true},
+		{"hole in VM_SOFTDIRTY VMA", SoftDirty, true, false},
+		{"hole", 0, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := entrySoftDirty(tt.entry, false); got != tt.dirty {
+				t.Errorf("entry %#016x: soft-dirty %v, want %v", tt.entry, got, tt.dirty)
+			}
+			if got := entrySoftDirty(tt.entry, true); got != tt.skipHoles {
+				t.Errorf("entry %#016x with skipHoles: soft-dirty %v, want %v", tt.entry, got, tt.skipHoles)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+// PAGEMAP_SCAN read strategy (-read-strategy scan)
+//
+// Linux 6.7 added the PAGEMAP_SCAN ioctl on /proc/pid/pagemap, which
+// returns only the address ranges whose pages match a category mask
+// instead of one 8-byte entry per page. For sparse dirtying of large
+// address spaces that saves copying and scanning mostly-clean entries.
+// It reports categories only, so per-page pagemap bits (-decode-flags)
+// are not available with it.
+package main
+
+import (
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// Read strategies for ReadOptions.Strategy
+const (
+	ReadSeek  = "seek"  // lseek + read per VMA (default)
+	ReadPread = "pread" // one pread per VMA
+	ReadScan  = "scan"  // PAGEMAP_SCAN ioctl, Linux 6.7+
+)
+
+const (
+	// _IOWR('f', 16, struct pm_scan_arg)
+	pagemapScanIoctl = 0xc0606610
+
+	pageIsPresent   = 1 << 3
+	pageIsSwapped   = 1 << 4
+	pageIsSoftDirty = 1 << 7
+
+	// pageRegions returned per ioctl call
+	scanVecLen = 1024
+)
+
+// pmScanArg mirrors struct pm_scan_arg from linux/fs.h
+type pmScanArg struct {
+	size              uint64
+	flags             uint64
+	start             uint64
+	end               uint64
+	walkEnd           uint64
+	vec               uint64
+	vecLen            uint64
+	maxPages          uint64
+	categoryInverted  uint64
+	categoryMask      uint64
+	categoryAnyofMask uint64
+	returnMask        uint64
+}
+
+// pageRegion mirrors struct page_region
+type pageRegion struct {
+	start      uint64
+	end        uint64
+	categories uint64
+}
+
+// scanSoftDirty returns the soft-dirty page ranges in [start, end) of the
+// process behind pagemapFd, reusing vec between calls. With skipHoles only
+// present or swapped pages are returned (see entrySoftDirty).
+func scanSoftDirty(pagemapFd int, start, end uint64, vec []pageRegion, skipHoles bool) ([]pageRegion, []pageRegion, error) {
+	if len(vec) == 0 {
+		vec = make([]pageRegion, scanVecLen)
+	}
+	var regions []pageRegion
+	for start < end {
+		arg := pmScanArg{
+			start:        start,
+			end:          end,
+			vec:          uint64(uintptr(unsafe.Pointer(&vec[0]))),
+			vecLen:       uint64(len(vec)),
+			categoryMask: pageIsSoftDirty,
+			returnMask:   pageIsSoftDirty,
+		}
+		if skipHoles {
+			arg.categoryAnyofMask = pageIsPresent | pageIsSwapped
+		}
+		arg.size = uint64(unsafe.Sizeof(arg))
+		n, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(pagemapFd), pagemapScanIoctl, uintptr(unsafe.Pointer(&arg)))
+		// arg.vec hides vec from the GC
+		runtime.KeepAlive(vec)
+		if errno != 0 {
+			return regions, vec, errno
+		}
+		regions = append(regions, vec[:n]...)
+		if arg.walkEnd <= start {
+			break
+		}
+		start = arg.walkEnd
+	}
+	return regions, vec, nil
+}
+
+// pagemapScanSupported probes whether this kernel's PAGEMAP_SCAN accepts
+// the soft-dirty category
+func pagemapScanSupported() bool {
+	fd, err := syscall.Open("/proc/self/pagemap", syscall.O_RDONLY, 0)
+	if err != nil {
+		return false
+	}
+	defer syscall.Close(fd)
+	buf := make([]byte, 2*PageSize)
+	probe := (uint64(uintptr(unsafe.Pointer(&buf[0]))) + PageSize - 1) &^ (PageSize - 1)
+	_, _, err = scanSoftDirty(fd, probe, probe+PageSize, nil, false)
+	runtime.KeepAlive(buf)
+	return err == nil
+}
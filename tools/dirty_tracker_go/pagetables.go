@@ -0,0 +1,99 @@
+// Page table estimate (-track-pagetables)
+//
+// The per-page view leaves out the page tables that map the dirty pages.
+// In a large, sparse address space they stop being negligible: one dirty
+// page per 2 MiB region needs a whole page-table page for each of them.
+// The kernel doesn't report which page-table pages changed, so with
+// -track-pagetables they are estimated from the dirty addresses, assuming
+// four-level paging with tables of one page of 8-byte entries, 512 of them
+// with 4 KiB pages as on x86-64:
+//
+//   - every distinct 2 MiB region with a dirty page counts one PTE table,
+//   - every distinct 1 GiB region one PMD table,
+//   - every distinct 512 GiB region one PUD table,
+//
+// per process, since each has its own tables (the regions grow with the
+// page size: 32 MiB, 64 GiB, and 128 TiB with 16 KiB pages); the top-level
+// PGD, one per process, is left out. Writing a page sets bits in its PTE, and the
+// soft-dirty clear write-protects it again, so the PTE tables counted are
+// written every interval the page is dirtied. CRIU doesn't dump page
+// tables but the restore has to build them again for every restored page,
+// and so does the destination of a migration. The upper levels only change
+// when tables are allocated, so counting them along is an upper bound.
+//
+// The estimate is over the listed dirty pages, so pages beyond
+// -max-pages-per-sample are missing from it; a 2 MiB region mapped by a
+// transparent huge page has no PTE table, which makes it an overestimate
+// for THP-backed memory. The summary reports the tables spanned by all
+// pages of the run and, per sample, the peak and mean.
+package main
+
+const pageTableLevels = 3 // PTE, PMD, PUD
+
+// pageTableEntries is the entries of a one-page table of 8-byte entries
+var pageTableEntries = PageSize / 8
+
+// PageTableEstimate is the -track-pagetables summary
+type PageTableEstimate struct {
+	PTETables                    int     `json:"pte_tables"`
+	PMDTables                    int     `json:"pmd_tables"`
+	PUDTables                    int     `json:"pud_tables"`
+	EstimatedPageTableDirtyPages int     `json:"estimated_page_table_dirty_pages"` // the three summed
+	EstimatedBytes               int     `json:"estimated_bytes"`
+	PerUniqueDirtyPage           float64 `json:"per_unique_dirty_page"` // table pages per unique dirty page
+	PeakSamplePages              int     `json:"peak_sample_pages"`
+	MeanSamplePages              float64 `json:"mean_sample_pages"`
+}
+
+// pageTableKey is one page-table page of a process
+type pageTableKey struct {
+	pid   int
+	level int // 0 for PTE tables
+	index uint64
+}
+
+// addPageTables adds the tables mapping pages to seen
+func addPageTables(seen map[pageTableKey]struct{}, pages []DirtyPage) {
+	for i := range pages {
+		span := PageSize * pageTableEntries
+		addr := pages[i].Address()
+		for level := 0; level < pageTableLevels; level++ {
+			seen[pageTableKey{pages[i].pid, level, addr / span}] = struct{}{}
+			span *= pageTableEntries
+		}
+	}
+}
+
+// pageTableEstimate estimates the page-table pages behind the dirty pages
+// of samples
+func pageTableEstimate(samples []DirtySample, uniquePages int) *PageTableEstimate {
+	est := &PageTableEstimate{}
+	all := make(map[pageTableKey]struct{})
+	sampled := 0
+	for i := range samples {
+		addPageTables(all, samples[i].DirtyPages)
+		perSample := make(map[pageTableKey]struct{})
+		addPageTables(perSample, samples[i].DirtyPages)
+		est.PeakSamplePages = max(est.PeakSamplePages, len(perSample))
+		sampled += len(perSample)
+	}
+	for key := range all {
+		switch key.level {
+		case 0:
+			est.PTETables++
+		case 1:
+			est.PMDTables++
+		default:
+			est.PUDTables++
+		}
+	}
+	est.EstimatedPageTableDirtyPages = len(all)
+	est.EstimatedBytes = len(all) * int(PageSize)
+	if uniquePages > 0 {
+		est.PerUniqueDirtyPage = float64(len(all)) / float64(uniquePages)
+	}
+	if len(samples) > 0 {
+		est.MeanSamplePages = float64(sampled) / float64(len(samples))
+	}
+	return est
+}
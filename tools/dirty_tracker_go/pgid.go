@@ -0,0 +1,83 @@
+// Process group tracking (-pgid)
+//
+// A shell job is a process group, and its members need not be one tree:
+// a pipeline's processes are siblings under the shell, and a member that
+// daemonizes is reparented out of any tree the tracker follows. With
+// -pgid N the tracked set is the processes whose process group (field 5
+// of /proc/[pid]/stat) is N, instead of the root's descendants. /proc is
+// rescanned before every sample: new members are tracked, and a live
+// process that moved to another group (setpgid, or a setsid that makes it
+// a leader) is dropped, and tracked again if it comes back. Without -pid
+// the root is the group leader, or the oldest member once the leader has
+// exited. The tracker itself is never a member it tracks, even when run
+// from the same job.
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// processGroupMembers returns the live processes in process group pgid
+func processGroupMembers(pgid int) map[int]struct{} {
+	members := make(map[int]struct{})
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return members
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || isSelf(pid) {
+			continue
+		}
+		stat, err := readProcStat(pid)
+		if err != nil || stat.Pgrp != pgid || stat.State == 'Z' || stat.State == 'X' {
+			continue
+		}
+		members[pid] = struct{}{}
+	}
+	return members
+}
+
+// processGroupRoot returns the leader of group pgid, or its oldest member
+func processGroupRoot(pgid int) (int, error) {
+	members := processGroupMembers(pgid)
+	if _, ok := members[pgid]; ok {
+		return pgid, nil
+	}
+	root, rootStart := 0, uint64(0)
+	for pid := range members {
+		stat, err := readProcStat(pid)
+		if err != nil {
+			continue
+		}
+		if root == 0 || stat.StartTime < rootStart || (stat.StartTime == rootStart && pid < root) {
+			root, rootStart = pid, stat.StartTime
+		}
+	}
+	if root == 0 {
+		return 0, fmt.Errorf("no process is in process group %d", pgid)
+	}
+	return root, nil
+}
+
+// trackProcessGroup brings the tracked set in line with the group's
+// members. Called with dt.mu held.
+func (dt *DirtyPageTracker) trackProcessGroup() {
+	members := processGroupMembers(dt.pgid)
+	for pid, tracker := range dt.trackers {
+		if _, ok := members[pid]; ok || !tracker.IsAlive() {
+			continue
+		}
+		tracker.Close()
+		delete(dt.trackers, pid)
+		fmt.Fprintf(os.Stderr, "Process %d left process group %d, no longer tracking it\n", pid, dt.pgid)
+	}
+	for pid := range members {
+		if dt.addProcessTracker(pid) {
+			fmt.Fprintf(os.Stderr, "Tracking process group member: %d\n", pid)
+			dt.recordTarget(pid)
+		}
+	}
+}
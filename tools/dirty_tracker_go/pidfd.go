@@ -0,0 +1,71 @@
+// Process identity through pidfds
+//
+// A PID can be reused once its process has exited and been reaped, so a
+// check like "does /proc/<pid> exist" can't tell the tracked process from
+// a newcomer that got its number, and the newcomer's dirty pages would be
+// attributed to the old one. Open therefore takes a pidfd (pidfd_open,
+// Linux 5.3+) before opening pagemap and checks it afterwards: a pidfd
+// always refers to the process it was opened for, so if that process is
+// still alive the pagemap is its own. IsAlive asks the pidfd instead of
+// /proc. A re-open (after losing access) also compares the process start
+// time with the first open's.
+//
+// Older kernels (ENOSYS) and -helper fall back to the /proc checks.
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Syscall numbers, the same on every architecture since Linux 5.1
+const (
+	sysPidfdSendSignal = 424
+	sysPidfdOpen       = 434
+)
+
+// pidfdOpen returns a pidfd for pid
+func pidfdOpen(pid int) (int, error) {
+	fd, _, errno := syscall.Syscall(sysPidfdOpen, uintptr(pid), 0, 0)
+	if errno != 0 {
+		return -1, errno
+	}
+	syscall.CloseOnExec(int(fd))
+	return int(fd), nil
+}
+
+// pidfdAlive reports whether the pidfd's process still exists (a zombie
+// counts, as its /proc entry does). Signal 0 only checks for existence;
+// EPERM means it exists but we may not signal it.
+func pidfdAlive(fd int) bool {
+	_, _, errno := syscall.Syscall6(sysPidfdSendSignal, uintptr(fd), 0, 0, 0, 0, 0)
+	return errno == 0 || errno == syscall.EPERM
+}
+
+// openIdentity takes the pidfd before Open opens the process's files
+func (pt *ProcessTracker) openIdentity() {
+	if pt.pidfd >= 0 {
+		return
+	}
+	if fd, err := pidfdOpen(pt.pid); err == nil {
+		pt.pidfd = fd
+	}
+}
+
+// checkIdentity verifies, once Open has opened the process's files, that
+// they belong to the process first opened
+func (pt *ProcessTracker) checkIdentity() error {
+	if pt.pidfd >= 0 && !pidfdAlive(pt.pidfd) {
+		return fmt.Errorf("process %d exited while opening: %w", pt.pid, syscall.ESRCH)
+	}
+	stat, err := readProcStat(pt.pid)
+	if err != nil {
+		return nil
+	}
+	if pt.startTicks == 0 {
+		pt.startTicks = stat.StartTime
+	} else if stat.StartTime != pt.startTicks {
+		return fmt.Errorf("PID %d was reused by another process: %w", pt.pid, syscall.ESRCH)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// Plot specs (-emit-plot)
+//
+// Writes a ready-to-run chart of the dirty rate timeline next to the
+// output: the rate (and its EMA) on the left axis and cumulative pages on
+// the right. It reads run.timeline.csv (see export.go), which is written
+// for the purpose when -format isn't csv. Given -output run.json:
+//
+//	-emit-plot gnuplot     run.plot.gp:  cd to its directory, gnuplot -p run.plot.gp
+//	-emit-plot vega-lite   run.vl.json:  open with the Vega editor or vega-embed,
+//	                                     served from the same directory
+//
+// Both refer to the CSV by file name, so keep the files together.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// plotPath returns the plot spec path for the given kind
+func plotPath(kind, output string) (string, error) {
+	base := strings.TrimSuffix(output, filepath.Ext(output))
+	switch kind {
+	case "gnuplot":
+		return base + ".plot.gp", nil
+	case "vega-lite":
+		return base + ".vl.json", nil
+	}
+	return "", fmt.Errorf("unknown -emit-plot %q (use gnuplot or vega-lite)", kind)
+}
+
+// gnuplotScript renders the timeline plot as a gnuplot script
+func gnuplotScript(workload, csvName string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Dirty rate timeline of %s\n", workload)
+	b.WriteString("set datafile separator ','\n")
+	fmt.Fprintf(&b, "set title %q noenhanced\n", "Dirty rate: "+workload)
+	b.WriteString("set xlabel 'time (s)'\n")
+	b.WriteString("set ylabel 'dirty rate (pages/s)'\n")
+	b.WriteString("set y2label 'cumulative pages'\n")
+	b.WriteString("set ytics nomirror\n")
+	b.WriteString("set y2tics\n")
+	b.WriteString("set key top left\n")
+	fmt.Fprintf(&b, "plot %q skip 1 using ($1/1000):2 with lines title 'rate', \\\n", csvName)
+	b.WriteString("     '' skip 1 using ($1/1000):3 with lines title 'EMA rate', \\\n")
+	b.WriteString("     '' skip 1 using ($1/1000):4 axes x1y2 with lines title 'cumulative pages'\n")
+	return []byte(b.String())
+}
+
+// vegaLiteSpec renders the timeline plot as a Vega-Lite v5 spec
+func vegaLiteSpec(workload, csvName string) ([]byte, error) {
+	line := func(field, title, color string) map[string]any {
+		return map[string]any{
+			"mark": map[string]any{"type": "line", "color": color},
+			"encoding": map[string]any{
+				"y": map[string]any{"field": field, "type": "quantitative", "title": title,
+					"axis": map[string]any{"titleColor": color}},
+			},
+		}
+	}
+	ema := line("ema_rate_pages_per_sec", "dirty rate (pages/s)", "#1f77b4")
+	ema["mark"] = map[string]any{"type": "line", "color": "#2ca02c", "strokeDash": []int{4, 2}}
+	spec := map[string]any{
+		"$schema":   "https://vega.github.io/schema/vega-lite/v5.json",
+		"title":     "Dirty rate: " + workload,
+		"width":     800,
+		"height":    300,
+		"data":      map[string]any{"url": csvName, "format": map[string]any{"type": "csv"}},
+		"transform": []any{map[string]any{"calculate": "datum.timestamp_ms / 1000", "as": "time_s"}},
+		"encoding": map[string]any{
+			"x": map[string]any{"field": "time_s", "type": "quantitative", "title": "time (s)"},
+		},
+		"layer": []any{
+			// The rate and its EMA share the left axis
+			map[string]any{"layer": []any{
+				line("rate_pages_per_sec", "dirty rate (pages/s)", "#1f77b4"),
+				ema,
+			}},
+			line("cumulative_pages", "cumulative pages", "#ff7f0e"),
+		},
+		"resolve": map[string]any{"scale": map[string]any{"y": "independent"}},
+	}
+	return json.MarshalIndent(spec, "", "  ")
+}
+
+// writePlot writes the -emit-plot spec for the pattern next to output,
+// plus the timeline CSV unless -format csv already wrote it. It returns
+// the paths written.
+func writePlot(kind string, pattern *DirtyPattern, output string, haveCSV bool) ([]string, error) {
+	path, err := plotPath(kind, output)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(output)
+	if dir != "" && dir != "." {
+		os.MkdirAll(dir, 0755)
+	}
+
+	var written []string
+	_, _, timelinePath := csvTablePaths(output)
+	if !haveCSV {
+		if err := writeTimelineCSV(pattern, timelinePath); err != nil {
+			return nil, err
+		}
+		written = append(written, timelinePath)
+	}
+
+	var data []byte
+	if kind == "gnuplot" {
+		data = gnuplotScript(pattern.Workload, filepath.Base(timelinePath))
+	} else if data, err = vegaLiteSpec(pattern.Workload, filepath.Base(timelinePath)); err != nil {
+		return nil, err
+	}
+	if err := writeOutputFile(path, data, false, codecNone); err != nil {
+		return nil, err
+	}
+	return append(written, path), nil
+}
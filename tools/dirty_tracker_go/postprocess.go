@@ -0,0 +1,41 @@
+// Post-processing the output (-post-process)
+//
+//	./dirty_tracker -pid 1234 -post-process ./my-analysis -output report.txt
+//
+// runs the given program (directly, not through a shell, with no
+// arguments) once tracking ends. Its contract:
+//
+//   - stdin is the final capture as -format json renders it, honouring
+//     -no-samples, -anonymize-paths and -deterministic
+//   - whatever it writes to stdout, in any format, becomes the primary
+//     output, written to -output (compressed as usual) or stdout
+//   - stderr is passed through to the tool's stderr
+//   - exit status 0 means success
+//
+// If the program can't be started or exits non-zero, the failure is
+// reported and the unprocessed JSON is written instead, so the capture is
+// never lost. Sinks, -upload-url, and -validate-schema see the unprocessed
+// capture.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// postProcess pipes the rendered capture through program and returns its
+// output, or data itself if the program failed
+func postProcess(program string, data []byte) []byte {
+	var out bytes.Buffer
+	cmd := exec.Command(program)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: -post-process %s failed (%v); writing the unprocessed capture\n", program, err)
+		return data
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,133 @@
+// Interval probe (-probe-interval)
+//
+// A sample has to discover children, parse every tracked process's maps,
+// read the pagemap of its writable VMAs, and clear its soft-dirty bits
+// before the next one is due. How long that takes grows with the target's
+// address space, so an interval that suits a small service can be
+// unattainable for a large JVM, and the run then falls behind (see
+// intervals_met_pct). -probe-interval opens the target with the same read
+// options as a capture, clears it once, and times -probe-iterations such
+// passes, -interval apart so that each finds a realistic amount of dirty
+// memory, then prints the timings as JSON and exits.
+//
+// min_feasible_interval_ms is the p99 pass time, the shortest interval the
+// tracker would keep up with; recommended_interval_ms leaves the pass at
+// most half of each interval (probeHeadroom), for the summary work and
+// output a real capture adds on top. The passes clear soft-dirty bits like
+// a capture does, unless -no-clear is given.
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// probeHeadroom is the recommended interval as a multiple of the p99 pass
+const probeHeadroom = 2
+
+// ProbeReport is the output of -probe-interval
+type ProbeReport struct {
+	Pid                   int     `json:"pid"`
+	Iterations            int     `json:"iterations"`
+	MaxProcesses          int     `json:"max_processes"`
+	MaxVMAs               int     `json:"max_vmas"`
+	MaxDirtyPages         int     `json:"max_dirty_pages"`
+	P50Ms                 float64 `json:"p50_ms"`
+	P90Ms                 float64 `json:"p90_ms"`
+	P99Ms                 float64 `json:"p99_ms"`
+	MaxMs                 float64 `json:"max_ms"`
+	ReadP50Ms             float64 `json:"read_p50_ms"`  // maps and pagemap
+	ClearP50Ms            float64 `json:"clear_p50_ms"` // clear_refs writes
+	MinFeasibleIntervalMs int     `json:"min_feasible_interval_ms"`
+	RecommendedIntervalMs int     `json:"recommended_interval_ms"`
+}
+
+// probeInterval times iterations sampling passes over the target
+func (dt *DirtyPageTracker) probeInterval(iterations int) (*ProbeReport, error) {
+	dt.startTime = time.Now()
+	if !dt.addProcessTracker(dt.rootPid) {
+		return nil, fmt.Errorf("cannot open process %d", dt.rootPid)
+	}
+	defer func() {
+		for _, tracker := range dt.trackers {
+			tracker.Close()
+		}
+	}()
+	if !dt.noClear {
+		for _, tracker := range dt.trackers {
+			tracker.ClearSoftDirty()
+		}
+	}
+
+	report := &ProbeReport{Pid: dt.rootPid, Iterations: iterations}
+	interval := time.Duration(dt.intervalMs) * time.Millisecond
+	var totals, reads, clears []float64
+	for i := 0; i < iterations; i++ {
+		time.Sleep(interval)
+		start := time.Now()
+		if dt.trackChildren {
+			dt.trackNewDescendants()
+		}
+		dt.removeDeadProcesses()
+		if len(dt.trackers) == 0 {
+			return nil, fmt.Errorf("process %d exited during the probe", dt.rootPid)
+		}
+		var read, clearTime time.Duration
+		vmas, dirty := 0, 0
+		for _, tracker := range dt.trackers {
+			readStart := time.Now()
+			_, count, err := tracker.ReadDirtyPages(make(map[uint64]struct{}), -1)
+			read += time.Since(readStart)
+			if err != nil {
+				continue
+			}
+			vmas += tracker.vmaCount
+			dirty += count
+			if !dt.noClear {
+				clearStart := time.Now()
+				tracker.ClearSoftDirty()
+				clearTime += time.Since(clearStart)
+			}
+		}
+		totals = append(totals, durationToMs(time.Since(start)))
+		reads = append(reads, durationToMs(read))
+		clears = append(clears, durationToMs(clearTime))
+		report.MaxProcesses = max(report.MaxProcesses, len(dt.trackers))
+		report.MaxVMAs = max(report.MaxVMAs, vmas)
+		report.MaxDirtyPages = max(report.MaxDirtyPages, dirty)
+	}
+
+	sort.Float64s(totals)
+	sort.Float64s(reads)
+	sort.Float64s(clears)
+	report.P50Ms = nearestRank(totals, 50)
+	report.P90Ms = nearestRank(totals, 90)
+	report.P99Ms = nearestRank(totals, 99)
+	report.MaxMs = totals[len(totals)-1]
+	report.ReadP50Ms = nearestRank(reads, 50)
+	report.ClearP50Ms = nearestRank(clears, 50)
+	report.MinFeasibleIntervalMs = max(int(math.Ceil(report.P99Ms)), 1)
+	report.RecommendedIntervalMs = max(int(math.Ceil(report.P99Ms*probeHeadroom)), 1)
+	return report, nil
+}
+
+// nearestRank returns the p-th percentile of sorted values
+func nearestRank(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[min(max(rank, 1), len(sorted))-1]
+}
+
+func durationToMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000.0
+}
+
+// printProbeSummary describes the report on stderr
+func printProbeSummary(r *ProbeReport) {
+	fmt.Fprintf(os.Stderr, "%d passes over up to %d processes (%d VMAs): p50 %.2f ms, p90 %.2f ms, p99 %.2f ms, max %.2f ms\n",
+		r.Iterations, r.MaxProcesses, r.MaxVMAs, r.P50Ms, r.P90Ms, r.P99Ms, r.MaxMs)
+	fmt.Fprintf(os.Stderr, "Minimum feasible interval: %d ms; recommended: -interval %d\n",
+		r.MinFeasibleIntervalMs, r.RecommendedIntervalMs)
+}
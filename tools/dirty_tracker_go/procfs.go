@@ -0,0 +1,204 @@
+// Helpers for reading per-process files under /proc
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ClockTicksPerSec is USER_HZ, the unit of /proc/[pid]/stat CPU times. It
+// is 100 on every Linux architecture regardless of the kernel's HZ.
+const ClockTicksPerSec = 100
+
+// ProcStat holds the /proc/[pid]/stat fields the tracker uses
+type ProcStat struct {
+	State     byte
+	Ppid      int
+	Pgrp      int
+	Utime     uint64 // clock ticks
+	Stime     uint64 // clock ticks
+	StartTime uint64 // clock ticks after boot
+	KstkESP   uint64 // stack pointer; 0 unless the kernel exposes it
+	KstkEIP   uint64 // instruction pointer; 0 unless the kernel exposes it
+}
+
+// CPUTicks returns user plus system CPU time in clock ticks
+func (s *ProcStat) CPUTicks() uint64 {
+	return s.Utime + s.Stime
+}
+
+// readProcStat parses /proc/[pid]/stat. The comm field may contain spaces
+// and parentheses, so fields are counted from the last ')'.
+func readProcStat(pid int) (*ProcStat, error) {
+	return parseStatFile(fmt.Sprintf("/proc/%d/stat", pid))
+}
+
+// isSelf reports whether pid is this process or one of its threads
+func isSelf(pid int) bool {
+	if pid == os.Getpid() {
+		return true
+	}
+	_, err := os.Stat(fmt.Sprintf("/proc/self/task/%d", pid))
+	return err == nil
+}
+
+// readTaskStat parses /proc/[pid]/task/[tid]/stat for a single thread
+func readTaskStat(pid, tid int) (*ProcStat, error) {
+	return parseStatFile(fmt.Sprintf("/proc/%d/task/%d/stat", pid, tid))
+}
+
+// processSleeping reports whether every thread of the process is in
+// interruptible (S) or uninterruptible (D) sleep. /proc/[pid]/stat only
+// shows the main thread, which in many servers sleeps while workers run.
+// Any read error counts as not sleeping.
+func processSleeping(pid int) bool {
+	entries, err := os.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+	if err != nil || len(entries) == 0 {
+		return false
+	}
+	for _, entry := range entries {
+		tid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			return false
+		}
+		stat, err := readTaskStat(pid, tid)
+		if err != nil || (stat.State != 'S' && stat.State != 'D') {
+			return false
+		}
+	}
+	return true
+}
+
+func parseStatFile(path string) (*ProcStat, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	content := string(data)
+	end := strings.LastIndexByte(content, ')')
+	if end < 0 {
+		return nil, fmt.Errorf("malformed %s", path)
+	}
+	// fields[0] is field 3 (state) in proc(5) numbering
+	fields := strings.Fields(content[end+1:])
+	if len(fields) < 28 {
+		return nil, fmt.Errorf("short %s", path)
+	}
+
+	stat := &ProcStat{State: fields[0][0]}
+	stat.Ppid, _ = strconv.Atoi(fields[1])
+	stat.Pgrp, _ = strconv.Atoi(fields[2])
+	stat.Utime, _ = strconv.ParseUint(fields[11], 10, 64)
+	stat.Stime, _ = strconv.ParseUint(fields[12], 10, 64)
+	stat.StartTime, _ = strconv.ParseUint(fields[19], 10, 64)
+	stat.KstkESP, _ = strconv.ParseUint(fields[26], 10, 64)
+	stat.KstkEIP, _ = strconv.ParseUint(fields[27], 10, 64)
+	return stat, nil
+}
+
+// readNSpid returns the process's PID in its innermost PID namespace from
+// the NSpid line of /proc/[pid]/status. For a process in the initial
+// namespace (or a kernel without NSpid) this is the host PID.
+func readNSpid(pid int) (int, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "NSpid:") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "NSpid:"))
+		if len(fields) == 0 {
+			break
+		}
+		return strconv.Atoi(fields[len(fields)-1])
+	}
+	return pid, nil
+}
+
+// threadStackPointer returns a thread's current stack pointer. It tries
+// /proc/[pid]/task/[tid]/syscall, whose second-to-last field is the stack
+// pointer while the thread is blocked, then the stat kstkesp field, which
+// modern kernels only fill in for core-dumping threads.
+func threadStackPointer(pid, tid int) (uint64, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/task/%d/syscall", pid, tid))
+	if err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) >= 3 {
+			sp, err := strconv.ParseUint(strings.TrimPrefix(fields[len(fields)-2], "0x"), 16, 64)
+			if err == nil && sp != 0 {
+				return sp, nil
+			}
+		}
+	}
+
+	stat, err := readTaskStat(pid, tid)
+	if err != nil {
+		return 0, err
+	}
+	if stat.KstkESP == 0 {
+		return 0, fmt.Errorf("stack pointer of thread %d not exposed (thread running?)", tid)
+	}
+	return stat.KstkESP, nil
+}
+
+// threadInstructionPointer returns a thread's instruction pointer and
+// where it came from, like threadStackPointer: the last field of the
+// syscall file while the thread is blocked, else the stat kstkeip field.
+func threadInstructionPointer(pid, tid int) (uint64, string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/task/%d/syscall", pid, tid))
+	if err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) >= 3 {
+			ip, err := strconv.ParseUint(strings.TrimPrefix(fields[len(fields)-1], "0x"), 16, 64)
+			if err == nil && ip != 0 {
+				return ip, "syscall", nil
+			}
+		}
+	}
+
+	stat, err := readTaskStat(pid, tid)
+	if err != nil {
+		return 0, "", err
+	}
+	if stat.KstkEIP == 0 {
+		return 0, "", fmt.Errorf("instruction pointer of thread %d not exposed (thread running?)", tid)
+	}
+	return stat.KstkEIP, "stat", nil
+}
+
+// ProcIO holds the /proc/[pid]/io storage counters. read_bytes and
+// write_bytes count bytes fetched from or sent to the block layer, so page
+// cache hits and socket traffic are not included.
+type ProcIO struct {
+	ReadBytes  uint64
+	WriteBytes uint64
+}
+
+// readProcIO parses /proc/[pid]/io. Reading it needs the same ptrace
+// access as pagemap.
+func readProcIO(pid int) (ProcIO, error) {
+	var io ProcIO
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/io", pid))
+	if err != nil {
+		return io, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		n, _ := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		switch key {
+		case "read_bytes":
+			io.ReadBytes = n
+		case "write_bytes":
+			io.WriteBytes = n
+		}
+	}
+	return io, nil
+}
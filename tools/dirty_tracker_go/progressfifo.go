@@ -0,0 +1,122 @@
+// Progress feed on a named pipe (-progress-fifo)
+//
+// For orchestrators that launch the tracker and want machine-readable
+// progress without parsing stderr, -progress-fifo PATH writes a JSON
+// object per line to the named pipe PATH every time the stderr progress
+// line is printed (every logEverySamples samples), and a final one with
+// "done": true when sampling ends:
+//
+//	{"elapsed_ms":1002.4,"samples":10,"dirty_pages":311,"rate_pages_per_sec":3104.2,"cumulative_pages":2870,"processes":2}
+//
+// dirty_pages and rate_pages_per_sec are those of the latest sample,
+// computed as in dirty_rate_timeline. The pipe is created if it doesn't
+// exist. Writing never blocks sampling: while no reader has the pipe open
+// updates are skipped and opening is retried at the next one, an update
+// that doesn't fit in a full pipe is dropped, and a reader that goes away
+// is waited for again. Each line is under PIPE_BUF, so readers never see a
+// partial one.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// ProgressUpdate is one line of the -progress-fifo feed
+type ProgressUpdate struct {
+	ElapsedMs       float64 `json:"elapsed_ms"`
+	Samples         int     `json:"samples"`
+	DirtyPages      int     `json:"dirty_pages"`
+	RatePagesPerSec float64 `json:"rate_pages_per_sec"`
+	CumulativePages int     `json:"cumulative_pages"`
+	Processes       int     `json:"processes"`
+	Done            bool    `json:"done,omitempty"`
+}
+
+// progressFifo writes the -progress-fifo feed
+type progressFifo struct {
+	path string
+	fd   int // -1 while no reader is connected
+
+	last     ProgressUpdate
+	havePrev bool
+	prevMs   float64
+}
+
+// openProgressFifo creates the pipe at path if needed. Readers are
+// connected lazily.
+func openProgressFifo(path string) (*progressFifo, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if err := syscall.Mkfifo(path, 0644); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else if info.Mode()&os.ModeNamedPipe == 0 {
+		return nil, fmt.Errorf("%s exists and is not a named pipe", path)
+	}
+	return &progressFifo{path: path, fd: -1}, nil
+}
+
+// observe takes in a sample and sends an update on every
+// logEverySamples-th
+func (p *progressFifo) observe(sample *DirtySample, samples int) {
+	var rate float64
+	if p.havePrev && !sample.discontinuous() {
+		if deltaTime := (sample.TimestampMs - p.prevMs) / 1000.0; deltaTime > 0 {
+			rate = float64(sample.DeltaDirtyCount) / deltaTime
+		}
+	}
+	p.havePrev, p.prevMs = true, sample.TimestampMs
+	p.last = ProgressUpdate{
+		ElapsedMs:       sample.TimestampMs,
+		Samples:         samples,
+		DirtyPages:      sample.DeltaDirtyCount,
+		RatePagesPerSec: rate,
+		CumulativePages: p.last.CumulativePages + sample.DeltaDirtyCount,
+		Processes:       len(sample.PidsTracked),
+	}
+	if samples%logEverySamples == 0 {
+		p.send(&p.last)
+	}
+}
+
+// send writes one update if a reader is connected and the pipe has room
+func (p *progressFifo) send(update *ProgressUpdate) {
+	if p.fd < 0 {
+		// ENXIO: no reader yet
+		fd, err := syscall.Open(p.path, syscall.O_WRONLY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			return
+		}
+		p.fd = fd
+	}
+	line, err := json.Marshal(update)
+	if err != nil {
+		return
+	}
+	// EAGAIN drops the update; EPIPE means the reader left
+	if _, err := syscall.Write(p.fd, append(line, '\n')); errors.Is(err, syscall.EPIPE) {
+		p.Close()
+	}
+}
+
+// finish sends the final update
+func (p *progressFifo) finish() {
+	final := p.last
+	final.Done = true
+	p.send(&final)
+}
+
+func (p *progressFifo) Close() error {
+	if p.fd < 0 {
+		return nil
+	}
+	err := syscall.Close(p.fd)
+	p.fd = -1
+	return err
+}
@@ -0,0 +1,68 @@
+// Memory pressure per sample (-psi)
+//
+// Each sample records the system-wide memory pressure stall information
+// from /proc/pressure/memory: the share of the last 10 seconds in which
+// some task ("some") or all non-idle tasks ("full") were stalled on memory,
+// in percent. Reclaim under pressure changes page residency and how often
+// pages are faulted back in and dirtied, so a dirtying spike can be read
+// against it. The values are system-wide and are not summed or averaged
+// into the summary.
+//
+// Kernels without CONFIG_PSI, or booted with psi=0, have no pressure file;
+// -psi then warns once and records nothing.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const psiMemoryPath = "/proc/pressure/memory"
+
+// PSISample is the memory pressure at sample time
+type PSISample struct {
+	SomeAvg10 float64 `json:"some_avg10"`
+	FullAvg10 float64 `json:"full_avg10"`
+}
+
+// readMemoryPressure parses the avg10 values of /proc/pressure/memory
+func readMemoryPressure() (*PSISample, error) {
+	f, err := os.Open(psiMemoryPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sample := &PSISample{}
+	found := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !strings.HasPrefix(fields[1], "avg10=") {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimPrefix(fields[1], "avg10="), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", psiMemoryPath, err)
+		}
+		switch fields[0] {
+		case "some":
+			sample.SomeAvg10 = value
+			found++
+		case "full":
+			sample.FullAvg10 = value
+			found++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if found == 0 {
+		return nil, fmt.Errorf("%s: no avg10 values", psiMemoryPath)
+	}
+	return sample, nil
+}
@@ -0,0 +1,323 @@
+// Raw pagemap snapshot (-raw-pagemap)
+//
+// The samples keep one bit of each pagemap entry. For offline analysis of
+// everything else the kernel exposes (present, swapped, PFN, swap slot,
+// exclusive, file/shared-anon, uffd-wp, and bits added later), -raw-pagemap
+// PATH writes the complete entries of every writable VMA of every tracked
+// process to PATH once, just before sample -raw-pagemap-sample (default 1)
+// reads them, so the soft-dirty bits are those that sample reports. PFNs
+// read as 0 without CAP_SYS_ADMIN.
+//
+// All integers are little-endian:
+//
+//	magic          8 bytes  "DTRAWPM1"
+//	page_size      u64
+//	timestamp_us   u64      time since tracking start, as sample timestamps
+//	sample         u64      1-based index of the sample it precedes
+//	process_count  u64
+//	per process, ascending by PID:
+//	  pid          u64
+//	  vma_count    u64
+//	  vma_count index entries, ascending by start:
+//	    start      u64
+//	    end        u64
+//	    perms      4 bytes  as in /proc/[pid]/maps, e.g. "rw-p"
+//	    path_len   u32
+//	    path       path_len bytes (anonymized with -anonymize-paths)
+//	  then for each index entry in turn, (end-start)/page_size pagemap
+//	  entries of u64, one per page from start
+//
+// A VMA unmapped between reading maps and reading its entries reads as
+// zero entries. decodeRawPagemap (or the -decode-raw-pagemap CLI mode)
+// reads a snapshot back, decoding the non-zero entries.
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	rawPagemapMagic = "DTRAWPM1"
+
+	// Pagemap bits decoded beyond the ones sampling uses
+	PageUffdWP   = uint64(1) << 57
+	pfnMask      = uint64(1)<<55 - 1
+	swapTypeBits = 5
+
+	// rawChunkPages is how many entries are read at a time
+	rawChunkPages = 1 << 16
+)
+
+// rawPagemapDump is a pending -raw-pagemap snapshot
+type rawPagemapDump struct {
+	path   string
+	sample int // 1-based
+	done   bool
+}
+
+// dumpRawPagemap writes the snapshot taken before sample number
+// len(dt.samples)+1, if that is the one requested
+func (dt *DirtyPageTracker) dumpRawPagemap() {
+	raw := dt.rawPagemap
+	if raw == nil || raw.done || len(dt.samples)+1 != raw.sample {
+		return
+	}
+	raw.done = true
+	if err := dt.writeRawPagemap(raw); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: -raw-pagemap: %v\n", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Raw pagemap snapshot written to %s\n", raw.path)
+}
+
+func (dt *DirtyPageTracker) writeRawPagemap(raw *rawPagemapDump) error {
+	f, err := os.Create(raw.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	var tmp [8]byte
+	put := func(v uint64) {
+		binary.LittleEndian.PutUint64(tmp[:], v)
+		w.Write(tmp[:])
+	}
+
+	pids := make([]int, 0, len(dt.trackers))
+	for pid := range dt.trackers {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+
+	w.WriteString(rawPagemapMagic)
+	put(PageSize)
+	put(uint64(time.Since(dt.startTime).Microseconds()))
+	put(uint64(raw.sample))
+	put(uint64(len(pids)))
+	for _, pid := range pids {
+		tracker := dt.trackers[pid]
+		vmas, err := tracker.ParseMaps()
+		if err != nil {
+			// Keep the layout intact with an empty process
+			vmas = nil
+		}
+		writable := vmas[:0]
+		for _, vma := range vmas {
+			if vma.IsWritable() {
+				writable = append(writable, vma)
+			}
+		}
+		put(uint64(pid))
+		put(uint64(len(writable)))
+		for _, vma := range writable {
+			pathname := vma.Pathname
+			if dt.readOpts.Anonymizer != nil {
+				pathname = dt.readOpts.Anonymizer.path(pathname)
+			}
+			put(vma.Start)
+			put(vma.End)
+			var perms [4]byte
+			copy(perms[:], vma.Perms)
+			w.Write(perms[:])
+			binary.LittleEndian.PutUint32(tmp[:4], uint32(len(pathname)))
+			w.Write(tmp[:4])
+			w.WriteString(pathname)
+		}
+		for _, vma := range writable {
+			if err := tracker.copyPagemap(w, vma.Start, vma.End); err != nil {
+				return err
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// copyPagemap writes the entries of [start, end) to w, zero-filling what
+// can't be read
+func (pt *ProcessTracker) copyPagemap(w io.Writer, start, end uint64) error {
+	total := int((end - start) / PageSize)
+	buf := make([]byte, min(total, rawChunkPages)*PagemapEntrySize)
+	for done := 0; done < total; {
+		chunk := buf[:min(total-done, rawChunkPages)*PagemapEntrySize]
+		n, err := pt.readPagemap(chunk, pagemapOffset(start+uint64(done)*PageSize))
+		if err != nil || n < 0 {
+			n = 0
+		}
+		clear(chunk[n:])
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+		done += len(chunk) / PagemapEntrySize
+	}
+	return nil
+}
+
+// RawPagemapSnapshot is a decoded -raw-pagemap file
+type RawPagemapSnapshot struct {
+	PageSize    uint64              `json:"page_size"`
+	TimestampMs float64             `json:"timestamp_ms"`
+	Sample      int                 `json:"sample"`
+	Processes   []RawPagemapProcess `json:"processes"`
+}
+
+type RawPagemapProcess struct {
+	Pid  int             `json:"pid"`
+	VMAs []RawPagemapVMA `json:"vmas"`
+}
+
+// RawPagemapVMA lists a VMA's non-zero entries; pages it leaves out had
+// an all-zero entry (not present, not swapped, no flags)
+type RawPagemapVMA struct {
+	Start    string           `json:"start"`
+	End      string           `json:"end"`
+	Perms    string           `json:"perms"`
+	Pathname string           `json:"pathname"`
+	Pages    []RawPagemapPage `json:"pages"`
+}
+
+// RawPagemapPage is one decoded pagemap entry
+type RawPagemapPage struct {
+	Addr       string  `json:"addr"`
+	Entry      string  `json:"entry"` // the raw 64 bits, in hex
+	Present    bool    `json:"present"`
+	Swapped    bool    `json:"swapped"`
+	FileOrShm  bool    `json:"file_or_shm"`
+	Exclusive  bool    `json:"exclusive"`
+	UffdWP     bool    `json:"uffd_wp"`
+	SoftDirty  bool    `json:"soft_dirty"`
+	PFN        *uint64 `json:"pfn,omitempty"`         // present pages
+	SwapType   *uint64 `json:"swap_type,omitempty"`   // swapped pages
+	SwapOffset *uint64 `json:"swap_offset,omitempty"` // swapped pages
+}
+
+// decodeRawPagemapEntry decodes the entry of the page at addr
+func decodeRawPagemapEntry(addr, entry uint64) RawPagemapPage {
+	page := RawPagemapPage{
+		Addr:      fmt.Sprintf("0x%x", addr),
+		Entry:     fmt.Sprintf("0x%016x", entry),
+		Present:   entry&PagePresent != 0,
+		Swapped:   entry&PageSwapped != 0,
+		FileOrShm: entry&PageFile != 0,
+		Exclusive: entry&PageExclusive != 0,
+		UffdWP:    entry&PageUffdWP != 0,
+		SoftDirty: entry&SoftDirty != 0,
+	}
+	switch {
+	case page.Present:
+		pfn := entry & pfnMask
+		page.PFN = &pfn
+	case page.Swapped:
+		swapType := entry & (1<<swapTypeBits - 1)
+		swapOffset := (entry & pfnMask) >> swapTypeBits
+		page.SwapType, page.SwapOffset = &swapType, &swapOffset
+	}
+	return page
+}
+
+// decodeRawPagemap reads a -raw-pagemap snapshot
+func decodeRawPagemap(r io.Reader) (*RawPagemapSnapshot, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(rawPagemapMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != rawPagemapMagic {
+		return nil, errors.New("not a raw pagemap snapshot (bad magic)")
+	}
+	var tmp [8]byte
+	get := func(what string) (uint64, error) {
+		if _, err := io.ReadFull(br, tmp[:]); err != nil {
+			return 0, fmt.Errorf("reading %s: %w", what, err)
+		}
+		return binary.LittleEndian.Uint64(tmp[:]), nil
+	}
+
+	var header [4]uint64
+	for i, what := range []string{"page size", "timestamp", "sample", "process count"} {
+		v, err := get(what)
+		if err != nil {
+			return nil, err
+		}
+		header[i] = v
+	}
+	if header[0] == 0 {
+		return nil, errors.New("raw pagemap snapshot has page size 0")
+	}
+	snap := &RawPagemapSnapshot{
+		PageSize:    header[0],
+		TimestampMs: float64(header[1]) / 1000,
+		Sample:      int(header[2]),
+		Processes:   []RawPagemapProcess{},
+	}
+	for p := uint64(0); p < header[3]; p++ {
+		pid, err := get("pid")
+		if err != nil {
+			return nil, err
+		}
+		count, err := get("vma count")
+		if err != nil {
+			return nil, err
+		}
+		proc := RawPagemapProcess{Pid: int(pid), VMAs: []RawPagemapVMA{}}
+		var bounds [][2]uint64
+		for v := uint64(0); v < count; v++ {
+			start, err := get("vma start")
+			if err != nil {
+				return nil, err
+			}
+			end, err := get("vma end")
+			if err != nil {
+				return nil, err
+			}
+			if end < start {
+				return nil, fmt.Errorf("vma 0x%x-0x%x ends before it starts", start, end)
+			}
+			if _, err := io.ReadFull(br, tmp[:8]); err != nil {
+				return nil, fmt.Errorf("reading vma perms: %w", err)
+			}
+			perms := string(tmp[:4])
+			pathname := make([]byte, binary.LittleEndian.Uint32(tmp[4:8]))
+			if _, err := io.ReadFull(br, pathname); err != nil {
+				return nil, fmt.Errorf("reading vma path: %w", err)
+			}
+			bounds = append(bounds, [2]uint64{start, end})
+			proc.VMAs = append(proc.VMAs, RawPagemapVMA{
+				Start:    fmt.Sprintf("0x%x", start),
+				End:      fmt.Sprintf("0x%x", end),
+				Perms:    perms,
+				Pathname: string(pathname),
+				Pages:    []RawPagemapPage{},
+			})
+		}
+		for v, bound := range bounds {
+			for addr := bound[0]; addr < bound[1]; addr += snap.PageSize {
+				entry, err := get("pagemap entry")
+				if err != nil {
+					return nil, err
+				}
+				if entry != 0 {
+					proc.VMAs[v].Pages = append(proc.VMAs[v].Pages, decodeRawPagemapEntry(addr, entry))
+				}
+			}
+		}
+		snap.Processes = append(snap.Processes, proc)
+	}
+	return snap, nil
+}
+
+// decodeRawPagemapFile decodes a -raw-pagemap file
+func decodeRawPagemapFile(path string) (*RawPagemapSnapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return decodeRawPagemap(f)
+}
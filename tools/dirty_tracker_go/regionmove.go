@@ -0,0 +1,149 @@
+// Region moves (-track-moves, -remap-moves)
+//
+// mremap(MREMAP_MAYMOVE), which realloc uses for large blocks, moves a
+// mapping and its pages to another address. Pagemap then reports the
+// pages at the new address, so once they are dirtied a single realloc
+// looks like a whole new dirty set and inflates the unique page count.
+//
+// With -track-moves each process's maps are compared with its previous
+// read: a writable VMA that disappeared and one that appeared with the
+// same permissions and backing (device, inode, pathname, and file offset)
+// is taken as a move and recorded in region_moves. The size may differ,
+// as when realloc grows a block by moving it; from_size_bytes then has the
+// size before. maps shows no identity for anonymous memory (its offset is
+// always 0), so among several candidates the one of the same size, else
+// the closest in size, is taken, and an anonymous region unmapped and
+// another mapped in one interval is reported as a move too. A region that
+// keeps its start but grows or shrinks in place hasn't moved, and keeps
+// the origin of an earlier move.
+//
+// -remap-moves (implies -track-moves) also counts a moved region's pages
+// in the unique set under the address they had before the first move, and
+// lists that address as stable_addr on its dirty pages.
+package main
+
+import "fmt"
+
+// RegionMove is a writable VMA that moved between two reads of its
+// process's maps; TimestampMs is that of the sample that saw it
+type RegionMove struct {
+	TimestampMs float64 `json:"timestamp_ms"`
+	Pid         int     `json:"pid"`
+	From        string  `json:"from"`
+	To          string  `json:"to"`
+	SizeBytes   uint64  `json:"size_bytes"`
+	FromSize    uint64  `json:"from_size_bytes,omitempty"` // if resized by the move
+	Pathname    string  `json:"pathname,omitempty"`
+	sampleIndex int
+}
+
+// moveKey is what a region keeps when it moves, resized or not
+type moveKey struct {
+	perms    string
+	device   string
+	inode    uint64
+	offset   uint64
+	pathname string
+}
+
+func regionMoveKey(v *VMAInfo) moveKey {
+	return moveKey{v.Perms, v.Device, v.Inode, v.Offset, v.Pathname}
+}
+
+// closestSize returns the index of the candidate nearest in size to vma,
+// the first of equal ones
+func closestSize(candidates []VMAInfo, vma *VMAInfo) int {
+	size := vma.End - vma.Start
+	best, bestDiff := 0, ^uint64(0)
+	for i := range candidates {
+		c := candidates[i].End - candidates[i].Start
+		diff := max(c, size) - min(c, size)
+		if diff < bestDiff {
+			best, bestDiff = i, diff
+		}
+	}
+	return best
+}
+
+// noteMoves compares vmas with the previous read's and records the moves
+// in pt.moves, updating pt.movedFrom
+func (pt *ProcessTracker) noteMoves(vmas []VMAInfo) {
+	prev := pt.prevVMAs
+	pt.prevVMAs = make([]VMAInfo, 0, len(vmas))
+	current := make(map[[2]uint64]struct{}, len(vmas))
+	for _, vma := range vmas {
+		if vma.IsWritable() {
+			pt.prevVMAs = append(pt.prevVMAs, vma)
+			current[[2]uint64{vma.Start, vma.End}] = struct{}{}
+		}
+	}
+	if prev == nil {
+		return
+	}
+
+	// Regions resized in place keep their start
+	currentStarts := make(map[uint64]struct{}, len(pt.prevVMAs))
+	for _, vma := range pt.prevVMAs {
+		currentStarts[vma.Start] = struct{}{}
+	}
+	gone := make(map[moveKey][]VMAInfo)
+	previous := make(map[uint64]struct{}, len(prev))
+	for _, vma := range prev {
+		previous[vma.Start] = struct{}{}
+		_, same := current[[2]uint64{vma.Start, vma.End}]
+		_, resized := currentStarts[vma.Start]
+		if !same && !resized {
+			key := regionMoveKey(&vma)
+			gone[key] = append(gone[key], vma)
+		}
+	}
+	origins := make(map[uint64]uint64)
+	for _, vma := range pt.prevVMAs {
+		if _, ok := previous[vma.Start]; ok {
+			if origin, moved := pt.movedFrom[vma.Start]; moved {
+				origins[vma.Start] = origin
+			}
+			continue
+		}
+		key := regionMoveKey(&vma)
+		candidates := gone[key]
+		if len(candidates) == 0 {
+			continue
+		}
+		i := closestSize(candidates, &vma)
+		from := candidates[i]
+		gone[key] = append(candidates[:i:i], candidates[i+1:]...)
+
+		origin, moved := pt.movedFrom[from.Start]
+		if !moved {
+			origin = from.Start
+		}
+		if origin != vma.Start {
+			origins[vma.Start] = origin
+		}
+		pathname := vma.Pathname
+		if pt.opts.Anonymizer != nil {
+			pathname = pt.opts.Anonymizer.path(pathname)
+		}
+		move := RegionMove{
+			Pid:       pt.pid,
+			From:      fmt.Sprintf("0x%x", from.Start),
+			To:        fmt.Sprintf("0x%x", vma.Start),
+			SizeBytes: vma.End - vma.Start,
+			Pathname:  pathname,
+		}
+		if size := from.End - from.Start; size != move.SizeBytes {
+			move.FromSize = size
+		}
+		pt.moves = append(pt.moves, move)
+	}
+	// Regions no longer mapped are forgotten
+	pt.movedFrom = origins
+}
+
+// takeMoves returns and clears the moves recorded since the last call
+func (pt *ProcessTracker) takeMoves() []RegionMove {
+	moves := pt.moves
+	pt.moves = nil
+	return moves
+}
@@ -0,0 +1,167 @@
+// Repeated captures (-repeat N)
+//
+// The tracker re-executes itself N times with the same flags, one capture
+// after another, and reports the mean and spread of the headline metrics
+// alongside the individual runs. With -exec every repetition launches the
+// workload anew (see exec.go); with -pid they track the same running
+// process, and the variance is that of consecutive windows of it. Per-run
+// side outputs (-stream, -live-csv, sinks) are written by each repetition
+// in turn and end up holding the last one.
+//
+// SIGINT/SIGTERM stops the current repetition as usual and skips the rest;
+// the report covers the runs that completed.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// MetricStats summarizes one metric over the repetitions. Stddev is the
+// sample standard deviation (0 with a single run).
+type MetricStats struct {
+	Mean   float64 `json:"mean"`
+	Stddev float64 `json:"stddev"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+}
+
+// RepeatedRuns is the -repeat output document
+type RepeatedRuns struct {
+	Repetitions int                    `json:"repetitions"` // requested
+	Completed   int                    `json:"completed"`
+	Aggregate   map[string]MetricStats `json:"aggregate"` // keyed by summary field name
+	Runs        []DirtyPattern         `json:"runs"`
+}
+
+// repeatMetrics are the aggregated summary fields
+var repeatMetrics = []struct {
+	name  string
+	value func(*Summary) float64
+}{
+	{"total_unique_pages", func(s *Summary) float64 { return float64(s.TotalUniquePages) }},
+	{"total_dirty_events", func(s *Summary) float64 { return float64(s.TotalDirtyEvents) }},
+	{"avg_dirty_rate_per_sec", func(s *Summary) float64 { return s.AvgDirtyRatePerSec }},
+	{"peak_dirty_rate", func(s *Summary) float64 { return s.PeakDirtyRate }},
+	{"locality_score", func(s *Summary) float64 { return s.LocalityScore }},
+	{"sample_count", func(s *Summary) float64 { return float64(s.SampleCount) }},
+}
+
+func metricStats(values []float64) MetricStats {
+	stats := MetricStats{Min: math.Inf(1), Max: math.Inf(-1)}
+	for _, v := range values {
+		stats.Mean += v
+		stats.Min = min(stats.Min, v)
+		stats.Max = max(stats.Max, v)
+	}
+	stats.Mean /= float64(len(values))
+	if len(values) > 1 {
+		var ss float64
+		for _, v := range values {
+			ss += (v - stats.Mean) * (v - stats.Mean)
+		}
+		stats.Stddev = math.Sqrt(ss / float64(len(values)-1))
+	}
+	return stats
+}
+
+// aggregateRuns fills in the aggregate statistics of completed runs
+func aggregateRuns(requested int, runs []DirtyPattern) RepeatedRuns {
+	report := RepeatedRuns{
+		Repetitions: requested,
+		Completed:   len(runs),
+		Aggregate:   make(map[string]MetricStats),
+		Runs:        runs,
+	}
+	if len(runs) == 0 {
+		return report
+	}
+	for _, metric := range repeatMetrics {
+		values := make([]float64, len(runs))
+		for i := range runs {
+			values[i] = metric.value(&runs[i].Summary)
+		}
+		report.Aggregate[metric.name] = metricStats(values)
+	}
+	return report
+}
+
+// runRepeated runs n captures with the command line's flags and returns
+// the report. Each child writes plain JSON to a temporary file, which later
+// flags on its command line make take precedence over -output and -format.
+func runRepeated(n int) (RepeatedRuns, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return RepeatedRuns{}, err
+	}
+
+	var mu sync.Mutex
+	var current *exec.Cmd
+	stopped := false
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	go func() {
+		for sig := range sigCh {
+			mu.Lock()
+			stopped = true
+			// Ctrl+C reaches the child through the process group, but a
+			// signal sent to us alone has to be passed on
+			if current != nil {
+				current.Process.Signal(sig)
+			}
+			mu.Unlock()
+		}
+	}()
+
+	var runs []DirtyPattern
+	for i := 0; i < n; i++ {
+		mu.Lock()
+		if stopped {
+			mu.Unlock()
+			break
+		}
+		tmp, err := os.CreateTemp("", "dirty_tracker_repeat_*.json")
+		if err != nil {
+			mu.Unlock()
+			return aggregateRuns(n, runs), err
+		}
+		tmp.Close()
+		args := reexecArgs("-repeat=1", "-format=json", "-zstd=false", "-output="+tmp.Name())
+		cmd := exec.Command(exe, args...)
+		cmd.Stderr = os.Stderr
+		fmt.Fprintf(os.Stderr, "Repetition %d/%d\n", i+1, n)
+		err = cmd.Start()
+		if err == nil {
+			current = cmd
+		}
+		mu.Unlock()
+
+		if err == nil {
+			err = cmd.Wait()
+		}
+		mu.Lock()
+		current = nil
+		mu.Unlock()
+
+		var pattern DirtyPattern
+		if err == nil {
+			var data []byte
+			if data, err = os.ReadFile(tmp.Name()); err == nil {
+				err = json.Unmarshal(data, &pattern)
+			}
+		}
+		os.Remove(tmp.Name())
+		if err != nil {
+			return aggregateRuns(n, runs), fmt.Errorf("repetition %d: %w", i+1, err)
+		}
+		runs = append(runs, pattern)
+	}
+	return aggregateRuns(n, runs), nil
+}
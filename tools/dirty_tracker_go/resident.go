@@ -0,0 +1,21 @@
+// Resident-only accounting (-resident-only)
+//
+// A soft-dirty page that has been swapped out since it was written is not
+// copied from RAM when migrating: its content is in swap, and CRIU reads
+// it from there (or the destination faults it in later). For sizing a
+// RAM-to-RAM transfer, -resident-only leaves such pages out of the listed
+// pages, counts, and rates, so total_dirty_size_bytes is what would
+// actually be copied out of memory. The swapped pages are still counted:
+// each sample's swapped_dirty_count and the summary's swapped_dirty_events
+// hold them, and all_dirty_size_bytes is the figure with them included.
+// Needs the present bit, so not with -read-strategy scan.
+package main
+
+// swappedDirtyEvents sums the samples' swapped_dirty_count
+func swappedDirtyEvents(samples []DirtySample) int {
+	total := 0
+	for i := range samples {
+		total += samples[i].SwappedDirtyCount
+	}
+	return total
+}
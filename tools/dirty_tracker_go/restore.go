@@ -0,0 +1,129 @@
+// Following a process across checkpoint/restore (-follow-restore)
+//
+// For testing CRIU itself: track a workload, checkpoint it with criu dump,
+// restore it with criu restore, and keep tracking the restored process as
+// the same logical run. The restored root is found through
+//
+//	pidfile:PATH   a file holding the PID, e.g. criu restore --pidfile PATH
+//	cmd:COMMAND    a shell command printing the PID on stdout
+//
+// Once the root process is gone (or its PID now belongs to a different
+// process, since CRIU normally restores the original PID), the source is
+// polled every interval until it names a live process that is not the old
+// one. The tracker then drops the old tree, attaches to the new root and
+// its descendants, and records a RestoreEvent. Samples taken meanwhile
+// see no processes.
+//
+// Restoring rewrites every page, so soft-dirty bits say nothing about the
+// workload right after a restore. The new root is cleared on attach and
+// its first sample, marked "restored", covers one interval from that
+// clear; like a resumed sample it has no rate and doesn't move the EMA.
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// RestoreEvent marks where tracking moved to a restored process
+type RestoreEvent struct {
+	TimestampMs float64 `json:"timestamp_ms"`
+	OldPid      int     `json:"old_pid"`
+	NewPid      int     `json:"new_pid"`
+}
+
+// restoreFollower locates the restored root process
+type restoreFollower struct {
+	pidfile   string
+	command   string
+	rootStart uint64 // start time of the current root, to spot PID reuse
+}
+
+// parseFollowRestore parses a -follow-restore source
+func parseFollowRestore(spec string) (*restoreFollower, error) {
+	kind, arg, ok := strings.Cut(spec, ":")
+	if !ok || arg == "" {
+		return nil, fmt.Errorf("-follow-restore %q is not pidfile:PATH or cmd:COMMAND", spec)
+	}
+	switch kind {
+	case "pidfile":
+		return &restoreFollower{pidfile: arg}, nil
+	case "cmd":
+		return &restoreFollower{command: arg}, nil
+	}
+	return nil, fmt.Errorf("-follow-restore: unknown source %q (use pidfile or cmd)", kind)
+}
+
+// poll returns the PID currently named by the source
+func (f *restoreFollower) poll() (int, bool) {
+	var out []byte
+	var err error
+	if f.pidfile != "" {
+		out, err = os.ReadFile(f.pidfile)
+	} else {
+		out, err = exec.Command("sh", "-c", f.command).Output()
+	}
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	return pid, err == nil && pid > 0
+}
+
+// liveStart returns a process's start time if it exists and is not a zombie
+func liveStart(pid int) (uint64, bool) {
+	stat, err := readProcStat(pid)
+	if err != nil || stat.State == 'Z' || stat.State == 'X' {
+		return 0, false
+	}
+	return stat.StartTime, true
+}
+
+// restored returns the restored root, with its start time, once the
+// current root rootPid is gone. Polling may run the source command, so it
+// is called without dt.mu; only the sampling loop changes the root.
+func (f *restoreFollower) restored(rootPid int) (int, uint64, bool) {
+	if start, ok := liveStart(rootPid); ok && start == f.rootStart {
+		return 0, 0, false
+	}
+	newPid, ok := f.poll()
+	if !ok {
+		return 0, 0, false
+	}
+	newStart, ok := liveStart(newPid)
+	if !ok || (newPid == rootPid && newStart == f.rootStart) {
+		return 0, 0, false
+	}
+	return newPid, newStart, true
+}
+
+// attachRestored moves tracking to the restored root found by restored.
+// It reports whether it did. Must hold dt.mu.
+func (dt *DirtyPageTracker) attachRestored(newPid int, newStart uint64, elapsedMs float64) bool {
+	f := dt.follow
+	oldPid := dt.rootPid
+	for pid, tracker := range dt.trackers {
+		tracker.Close()
+		delete(dt.trackers, pid)
+		dt.deadPids[pid] = struct{}{}
+	}
+	// The PID may be the old one again; forget what was known about it
+	delete(dt.deadPids, newPid)
+	delete(dt.knownPids, newPid)
+	delete(dt.cpuSeen, newPid)
+	delete(dt.ioSeen, newPid)
+
+	dt.rootPid = newPid
+	if !dt.addProcessTracker(newPid) {
+		delete(dt.deadPids, newPid)
+		return false
+	}
+	f.rootStart = newStart
+	dt.recordTarget(newPid)
+	dt.restoreEvents = append(dt.restoreEvents, RestoreEvent{TimestampMs: elapsedMs, OldPid: oldPid, NewPid: newPid})
+	fmt.Fprintf(os.Stderr, "Re-attached to restored process %d (was %d)\n", newPid, oldPid)
+	return true
+}
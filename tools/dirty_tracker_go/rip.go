@@ -0,0 +1,156 @@
+// Instruction pointers at dirty spikes (-sample-rip)
+//
+// To tie heavy dirtying to code, -sample-rip N records where the threads
+// of the processes that dirtied pages are executing whenever a sample has
+// at least N dirty pages. Each entry has the thread's instruction pointer
+// and, when it falls in a file mapping, the file and offset to symbolize,
+// e.g. addr2line -f -e <pathname> <file_offset>.
+//
+// Without -rip-ptrace the pointer is read from /proc, which is free but
+// weak: /proc/[pid]/task/[tid]/syscall only has it while the thread is
+// blocked in a system call (so it points at the call site, not at the
+// stores), and modern kernels leave the stat kstkeip field zero. A thread
+// that is running is then reported as a failure.
+//
+// -rip-ptrace reads the registers with ptrace instead, which works for
+// running threads but stops the world: each thread is seized, interrupted,
+// read, and released in turn, a pause of some tens of microseconds per
+// thread on every spike sample, and it needs ptrace attach permission
+// (kernel.yama.ptrace_scope, or root), more than pagemap reads need. It
+// also fails while another tracer, such as a debugger or CRIU, is attached.
+// Signals that arrive during the stop are passed on when releasing.
+//
+// At most ripMaxThreads threads are read per process.
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"syscall"
+)
+
+const (
+	ripMaxThreads = 64
+
+	ptraceSeize     = 0x4206
+	ptraceInterrupt = 0x4207
+	ptraceEventStop = 128
+)
+
+// RIPSample is one thread's instruction pointer at a spike sample
+type RIPSample struct {
+	Pid        int    `json:"pid"`
+	Tid        int    `json:"tid"`
+	RIP        string `json:"rip"`
+	Source     string `json:"source"` // "ptrace", "syscall", or "stat"
+	Pathname   string `json:"pathname,omitempty"`
+	FileOffset string `json:"file_offset,omitempty"` // within Pathname, for file mappings
+}
+
+// ptrace issues a raw ptrace request; the syscall package lacks
+// PTRACE_SEIZE and a detach that passes a signal on
+func ptrace(request, tid int, addr, data uintptr) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, uintptr(request), uintptr(tid), addr, data, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// ptraceInstructionPointer stops one thread, reads its program counter,
+// and lets it go. Every ptrace request must come from the tracing thread,
+// so the goroutine stays on its OS thread throughout.
+func ptraceInstructionPointer(tid int) (uint64, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := ptrace(ptraceSeize, tid, 0, 0); err != nil {
+		return 0, fmt.Errorf("seize thread %d: %w", tid, err)
+	}
+	if err := ptrace(ptraceInterrupt, tid, 0, 0); err != nil {
+		ptrace(syscall.PTRACE_DETACH, tid, 0, 0)
+		return 0, fmt.Errorf("interrupt thread %d: %w", tid, err)
+	}
+	var status syscall.WaitStatus
+	if _, err := syscall.Wait4(tid, &status, syscall.WALL, nil); err != nil {
+		ptrace(syscall.PTRACE_DETACH, tid, 0, 0)
+		return 0, fmt.Errorf("wait for thread %d: %w", tid, err)
+	}
+	if !status.Stopped() {
+		return 0, fmt.Errorf("thread %d exited", tid)
+	}
+	// A signal that stopped the thread before the interrupt did is owed
+	// to it on release
+	var pending uintptr
+	if status.StopSignal() != syscall.SIGTRAP || status.TrapCause() != ptraceEventStop {
+		pending = uintptr(status.StopSignal())
+	}
+
+	var regs syscall.PtraceRegs
+	err := syscall.PtraceGetRegs(tid, &regs)
+	ptrace(syscall.PTRACE_DETACH, tid, 0, pending)
+	if err != nil {
+		return 0, fmt.Errorf("read registers of thread %d: %w", tid, err)
+	}
+	return regs.PC(), nil
+}
+
+// sampleRIPs records the instruction pointers of the threads of the
+// processes that dirtied pages this sample
+func (dt *DirtyPageTracker) sampleRIPs(perPid map[int]int) []RIPSample {
+	var pids []int
+	for pid, count := range perPid {
+		if count > 0 {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+
+	var rips []RIPSample
+	for _, pid := range pids {
+		tracker := dt.trackers[pid]
+		entries, err := os.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+		if err != nil || tracker == nil {
+			dt.ripFailures++
+			continue
+		}
+		vmas, _ := tracker.ParseMaps()
+		for i, entry := range entries {
+			tid, err := strconv.Atoi(entry.Name())
+			if err != nil || i >= ripMaxThreads {
+				break
+			}
+			var ip uint64
+			source := "ptrace"
+			if dt.ripPtrace {
+				ip, err = ptraceInstructionPointer(tid)
+			} else {
+				ip, source, err = threadInstructionPointer(pid, tid)
+			}
+			if err != nil {
+				dt.ripFailures++
+				continue
+			}
+
+			rip := RIPSample{Pid: pid, Tid: tid, RIP: fmt.Sprintf("0x%x", ip), Source: source}
+			for _, vma := range vmas {
+				if ip < vma.Start || ip >= vma.End || vma.Pathname == "" {
+					continue
+				}
+				rip.Pathname = vma.Pathname
+				if tracker.opts.Anonymizer != nil {
+					rip.Pathname = tracker.opts.Anonymizer.path(rip.Pathname)
+				}
+				if vma.Inode != 0 {
+					rip.FileOffset = fmt.Sprintf("0x%x", ip-vma.Start+vma.Offset)
+				}
+				break
+			}
+			rips = append(rips, rip)
+		}
+	}
+	return rips
+}
@@ -0,0 +1,99 @@
+// Resident set growth (-rss-growth)
+//
+// Allocation-heavy workloads both grow and dirty, and the two cost a
+// migration differently: newly touched memory is dirtied once, while
+// re-dirtied memory keeps coming back. With -rss-growth each sample
+// records the summed resident set size of the tracked processes (from
+// /proc/[pid]/statm, which any user can read), and the output gets an
+// rss_growth_timeline of the change per sample next to that sample's dirty
+// page count.
+//
+// The summary's allocation_share is the growth in pages over the dirty
+// events: near 1, dirtying is mostly memory being allocated and touched
+// for the first time; near 0, it is existing memory being re-dirtied.
+// Only growth counts, and it is capped at the sample's dirty count, since
+// pages read in from files or swap grow the RSS without being dirtied.
+// Processes that join or leave the tracked set change the sum too.
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// RSSGrowthEntry is the change in resident set size over one sample
+type RSSGrowthEntry struct {
+	TimestampMs       float64 `json:"timestamp_ms"`
+	RSSBytes          uint64  `json:"rss_bytes"`
+	DeltaBytes        int64   `json:"delta_bytes"`
+	GrowthBytesPerSec float64 `json:"growth_bytes_per_sec"`
+	DirtyPages        int     `json:"dirty_pages"`
+}
+
+// readRSSPages returns the resident pages of a process, the second field
+// of /proc/[pid]/statm
+func readRSSPages(pid int) (uint64, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/statm", pid))
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("/proc/%d/statm: too few fields", pid)
+	}
+	return strconv.ParseUint(fields[1], 10, 64)
+}
+
+// sampleRSS sums the resident set of pids; processes that can't be read
+// (they just exited) count as nothing
+func sampleRSS(pids []int) uint64 {
+	var pages uint64
+	for _, pid := range pids {
+		if n, err := readRSSPages(pid); err == nil {
+			pages += n
+		}
+	}
+	return pages * PageSize
+}
+
+// rssGrowthTimeline derives the per-sample RSS change. The first sample
+// has no predecessor and so no change.
+func rssGrowthTimeline(samples []DirtySample) []RSSGrowthEntry {
+	timeline := make([]RSSGrowthEntry, len(samples))
+	for i := range samples {
+		sample := &samples[i]
+		entry := RSSGrowthEntry{
+			TimestampMs: sample.TimestampMs,
+			RSSBytes:    sample.RSSBytes,
+			DirtyPages:  sample.DeltaDirtyCount,
+		}
+		if i > 0 {
+			prev := &samples[i-1]
+			entry.DeltaBytes = int64(sample.RSSBytes) - int64(prev.RSSBytes)
+			if elapsed := sample.TimestampMs - prev.TimestampMs; elapsed > 0 {
+				entry.GrowthBytesPerSec = float64(entry.DeltaBytes) * 1000.0 / elapsed
+			}
+		}
+		timeline[i] = entry
+	}
+	return timeline
+}
+
+// allocationShare is the RSS growth in pages, capped per sample at the
+// sample's dirty count, over all dirty events
+func allocationShare(timeline []RSSGrowthEntry) *float64 {
+	grown, dirtied := 0, 0
+	for _, entry := range timeline {
+		dirtied += entry.DirtyPages
+		if entry.DeltaBytes > 0 {
+			grown += min(int(uint64(entry.DeltaBytes)/PageSize), entry.DirtyPages)
+		}
+	}
+	if dirtied == 0 {
+		return nil
+	}
+	share := float64(grown) / float64(dirtied)
+	return &share
+}
@@ -0,0 +1,125 @@
+// Sampling schedules (-schedule)
+//
+// A schedule runs the capture at different resolutions in turn, e.g. a
+// coarse phase for the long-term trend followed by a fine one for the
+// convergence detail, without paying for fine sampling throughout:
+//
+//	-schedule "1s x60, 100ms x100"
+//
+// Steps are INTERVAL xCOUNT, separated by commas; INTERVAL is a Go
+// duration of a whole number of milliseconds, as -interval is, and COUNT
+// the number of samples taken at it (intervals, under -accumulate).
+// The wait before a sample is that of the sample's own step, and the
+// first sample of the run is taken at once as usual. The last step may
+// leave out xCOUNT to repeat until the run ends; otherwise the run stops
+// with stop_reason "schedule" after the last step. -duration still bounds
+// the run, but when it isn't given and the schedule is bounded, the
+// schedule alone decides the length.
+//
+// Each sample records its step's interval_ms, which intervals_met_pct and
+// -deterministic timestamps follow; the summary's interval_ms is the first
+// step's. The schedule is recorded in metadata.
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type scheduleStep struct {
+	interval time.Duration
+	count    int // 0 repeats until the run ends
+}
+
+// sampleSchedule steps through a parsed schedule as samples are taken
+type sampleSchedule struct {
+	steps []scheduleStep
+	step  int
+	taken int // samples taken in the current step
+}
+
+// parseSchedule parses a -schedule string
+func parseSchedule(s string) (*sampleSchedule, error) {
+	var steps []scheduleStep
+	for _, part := range strings.Split(s, ",") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, fmt.Errorf("step %q is not INTERVAL xCOUNT", strings.TrimSpace(part))
+		}
+		interval, err := time.ParseDuration(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("step %q: %v", strings.TrimSpace(part), err)
+		}
+		if interval < time.Millisecond || interval%time.Millisecond != 0 {
+			return nil, fmt.Errorf("step %q: interval must be a whole number of milliseconds", strings.TrimSpace(part))
+		}
+		step := scheduleStep{interval: interval}
+		if len(fields) == 2 {
+			count, err := strconv.Atoi(strings.TrimPrefix(fields[1], "x"))
+			if err != nil || !strings.HasPrefix(fields[1], "x") || count < 1 {
+				return nil, fmt.Errorf("step %q: count must be x followed by a positive number", strings.TrimSpace(part))
+			}
+			step.count = count
+		}
+		steps = append(steps, step)
+	}
+	for _, step := range steps[:len(steps)-1] {
+		if step.count == 0 {
+			return nil, fmt.Errorf("only the last step may leave out its count")
+		}
+	}
+	return &sampleSchedule{steps: steps}, nil
+}
+
+// String renders the schedule in canonical form
+func (sc *sampleSchedule) String() string {
+	parts := make([]string, len(sc.steps))
+	for i, step := range sc.steps {
+		parts[i] = step.interval.String()
+		if step.count > 0 {
+			parts[i] += fmt.Sprintf(" x%d", step.count)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// bounded reports whether the schedule ends by itself
+func (sc *sampleSchedule) bounded() bool {
+	return sc.steps[len(sc.steps)-1].count > 0
+}
+
+// interval returns the current step's interval
+func (sc *sampleSchedule) interval() time.Duration {
+	return sc.steps[sc.step].interval
+}
+
+// longest returns the longest interval of the schedule
+func (sc *sampleSchedule) longest() time.Duration {
+	var longest time.Duration
+	for _, step := range sc.steps {
+		longest = max(longest, step.interval)
+	}
+	return longest
+}
+
+// last reports whether the next sample is the schedule's last
+func (sc *sampleSchedule) last() bool {
+	step := sc.steps[sc.step]
+	return sc.step == len(sc.steps)-1 && step.count > 0 && sc.taken == step.count-1
+}
+
+// next counts a sample taken and returns the interval before the next
+// one, or false once the schedule is done
+func (sc *sampleSchedule) next() (time.Duration, bool) {
+	sc.taken++
+	if count := sc.steps[sc.step].count; count > 0 && sc.taken >= count {
+		sc.step++
+		sc.taken = 0
+	}
+	if sc.step >= len(sc.steps) {
+		return 0, false
+	}
+	return sc.interval(), true
+}
@@ -0,0 +1,164 @@
+// Python output compatibility check (-validate-schema)
+//
+// The JSON output promises compatibility with tools/dirty_tracker.py.
+// pythonSchema lists every field that tracker emits with its JSON type.
+// Go-only fields may be added freely, since consumers of the Python
+// format ignore unknown keys, but a field that is missing, renamed, or of
+// a different type (including null where Python writes a list) breaks
+// them and is reported. testdata/schema.golden.json is a capture in the
+// Python format; the tests hold pythonSchema and the output to it.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// schemaObject maps field names to JSON kinds: "string", "number", "bool",
+// "array", "object", or a nested schema for arrays/objects of objects
+type schemaObject map[string]any
+
+// schemaArrayOf describes an array whose elements match an object schema
+type schemaArrayOf struct{ elem schemaObject }
+
+var pythonDirtyPageSchema = schemaObject{
+	"addr":      "string",
+	"vma_type":  "string",
+	"vma_perms": "string",
+	"pathname":  "string",
+	"vma_start": "string",
+	"vma_end":   "string",
+	"size":      "number",
+}
+
+var pythonSampleSchema = schemaObject{
+	"timestamp_ms":      "number",
+	"dirty_pages":       schemaArrayOf{pythonDirtyPageSchema},
+	"delta_dirty_count": "number",
+	"pids_tracked":      "array",
+}
+
+var pythonSummarySchema = schemaObject{
+	"total_unique_pages":     "number",
+	"total_dirty_events":     "number",
+	"total_dirty_size_bytes": "number",
+	"avg_dirty_rate_per_sec": "number",
+	"peak_dirty_rate":        "number",
+	"vma_distribution":       "object",
+	"vma_size_distribution":  "object",
+	"sample_count":           "number",
+	"interval_ms":            "number",
+	"max_processes_tracked":  "number",
+	"total_pids_seen":        "array",
+}
+
+var pythonTimelineSchema = schemaObject{
+	"timestamp_ms":       "number",
+	"rate_pages_per_sec": "number",
+	"cumulative_pages":   "number",
+	"processes_tracked":  "number",
+}
+
+var pythonSchema = schemaObject{
+	"workload":             "string",
+	"root_pid":             "number",
+	"track_children":       "bool",
+	"tracking_duration_ms": "number",
+	"page_size":            "number",
+	"pagemap_scan_used":    "bool",
+	"clear_on_scan":        "bool",
+	"samples":              schemaArrayOf{pythonSampleSchema},
+	"summary":              pythonSummarySchema,
+	"dirty_rate_timeline":  schemaArrayOf{pythonTimelineSchema},
+}
+
+// validateSchema checks flat JSON output against pythonSchema and returns
+// one message per mismatch
+func validateSchema(data []byte) []string {
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return []string{fmt.Sprintf("not a JSON object: %v", err)}
+	}
+
+	schema := pythonSchema
+	// With no samples the Python tracker writes "summary": {}
+	if samples, ok := doc["samples"].([]any); ok && len(samples) == 0 {
+		schema = make(schemaObject, len(pythonSchema))
+		for k, v := range pythonSchema {
+			schema[k] = v
+		}
+		schema["summary"] = "object"
+	}
+
+	var errs []string
+	checkSchemaObject("", doc, schema, &errs)
+	return errs
+}
+
+func checkSchemaObject(path string, obj map[string]any, schema schemaObject, errs *[]string) {
+	names := make([]string, 0, len(schema))
+	for name := range schema {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		want := schema[name]
+		field := path + "." + name
+		value, ok := obj[name]
+		if !ok {
+			*errs = append(*errs, fmt.Sprintf("%s: missing", field))
+			continue
+		}
+		checkSchemaValue(field, value, want, errs)
+	}
+}
+
+func checkSchemaValue(path string, value, want any, errs *[]string) {
+	switch want := want.(type) {
+	case schemaObject:
+		obj, ok := value.(map[string]any)
+		if !ok {
+			*errs = append(*errs, fmt.Sprintf("%s: want object, got %s", path, jsonKind(value)))
+			return
+		}
+		checkSchemaObject(path, obj, want, errs)
+	case schemaArrayOf:
+		arr, ok := value.([]any)
+		if !ok {
+			*errs = append(*errs, fmt.Sprintf("%s: want array, got %s", path, jsonKind(value)))
+			return
+		}
+		for i, elem := range arr {
+			checkSchemaValue(fmt.Sprintf("%s[%d]", path, i), elem, want.elem, errs)
+			// One bad element usually means all are; don't flood the report
+			if len(*errs) > 50 {
+				return
+			}
+		}
+	case string:
+		if got := jsonKind(value); got != want {
+			*errs = append(*errs, fmt.Sprintf("%s: want %s, got %s", path, want, got))
+		}
+	}
+}
+
+// jsonKind names the JSON type of a value decoded into an any
+func jsonKind(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "bool"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// schemaGolden is output of tools/dirty_tracker.py, the format
+// pythonSchema describes
+const schemaGolden = "testdata/schema.golden.json"
+
+// checkSchemaKeys fails for fields of obj, at path, that schema doesn't
+// list; validateSchema reports the ones it lists and obj lacks
+func checkSchemaKeys(t *testing.T, path string, obj map[string]any, schema schemaObject) {
+	t.Helper()
+	var extra []string
+	for name, value := range obj {
+		want, ok := schema[name]
+		if !ok {
+			extra = append(extra, path+"."+name)
+			continue
+		}
+		switch want := want.(type) {
+		case schemaObject:
+			if child, ok := value.(map[string]any); ok {
+				checkSchemaKeys(t, path+"."+name, child, want)
+			}
+		case schemaArrayOf:
+			elems, _ := value.([]any)
+			for _, elem := range elems {
+				if child, ok := elem.(map[string]any); ok {
+					checkSchemaKeys(t, path+"."+name+"[]", child, want.elem)
+				}
+			}
+		}
+	}
+	if len(extra) > 0 {
+		sort.Strings(extra)
+		t.Errorf("golden fields missing from pythonSchema: %s", strings.Join(extra, ", "))
+	}
+}
+
+func TestPythonSchemaMatchesGolden(t *testing.T) {
+	data, err := os.ReadFile(schemaGolden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range validateSchema(data) {
+		t.Errorf("golden: %s", msg)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	checkSchemaKeys(t, "", doc, pythonSchema)
+}
+
+func TestOutputMatchesGolden(t *testing.T) {
+	data, err := os.ReadFile(schemaGolden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var golden DirtyPattern
+	if err := json.Unmarshal(data, &golden); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		samples []DirtySample
+	}{
+		{"samples", golden.Samples},
+		{"no samples", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dt := NewDirtyPageTracker(golden.RootPid, int(golden.Summary.IntervalMs), golden.TrackChildren, golden.Workload, !golden.ClearOnScan)
+			dt.startTime = time.Now()
+			for _, sample := range tt.samples {
+				dt.samples = append(dt.samples, sample)
+				dt.totalDirtyPages += sample.DeltaDirtyCount
+				for i := range sample.DirtyPages {
+					dt.uniqueAddrs[sample.DirtyPages[i].Address()] = struct{}{}
+				}
+			}
+			pattern := dt.GetDirtyPattern()
+			out, err := renderOutput("json", &pattern, dt.startTime, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, msg := range validateSchema(out) {
+				t.Errorf("output: %s", msg)
+			}
+			if pattern.Summary.VMAUniqueDistribution == nil {
+				t.Error("vma_unique_distribution is null")
+			}
+			var streamed bytes.Buffer
+			if err := encodeJSONOutput(&streamed, &pattern, false); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(streamed.Bytes(), append(out, '\n')) {
+				t.Error("streamed output differs from the rendered output")
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+// Self-test mode: track a synthetic child with a known dirty rate
+//
+// The tracker re-executes itself with selfTestChildEnv set. The child maps
+// an anonymous buffer, reports its address on stdout, and then sweeps over
+// it writing one byte per page at a fixed rate. The parent tracks the child
+// and compares the dirty rate measured inside the buffer with the rate the
+// child was told to produce. It also checks that the output still matches
+// the Python tracker's schema.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	selfTestChildEnv = "DIRTY_TRACKER_SELFTEST_CHILD"
+
+	// selfTestTick is how often the child writes its batch of pages
+	selfTestTick = 10 * time.Millisecond
+)
+
+// SelfTestResult is printed to stdout after a -selftest run
+type SelfTestResult struct {
+	Passed           bool     `json:"passed"`
+	ExpectedRate     float64  `json:"expected_rate_pages_per_sec"`
+	MeasuredRate     float64  `json:"measured_rate_pages_per_sec"`
+	RelativeError    float64  `json:"relative_error"`
+	Tolerance        float64  `json:"tolerance"`
+	WorkingSetPages  int      `json:"working_set_pages"`
+	SampleCount      int      `json:"sample_count"`
+	IntervalMs       int      `json:"interval_ms"`
+	OtherDirtyEvents int      `json:"other_dirty_events"`
+	Kernel           string   `json:"kernel,omitempty"`
+	SchemaErrs       []string `json:"schema_errors,omitempty"`
+}
+
+// runSelfTestChild is the synthetic workload. It never returns; the parent
+// kills it when tracking is done.
+func runSelfTestChild(spec string) {
+	var rate, pages int
+	if _, err := fmt.Sscanf(spec, "%d:%d", &rate, &pages); err != nil || rate <= 0 || pages <= 0 {
+		fmt.Fprintf(os.Stderr, "selftest child: bad spec %q\n", spec)
+		os.Exit(1)
+	}
+
+	pageSize := int(PageSize)
+	buf, err := syscall.Mmap(-1, 0, pages*pageSize,
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANONYMOUS)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "selftest child: mmap: %v\n", err)
+		os.Exit(1)
+	}
+	// Fault everything in before reporting so setup isn't measured
+	for i := 0; i < pages; i++ {
+		buf[i*pageSize] = 1
+	}
+
+	// &buf[0] is stable for an mmap'd region
+	addr := uint64(uintptr(unsafe.Pointer(&buf[0])))
+	fmt.Printf("%x %d\n", addr, len(buf))
+	os.Stdout.Sync()
+
+	perTick := float64(rate) * selfTestTick.Seconds()
+	owed := 0.0
+	next := 0
+	ticker := time.NewTicker(selfTestTick)
+	for range ticker.C {
+		owed += perTick
+		for ; owed >= 1; owed-- {
+			buf[next*pageSize]++
+			next = (next + 1) % pages
+		}
+	}
+}
+
+// runSelfTest spawns the synthetic child, tracks it, and reports whether
+// the measured rate is within tolerance of the configured rate.
+func runSelfTest(rate, workingSetPages, intervalMs int, duration time.Duration, tolerance float64) bool {
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "selftest: %v\n", err)
+		return false
+	}
+
+	cmd := exec.Command(exe)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%d:%d", selfTestChildEnv, rate, workingSetPages))
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "selftest: %v\n", err)
+		return false
+	}
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "selftest: start child: %v\n", err)
+		return false
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "selftest: child did not report its buffer: %v\n", err)
+		return false
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		fmt.Fprintf(os.Stderr, "selftest: malformed child report %q\n", line)
+		return false
+	}
+	bufStart, _ := strconv.ParseUint(fields[0], 16, 64)
+	bufLen, _ := strconv.ParseUint(fields[1], 10, 64)
+	bufEnd := bufStart + bufLen
+
+	fmt.Fprintf(os.Stderr, "Self-test: child %d dirtying %d pages/sec over %d pages for %.1fs\n",
+		cmd.Process.Pid, rate, workingSetPages, duration.Seconds())
+
+	tracker := NewDirtyPageTracker(cmd.Process.Pid, intervalMs, false, "selftest", false)
+	tracker.Run(duration)
+	pattern := tracker.GetDirtyPattern()
+
+	result := SelfTestResult{
+		ExpectedRate:    float64(rate),
+		Tolerance:       tolerance,
+		WorkingSetPages: workingSetPages,
+		SampleCount:     len(pattern.Samples),
+		IntervalMs:      intervalMs,
+	}
+	if uts, err := unameRelease(); err == nil {
+		result.Kernel = uts
+	}
+
+	// The first sample only covers the gap between the initial clear and
+	// the first read, so measure over the rest
+	if len(pattern.Samples) > 1 {
+		inBuffer := 0
+		for _, sample := range pattern.Samples[1:] {
+			for i := range sample.DirtyPages {
+				addr := sample.DirtyPages[i].Address()
+				if addr >= bufStart && addr < bufEnd {
+					inBuffer++
+				} else {
+					result.OtherDirtyEvents++
+				}
+			}
+		}
+		elapsedSec := (pattern.Samples[len(pattern.Samples)-1].TimestampMs - pattern.Samples[0].TimestampMs) / 1000.0
+		if elapsedSec > 0 {
+			result.MeasuredRate = float64(inBuffer) / elapsedSec
+		}
+	}
+	result.RelativeError = math.Abs(result.MeasuredRate-result.ExpectedRate) / result.ExpectedRate
+	if data, err := json.Marshal(pattern); err == nil {
+		result.SchemaErrs = validateSchema(data)
+	}
+	result.Passed = result.RelativeError <= tolerance && len(result.SchemaErrs) == 0
+	for _, msg := range result.SchemaErrs {
+		fmt.Fprintf(os.Stderr, "Schema drift: %s\n", msg)
+	}
+
+	status := "PASS"
+	if !result.Passed {
+		status = "FAIL"
+	}
+	fmt.Fprintf(os.Stderr, "Self-test %s: expected %.1f pages/sec, measured %.1f pages/sec (error %.1f%%, tolerance %.1f%%)\n",
+		status, result.ExpectedRate, result.MeasuredRate, result.RelativeError*100, tolerance*100)
+	if result.MeasuredRate == 0 {
+		fmt.Fprintln(os.Stderr, "No dirty pages observed; is CONFIG_MEM_SOFT_DIRTY enabled and are we running as root?")
+	}
+
+	jsonData, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(jsonData))
+	return result.Passed
+}
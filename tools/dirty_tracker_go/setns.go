@@ -0,0 +1,105 @@
+// PID namespace entry (-setns)
+//
+// The children files descendant discovery reads list PIDs as seen by the
+// PID namespace of the procfs they are read through, the host's for a
+// tracker on the host. That works for a containerized tree, but the PIDs
+// recorded are host PIDs, and a tracker in a sibling namespace can't
+// resolve the target's children at all. With -setns the tracker
+// re-executes itself inside the target's PID namespace, in a mount
+// namespace of its own where it mounts that namespace's procfs over
+// /proc, so every PID it reads, follows, and records (root_pid included)
+// is the in-namespace one. -pid (or -container) still names the target as
+// the invoking tracker sees it; it is translated via NSpid.
+//
+// setns(CLONE_NEWPID) only moves children later forked by the calling
+// thread, so a locked thread enters the namespace and starts the child,
+// which unshares the mount namespace before exec, while it still has a
+// single thread. Needs CAP_SYS_ADMIN over the target namespace's user
+// namespace, i.e. root for a container, for both setns and the mount. The
+// child is a member of the target's namespace, visible to the processes
+// there, and is killed if the namespace's init exits. The parent waits
+// for it, passes on SIGINT/SIGTERM, and exits with its status. Only /proc
+// is replaced, so paths given on the command line resolve as usual.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"syscall"
+)
+
+const setnsChildEnv = "DIRTY_TRACKER_SETNS_CHILD"
+
+// runInPIDNamespace re-executes the tracker in pid's PID namespace,
+// tracking pid by its PID there, and returns the child's exit code
+func runInPIDNamespace(pid int) (int, error) {
+	if sysSetns == 0 {
+		return 0, errors.New("not supported on this architecture")
+	}
+	nsPid, err := readNSpid(pid)
+	if err != nil {
+		return 0, fmt.Errorf("reading NSpid of %d: %w", pid, err)
+	}
+	nsFd, err := syscall.Open(fmt.Sprintf("/proc/%d/ns/pid", pid), syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return 0, fmt.Errorf("opening PID namespace of %d: %w", pid, err)
+	}
+	defer syscall.Close(nsFd)
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+
+	// Later flags take precedence; -container was resolved to pid already
+	args := reexecArgs("-setns=false", "-container=", fmt.Sprintf("-pid=%d", nsPid))
+	cmd := exec.Command(exe, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), setnsChildEnv+"=1")
+	// Go also makes / MS_PRIVATE in the new mount namespace, so the
+	// child's /proc mount doesn't propagate back
+	cmd.SysProcAttr = &syscall.SysProcAttr{Unshareflags: syscall.CLONE_NEWNS}
+
+	// The thread that entered the namespace is never unlocked, so the
+	// runtime discards it instead of running other goroutines on it
+	started := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		if _, _, errno := syscall.RawSyscall(sysSetns, uintptr(nsFd), syscall.CLONE_NEWPID, 0); errno != 0 {
+			started <- fmt.Errorf("setns: %w", errno)
+			return
+		}
+		started <- cmd.Start()
+	}()
+	if err := <-started; err != nil {
+		return 0, err
+	}
+	fmt.Fprintf(os.Stderr, "Entered the PID namespace of %d, where it is PID %d\n", pid, nsPid)
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	go func() {
+		for sig := range sigCh {
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	var exitErr *exec.ExitError
+	if err := cmd.Wait(); errors.As(err, &exitErr) {
+		// Killed by a signal: ExitCode is -1
+		return max(exitErr.ExitCode(), 1), nil
+	} else if err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+// mountNamespaceProc mounts a procfs of the tracker's PID namespace over
+// /proc, in the mount namespace runInPIDNamespace gave it
+func mountNamespaceProc() error {
+	return syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+}
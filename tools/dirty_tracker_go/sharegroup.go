@@ -0,0 +1,188 @@
+// Share-group tracking (-share-group)
+//
+// Processes that map the same MAP_SHARED file or shmem segment dirty it
+// collectively, and none of them need be a descendant of the root. With
+// -share-group the tracker also follows every process that has a writable
+// shared mapping of a file (device + inode) the root process maps shared.
+//
+// Each mapper has its own PTEs, so a shared page written by two processes
+// is soft-dirty in both, usually at different virtual addresses. Shared
+// pages are therefore identified by (device, inode, file page) and counted
+// once per sample, and once in total_unique_pages under the address of the
+// first mapper that dirtied them.
+//
+// A bit set in one mapper's PTE says nothing about the others: a page is
+// listed under the first process found with the bit set, recorded in its
+// observed_by_pid (so addr is read in that process's address space), and
+// further mappers seeing the same page dirty in that sample are counted in
+// the sample's shared_duplicate_observations rather than listed. So that
+// the deduplication hides no process's view, shared_observations_by_pid
+// counts, for every mapper, the dirty shared pages it saw in the sample,
+// listed under it or not.
+//
+// Finding mappers means reading /proc/*/maps. That is done at start and
+// then at most every shareGroupRescan, and stops after shareGroupScanLimit
+// processes, so the cost stays bounded on hosts with many processes.
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	shareGroupRescan    = 5 * time.Second
+	shareGroupScanLimit = 4096
+)
+
+// sharedFile identifies a shared mapping's backing object
+type sharedFile struct {
+	device string
+	inode  uint64
+}
+
+// sharedFilePage identifies one page of a shared backing object
+type sharedFilePage struct {
+	sharedFile
+	page uint64 // file offset / PageSize
+}
+
+// sharedPages deduplicates shared pages across mappers. It is only touched
+// from ReadDirtyPages, which runs with DirtyPageTracker.mu held.
+type sharedPages struct {
+	canonical map[sharedFilePage]uint64   // first address each page was seen dirty at
+	sample    map[sharedFilePage]struct{} // pages already attributed in this sample
+
+	duplicates int         // observations in this sample of pages already attributed
+	observed   map[int]int // observations in this sample by observing PID
+}
+
+func newSharedPages() *sharedPages {
+	return &sharedPages{
+		canonical: make(map[sharedFilePage]uint64),
+		sample:    make(map[sharedFilePage]struct{}),
+		observed:  make(map[int]int),
+	}
+}
+
+// startSample forgets per-sample attribution
+func (sp *sharedPages) startSample() {
+	clear(sp.sample)
+	sp.duplicates = 0
+	sp.observed = make(map[int]int)
+}
+
+// attribute records a dirty shared page seen by pid and returns the
+// address to count it under in the unique set, or false if another mapper
+// already reported it in this sample
+func (sp *sharedPages) attribute(key sharedFilePage, addr uint64, pid int) (uint64, bool) {
+	sp.observed[pid]++
+	if _, seen := sp.sample[key]; seen {
+		sp.duplicates++
+		return 0, false
+	}
+	sp.sample[key] = struct{}{}
+	if canon, ok := sp.canonical[key]; ok {
+		return canon, true
+	}
+	sp.canonical[key] = addr
+	return addr, true
+}
+
+// sharedKey returns the backing object of a writable shared file or shmem
+// mapping
+func sharedKey(vma *VMAInfo) (sharedFile, bool) {
+	if len(vma.Perms) < 4 || vma.Perms[1] != 'w' || vma.Perms[3] != 's' || vma.Inode == 0 {
+		return sharedFile{}, false
+	}
+	return sharedFile{vma.Device, vma.Inode}, true
+}
+
+// sharedFiles returns the writable shared objects among the given VMAs
+func sharedFiles(vmas []VMAInfo) map[sharedFile]struct{} {
+	files := make(map[sharedFile]struct{})
+	for i := range vmas {
+		if key, ok := sharedKey(&vmas[i]); ok {
+			files[key] = struct{}{}
+		}
+	}
+	return files
+}
+
+// findSharers scans /proc for processes other than the skipped ones that
+// map any of files writable and shared
+func findSharers(files map[sharedFile]struct{}, skip map[int]struct{}) []int {
+	if len(files) == 0 {
+		return nil
+	}
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+
+	var sharers []int
+	scanned := 0
+	self := os.Getpid()
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		if _, ok := skip[pid]; ok {
+			continue
+		}
+		if scanned >= shareGroupScanLimit {
+			fmt.Fprintf(os.Stderr, "Warning: share-group scan stopped after %d processes\n", shareGroupScanLimit)
+			break
+		}
+		scanned++
+
+		data, err := os.ReadFile(fmt.Sprintf("/proc/%d/maps", pid))
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			vma, ok := parseMapsLine(line)
+			if !ok {
+				continue
+			}
+			if key, ok := sharedKey(&vma); ok {
+				if _, match := files[key]; match {
+					sharers = append(sharers, pid)
+					break
+				}
+			}
+		}
+	}
+	return sharers
+}
+
+// trackShareGroup starts tracking processes that share writable memory
+// with the root. Must be called with dt.mu held.
+func (dt *DirtyPageTracker) trackShareGroup() {
+	root, ok := dt.trackers[dt.rootPid]
+	if !ok {
+		return
+	}
+	vmas, err := root.ParseMaps()
+	if err != nil {
+		return
+	}
+	skip := make(map[int]struct{}, len(dt.knownPids)+len(dt.deadPids))
+	for pid := range dt.knownPids {
+		skip[pid] = struct{}{}
+	}
+	for pid := range dt.deadPids {
+		skip[pid] = struct{}{}
+	}
+
+	for _, pid := range findSharers(sharedFiles(vmas), skip) {
+		if dt.addProcessTracker(pid) {
+			fmt.Fprintf(os.Stderr, "Tracking share-group process: %d\n", pid)
+		}
+	}
+	dt.lastShareScan = time.Now()
+}
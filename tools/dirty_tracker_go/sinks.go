@@ -0,0 +1,165 @@
+// Output sinks (-sink)
+//
+// Besides the primary output (-output/-format), any number of extra sinks
+// can receive the final capture, each in its own format:
+//
+//	-sink json:run.json.gz -sink influx:tcp:telegraf:8094 -sink folded:-
+//
+// A sink is FORMAT:DEST. FORMAT is any single-stream -format (json,
+// normalized, folded, influx, delta, matrix, vma-csv, md; csv writes several
+// files and is only available as the primary output). DEST is one of
+//
+//	"-"            stdout
+//	unix:PATH      a Unix stream socket
+//	tcp:HOST:PORT  a TCP connection
+//	PATH           a file; .gz/.zst compress as for -output
+//
+// Precedence: the primary output is written first, then sinks in command
+// line order. If -sink is given without -output, the primary output is
+// skipped rather than printed to stdout; use a "-" sink to get it there.
+//
+// Error handling: a sink that cannot be opened or written is reported and
+// skipped, the remaining sinks are still written, and the tool exits
+// non-zero at the end. Sinks never fsync; -fsync-every applies to the
+// primary output only. The per-sample -stream is independent of sinks.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// OutputSink is one -sink destination
+type OutputSink struct {
+	Format string
+	Dest   string
+}
+
+// sinkList collects repeated -sink flags
+type sinkList []OutputSink
+
+func (l *sinkList) String() string {
+	specs := make([]string, len(*l))
+	for i, s := range *l {
+		specs[i] = s.Format + ":" + s.Dest
+	}
+	return strings.Join(specs, ",")
+}
+
+func (l *sinkList) Set(spec string) error {
+	format, dest, ok := strings.Cut(spec, ":")
+	if !ok || dest == "" {
+		return fmt.Errorf("sink %q is not FORMAT:DEST", spec)
+	}
+	switch format {
+	case "json", "normalized", "folded", "influx", "delta", "matrix", "vma-csv", "md":
+	default:
+		return fmt.Errorf("sink %q: unsupported format %q", spec, format)
+	}
+	*l = append(*l, OutputSink{Format: format, Dest: dest})
+	return nil
+}
+
+// open returns the sink's raw writer and the compression to apply
+func (s OutputSink) open() (io.WriteCloser, string, error) {
+	switch {
+	case s.Dest == "-":
+		return nopWriteCloser{os.Stdout}, codecNone, nil
+	case strings.HasPrefix(s.Dest, "unix:"):
+		conn, err := net.Dial("unix", strings.TrimPrefix(s.Dest, "unix:"))
+		return conn, codecNone, err
+	case strings.HasPrefix(s.Dest, "tcp:"):
+		conn, err := net.DialTimeout("tcp", strings.TrimPrefix(s.Dest, "tcp:"), 5*time.Second)
+		return conn, codecNone, err
+	}
+
+	codec, path := outputCodec(s.Dest, false)
+	if dir := filepath.Dir(path); dir != "" && dir != "." {
+		os.MkdirAll(dir, 0755)
+	}
+	f, err := os.Create(path)
+	return f, codec, err
+}
+
+// write sends one rendered capture to the sink
+func (s OutputSink) write(data []byte) error {
+	w, codec, err := s.open()
+	if err != nil {
+		return err
+	}
+	if err := writeCompressed(w, data, codec); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// renderOutput serializes a pattern in one of the single-stream formats
+func renderOutput(format string, pattern *DirtyPattern, start time.Time, noSamples bool) ([]byte, error) {
+	switch format {
+	case "normalized":
+		return json.MarshalIndent(Normalize(pattern), "", "  ")
+	case "folded":
+		return foldedStacks(pattern), nil
+	case "influx":
+		return influxLines(pattern, start), nil
+	case "delta":
+		return encodeDeltaStream(pattern), nil
+	case "matrix":
+		return sparseMatrix(pattern), nil
+	case "vma-csv":
+		return vmaTypeCSV(pattern), nil
+	case "md":
+		return markdownReport(pattern), nil
+	}
+	if noSamples {
+		return json.MarshalIndent(summaryOnlyPattern{DirtyPattern: *pattern}, "", "  ")
+	}
+	return json.MarshalIndent(pattern, "", "  ")
+}
+
+// encodeJSONOutput encodes the JSON output renderOutput renders into w,
+// followed by a newline
+func encodeJSONOutput(w io.Writer, pattern *DirtyPattern, noSamples bool) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if noSamples {
+		return enc.Encode(summaryOnlyPattern{DirtyPattern: *pattern})
+	}
+	return enc.Encode(pattern)
+}
+
+// writeSinks renders and writes every sink, reporting failures, and
+// returns whether all succeeded. Each format is rendered once.
+func writeSinks(sinks []OutputSink, pattern *DirtyPattern, start time.Time, noSamples bool) bool {
+	rendered := make(map[string][]byte)
+	ok := true
+	for _, sink := range sinks {
+		data, done := rendered[sink.Format]
+		if !done {
+			var err error
+			data, err = renderOutput(sink.Format, pattern, start, noSamples)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: sink %s:%s: encoding: %v\n", sink.Format, sink.Dest, err)
+				ok = false
+				continue
+			}
+			rendered[sink.Format] = data
+		}
+		if err := sink.write(data); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: sink %s:%s: %v\n", sink.Format, sink.Dest, err)
+			ok = false
+			continue
+		}
+		if sink.Dest != "-" {
+			fmt.Fprintf(os.Stderr, "Output written to %s\n", sink.Dest)
+		}
+	}
+	return ok
+}
@@ -0,0 +1,99 @@
+// Output split by VMA type (-split-by-vma-type)
+//
+//	./dirty_tracker -pid 1234 -split-by-vma-type -output out/redis
+//
+// writes one JSON capture per VMA type that has dirty pages, such as
+// out/redis.heap.json and out/redis.anonymous.json, instead of a single
+// out/redis.json, so each memory class can go to its own analysis. An
+// .json extension on -output is dropped first; a .gz or .zst one (or
+// -zstd) is kept at the end of every file name. Sinks and -upload-url
+// still get the whole capture.
+//
+// Each file is a complete DirtyPattern holding only that type's pages,
+// with its summary and timeline recomputed from them as if the capture
+// had been of that type alone, and the run-wide parts (processes, events,
+// maps, metadata) as they are. Every file has all samples, including ones
+// in which the type had no dirty pages. The per-type counts come from the
+// listed pages, so pages beyond -max-pages-per-sample are missing from
+// them, and unique pages are counted by address.
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// vmaTypePattern is one -split-by-vma-type output
+type vmaTypePattern struct {
+	vmaType string
+	pattern DirtyPattern
+}
+
+// vmaTypePatterns returns a pattern per VMA type with dirty pages, in
+// name order. It must not run concurrently with sampling.
+func (dt *DirtyPageTracker) vmaTypePatterns() []vmaTypePattern {
+	types := make(map[string]struct{})
+	for i := range dt.samples {
+		for j := range dt.samples[i].DirtyPages {
+			types[dt.samples[i].DirtyPages[j].VMAType] = struct{}{}
+		}
+	}
+	names := make([]string, 0, len(types))
+	for vmaType := range types {
+		names = append(names, vmaType)
+	}
+	sort.Strings(names)
+
+	// GetDirtyPattern summarizes the tracker's samples, so each type's
+	// are swapped in for the call
+	samples, uniqueAddrs, total := dt.samples, dt.uniqueAddrs, dt.totalDirtyPages
+	defer func() {
+		dt.samples, dt.uniqueAddrs, dt.totalDirtyPages = samples, uniqueAddrs, total
+	}()
+
+	out := make([]vmaTypePattern, 0, len(names))
+	for _, vmaType := range names {
+		dt.samples = make([]DirtySample, len(samples))
+		dt.uniqueAddrs = make(map[uint64]struct{})
+		dt.totalDirtyPages = 0
+		for i := range samples {
+			sample := samples[i]
+			sample.DirtyPages = []DirtyPage{}
+			for _, page := range samples[i].DirtyPages {
+				if page.VMAType == vmaType {
+					sample.DirtyPages = append(sample.DirtyPages, page)
+					dt.uniqueAddrs[page.Address()] = struct{}{}
+				}
+			}
+			sample.DeltaDirtyCount = len(sample.DirtyPages)
+			// Swapped pages left out by -resident-only have no type
+			sample.SwappedDirtyCount = 0
+			if vmaType != "stack" {
+				sample.StackGrowthCount, sample.StackRedirtyCount = 0, 0
+			}
+			dt.totalDirtyPages += sample.DeltaDirtyCount
+			dt.samples[i] = sample
+		}
+		out = append(out, vmaTypePattern{vmaType, dt.GetDirtyPattern()})
+	}
+	return out
+}
+
+// vmaTypePath returns the -split-by-vma-type file of vmaType for the
+// output path, which may carry a compression suffix
+func vmaTypePath(output, vmaType string) string {
+	compression := ""
+	for _, ext := range []string{".gz", ".zst"} {
+		if strings.HasSuffix(output, ext) {
+			compression = ext
+			output = strings.TrimSuffix(output, ext)
+			break
+		}
+	}
+	if filepath.Ext(output) == ".json" {
+		output = strings.TrimSuffix(output, ".json")
+	}
+	return fmt.Sprintf("%s.%s.json%s", output, vmaType, compression)
+}
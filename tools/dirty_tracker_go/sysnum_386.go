@@ -0,0 +1,7 @@
+package main
+
+// Syscall numbers package syscall lacks, on 32-bit x86
+const (
+	sysSetns = 346
+	sysKcmp  = 349
+)
@@ -0,0 +1,7 @@
+package main
+
+// Syscall numbers package syscall lacks, on x86-64
+const (
+	sysSetns = 308
+	sysKcmp  = 312
+)
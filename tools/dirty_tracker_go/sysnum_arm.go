@@ -0,0 +1,7 @@
+package main
+
+// Syscall numbers package syscall lacks, on 32-bit ARM
+const (
+	sysSetns = 375
+	sysKcmp  = 378
+)
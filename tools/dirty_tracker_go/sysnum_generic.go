@@ -0,0 +1,10 @@
+//go:build arm64 || loong64 || riscv64
+
+package main
+
+// Syscall numbers package syscall lacks, on the architectures with the
+// generic syscall table
+const (
+	sysSetns = 268
+	sysKcmp  = 272
+)
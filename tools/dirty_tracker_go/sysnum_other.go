@@ -0,0 +1,10 @@
+//go:build !386 && !amd64 && !arm && !arm64 && !loong64 && !ppc64 && !ppc64le && !riscv64 && !s390x
+
+package main
+
+// setns(2) and kcmp(2) aren't wired up on this architecture; -setns and
+// -detect-vfork fail
+const (
+	sysSetns = 0
+	sysKcmp  = 0
+)
@@ -0,0 +1,9 @@
+//go:build ppc64 || ppc64le
+
+package main
+
+// Syscall numbers package syscall lacks, on 64-bit POWER
+const (
+	sysSetns = 350
+	sysKcmp  = 354
+)
@@ -0,0 +1,7 @@
+package main
+
+// Syscall numbers package syscall lacks, on s390x
+const (
+	sysSetns = 339
+	sysKcmp  = 343
+)
@@ -0,0 +1,161 @@
+// Dirty rate threshold events (-threshold)
+//
+// For alerting, -threshold turns the rate into discrete levels:
+//
+//	-threshold warn:1000,crit:5000
+//
+// names levels entered at rates (pages/sec) of at least the given value;
+// below the lowest the level is "ok". Each sample's rate, its
+// delta_dirty_count over the time since the previous sample, maps to a
+// level, and a change of level is recorded in rate_events once the new
+// level has held for -threshold-samples consecutive samples, so a rate
+// hovering at a threshold doesn't flap. The event is stamped with the
+// sample that confirmed it. Moving up or down several levels at once is
+// one event. With -threshold-log each event is also printed to stderr as
+// it happens.
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// rateLevelOK is the level below every threshold
+const rateLevelOK = "ok"
+
+// RateThreshold is one -threshold level
+type RateThreshold struct {
+	Name string  `json:"name"`
+	Rate float64 `json:"rate_pages_per_sec"`
+}
+
+// RateEvent is a confirmed change of threshold level
+type RateEvent struct {
+	TimestampMs     float64 `json:"timestamp_ms"`
+	Level           string  `json:"level"`
+	PreviousLevel   string  `json:"previous_level"`
+	Direction       string  `json:"direction"` // "up" or "down"
+	RatePagesPerSec float64 `json:"rate_pages_per_sec"`
+	sampleIndex     int
+}
+
+// rateAlerter tracks the level across samples
+type rateAlerter struct {
+	thresholds []RateThreshold // ascending by rate
+	debounce   int
+	log        bool
+
+	prevMs  float64
+	started bool
+	level   int // index into thresholds, -1 for ok
+	pending int // level being confirmed; equal to level when none is
+	held    int // consecutive samples at pending
+	events  []RateEvent
+}
+
+// parseThresholds parses a -threshold list of NAME:RATE
+func parseThresholds(s string) ([]RateThreshold, error) {
+	var thresholds []RateThreshold
+	names := make(map[string]bool)
+	for _, part := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%q is not NAME:RATE", strings.TrimSpace(part))
+		}
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("%q: rate must be a positive number", strings.TrimSpace(part))
+		}
+		if name == rateLevelOK || names[name] {
+			return nil, fmt.Errorf("level name %q is reserved or repeated", name)
+		}
+		names[name] = true
+		thresholds = append(thresholds, RateThreshold{Name: name, Rate: rate})
+	}
+	sort.Slice(thresholds, func(i, j int) bool { return thresholds[i].Rate < thresholds[j].Rate })
+	for i := 1; i < len(thresholds); i++ {
+		if thresholds[i].Rate == thresholds[i-1].Rate {
+			return nil, fmt.Errorf("levels %q and %q have the same rate", thresholds[i-1].Name, thresholds[i].Name)
+		}
+	}
+	return thresholds, nil
+}
+
+func newRateAlerter(thresholds []RateThreshold, debounce int, log bool) *rateAlerter {
+	return &rateAlerter{thresholds: thresholds, debounce: debounce, log: log, level: -1, pending: -1}
+}
+
+func (a *rateAlerter) levelName(level int) string {
+	if level < 0 {
+		return rateLevelOK
+	}
+	return a.thresholds[level].Name
+}
+
+// levelOf returns the highest level whose rate is reached, or -1
+func (a *rateAlerter) levelOf(rate float64) int {
+	level := -1
+	for i, threshold := range a.thresholds {
+		if rate >= threshold.Rate {
+			level = i
+		}
+	}
+	return level
+}
+
+// observe takes a new sample's rate into account
+func (a *rateAlerter) observe(sample *DirtySample, sampleIndex int) {
+	if !a.started || sample.discontinuous() {
+		a.started = true
+		a.prevMs = sample.TimestampMs
+		return
+	}
+	deltaSec := (sample.TimestampMs - a.prevMs) / 1000.0
+	a.prevMs = sample.TimestampMs
+	if deltaSec <= 0 {
+		return
+	}
+	rate := float64(sample.DeltaDirtyCount) / deltaSec
+
+	level := a.levelOf(rate)
+	if level == a.level {
+		a.pending, a.held = level, 0
+		return
+	}
+	if level != a.pending {
+		a.pending, a.held = level, 0
+	}
+	a.held++
+	if a.held < a.debounce {
+		return
+	}
+
+	event := RateEvent{
+		TimestampMs:     sample.TimestampMs,
+		Level:           a.levelName(level),
+		PreviousLevel:   a.levelName(a.level),
+		Direction:       "up",
+		RatePagesPerSec: rate,
+		sampleIndex:     sampleIndex,
+	}
+	if level < a.level {
+		event.Direction = "down"
+	}
+	a.events = append(a.events, event)
+	a.level, a.pending, a.held = level, level, 0
+	if a.log {
+		fmt.Fprintf(os.Stderr, "Rate %s at %.0fms: %s -> %s (%.1f pages/sec)\n",
+			event.Direction, event.TimestampMs, event.PreviousLevel, event.Level, rate)
+	}
+}
+
+// rateEvents returns the recorded threshold events
+func (dt *DirtyPageTracker) rateEvents() []RateEvent {
+	if dt.alerter == nil {
+		return nil
+	}
+	return dt.alerter.events
+}
@@ -0,0 +1,167 @@
+// VMA type changes (type_changed_pages)
+//
+// A page dirtied under one kind of mapping can later sit in another: a
+// JIT writes code and mprotects it RW->RX, or a loader makes a segment
+// writable. Each page dirtied so far remembers the type and permissions
+// of the VMA it was last seen in, and every maps read compares them with
+// the VMA now holding it, non-writable ones included, since a region
+// that lost write permission is never read for dirty pages again. The
+// distinct pages that changed are counted in type_changed_pages, with the
+// first changes as type_change_examples.
+//
+// Merged and split captures only have the samples, so there a change is
+// seen only between two samples listing the page.
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// maxTypeChangeExamples caps the examples reported with TypeChangedPages
+const maxTypeChangeExamples = 10
+
+// TypeChange is one page whose containing VMA changed type or permissions
+type TypeChange struct {
+	Pid         int     `json:"pid,omitempty"`
+	Addr        string  `json:"addr"`
+	TimestampMs float64 `json:"timestamp_ms"` // sample that saw the new type
+	FromType    string  `json:"from_type"`
+	FromPerms   string  `json:"from_perms"`
+	ToType      string  `json:"to_type"`
+	ToPerms     string  `json:"to_perms"`
+}
+
+// pageClass is the type and permissions of the VMA holding a page
+type pageClass struct {
+	vmaType string
+	perms   string
+}
+
+// classRange is one VMA of a maps read, reduced to its pageClass
+type classRange struct {
+	start, end uint64
+	class      pageClass
+}
+
+// typeChangeKey identifies a page of a process
+type typeChangeKey struct {
+	pid  int
+	addr string
+}
+
+// notePageClass records the class of a page dirtied in vma
+func (pt *ProcessTracker) notePageClass(addr uint64, vma *VMAInfo) {
+	if pt.pageClasses == nil {
+		pt.pageClasses = make(map[uint64]pageClass)
+	}
+	pt.pageClasses[addr] = pageClass{vma.VMAType(), vma.Perms}
+}
+
+// noteTypeChanges compares the VMA now holding each page dirtied so far
+// with the class it was last seen under and records the changes in
+// pt.typeChanges. Pages no longer mapped are forgotten. Nothing is
+// compared while the maps keep the layout of the last read.
+func (pt *ProcessTracker) noteTypeChanges(vmas []VMAInfo) {
+	layout := make([]classRange, len(vmas))
+	for i := range vmas {
+		layout[i] = classRange{vmas[i].Start, vmas[i].End, pageClass{vmas[i].VMAType(), vmas[i].Perms}}
+	}
+	same := len(layout) == len(pt.classLayout)
+	for i := 0; same && i < len(layout); i++ {
+		same = layout[i] == pt.classLayout[i]
+	}
+	pt.classLayout = layout
+	if same || len(pt.pageClasses) == 0 {
+		return
+	}
+
+	// maps lists VMAs in address order
+	var changed []uint64
+	for addr, class := range pt.pageClasses {
+		i := sort.Search(len(layout), func(i int) bool { return layout[i].end > addr })
+		if i == len(layout) || layout[i].start > addr {
+			delete(pt.pageClasses, addr)
+			continue
+		}
+		if layout[i].class != class {
+			changed = append(changed, addr)
+		}
+	}
+	sort.Slice(changed, func(i, j int) bool { return changed[i] < changed[j] })
+	for _, addr := range changed {
+		i := sort.Search(len(layout), func(i int) bool { return layout[i].end > addr })
+		from, to := pt.pageClasses[addr], layout[i].class
+		pt.pageClasses[addr] = to
+		pt.typeChanges = append(pt.typeChanges, TypeChange{
+			Pid:       pt.pid,
+			Addr:      fmt.Sprintf("0x%x", addr),
+			FromType:  from.vmaType,
+			FromPerms: from.perms,
+			ToType:    to.vmaType,
+			ToPerms:   to.perms,
+		})
+	}
+}
+
+// takeTypeChanges returns and clears the changes recorded since the last
+// call
+func (pt *ProcessTracker) takeTypeChanges() []TypeChange {
+	changes := pt.typeChanges
+	pt.typeChanges = nil
+	return changes
+}
+
+// noteTypeChange adds a change seen by a read to the run's count and
+// examples
+func (dt *DirtyPageTracker) noteTypeChange(change TypeChange) {
+	if dt.typeChanged == nil {
+		dt.typeChanged = make(map[typeChangeKey]struct{})
+	}
+	dt.typeChanged[typeChangeKey{change.Pid, change.Addr}] = struct{}{}
+	if len(dt.typeChanges) < maxTypeChangeExamples {
+		dt.typeChanges = append(dt.typeChanges, change)
+	}
+}
+
+// vmaTypeChanges returns how many distinct pages changed VMA type or
+// permissions, with the first changes as examples. It starts from the
+// changes seen by reads and adds those between samples listing a page
+// under different classes, which is all a merged capture has.
+func vmaTypeChanges(samples []DirtySample, seen map[typeChangeKey]struct{}, seenExamples []TypeChange) (int, []TypeChange) {
+	changed := make(map[typeChangeKey]struct{}, len(seen))
+	for key := range seen {
+		changed[key] = struct{}{}
+	}
+	examples := append([]TypeChange(nil), seenExamples...)
+	last := make(map[typeChangeKey]*DirtyPage)
+	for i := range samples {
+		for j := range samples[i].DirtyPages {
+			page := &samples[i].DirtyPages[j]
+			key := typeChangeKey{page.pid, page.Addr}
+			prev, ok := last[key]
+			last[key] = page
+			if !ok || (prev.VMAType == page.VMAType && prev.VMAPerms == page.VMAPerms) {
+				continue
+			}
+			if _, ok := changed[key]; ok {
+				continue
+			}
+			changed[key] = struct{}{}
+			examples = append(examples, TypeChange{
+				Pid:         page.pid,
+				Addr:        page.Addr,
+				TimestampMs: samples[i].TimestampMs,
+				FromType:    prev.VMAType,
+				FromPerms:   prev.VMAPerms,
+				ToType:      page.VMAType,
+				ToPerms:     page.VMAPerms,
+			})
+		}
+	}
+	sort.SliceStable(examples, func(i, j int) bool { return examples[i].TimestampMs < examples[j].TimestampMs })
+	if len(examples) > maxTypeChangeExamples {
+		examples = examples[:maxTypeChangeExamples]
+	}
+	return len(changed), examples
+}
@@ -0,0 +1,43 @@
+// Unique page target (-stop-at-unique)
+//
+// For working set size experiments, -stop-at-unique N ends the run with
+// stop_reason "unique_target" after the first sample that brings the
+// distinct dirty pages seen to N or more, answering how long the workload
+// takes to touch N pages. unique_target records the time of that sample
+// and the unique count then, which overshoots N by up to that sample's new
+// pages; the sampling interval bounds the error of the time. If the run
+// ends first for another reason, reached is false and unique_pages is the
+// count at the end. Like total_unique_pages, the count leaves out a child's
+// first read kept out by -child-first-sample.
+package main
+
+// UniqueTarget records when the run reached -stop-at-unique
+type UniqueTarget struct {
+	Target      int     `json:"target"`
+	Reached     bool    `json:"reached"`
+	UniquePages int     `json:"unique_pages"`
+	TimestampMs float64 `json:"timestamp_ms,omitempty"` // of the sample that reached it
+}
+
+// reach checks the unique count after a sample and reports whether it
+// reached the target for the first time. Must be called with dt.mu held.
+func (u *UniqueTarget) reach(unique int, timestampMs float64) bool {
+	if u.Reached || unique < u.Target {
+		return false
+	}
+	u.Reached, u.UniquePages, u.TimestampMs = true, unique, timestampMs
+	return true
+}
+
+// result returns the record for the output, with the current count if
+// the target wasn't reached
+func (u *UniqueTarget) result(unique int) *UniqueTarget {
+	if u == nil {
+		return nil
+	}
+	r := *u
+	if !r.Reached {
+		r.UniquePages = unique
+	}
+	return &r
+}
@@ -0,0 +1,137 @@
+// Upload on completion (-upload-url)
+//
+// After the local outputs are written, the capture is POSTed as JSON
+// (honoring -no-samples, whatever -format is) to an HTTP(S) endpoint, for
+// CI pipelines that collect artifacts centrally. S3 works through a
+// presigned URL or any gateway that accepts a POST. With -upload-gzip the
+// body is gzipped and sent with Content-Encoding: gzip.
+//
+// -upload-token adds an "Authorization: Bearer" header; to keep the token
+// out of the process list, DIRTY_TRACKER_UPLOAD_TOKEN is used when the
+// flag is not given.
+//
+// A network error, 429, or 5xx is retried up to uploadAttempts times with
+// a doubling delay; any other non-2xx status fails at once. A failed
+// upload is reported and makes the tool exit non-zero, but the local
+// output is already written and is kept. Messages name the endpoint by
+// scheme, host, and path only: the query of a presigned URL is a
+// credential, as is any user:password in it.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	uploadAttempts = 3
+	uploadTimeout  = 30 * time.Second
+	uploadBackoff  = time.Second
+)
+
+// uploadStatusError is a non-2xx reply
+type uploadStatusError struct {
+	status int
+	body   string
+}
+
+func (e *uploadStatusError) Error() string {
+	if e.body == "" {
+		return fmt.Sprintf("server replied %d", e.status)
+	}
+	return fmt.Sprintf("server replied %d: %s", e.status, e.body)
+}
+
+func (e *uploadStatusError) retryable() bool {
+	return e.status == http.StatusTooManyRequests || e.status >= 500
+}
+
+// redactURL returns the scheme, host, and path of raw, leaving out the
+// userinfo, query, and fragment
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "(unparsable URL)"
+	}
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String()
+}
+
+// postCapture sends one upload attempt. Errors don't include the URL.
+func postCapture(client *http.Client, endpoint, token string, body []byte, gzipped bool) error {
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		// A *url.Error quotes the whole URL
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "dirty_tracker/"+version)
+	if gzipped {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+	return &uploadStatusError{status: resp.StatusCode, body: string(bytes.TrimSpace(snippet))}
+}
+
+// uploadPattern renders the capture as JSON and POSTs it, retrying
+// transient failures, and reports whether it succeeded
+func uploadPattern(endpoint, token string, gzipped bool, pattern *DirtyPattern, noSamples bool) bool {
+	if token == "" {
+		token = os.Getenv("DIRTY_TRACKER_UPLOAD_TOKEN")
+	}
+	body, err := renderOutput("json", pattern, time.Time{}, noSamples)
+	if err == nil && gzipped {
+		var buf bytes.Buffer
+		if err = writeCompressed(&buf, body, codecGzip); err == nil {
+			body = buf.Bytes()
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: -upload-url: encoding: %v\n", err)
+		return false
+	}
+
+	client := &http.Client{Timeout: uploadTimeout}
+	delay := uploadBackoff
+	for attempt := 1; ; attempt++ {
+		err = postCapture(client, endpoint, token, body, gzipped)
+		if err == nil {
+			fmt.Fprintf(os.Stderr, "Uploaded to %s\n", redactURL(endpoint))
+			return true
+		}
+		statusErr, isStatus := err.(*uploadStatusError)
+		if attempt == uploadAttempts || (isStatus && !statusErr.retryable()) {
+			break
+		}
+		fmt.Fprintf(os.Stderr, "Warning: upload attempt %d/%d failed: %v; retrying in %v\n", attempt, uploadAttempts, err, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+	fmt.Fprintf(os.Stderr, "Error: upload to %s failed: %v (local output kept)\n", redactURL(endpoint), err)
+	return false
+}
@@ -0,0 +1,109 @@
+// Shared address spaces of vfork children (-detect-vfork)
+//
+// A vfork child runs in its parent's address space until it calls exec or
+// exits, and the parent is suspended (state D) for that window. Tracking
+// both would read the same pagemap twice, counting every page the child
+// dirties once per process, and each clear_refs would reset the other's
+// bits. With -detect-vfork, child discovery asks kcmp(KCMP_VM) whether a
+// new process shares its tracked parent's mm and, if so, leaves it out
+// while it does: the parent's reads already cover the shared pages. Once
+// the child has exec'd into its own mm it is tracked like any new child.
+// The same applies to a plain clone(CLONE_VM) child whose parent keeps
+// running; parent_suspended tells the two apart.
+//
+// Each window is recorded in vfork_events, ending when the child stops
+// sharing (exec) or disappears (exit); end_ms is left out for one still
+// open when the run stops. Windows are only seen when they span a
+// discovery pass, i.e. a sample; a child that execs sooner was never
+// double-counted. Needs kcmp (CONFIG_CHECKPOINT_RESTORE, as CRIU itself
+// does) and can't be combined with -helper, whose target we may not
+// compare.
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+const kcmpVM = 1 // KCMP_VM
+
+// VforkEvent is a window in which a child shared a tracked parent's
+// address space and was not tracked
+type VforkEvent struct {
+	ParentPid       int     `json:"parent_pid"`
+	ChildPid        int     `json:"child_pid"`
+	TimestampMs     float64 `json:"timestamp_ms"`
+	EndMs           float64 `json:"end_ms,omitempty"`
+	EndedBy         string  `json:"ended_by,omitempty"` // "exec" or "exit"
+	ParentSuspended bool    `json:"parent_suspended"`
+}
+
+// sharesMM reports whether two processes use the same address space
+func sharesMM(pid1, pid2 int) (bool, error) {
+	if sysKcmp == 0 {
+		return false, syscall.ENOSYS
+	}
+	ret, _, errno := syscall.Syscall6(sysKcmp, uintptr(pid1), uintptr(pid2), kcmpVM, 0, 0, 0)
+	if errno != 0 {
+		return false, errno
+	}
+	return ret == 0, nil
+}
+
+// vforkParent returns the tracked parent whose address space pid shares,
+// or 0. Errors (pid gone, kcmp unavailable) count as not sharing.
+func (dt *DirtyPageTracker) vforkParent(pid int) int {
+	stat, err := readProcStat(pid)
+	if err != nil {
+		return 0
+	}
+	if _, tracked := dt.trackers[stat.Ppid]; !tracked {
+		return 0
+	}
+	if shared, err := sharesMM(pid, stat.Ppid); err != nil || !shared {
+		return 0
+	}
+	return stat.Ppid
+}
+
+// holdVforkChild reports whether childPid must be left out for sharing
+// its parent's address space, opening a vfork event the first time
+func (dt *DirtyPageTracker) holdVforkChild(childPid int) bool {
+	parent := dt.vforkParent(childPid)
+	if parent == 0 {
+		return false
+	}
+	if _, open := dt.openVforks[childPid]; !open {
+		event := VforkEvent{
+			ParentPid:   parent,
+			ChildPid:    childPid,
+			TimestampMs: float64(time.Since(dt.startTime).Microseconds()) / 1000.0,
+		}
+		if stat, err := readProcStat(parent); err == nil {
+			event.ParentSuspended = stat.State == 'D'
+		}
+		dt.vforkEvents = append(dt.vforkEvents, event)
+		dt.openVforks[childPid] = len(dt.vforkEvents) - 1
+	}
+	return true
+}
+
+// endVforks closes the events of children that no longer share: those
+// discovered again with their own mm (exec) and those gone (exit)
+func (dt *DirtyPageTracker) endVforks(descendants map[int]struct{}) {
+	now := float64(time.Since(dt.startTime).Microseconds()) / 1000.0
+	for pid, i := range dt.openVforks {
+		endedBy := "exit"
+		if _, found := descendants[pid]; found {
+			if dt.vforkParent(pid) != 0 {
+				continue
+			}
+			if stat, err := readProcStat(pid); err == nil && stat.State != 'Z' {
+				endedBy = "exec"
+			}
+		}
+		dt.vforkEvents[i].EndMs = now
+		dt.vforkEvents[i].EndedBy = endedBy
+		delete(dt.openVforks, pid)
+	}
+}
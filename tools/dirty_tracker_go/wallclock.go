@@ -0,0 +1,56 @@
+// Wall-clock sample times (-wall-clock)
+//
+// timestamp_ms is taken from Go's monotonic clock, so NTP stepping or
+// slewing the system clock during a long capture never moves it, and the
+// rates and intervals computed from it stay correct. With -wall-clock each
+// sample also records the system (wall) clock it was taken at, as Unix
+// milliseconds in wall_time_ms, for lining samples up with external logs
+// and for seeing the adjustments themselves: the summary compares the wall
+// clock's progress with the monotonic one between consecutive samples and
+// reports the total drift (a slew), the gaps that differ by more than
+// clockStepMs (a step), and the largest difference. Only the output is
+// affected; nothing is derived from the wall clock.
+package main
+
+import "math"
+
+// clockStepMs is the disagreement between the wall and monotonic time of
+// one interval counted as a clock step; slewing stays well below it
+const clockStepMs = 10
+
+// WallClockReport compares the wall clock's progress with the monotonic
+// clock's over the run
+type WallClockReport struct {
+	DriftMs   float64 `json:"drift_ms"`    // wall minus monotonic elapsed time
+	Steps     int     `json:"steps"`       // intervals off by more than clockStepMs
+	MaxStepMs float64 `json:"max_step_ms"` // signed, largest in magnitude
+}
+
+// wallClockReport compares the clocks over the samples, or returns nil if
+// some sample has no wall time
+func wallClockReport(samples []DirtySample) *WallClockReport {
+	if len(samples) == 0 {
+		return nil
+	}
+	for i := range samples {
+		if samples[i].WallTimeMs == 0 {
+			return nil
+		}
+	}
+	r := &WallClockReport{}
+	for i := 1; i < len(samples); i++ {
+		mono := samples[i].TimestampMs - samples[i-1].TimestampMs
+		if mono <= 0 {
+			continue
+		}
+		off := (samples[i].WallTimeMs - samples[i-1].WallTimeMs) - mono
+		r.DriftMs += off
+		if math.Abs(off) > clockStepMs {
+			r.Steps++
+		}
+		if math.Abs(off) > math.Abs(r.MaxStepMs) {
+			r.MaxStepMs = off
+		}
+	}
+	return r
+}
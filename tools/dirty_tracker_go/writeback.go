@@ -0,0 +1,93 @@
+// Shared file write-back (-shared-file-writeback)
+//
+// Pages a process dirties in a MAP_SHARED mapping of a regular file are
+// written back to that file by the kernel; CRIU restores the mapping from
+// the file and doesn't put those pages in the image. Counting them as
+// checkpoint content overstates the dump of workloads that mmap data
+// files. With -shared-file-writeback each such page is tagged
+// file_writeback and the summary splits the dirtying into the write-back
+// bucket, per file, and the rest that a checkpoint has to capture.
+//
+// A mapping counts as write-back when it is shared ('s'), writable, backed
+// by a named file (an inode and an absolute path), and not one of the
+// shared mappings whose content CRIU does dump: shared anonymous memory
+// (/dev/zero), SysV shm, memfd, and files deleted since they were mapped.
+// The split uses the listed pages, so pages beyond -max-pages-per-sample
+// are counted as checkpoint content, and unique pages are counted by
+// address.
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// FileWritebackReport splits the dirty pages into write-back and
+// checkpoint content
+type FileWritebackReport struct {
+	DirtyPages            int             `json:"dirty_pages"`
+	UniquePages           int             `json:"unique_pages"`
+	CheckpointDirtyPages  int             `json:"checkpoint_dirty_pages"`
+	CheckpointUniquePages int             `json:"checkpoint_unique_pages"`
+	CheckpointSizeBytes   int             `json:"checkpoint_size_bytes"` // of the unique pages
+	Files                 []FileWriteback `json:"files"`                 // busiest first
+}
+
+// FileWriteback is the write-back dirtying of one file
+type FileWriteback struct {
+	Path        string `json:"path"`
+	DirtyPages  int    `json:"dirty_pages"`
+	UniquePages int    `json:"unique_pages"`
+}
+
+// isFileWriteback reports whether dirty pages of vma go back to its file
+func (v *VMAInfo) isFileWriteback() bool {
+	if _, ok := sharedKey(v); !ok || !strings.HasPrefix(v.Pathname, "/") {
+		return false
+	}
+	return !v.deleted && v.Pathname != "/dev/zero" &&
+		!strings.HasPrefix(v.Pathname, "/SYSV") && !strings.HasPrefix(v.Pathname, "/memfd:")
+}
+
+// fileWriteback builds the report from the tagged pages. totalEvents and
+// totalUnique are the run's totals.
+func fileWriteback(samples []DirtySample, totalEvents, totalUnique int) *FileWritebackReport {
+	report := &FileWritebackReport{Files: []FileWriteback{}}
+	unique := make(map[uint64]struct{})
+	files := make(map[string]*FileWriteback)
+	fileUnique := make(map[string]map[uint64]struct{})
+	for i := range samples {
+		for j := range samples[i].DirtyPages {
+			page := &samples[i].DirtyPages[j]
+			if !page.FileWriteback {
+				continue
+			}
+			report.DirtyPages++
+			unique[page.Address()] = struct{}{}
+			file, ok := files[page.Pathname]
+			if !ok {
+				file = &FileWriteback{Path: page.Pathname}
+				files[page.Pathname] = file
+				fileUnique[page.Pathname] = make(map[uint64]struct{})
+			}
+			file.DirtyPages++
+			fileUnique[page.Pathname][page.Address()] = struct{}{}
+		}
+	}
+	report.UniquePages = len(unique)
+	report.CheckpointDirtyPages = totalEvents - report.DirtyPages
+	report.CheckpointUniquePages = max(0, totalUnique-report.UniquePages)
+	report.CheckpointSizeBytes = report.CheckpointUniquePages * int(PageSize)
+
+	for path, file := range files {
+		file.UniquePages = len(fileUnique[path])
+		report.Files = append(report.Files, *file)
+	}
+	sort.Slice(report.Files, func(a, b int) bool {
+		if report.Files[a].DirtyPages != report.Files[b].DirtyPages {
+			return report.Files[a].DirtyPages > report.Files[b].DirtyPages
+		}
+		return report.Files[a].Path < report.Files[b].Path
+	})
+	return report
+}
@@ -0,0 +1,95 @@
+// Zero-filled dirty pages (-detect-zero)
+//
+// A dirty page that is all zeros costs CRIU almost nothing to dump, so
+// counting them refines the transfer estimate. With -detect-zero the
+// tracker reads the content of listed dirty pages from /proc/[pid]/mem,
+// which needs the same ptrace access as pagemap, and counts the all-zero
+// ones. Only present pages are read: reading a swapped-out page would
+// fault it back in and change the process being measured.
+//
+// Reading a page per dirty page is expensive, so -zero-sample-every N
+// checks only every Nth present dirty page, and the summary extrapolates
+// the zero share to all dirty events. Not available with -read-strategy
+// scan, which doesn't report presence, or -helper, which can't read
+// memory.
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// ZeroPageStats summarizes -detect-zero over the run. Pages are counted
+// per sample, like total_dirty_events.
+type ZeroPageStats struct {
+	SampleEvery         int     `json:"sample_every"`
+	PagesChecked        int     `json:"pages_checked"`
+	ZeroDirtyPages      int     `json:"zero_dirty_pages"` // among the checked pages
+	ZeroFraction        float64 `json:"zero_fraction"`
+	EstimatedZeroEvents int     `json:"estimated_zero_dirty_events"` // ZeroFraction x total_dirty_events
+	UnreadablePages     int     `json:"unreadable_pages,omitempty"`
+	UnreadablePids      []int   `json:"unreadable_pids,omitempty"`
+
+	unreadableByPid map[int]struct{}
+	presentSeen     int // present dirty pages, for SampleEvery
+}
+
+func newZeroPageStats(every int) *ZeroPageStats {
+	return &ZeroPageStats{SampleEvery: every, unreadableByPid: make(map[int]struct{})}
+}
+
+// checkZero reads one present dirty page, if it is due for checking, and
+// counts it. Called from ReadDirtyPages for listed pages.
+func (pt *ProcessTracker) checkZero(addr uint64) {
+	stats := pt.zero
+	stats.presentSeen++
+	if (stats.presentSeen-1)%stats.SampleEvery != 0 {
+		return
+	}
+	if _, failed := stats.unreadableByPid[pt.pid]; failed {
+		stats.UnreadablePages++
+		return
+	}
+	if pt.memFile == nil {
+		f, err := os.Open(fmt.Sprintf("/proc/%d/mem", pt.pid))
+		if err != nil {
+			stats.unreadableByPid[pt.pid] = struct{}{}
+			stats.UnreadablePages++
+			return
+		}
+		pt.memFile = f
+	}
+
+	if len(pt.zeroBuf) != int(PageSize) {
+		pt.zeroBuf = make([]byte, PageSize)
+	}
+	// The offset of /proc/[pid]/mem is the address; addresses above
+	// 1<<63 don't fit an int64 but are kernel space and never listed
+	if _, err := pt.memFile.ReadAt(pt.zeroBuf, int64(addr)); err != nil {
+		// The page may have been unmapped since pagemap was read
+		stats.UnreadablePages++
+		return
+	}
+	stats.PagesChecked++
+	for _, b := range pt.zeroBuf {
+		if b != 0 {
+			return
+		}
+	}
+	stats.ZeroDirtyPages++
+}
+
+// finish fills in the derived fields for the summary
+func (s *ZeroPageStats) finish(totalDirtyEvents int) ZeroPageStats {
+	out := *s
+	if out.PagesChecked > 0 {
+		out.ZeroFraction = float64(out.ZeroDirtyPages) / float64(out.PagesChecked)
+		out.EstimatedZeroEvents = int(out.ZeroFraction*float64(totalDirtyEvents) + 0.5)
+	}
+	for pid := range s.unreadableByPid {
+		out.UnreadablePids = append(out.UnreadablePids, pid)
+	}
+	sort.Ints(out.UnreadablePids)
+	return out
+}
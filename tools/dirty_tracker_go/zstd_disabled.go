@@ -0,0 +1,15 @@
+//go:build !zstd
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+// zstdAvailable reports whether this binary was built with -tags zstd
+const zstdAvailable = false
+
+func newZstdWriter(io.Writer) (io.WriteCloser, error) {
+	return nil, errors.New("zstd support not built in (rebuild with -tags zstd)")
+}
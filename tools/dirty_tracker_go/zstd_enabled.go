@@ -0,0 +1,20 @@
+//go:build zstd
+
+// zstd support via the pure-Go github.com/klauspost/compress package,
+// pinned in go.mod. It is kept behind a build tag so the default build
+// compiles none of it:
+//
+//	go build -tags zstd -o dirty_tracker .
+package main
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const zstdAvailable = true
+
+func newZstdWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w)
+}